@@ -13,6 +13,7 @@ verbose="false"
 build_output_dir=""
 artifact_output_path=""
 use_hardware_acceleration="true"
+packer_extra_args=()
 
 script_dir=$(
 	cd "$(dirname "$0")" || exit 1
@@ -237,6 +238,24 @@ while [[ $# -gt 0 ]]; do
 			verbose="true"
 			shift
 			;;
+	--packer-var)
+		if [[ -n "$2" && "$2" == *=* ]]; then
+			packer_extra_args+=(-var "$2")
+			shift 2
+		else
+			echo "Invalid value for --packer-var: $2. Expected key=value." >&2
+			exit 1
+		fi
+		;;
+	--packer-var-file)
+		if [[ -n "$2" && -f "$2" ]]; then
+			packer_extra_args+=("-var-file=$2")
+			shift 2
+		else
+			echo "Invalid value for --packer-var-file: $2. The file does not exist." >&2
+			exit 1
+		fi
+		;;
 	*)
 		echo "Unknown option: $1" >&2
 		exit 1
@@ -373,4 +392,5 @@ packer build \
 	-var "arch=$arch" \
 	-var "cpus=$cpus" \
 	-var "memory=$memory" \
+	"${packer_extra_args[@]}" \
 	"$packer_file"
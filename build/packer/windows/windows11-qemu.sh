@@ -13,6 +13,7 @@ artifact_output_path=""
 use_hardware_acceleration="true"
 packer_start_only="${DEV_ALCHEMY_PACKER_START_ONLY:-false}"
 packer_start_timeout="${DEV_ALCHEMY_PACKER_START_TIMEOUT:-180}"
+packer_extra_args=()
 
 script_dir=$(
 	cd "$(dirname "$0")" || exit 1
@@ -250,6 +251,7 @@ run_packer_build() {
 		-var "arch=${arch}" \
 		-var "cpus=${cpus}" \
 		-var "memory=${memory}" \
+		"${packer_extra_args[@]}" \
 		"$packer_file"
 }
 
@@ -417,6 +419,24 @@ while [[ $# -gt 0 ]]; do
 			exit 1
 		fi
 		;;
+	--packer-var)
+		if [[ -n "$2" && "$2" == *=* ]]; then
+			packer_extra_args+=(-var "$2")
+			shift 2
+		else
+			echo "Invalid value for --packer-var: $2. Expected key=value." >&2
+			exit 1
+		fi
+		;;
+	--packer-var-file)
+		if [[ -n "$2" && -f "$2" ]]; then
+			packer_extra_args+=("-var-file=$2")
+			shift 2
+		else
+			echo "Invalid value for --packer-var-file: $2. The file does not exist." >&2
+			exit 1
+		fi
+		;;
 	*)
 		echo "Unknown option: $1" >&2
 		exit 1
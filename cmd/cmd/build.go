@@ -6,9 +6,11 @@ import (
 	"math/rand"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
 
@@ -20,7 +22,10 @@ import (
 // Implementations should honour ctx.Done() so they can abort early.
 type buildRunner func(ctx context.Context, vm alchemy_build.VirtualMachineConfig) error
 
-var inspectBuildArtifactExists = alchemy_build.BuildArtifactsExistQuiet
+var (
+	inspectBuildArtifactExists = alchemy_build.BuildArtifactsExistQuiet
+	loadBuildCatalog           = alchemy_build.LoadBuildCatalog
+)
 
 func isBuildSupported(vm alchemy_build.VirtualMachineConfig) bool {
 	switch vm.HostOs {
@@ -238,6 +243,60 @@ func runBuild(vm alchemy_build.VirtualMachineConfig) error {
 	)
 }
 
+// applyBuildPackerVariables layers build catalog packer_vars and the --var /
+// --var-file flags onto each target and validates them against the target's
+// Packer template before any build starts.
+func applyBuildPackerVariables(vms []alchemy_build.VirtualMachineConfig, assignments []string, varFiles []string) ([]alchemy_build.VirtualMachineConfig, error) {
+	catalog, _, _, err := loadBuildCatalog()
+	if err != nil {
+		return nil, err
+	}
+	cliVars, err := alchemy_build.ParsePackerVarAssignments(assignments)
+	if err != nil {
+		return nil, err
+	}
+	cliVarFiles := make([]string, 0, len(varFiles))
+	for _, varFile := range varFiles {
+		absPath, err := filepath.Abs(varFile)
+		if err != nil {
+			return nil, fmt.Errorf("resolve Packer var file %s: %w", varFile, err)
+		}
+		cliVarFiles = append(cliVarFiles, absPath)
+	}
+
+	configured := make([]alchemy_build.VirtualMachineConfig, 0, len(vms))
+	for _, vm := range vms {
+		vm = catalog.Apply(vm)
+		vm = alchemy_build.WithPackerVariables(vm, cliVars, cliVarFiles)
+		if err := alchemy_build.ValidatePackerVariables(vm); err != nil {
+			return nil, fmt.Errorf("%s/%s/%s/%s: %w", vm.OS, vm.UbuntuType, vm.Arch, vm.VirtualizationEngine, err)
+		}
+		configured = append(configured, vm)
+	}
+	return configured, nil
+}
+
+// buildAndVerify runs build for vm and, with --verify, boot tests the
+// resulting artifact. Targets without verify support are built and reported
+// as unverified.
+func buildAndVerify(vm alchemy_build.VirtualMachineConfig, build func(alchemy_build.VirtualMachineConfig) error) error {
+	if err := build(vm); err != nil {
+		return err
+	}
+	if !buildVerify {
+		return nil
+	}
+	if !isVerifySupported(vm) {
+		fmt.Printf("⚠️ Skipping verification for OS: %s, Type: %s, Architecture: %s: only QEMU targets on Linux hosts can be verified\n", vm.OS, vm.UbuntuType, vm.Arch)
+		return nil
+	}
+	fmt.Printf("🔍 Verifying build artifact for OS: %s, Type: %s, Architecture: %s\n", vm.OS, vm.UbuntuType, vm.Arch)
+	if err := runVerifyFunc(vm, verifyOptions()); err != nil {
+		return fmt.Errorf("build succeeded but verification failed: %w", err)
+	}
+	return nil
+}
+
 func buildArtifactState(vm alchemy_build.VirtualMachineConfig) (string, error) {
 	artifactsExist, err := inspectBuildArtifactExists(vm)
 	if err != nil {
@@ -264,6 +323,14 @@ var (
 	noCache      bool
 	buildVerbose bool
 	buildEngine  string
+
+	buildPackerVars     []string
+	buildPackerVarFiles []string
+	buildPlan           bool
+	buildSkipPreflight  bool
+	buildStrictChecksum bool
+	buildOptimize       bool
+	buildVerify         bool
 )
 
 func printAvailableBuildCombinations() error {
@@ -301,8 +368,13 @@ Example:
   alchemy build windows11 --arch arm64
   alchemy build windows11 --arch amd64 --engine hyperv
   alchemy build windows11 --arch amd64 --engine virtualbox
+  alchemy build ubuntu --type server --arch amd64 --var disk_size=81920
+  alchemy build windows11 --arch amd64 --var-file ./windows.pkrvars.hcl
   alchemy build all
   alchemy build all --parallel 4
+  alchemy build all --plan
+  alchemy build ubuntu --type server --arch amd64 --optimize
+  alchemy build ubuntu --type server --arch amd64 --verify
 `,
 	Args: cobra.ExactArgs(1), // Enforce exactly one positional argument
 	Run: func(cmd *cobra.Command, args []string) {
@@ -323,11 +395,25 @@ Example:
 				fmt.Printf("❌ %v\n", err)
 				return
 			}
-			fmt.Printf("🔧 Building all available stable VM configurations with %d parallel builds\n", parallel)
+			available_virtual_machines, err = applyBuildPackerVariables(available_virtual_machines, buildPackerVars, buildPackerVarFiles)
+			if err != nil {
+				fmt.Printf("❌ %v\n", err)
+				return
+			}
 			for i := range available_virtual_machines {
 				available_virtual_machines[i].NoCache = noCache
 				available_virtual_machines[i].Verbose = buildVerbose
+				available_virtual_machines[i].SkipPreflight = buildSkipPreflight
+				available_virtual_machines[i].StrictChecksums = buildStrictChecksum
+				available_virtual_machines[i].Optimize = available_virtual_machines[i].Optimize || buildOptimize
+			}
+			if buildPlan {
+				if err := printBuildPlan(os.Stdout, available_virtual_machines); err != nil {
+					fmt.Printf("❌ %v\n", err)
+				}
+				return
 			}
+			fmt.Printf("🔧 Building all available stable VM configurations with %d parallel builds\n", parallel)
 
 			ctx, cancel := context.WithCancel(context.Background())
 			defer cancel()
@@ -350,7 +436,7 @@ Example:
 					return ctx.Err()
 				default:
 				}
-				return runBuild(vm)
+				return buildAndVerify(vm, runBuild)
 			}
 
 			errs := runParallelBuilds(ctx, available_virtual_machines, parallel, runner)
@@ -371,7 +457,12 @@ Example:
 			fmt.Printf("❌ %v\n", err)
 			return
 		}
-		fmt.Printf("🔧 Building VM for OS: %s, Type: %s, Architecture: %s, Engine: %s\n", osName, osType, arch, alchemy_build.DisplayVirtualizationEngine(VirtualMachineConfig.VirtualizationEngine))
+		configured, err := applyBuildPackerVariables([]alchemy_build.VirtualMachineConfig{VirtualMachineConfig}, buildPackerVars, buildPackerVarFiles)
+		if err != nil {
+			fmt.Printf("❌ %v\n", err)
+			return
+		}
+		VirtualMachineConfig = configured[0]
 
 		// #nosec G404 -- this random value only spreads local VNC port selection and is not security-sensitive.
 		port := 5900 + (rand.Intn(100) + 1)
@@ -380,8 +471,23 @@ Example:
 		VirtualMachineConfig.Headless = headless
 		VirtualMachineConfig.NoCache = noCache
 		VirtualMachineConfig.Verbose = buildVerbose
+		VirtualMachineConfig.SkipPreflight = buildSkipPreflight
+		VirtualMachineConfig.StrictChecksums = buildStrictChecksum
+		VirtualMachineConfig.Optimize = VirtualMachineConfig.Optimize || buildOptimize
+
+		if buildPlan {
+			if err := printBuildPlan(os.Stdout, []alchemy_build.VirtualMachineConfig{VirtualMachineConfig}); err != nil {
+				fmt.Printf("❌ %v\n", err)
+			}
+			return
+		}
+		if buildVerify && !isVerifySupported(VirtualMachineConfig) {
+			fmt.Printf("❌ --verify is only supported for QEMU targets on Linux hosts\n")
+			return
+		}
+		fmt.Printf("🔧 Building VM for OS: %s, Type: %s, Architecture: %s, Engine: %s\n", osName, osType, arch, alchemy_build.DisplayVirtualizationEngine(VirtualMachineConfig.VirtualizationEngine))
 
-		if err := runBuild(VirtualMachineConfig); err != nil {
+		if err := buildAndVerify(VirtualMachineConfig, runBuild); err != nil {
 			fmt.Printf("❌ Build failed for OS: %s, Type: %s, Architecture: %s — %v\n", osName, osType, arch, err)
 		}
 	},
@@ -407,4 +513,13 @@ func init() {
 	buildCmd.Flags().BoolVar(&headless, "headless", false, "Run QEMU in headless mode (no GUI, VNC only)")
 	buildCmd.Flags().BoolVarP(&buildVerbose, "verbose", "v", false, "Enable verbose Packer logging (sets PACKER_LOG=1)")
 	buildCmd.Flags().BoolVar(&noCache, "no-cache", false, "Force a rebuild even when the build artifact already exists")
+	buildCmd.Flags().StringArrayVar(&buildPackerVars, "var", nil, "Extra Packer variable as key=value; repeatable and validated against the target's Packer template")
+	buildCmd.Flags().StringArrayVar(&buildPackerVarFiles, "var-file", nil, "Extra Packer var file (.pkrvars.hcl or .json); repeatable")
+	buildCmd.Flags().BoolVar(&buildOptimize, "optimize", false, "Sparsify and zstd-compress QCOW2 artifacts after a successful build")
+	buildCmd.Flags().BoolVar(&buildVerify, "verify", false, "Boot a throwaway overlay of each fresh QCOW2 artifact and wait for SSH or WinRM before marking it verified")
+	buildCmd.Flags().StringVar(&verifyPlaybook, "verify-playbook", "", "Optional check playbook to run against the booted guest with --verify")
+	buildCmd.Flags().DurationVar(&verifyBootTimeout, "verify-boot-timeout", 20*time.Minute, "How long the guest may take until SSH or WinRM answers with --verify")
+	buildCmd.Flags().BoolVar(&buildSkipPreflight, "skip-preflight", false, "Skip the free disk space, required executable, KVM access, and memory checks before a build")
+	buildCmd.Flags().BoolVar(&buildStrictChecksum, "strict-checksums", false, "Refuse to build when a dependency has neither a pinned checksum nor one recorded in the dependency lock")
+	buildCmd.Flags().BoolVar(&buildPlan, "plan", false, "Show targets, cached artifacts, pending downloads, VNC ports, the build command, and estimated disk space without starting Packer")
 }
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+)
+
+var planBuilds = alchemy_build.PlanBuilds
+
+// printBuildPlan resolves and prints what building vms would do without
+// starting Packer.
+func printBuildPlan(writer io.Writer, vms []alchemy_build.VirtualMachineConfig) error {
+	plans, err := planBuilds(vms)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(writer, "📋 Build plan for %d target(s) (Packer is not started)\n", len(plans))
+	for _, plan := range plans {
+		writeBuildPlan(writer, plan)
+	}
+	fmt.Fprintf(writer, "\nEstimated disk space required: %s\n", alchemy_build.FormatByteSize(alchemy_build.EstimatedPlanDiskBytes(plans)))
+	return nil
+}
+
+func writeBuildPlan(writer io.Writer, plan alchemy_build.BuildPlan) {
+	vm := plan.Config
+	fmt.Fprintf(writer, "\n%s/%s/%s (%s): %s\n", vm.OS, displayVirtualMachineType(vm), vm.Arch, alchemy_build.DisplayVirtualizationEngine(vm.VirtualizationEngine), plan.Action)
+
+	fmt.Fprintln(writer, "  Artifacts:")
+	for _, artifact := range plan.Artifacts {
+		fmt.Fprintf(writer, "    %s\n", describeBuildPlanArtifact(artifact))
+	}
+
+	if plan.Action != alchemy_build.BuildPlanActionSkip {
+		fmt.Fprintln(writer, "  Dependencies:")
+		if len(plan.Dependencies) == 0 {
+			fmt.Fprintln(writer, "    none")
+		}
+		for _, dep := range plan.Dependencies {
+			fmt.Fprintf(writer, "    %s\n", describeBuildPlanDependency(dep))
+		}
+	}
+
+	if plan.VncPort > 0 {
+		fmt.Fprintf(writer, "  VNC port: %d (first free port at plan time)\n", plan.VncPort)
+	} else {
+		fmt.Fprintln(writer, "  VNC port: -")
+	}
+	fmt.Fprintf(writer, "  Command: %s %s\n", plan.Executable, strings.Join(plan.Args, " "))
+	if vm.Optimize {
+		fmt.Fprintln(writer, "  Optimize: QCOW2 artifacts are sparsified and zstd-compressed after the build")
+	}
+	if plan.Action != alchemy_build.BuildPlanActionSkip {
+		fmt.Fprintf(writer, "  Estimated disk: %s\n", alchemy_build.FormatByteSize(plan.EstimatedDiskBytes))
+	}
+}
+
+func describeBuildPlanArtifact(artifact alchemy_build.BuildPlanArtifact) string {
+	if !artifact.Exists {
+		return "missing  " + artifact.Path
+	}
+	description := fmt.Sprintf("cached   %s (%s)", artifact.Path, alchemy_build.FormatByteSize(artifact.SizeBytes))
+	if artifact.Stale {
+		description += " [stale: older than the Packer template; use --no-cache to rebuild]"
+	}
+	return description
+}
+
+func describeBuildPlanDependency(dep alchemy_build.BuildPlanDependency) string {
+	if dep.Cached {
+		return fmt.Sprintf("cached   %s (%s)", dep.LocalPath, alchemy_build.FormatByteSize(dep.SizeBytes))
+	}
+
+	size := "size unknown"
+	if dep.SizeBytes >= 0 {
+		size = alchemy_build.FormatByteSize(dep.SizeBytes)
+	}
+	source := "source resolved at build time"
+	if dep.Source != "" {
+		source = "from " + dep.Source
+	}
+	return fmt.Sprintf("download %s (%s) %s", dep.LocalPath, size, source)
+}
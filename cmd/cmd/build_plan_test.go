@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+)
+
+func TestPrintBuildPlanDescribesEachTarget(t *testing.T) {
+	previousPlanner := planBuilds
+	t.Cleanup(func() {
+		planBuilds = previousPlanner
+	})
+	planBuilds = func(vms []alchemy_build.VirtualMachineConfig) ([]alchemy_build.BuildPlan, error) {
+		return []alchemy_build.BuildPlan{
+			{
+				Config:     optimizedUbuntuQemuBuildTarget(),
+				Action:     alchemy_build.BuildPlanActionBuild,
+				Artifacts:  []alchemy_build.BuildPlanArtifact{{Path: "/cache/ubuntu.qcow2"}},
+				VncPort:    5903,
+				Executable: "bash",
+				Args:       []string{"build.sh", "--vnc-port", "5903"},
+				Dependencies: []alchemy_build.BuildPlanDependency{
+					{LocalPath: "/cache/ubuntu.iso", Source: "https://example.test/ubuntu.iso", SizeBytes: 3 << 30},
+					{LocalPath: "/cache/efi.deb", SizeBytes: -1},
+				},
+				EstimatedDiskBytes: 67 << 30,
+			},
+			{
+				Config: alchemy_build.VirtualMachineConfig{
+					OS:                   "windows11",
+					Arch:                 "amd64",
+					HostOs:               alchemy_build.HostOsLinux,
+					VirtualizationEngine: alchemy_build.VirtualizationEngineQemu,
+				},
+				Action:     alchemy_build.BuildPlanActionSkip,
+				Artifacts:  []alchemy_build.BuildPlanArtifact{{Path: "/cache/win11.qcow2", Exists: true, SizeBytes: 20 << 30, Stale: true}},
+				VncPort:    5904,
+				Executable: "bash",
+				Args:       []string{"build.sh"},
+			},
+		}, nil
+	}
+
+	var buf bytes.Buffer
+	if err := printBuildPlan(&buf, nil); err != nil {
+		t.Fatalf("printBuildPlan returned error: %v", err)
+	}
+
+	output := buf.String()
+	for _, want := range []string{
+		"Build plan for 2 target(s)",
+		"ubuntu/server/amd64 (qemu): build",
+		"missing  /cache/ubuntu.qcow2",
+		"download /cache/ubuntu.iso (3.0 GiB) from https://example.test/ubuntu.iso",
+		"download /cache/efi.deb (size unknown) source resolved at build time",
+		"VNC port: 5903 (first free port at plan time)",
+		"Command: bash build.sh --vnc-port 5903",
+		"Optimize: QCOW2 artifacts are sparsified and zstd-compressed",
+		"windows11/-/amd64 (qemu): skip",
+		"cached   /cache/win11.qcow2 (20.0 GiB) [stale",
+		"Estimated disk space required:",
+	} {
+		if !strings.Contains(output, want) {
+			t.Fatalf("expected plan output to contain %q, got %q", want, output)
+		}
+	}
+}
+
+func TestBuildHelpIncludesPlanFlag(t *testing.T) {
+	output := buildCmd.Flags().FlagUsages()
+	for _, want := range []string{"--plan", "--optimize"} {
+		if !strings.Contains(output, want) {
+			t.Fatalf("expected %s flag in build flags, got %q", want, output)
+		}
+	}
+}
+
+func optimizedUbuntuQemuBuildTarget() alchemy_build.VirtualMachineConfig {
+	vm := ubuntuQemuBuildTarget()
+	vm.Optimize = true
+	return vm
+}
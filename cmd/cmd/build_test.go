@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"bytes"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -253,3 +254,76 @@ func TestBuildListRowIncludesArtifactStatus(t *testing.T) {
 		t.Fatalf("expected missing ubuntu build artifact row, got %q", output)
 	}
 }
+
+func TestApplyBuildPackerVariablesLayersCatalogThenCLI(t *testing.T) {
+	withRepoBuildProjectDir(t)
+	previousLoader := loadBuildCatalog
+	t.Cleanup(func() {
+		loadBuildCatalog = previousLoader
+	})
+	loadBuildCatalog = func() (alchemy_build.BuildCatalog, string, bool, error) {
+		return alchemy_build.BuildCatalog{Targets: []alchemy_build.BuildCatalogEntry{
+			{OS: "ubuntu", PackerVars: map[string]string{"cpus": "4", "memory": "8192"}},
+		}}, "build-catalog.yml", true, nil
+	}
+
+	vms, err := applyBuildPackerVariables(
+		[]alchemy_build.VirtualMachineConfig{ubuntuQemuBuildTarget()},
+		[]string{"cpus=8"},
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("applyBuildPackerVariables returned error: %v", err)
+	}
+	if got := vms[0].PackerVars; got["cpus"] != "8" || got["memory"] != "8192" {
+		t.Fatalf("expected CLI cpus and catalog memory, got %v", got)
+	}
+}
+
+func TestApplyBuildPackerVariablesRejectsUndeclaredVariable(t *testing.T) {
+	withRepoBuildProjectDir(t)
+	previousLoader := loadBuildCatalog
+	t.Cleanup(func() {
+		loadBuildCatalog = previousLoader
+	})
+	loadBuildCatalog = func() (alchemy_build.BuildCatalog, string, bool, error) {
+		return alchemy_build.BuildCatalog{}, "build-catalog.yml", false, nil
+	}
+
+	_, err := applyBuildPackerVariables(
+		[]alchemy_build.VirtualMachineConfig{ubuntuQemuBuildTarget()},
+		[]string{"disk_sise=81920"},
+		nil,
+	)
+	if err == nil {
+		t.Fatal("expected undeclared variable to be rejected")
+	}
+	if !strings.Contains(err.Error(), "ubuntu/server/amd64/qemu") || !strings.Contains(err.Error(), `"disk_sise"`) {
+		t.Fatalf("expected error naming the target and variable, got %q", err.Error())
+	}
+}
+
+func ubuntuQemuBuildTarget() alchemy_build.VirtualMachineConfig {
+	return alchemy_build.VirtualMachineConfig{
+		OS:                   "ubuntu",
+		UbuntuType:           "server",
+		Arch:                 "amd64",
+		HostOs:               alchemy_build.HostOsLinux,
+		VirtualizationEngine: alchemy_build.VirtualizationEngineQemu,
+	}
+}
+
+func withRepoBuildProjectDir(t *testing.T) {
+	t.Helper()
+
+	projectDir, err := filepath.Abs(filepath.Join("..", ".."))
+	if err != nil {
+		t.Fatalf("failed to resolve repository root: %v", err)
+	}
+	dirs := alchemy_build.GetDirectoriesInstance()
+	previous := *dirs
+	t.Cleanup(func() {
+		*dirs = previous
+	})
+	dirs.ProjectDir = projectDir
+}
@@ -0,0 +1,181 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	"github.com/csautter/dev-alchemy/pkg/deploy"
+	"github.com/csautter/dev-alchemy/pkg/provision"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cacheGCDryRun         bool
+	cacheGCKeepRecordings int
+	cacheGCStagedMinAge   time.Duration
+	cacheMoveTo           string
+)
+
+var (
+	cacheUsageReport         = alchemy_build.CacheUsageReport
+	collectCacheGarbage      = alchemy_build.CollectCacheGarbage
+	pruneRoleSourceCheckouts = provision.PruneRoleSourceCheckouts
+	cacheCategoryRoots       = func() []alchemy_build.CacheCategoryRoot {
+		return append(provision.RoleSourceCacheRoots(), deploy.LibvirtImageCacheRoots(alchemy_build.GetCurrentHostOs())...)
+	}
+	moveDataDir    = alchemy_build.MoveDataDir
+	currentDataDir = func(category alchemy_build.DataDirCategory) string {
+		if dir := alchemy_build.GetDirectoriesInstance().DataDir(category); dir != "" || category != alchemy_build.DataDirImages {
+			return dir
+		}
+		return deploy.LinuxLibvirtImageDir()
+	}
+)
+
+func dataDirCategoryNames() []string {
+	var names []string
+	for _, category := range alchemy_build.DataDirCategories() {
+		names = append(names, string(category))
+	}
+	return names
+}
+
+func writeCacheUsage(writer io.Writer, usage []alchemy_build.CacheUsage) {
+	var total int64
+	for _, category := range usage {
+		total += category.SizeBytes
+		fmt.Fprintf(writer, "%-18s %10s\n", category.Category, alchemy_build.FormatByteSize(category.SizeBytes))
+		for _, path := range category.Paths {
+			fmt.Fprintf(writer, "  in %s\n", path)
+		}
+		for _, target := range category.Targets {
+			fmt.Fprintf(writer, "  %-30s %10s\n", target.Target, alchemy_build.FormatByteSize(target.SizeBytes))
+		}
+	}
+	fmt.Fprintf(writer, "%-18s %10s\n", "total", alchemy_build.FormatByteSize(total))
+}
+
+func writeCacheRemovals(writer io.Writer, removals []alchemy_build.CacheRemoval, dryRun bool) {
+	if len(removals) == 0 {
+		fmt.Fprintln(writer, "Nothing to collect.")
+		return
+	}
+	verb := "Removed"
+	if dryRun {
+		verb = "Would remove"
+	}
+	var total int64
+	for _, removal := range removals {
+		total += removal.SizeBytes
+		fmt.Fprintf(writer, "%s %s %s (%s): %s\n", verb, removal.Category, removal.Path, alchemy_build.FormatByteSize(removal.SizeBytes), removal.Reason)
+	}
+	fmt.Fprintf(writer, "%s %d item(s), %s in total\n", verb, len(removals), alchemy_build.FormatByteSize(total))
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Report and reclaim disk space used by managed data",
+	Long: `Reports how much disk space build artifacts, dependencies, VNC recordings,
+staged artifacts, role-source checkouts, libvirt images, the Packer cache, and
+Vagrant data use, and removes data that is no longer needed.
+
+Examples:
+  alchemy cache du
+  alchemy cache gc --dry-run
+  alchemy cache gc --keep-recordings 1
+  alchemy cache move cache --to /mnt/data/dev-alchemy/cache
+`,
+}
+
+var cacheDuCmd = &cobra.Command{
+	Use:   "du",
+	Short: "Show disk usage per category and per build target",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		usage, err := cacheUsageReport(alchemy_build.AvailableVirtualMachineConfigs(), cacheCategoryRoots())
+		if err != nil {
+			return err
+		}
+		writeCacheUsage(cmd.OutOrStdout(), usage)
+		return nil
+	},
+}
+
+var cacheGCCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove old recordings, orphaned staged artifacts, stale role sources, and unused ISOs",
+	Long: `Removes managed data that no build or provisioning run needs any more:
+
+  - VNC recordings beyond the newest --keep-recordings (-1 keeps all)
+  - staged artifacts of interrupted no-cache builds that have not changed
+    for --staged-min-age
+  - blobs of interrupted OCI pulls that have not been written to for seven
+    days, like the next pull into the same artifact root does
+  - Git role and playbook source checkouts that ansible-role-sources.yml no
+    longer configures
+  - ISO and Debian package downloads that no dependency references
+
+Build artifacts are never removed. Use --dry-run to see what would go.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		removals, err := collectCacheGarbage(alchemy_build.CacheGCPolicy{
+			KeepRecordings: cacheGCKeepRecordings,
+			StagedMinAge:   cacheGCStagedMinAge,
+			DryRun:         cacheGCDryRun,
+		})
+		checkouts, checkoutErr := pruneRoleSourceCheckouts(cacheGCDryRun)
+		writeCacheRemovals(cmd.OutOrStdout(), append(removals, checkouts...), cacheGCDryRun)
+		return errors.Join(err, checkoutErr)
+	},
+}
+
+var cacheMoveCmd = &cobra.Command{
+	Use:   "move <category> --to <path>",
+	Short: "Relocate a data directory and record the new location",
+	Long: `Moves one data directory to a new location and records it in
+directories.yml in the config directory, so later runs use it.
+
+Categories: cache, packer-cache, vagrant, recordings, images.
+
+The data is renamed when the target is on the same filesystem. Otherwise it
+is copied, the copy is verified, and the original is removed only after the
+config was updated. The target must be empty or missing. An images dir that
+still holds VM disks is not moved, because libvirt domains reference their
+disks by path. Do not run builds or deploys while moving.
+
+Examples:
+  alchemy cache move cache --to /mnt/data/dev-alchemy/cache
+  alchemy cache move recordings --to /mnt/data/dev-alchemy/recordings
+`,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: dataDirCategoryNames(),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		category := alchemy_build.DataDirCategory(args[0])
+		move, err := moveDataDir(category, currentDataDir(category), cacheMoveTo)
+		if move.ConfigPath == "" {
+			return err
+		}
+		how := "renamed"
+		if move.Copied {
+			how = "copied across filesystems"
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Moved %s dir from %s to %s (%s, %s).\n", move.Category, move.From, move.To, how, alchemy_build.FormatByteSize(move.SizeBytes))
+		fmt.Fprintf(cmd.OutOrStdout(), "Recorded the new location in %s.\n", move.ConfigPath)
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(cacheCmd)
+	cacheCmd.AddCommand(cacheDuCmd, cacheGCCmd, cacheMoveCmd)
+
+	cacheGCCmd.Flags().BoolVar(&cacheGCDryRun, "dry-run", false, "Only report what would be removed")
+	cacheGCCmd.Flags().IntVar(&cacheGCKeepRecordings, "keep-recordings", 3, "Number of the newest VNC recordings to keep; -1 keeps all")
+	cacheGCCmd.Flags().DurationVar(&cacheGCStagedMinAge, "staged-min-age", 24*time.Hour, "Keep staged artifacts that changed more recently, as their build may still run")
+	cacheMoveCmd.Flags().StringVar(&cacheMoveTo, "to", "", "Absolute path of the new, empty location")
+	_ = cacheMoveCmd.MarkFlagRequired("to")
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+)
+
+func TestWriteCacheUsageShowsCategoriesTargetsAndTotal(t *testing.T) {
+	var output bytes.Buffer
+	writeCacheUsage(&output, []alchemy_build.CacheUsage{
+		{
+			Category:  alchemy_build.CacheCategoryArtifacts,
+			Paths:     []string{"/cache"},
+			SizeBytes: 3 << 30,
+			Targets: []alchemy_build.CacheTargetUsage{
+				{Target: "ubuntu-server-amd64", SizeBytes: 1 << 30},
+				{Target: "windows11-amd64", SizeBytes: 2 << 30},
+			},
+		},
+		{Category: alchemy_build.CacheCategoryPacker, Paths: []string{"/packer_cache"}, SizeBytes: 1 << 30},
+	})
+
+	for _, want := range []string{
+		"artifacts             3.0 GiB",
+		"  in /cache\n",
+		"  ubuntu-server-amd64               1.0 GiB",
+		"packer cache          1.0 GiB",
+		"total                 4.0 GiB",
+	} {
+		if !strings.Contains(output.String(), want) {
+			t.Fatalf("expected output to contain %q, got:\n%s", want, output.String())
+		}
+	}
+}
+
+func TestCacheGCCommandCombinesBuildAndRoleSourceRemovals(t *testing.T) {
+	previousCollect, previousPrune := collectCacheGarbage, pruneRoleSourceCheckouts
+	previousDryRun, previousKeep := cacheGCDryRun, cacheGCKeepRecordings
+	t.Cleanup(func() {
+		collectCacheGarbage, pruneRoleSourceCheckouts = previousCollect, previousPrune
+		cacheGCDryRun, cacheGCKeepRecordings = previousDryRun, previousKeep
+	})
+	cacheGCDryRun, cacheGCKeepRecordings = true, 2
+
+	collectCacheGarbage = func(policy alchemy_build.CacheGCPolicy) ([]alchemy_build.CacheRemoval, error) {
+		if !policy.DryRun || policy.KeepRecordings != 2 {
+			t.Fatalf("expected flags to reach the policy, got %+v", policy)
+		}
+		return []alchemy_build.CacheRemoval{{Category: alchemy_build.CacheCategoryRecordings, Path: "/cache/old", SizeBytes: 1 << 20, Reason: "older"}}, nil
+	}
+	pruneRoleSourceCheckouts = func(dryRun bool) ([]alchemy_build.CacheRemoval, error) {
+		return nil, errors.New("invalid role source config")
+	}
+
+	var output bytes.Buffer
+	cacheGCCmd.SetOut(&output)
+	t.Cleanup(func() { cacheGCCmd.SetOut(nil) })
+
+	err := cacheGCCmd.RunE(cacheGCCmd, nil)
+	if err == nil || !strings.Contains(err.Error(), "invalid role source config") {
+		t.Fatalf("expected the role source error to be returned, got %v", err)
+	}
+	for _, want := range []string{"Would remove recordings /cache/old (1.0 MiB): older", "Would remove 1 item(s), 1.0 MiB in total"} {
+		if !strings.Contains(output.String(), want) {
+			t.Fatalf("expected output to contain %q, got:\n%s", want, output.String())
+		}
+	}
+}
+
+func TestCacheMoveCommandReportsMove(t *testing.T) {
+	previousMove, previousCurrent, previousTo := moveDataDir, currentDataDir, cacheMoveTo
+	t.Cleanup(func() {
+		moveDataDir, currentDataDir, cacheMoveTo = previousMove, previousCurrent, previousTo
+	})
+	cacheMoveTo = "/mnt/data/recordings"
+	currentDataDir = func(category alchemy_build.DataDirCategory) string { return "/cache" }
+	moveDataDir = func(category alchemy_build.DataDirCategory, from string, to string) (alchemy_build.DataDirMove, error) {
+		if category != alchemy_build.DataDirRecordings || from != "/cache" || to != "/mnt/data/recordings" {
+			t.Fatalf("unexpected move of %s from %q to %q", category, from, to)
+		}
+		return alchemy_build.DataDirMove{Category: category, From: from, To: to, Copied: true, SizeBytes: 1 << 30, ConfigPath: "/config/directories.yml"}, nil
+	}
+
+	var output bytes.Buffer
+	cacheMoveCmd.SetOut(&output)
+	t.Cleanup(func() { cacheMoveCmd.SetOut(nil) })
+	if err := cacheMoveCmd.RunE(cacheMoveCmd, []string{"recordings"}); err != nil {
+		t.Fatalf("cache move returned error: %v", err)
+	}
+	for _, want := range []string{
+		"Moved recordings dir from /cache to /mnt/data/recordings (copied across filesystems, 1.0 GiB).",
+		"Recorded the new location in /config/directories.yml.",
+	} {
+		if !strings.Contains(output.String(), want) {
+			t.Fatalf("expected output to contain %q, got:\n%s", want, output.String())
+		}
+	}
+}
+
+func TestCacheMoveCommandPrintsNothingWhenRefused(t *testing.T) {
+	previousMove := moveDataDir
+	t.Cleanup(func() { moveDataDir = previousMove })
+	moveDataDir = func(alchemy_build.DataDirCategory, string, string) (alchemy_build.DataDirMove, error) {
+		return alchemy_build.DataDirMove{}, errors.New("target must be empty")
+	}
+
+	var output bytes.Buffer
+	cacheMoveCmd.SetOut(&output)
+	t.Cleanup(func() { cacheMoveCmd.SetOut(nil) })
+	if err := cacheMoveCmd.RunE(cacheMoveCmd, []string{"vagrant"}); err == nil || output.Len() != 0 {
+		t.Fatalf("expected only an error, got err=%v output=%q", err, output.String())
+	}
+}
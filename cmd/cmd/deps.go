@@ -0,0 +1,385 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	"github.com/spf13/cobra"
+)
+
+var (
+	depsPruneDryRun   bool
+	depsExportTargets []string
+	depsExportHostOS  string
+	depsFetchStrict   bool
+	depsFetchRepin    bool
+	depsVerifyFull    bool
+)
+
+var (
+	dependencyStatuses     = alchemy_build.DependencyStatuses
+	fetchDependencies      = alchemy_build.FetchDependencies
+	repinDependencies      = alchemy_build.RepinDependencies
+	pruneDependencies      = alchemy_build.PruneDependencies
+	exportDependencyBundle = alchemy_build.ExportDependencyBundle
+	importDependencyBundle = alchemy_build.ImportDependencyBundle
+	dependencyTargetsFunc  = availableBuildVirtualMachines
+)
+
+// resolveDependencyTargets selects the build targets whose dependencies a deps
+// subcommand acts on. "all" selects every build target of the host. Targets
+// that differ only by engine are all selected; their shared dependencies are
+// deduplicated by path.
+func resolveDependencyTargets(osName string, osTypeValue string, archValue string) ([]alchemy_build.VirtualMachineConfig, error) {
+	vms := dependencyTargetsFunc()
+	if osName == "all" {
+		return vms, nil
+	}
+	if osName != "ubuntu" {
+		osTypeValue = ""
+	}
+	var matches []alchemy_build.VirtualMachineConfig
+	for _, vm := range vms {
+		if vm.OS == osName && vm.UbuntuType == osTypeValue && vm.Arch == archValue {
+			matches = append(matches, vm)
+		}
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("invalid combination: OS=%s, Type=%s, Arch=%s", osName, osTypeValue, archValue)
+	}
+	return matches, nil
+}
+
+// buildVirtualMachinesForHostOS returns the build targets of hostOs, which may
+// differ from the current host when preparing a bundle for another lab.
+func buildVirtualMachinesForHostOS(hostOs alchemy_build.HostOsType) []alchemy_build.VirtualMachineConfig {
+	var supported []alchemy_build.VirtualMachineConfig
+	for _, vm := range alchemy_build.AvailableVirtualMachineConfigsForHostOS(hostOs) {
+		if isBuildSupported(vm) {
+			supported = append(supported, vm)
+		}
+	}
+	return supported
+}
+
+// resolveDependencyTargetSpecs selects targets from specs of the form
+// os/arch or ubuntu/type/arch. "all" selects every target in vms.
+func resolveDependencyTargetSpecs(vms []alchemy_build.VirtualMachineConfig, specs []string) ([]alchemy_build.VirtualMachineConfig, error) {
+	var selected []alchemy_build.VirtualMachineConfig
+	seen := map[string]bool{}
+	for _, spec := range specs {
+		if spec == "all" {
+			return vms, nil
+		}
+		parts := strings.Split(spec, "/")
+		var osName, osTypeValue, archValue string
+		switch {
+		case len(parts) == 3 && parts[0] == "ubuntu":
+			osName, osTypeValue, archValue = parts[0], parts[1], parts[2]
+		case len(parts) == 2 && parts[0] != "ubuntu":
+			osName, archValue = parts[0], parts[1]
+		default:
+			return nil, fmt.Errorf("invalid target %q; expected <os>/<arch> or ubuntu/<type>/<arch>", spec)
+		}
+		matched := false
+		for _, vm := range vms {
+			if vm.OS != osName || vm.UbuntuType != osTypeValue || vm.Arch != archValue {
+				continue
+			}
+			matched = true
+			key := fmt.Sprintf("%s/%s/%s/%s", vm.OS, vm.UbuntuType, vm.Arch, vm.VirtualizationEngine)
+			if !seen[key] {
+				seen[key] = true
+				selected = append(selected, vm)
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no build target matches %q", spec)
+		}
+	}
+	return selected, nil
+}
+
+func describeDependencyTargets(vms []alchemy_build.VirtualMachineConfig) string {
+	targets := make([]string, 0, len(vms))
+	for _, vm := range vms {
+		targets = append(targets, fmt.Sprintf("%s/%s/%s", vm.OS, displayVirtualMachineType(vm), vm.Arch))
+	}
+	return strings.Join(targets, ", ")
+}
+
+func writeDependencyStatuses(writer io.Writer, statuses []alchemy_build.DependencyStatus) {
+	if len(statuses) == 0 {
+		fmt.Fprintln(writer, "No web file dependencies are needed by the selected targets.")
+		return
+	}
+	for i, status := range statuses {
+		if i > 0 {
+			fmt.Fprintln(writer)
+		}
+		fmt.Fprintf(writer, "%s\n", status.LocalPath)
+		if status.State == alchemy_build.DependencyStateMissing {
+			fmt.Fprintf(writer, "  State:     %s\n", status.State)
+		} else {
+			fmt.Fprintf(writer, "  State:     %s (%s)\n", status.State, alchemy_build.FormatByteSize(status.SizeBytes))
+		}
+		switch {
+		case status.SourceResolvedAtFetch:
+			fmt.Fprintln(writer, "  Source:    resolved at fetch time")
+		case status.Source != "":
+			fmt.Fprintf(writer, "  Source:    %s\n", status.Source)
+		}
+		for _, fallback := range status.FallbackSources {
+			fmt.Fprintf(writer, "  Fallback:  %s\n", fallback)
+		}
+		for _, effective := range status.EffectiveSources {
+			if status.SourceOverridden {
+				fmt.Fprintf(writer, "  Effective: %s (override)\n", effective)
+			} else {
+				fmt.Fprintf(writer, "  Effective: %s (mirror)\n", effective)
+			}
+		}
+		checksum := status.Checksum
+		switch {
+		case checksum == "":
+			checksum = "none"
+		case status.ChecksumLocked:
+			checksum += " (locked)"
+		}
+		fmt.Fprintf(writer, "  Checksum:  %s\n", checksum)
+		fmt.Fprintf(writer, "  Targets:   %s\n", describeDependencyTargets(status.Targets))
+	}
+}
+
+// verifyDependencies checks the dependencies of vms against their pinned
+// checksums and fails when any is missing or does not match. Files verified
+// before and unchanged since are only re-hashed with full.
+func verifyDependencies(writer io.Writer, vms []alchemy_build.VirtualMachineConfig, full bool) error {
+	verification := alchemy_build.CachedChecksumVerification
+	if full {
+		verification = alchemy_build.FullChecksumVerification
+	}
+	statuses, err := dependencyStatuses(vms, verification)
+	if err != nil {
+		return err
+	}
+	writeDependencyStatuses(writer, statuses)
+
+	var missing, invalid int
+	for _, status := range statuses {
+		switch status.State {
+		case alchemy_build.DependencyStateMissing:
+			missing++
+		case alchemy_build.DependencyStateInvalid:
+			invalid++
+		}
+	}
+	if missing > 0 || invalid > 0 {
+		return fmt.Errorf("%d dependencies are missing and %d fail their checksum; run \"alchemy deps fetch\" to download them again", missing, invalid)
+	}
+	return nil
+}
+
+func writePrunedDependencies(writer io.Writer, pruned []alchemy_build.PrunedDependency, dryRun bool) {
+	if len(pruned) == 0 {
+		fmt.Fprintln(writer, "No unreferenced dependency downloads found.")
+		return
+	}
+	verb := "Removed"
+	if dryRun {
+		verb = "Would remove"
+	}
+	var total int64
+	for _, dep := range pruned {
+		total += dep.SizeBytes
+		fmt.Fprintf(writer, "%s %s (%s)\n", verb, dep.Path, alchemy_build.FormatByteSize(dep.SizeBytes))
+	}
+	fmt.Fprintf(writer, "%s %d file(s), %s in total\n", verb, len(pruned), alchemy_build.FormatByteSize(total))
+}
+
+var depsCmd = &cobra.Command{
+	Use:   "deps",
+	Short: "Manage downloaded build dependencies such as ISOs",
+	Long: `Lists, pre-fetches, verifies, prunes, and bundles the web file dependencies (installer
+ISOs, guest tools, firmware packages) that builds download into the cache
+directory. Builds fetch missing dependencies on their own; these commands let
+you do it ahead of time, for example while on a fast network.
+
+Examples:
+  alchemy deps list
+  alchemy deps fetch ubuntu --type server --arch amd64
+  alchemy deps fetch all
+  alchemy deps verify
+  alchemy deps prune --dry-run
+  alchemy deps export --targets ubuntu/server/amd64 bundle.tar
+  alchemy deps import bundle.tar
+`,
+}
+
+var depsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the dependencies of all build targets on this host",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		statuses, err := dependencyStatuses(dependencyTargetsFunc(), alchemy_build.SkipChecksumVerification)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Web file dependencies for host OS: %s\n\n", alchemy_build.GetCurrentHostOs())
+		writeDependencyStatuses(os.Stdout, statuses)
+		return nil
+	},
+}
+
+var depsFetchCmd = &cobra.Command{
+	Use:   "fetch <osname|all>",
+	Short: "Download the missing or invalid dependencies of build targets",
+	Long: `Downloads the missing or invalid dependencies of the selected build targets.
+
+Dependencies without a checksum in the catalog, such as the Windows ISO, are
+trusted on first download: their SHA256 is recorded in the dependency lock and
+every later download or build must match it. --repin forgets the recorded
+checksums of the selected targets so the current files are recorded again.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if depsFetchStrict && depsFetchRepin {
+			return fmt.Errorf("--repin records new checksums and cannot be combined with --strict-checksums")
+		}
+		vms, err := resolveDependencyTargets(args[0], osType, arch)
+		if err != nil {
+			return err
+		}
+		for i := range vms {
+			vms[i].StrictChecksums = depsFetchStrict
+		}
+		if depsFetchRepin {
+			removed, err := repinDependencies(vms)
+			if err != nil {
+				return fmt.Errorf("failed to repin dependencies: %w", err)
+			}
+			for _, path := range removed {
+				fmt.Printf("🔓 Forgot the recorded checksum of %s\n", path)
+			}
+		}
+		fmt.Printf("📦 Fetching dependencies for %s\n", describeDependencyTargets(vms))
+		if err := fetchDependencies(vms); err != nil {
+			return fmt.Errorf("failed to fetch dependencies: %w", err)
+		}
+		fmt.Println("✅ All dependencies are present and valid")
+		return nil
+	},
+}
+
+var depsVerifyCmd = &cobra.Command{
+	Use:   "verify [osname|all]",
+	Short: "Check cached dependencies against their pinned checksums",
+	Long: `Checks cached dependencies against their pinned checksums.
+
+A file that was verified before is not hashed again while its size,
+modification time, and inode are unchanged. Use --full to re-hash every file.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		osName := "all"
+		if len(args) == 1 {
+			osName = args[0]
+		}
+		vms, err := resolveDependencyTargets(osName, osType, arch)
+		if err != nil {
+			return err
+		}
+		if err := verifyDependencies(os.Stdout, vms, depsVerifyFull); err != nil {
+			return err
+		}
+		fmt.Println("\n✅ All dependencies are present and valid")
+		return nil
+	},
+}
+
+var depsPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove cached downloads that no dependency references any more",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pruned, err := pruneDependencies(depsPruneDryRun)
+		writePrunedDependencies(os.Stdout, pruned, depsPruneDryRun)
+		return err
+	},
+}
+
+// writeDependencyBundleManifest lists the bundled files followed by summary,
+// which is completed with the file count and total size.
+func writeDependencyBundleManifest(writer io.Writer, summary string, manifest alchemy_build.DependencyBundleManifest) {
+	var total int64
+	for _, file := range manifest.Files {
+		total += file.SizeBytes
+		fmt.Fprintf(writer, "  %s (%s)\n", file.Path, alchemy_build.FormatByteSize(file.SizeBytes))
+	}
+	fmt.Fprintf(writer, "✅ %s: %d file(s), %s in total\n", summary, len(manifest.Files), alchemy_build.FormatByteSize(total))
+}
+
+var depsExportCmd = &cobra.Command{
+	Use:   "export <bundle.tar>",
+	Short: "Pack the dependencies of build targets into an offline bundle",
+	Long: `Fetches the dependencies of the selected build targets and packs them into a
+tar archive with a manifest of SHA256 checksums. Import the bundle with
+"alchemy deps import" on a host without network access.
+
+Targets are <os>/<arch> or ubuntu/<type>/<arch>, or "all". Use --host-os to
+prepare a bundle for a lab whose hosts run a different OS.
+
+Examples:
+  alchemy deps export --targets ubuntu/server/amd64,windows11/amd64 bundle.tar
+  alchemy deps export --host-os linux --targets all bundle.tar
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hostOs, err := parseHostOS(depsExportHostOS)
+		if err != nil {
+			return err
+		}
+		vms, err := resolveDependencyTargetSpecs(buildVirtualMachinesForHostOS(hostOs), depsExportTargets)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("📦 Exporting dependencies for %s\n", describeDependencyTargets(vms))
+		manifest, err := exportDependencyBundle(vms, args[0])
+		if err != nil {
+			return err
+		}
+		writeDependencyBundleManifest(os.Stdout, "Exported dependencies to "+args[0], manifest)
+		return nil
+	},
+}
+
+var depsImportCmd = &cobra.Command{
+	Use:   "import <bundle.tar>",
+	Short: "Place the dependencies of an offline bundle into the cache dir",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		manifest, err := importDependencyBundle(args[0])
+		if err != nil {
+			return err
+		}
+		writeDependencyBundleManifest(os.Stdout, "Imported dependencies from "+args[0], manifest)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(depsCmd)
+	depsCmd.AddCommand(depsListCmd, depsFetchCmd, depsVerifyCmd, depsPruneCmd, depsExportCmd, depsImportCmd)
+
+	for _, command := range []*cobra.Command{depsFetchCmd, depsVerifyCmd} {
+		command.Flags().StringVarP(&arch, "arch", "a", "amd64", "Target architecture (e.g., amd64, arm64)")
+		command.Flags().StringVarP(&osType, "type", "t", "server", "Type of OS (e.g., server, desktop)")
+	}
+	depsFetchCmd.Flags().BoolVar(&depsFetchStrict, "strict-checksums", false, "Fail when a dependency has neither a pinned checksum nor one recorded in the dependency lock")
+	depsFetchCmd.Flags().BoolVar(&depsFetchRepin, "repin", false, "Forget the recorded checksums of the selected targets and record them again")
+	depsVerifyCmd.Flags().BoolVar(&depsVerifyFull, "full", false, "Re-hash every file instead of trusting earlier verifications of unchanged files")
+	depsPruneCmd.Flags().BoolVar(&depsPruneDryRun, "dry-run", false, "Only report what would be removed")
+	depsExportCmd.Flags().StringSliceVar(&depsExportTargets, "targets", []string{"all"}, "Comma-separated targets to bundle (<os>/<arch>, ubuntu/<type>/<arch>, or all)")
+	depsExportCmd.Flags().StringVar(&depsExportHostOS, "host-os", "", "Host OS the bundle is for (linux, windows, darwin); defaults to the current host")
+}
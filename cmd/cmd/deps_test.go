@@ -0,0 +1,180 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+)
+
+func stubDependencyTargets(t *testing.T, vms []alchemy_build.VirtualMachineConfig) {
+	t.Helper()
+	previousTargets := dependencyTargetsFunc
+	previousStatuses := dependencyStatuses
+	t.Cleanup(func() {
+		dependencyTargetsFunc = previousTargets
+		dependencyStatuses = previousStatuses
+	})
+	dependencyTargetsFunc = func() []alchemy_build.VirtualMachineConfig { return vms }
+}
+
+func TestResolveDependencyTargetsSelectsSingleTargetOrAll(t *testing.T) {
+	arm64 := ubuntuQemuBuildTarget()
+	arm64.Arch = "arm64"
+	stubDependencyTargets(t, []alchemy_build.VirtualMachineConfig{ubuntuQemuBuildTarget(), arm64})
+
+	vms, err := resolveDependencyTargets("ubuntu", "server", "arm64")
+	if err != nil {
+		t.Fatalf("resolveDependencyTargets returned error: %v", err)
+	}
+	if len(vms) != 1 || vms[0].Arch != "arm64" {
+		t.Fatalf("expected the arm64 target, got %+v", vms)
+	}
+
+	vms, err = resolveDependencyTargets("all", "server", "amd64")
+	if err != nil || len(vms) != 2 {
+		t.Fatalf("expected all targets, got %+v err=%v", vms, err)
+	}
+
+	if _, err := resolveDependencyTargets("windows11", "server", "amd64"); err == nil {
+		t.Fatal("expected an unavailable target to be rejected")
+	}
+}
+
+func TestResolveDependencyTargetsSelectsEveryEngineOfTarget(t *testing.T) {
+	hyperv := alchemy_build.VirtualMachineConfig{OS: "windows11", Arch: "amd64", HostOs: alchemy_build.HostOsWindows, VirtualizationEngine: alchemy_build.VirtualizationEngineHyperv}
+	virtualbox := hyperv
+	virtualbox.VirtualizationEngine = alchemy_build.VirtualizationEngineVirtualBox
+	stubDependencyTargets(t, []alchemy_build.VirtualMachineConfig{hyperv, virtualbox})
+
+	vms, err := resolveDependencyTargets("windows11", "server", "amd64")
+	if err != nil {
+		t.Fatalf("resolveDependencyTargets returned error: %v", err)
+	}
+	if len(vms) != 2 || vms[0].VirtualizationEngine != hyperv.VirtualizationEngine || vms[1].VirtualizationEngine != virtualbox.VirtualizationEngine {
+		t.Fatalf("expected both engines of windows11/amd64, got %+v", vms)
+	}
+}
+
+func TestWriteDependencyStatusesDescribesEachDependency(t *testing.T) {
+	var output bytes.Buffer
+	writeDependencyStatuses(&output, []alchemy_build.DependencyStatus{
+		{
+			LocalPath:       "/cache/linux/ubuntu.iso",
+			Source:          "https://releases.ubuntu.com/ubuntu.iso",
+			FallbackSources: []string{"https://mirror.example/ubuntu.iso"},
+			Checksum:        "sha256:abc",
+			State:           alchemy_build.DependencyStateValid,
+			SizeBytes:       2 << 30,
+			Targets:         []alchemy_build.VirtualMachineConfig{ubuntuQemuBuildTarget()},
+		},
+		{
+			LocalPath:             "/cache/windows11/iso/win11.iso",
+			SourceResolvedAtFetch: true,
+			State:                 alchemy_build.DependencyStateMissing,
+		},
+		{
+			LocalPath:             "/cache/windows11/iso/win11-arm64.iso",
+			SourceResolvedAtFetch: true,
+			Checksum:              "sha256:def",
+			ChecksumLocked:        true,
+			State:                 alchemy_build.DependencyStatePresent,
+		},
+		{
+			LocalPath:        "/cache/windows/virtio-win.iso",
+			Source:           "https://fedorapeople.org/virtio-win.iso",
+			EffectiveSources: []string{"https://artifacts.example/virtio-win.iso"},
+			SourceOverridden: true,
+			State:            alchemy_build.DependencyStateMissing,
+		},
+	})
+
+	for _, want := range []string{
+		"State:     valid (2.0 GiB)",
+		"Source:    https://releases.ubuntu.com/ubuntu.iso",
+		"Fallback:  https://mirror.example/ubuntu.iso",
+		"Targets:   ubuntu/server/amd64",
+		"State:     missing\n",
+		"Source:    resolved at fetch time",
+		"Checksum:  none",
+		"Checksum:  sha256:def (locked)",
+		"Effective: https://artifacts.example/virtio-win.iso (override)",
+	} {
+		if !strings.Contains(output.String(), want) {
+			t.Fatalf("expected output to contain %q, got:\n%s", want, output.String())
+		}
+	}
+}
+
+func TestVerifyDependenciesFailsOnMissingOrInvalidFiles(t *testing.T) {
+	stubDependencyTargets(t, nil)
+	dependencyStatuses = func(vms []alchemy_build.VirtualMachineConfig, verification alchemy_build.ChecksumVerification) ([]alchemy_build.DependencyStatus, error) {
+		if verification != alchemy_build.FullChecksumVerification {
+			t.Fatalf("expected --full to re-hash every file, got %v", verification)
+		}
+		return []alchemy_build.DependencyStatus{
+			{LocalPath: "/cache/a.iso", State: alchemy_build.DependencyStateValid},
+			{LocalPath: "/cache/b.iso", State: alchemy_build.DependencyStateInvalid},
+			{LocalPath: "/cache/c.iso", State: alchemy_build.DependencyStateMissing},
+		}, nil
+	}
+
+	var output bytes.Buffer
+	err := verifyDependencies(&output, nil, true)
+	if err == nil || !strings.Contains(err.Error(), "1 dependencies are missing and 1 fail their checksum") {
+		t.Fatalf("expected verification failure, got %v", err)
+	}
+}
+
+func TestWritePrunedDependenciesReportsDryRun(t *testing.T) {
+	var output bytes.Buffer
+	writePrunedDependencies(&output, []alchemy_build.PrunedDependency{{Path: "/cache/old.iso", SizeBytes: 1 << 20}}, true)
+
+	if !strings.Contains(output.String(), "Would remove /cache/old.iso (1.0 MiB)") {
+		t.Fatalf("expected dry-run report, got %q", output.String())
+	}
+}
+
+func TestResolveDependencyTargetSpecsParsesOsTypeAndArch(t *testing.T) {
+	windows := alchemy_build.VirtualMachineConfig{
+		OS:                   "windows11",
+		Arch:                 "arm64",
+		HostOs:               alchemy_build.HostOsLinux,
+		VirtualizationEngine: alchemy_build.VirtualizationEngineQemu,
+	}
+	vms := []alchemy_build.VirtualMachineConfig{ubuntuQemuBuildTarget(), windows}
+
+	selected, err := resolveDependencyTargetSpecs(vms, []string{"ubuntu/server/amd64", "windows11/arm64", "ubuntu/server/amd64"})
+	if err != nil {
+		t.Fatalf("resolveDependencyTargetSpecs returned error: %v", err)
+	}
+	if len(selected) != 2 || selected[0].OS != "ubuntu" || selected[1].OS != "windows11" {
+		t.Fatalf("expected ubuntu and windows targets once each, got %+v", selected)
+	}
+
+	if selected, err := resolveDependencyTargetSpecs(vms, []string{"all"}); err != nil || len(selected) != 2 {
+		t.Fatalf("expected all targets, got %+v err=%v", selected, err)
+	}
+	for _, spec := range []string{"ubuntu/amd64", "windows11/server/amd64", "ubuntu/desktop/amd64"} {
+		if _, err := resolveDependencyTargetSpecs(vms, []string{spec}); err == nil {
+			t.Fatalf("expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestWriteDependencyBundleManifestSummarizesFiles(t *testing.T) {
+	var output bytes.Buffer
+	writeDependencyBundleManifest(&output, "Exported dependencies to bundle.tar", alchemy_build.DependencyBundleManifest{
+		Files: []alchemy_build.DependencyBundleFile{
+			{Path: "linux/ubuntu.iso", SizeBytes: 1 << 20},
+			{Path: "windows/virtio-win.iso", SizeBytes: 1 << 20},
+		},
+	})
+
+	for _, want := range []string{"  linux/ubuntu.iso (1.0 MiB)", "Exported dependencies to bundle.tar: 2 file(s), 2.0 MiB in total"} {
+		if !strings.Contains(output.String(), want) {
+			t.Fatalf("expected output to contain %q, got:\n%s", want, output.String())
+		}
+	}
+}
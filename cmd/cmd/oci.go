@@ -1,10 +1,13 @@
 package cmd
 
 import (
+	"cmp"
 	"context"
+	"crypto/ed25519"
 	"fmt"
 	"io"
 	"os"
+	"slices"
 	"strings"
 
 	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
@@ -17,33 +20,61 @@ import (
 type ociTransferRunner func(*cobra.Command, context.Context, alchemy_build.VirtualMachineConfig, string, alchemy_oci.RegistryOptions, alchemy_oci.TransferProgress) (alchemy_oci.TransferResult, error)
 
 var (
-	ociOS                       string
-	ociType                     string
-	ociArch                     string
-	ociEngine                   string
-	ociHostOS                   string
-	ociPlainHTTP                bool
-	ociInsecureSkipTLSVerify    bool
-	ociCAFile                   string
-	ociUsername                 string
-	ociPassword                 string
-	ociPasswordStdin            bool
-	ociAccessToken              string
-	ociRefreshToken             string
-	ociDisableDockerCredentials bool
-	ociAssumeYes                bool
+	ociOS                   string
+	ociType                 string
+	ociArch                 string
+	ociEngine               string
+	ociHostOS               string
+	ociRegistry             ociRegistryFlags
+	ociAssumeYes            bool
+	ociChunked              bool
+	ociChunkSizeMiB         int64
+	ociContentDefinedChunks bool
+	ociSign                 bool
+	ociSigningKeyFile       string
+	ociVerify               bool
+	ociVerificationKeyFiles []string
+	ociAttachBuildLog       string
+	ociAttachProvenance     bool
+	ociAttachRecording      bool
+	ociIndex                bool
 
 	runOCIPush ociTransferRunner = func(_ *cobra.Command, ctx context.Context, vm alchemy_build.VirtualMachineConfig, reference string, opts alchemy_oci.RegistryOptions, progress alchemy_oci.TransferProgress) (alchemy_oci.TransferResult, error) {
-		return alchemy_oci.Push(ctx, vm, reference, alchemy_oci.PushOptions{RegistryOptions: opts, Progress: progress})
+		signingKey, err := ociPushSigningKey()
+		if err != nil {
+			return alchemy_oci.TransferResult{}, err
+		}
+		recordingPath, err := ociPushRecordingPath(vm)
+		if err != nil {
+			return alchemy_oci.TransferResult{}, err
+		}
+		return alchemy_oci.Push(ctx, vm, reference, alchemy_oci.PushOptions{
+			RegistryOptions:        opts,
+			Progress:               progress,
+			ChunkSize:              ociPushChunkSize(),
+			ContentDefinedChunking: ociContentDefinedChunks,
+			SigningKey:             signingKey,
+			BuildLogPath:           ociAttachBuildLog,
+			RecordingPath:          recordingPath,
+			AttachProvenance:       ociAttachProvenance,
+			Index:                  ociIndex,
+		})
 	}
 	runOCIPull ociTransferRunner = func(cmd *cobra.Command, ctx context.Context, vm alchemy_build.VirtualMachineConfig, reference string, opts alchemy_oci.RegistryOptions, progress alchemy_oci.TransferProgress) (alchemy_oci.TransferResult, error) {
+		verificationKeys, err := ociPullVerificationKeys(reference)
+		if err != nil {
+			return alchemy_oci.TransferResult{}, err
+		}
 		return alchemy_oci.Pull(ctx, vm, reference, alchemy_oci.PullOptions{
 			RegistryOptions:           opts,
 			Progress:                  progress,
 			ConfirmForeignArtifactUse: confirmOCIForeignArtifactUse(cmd),
+			VerificationKeys:          verificationKeys,
 		})
 	}
 	inspectOCIArtifactState = localOCIArtifactState
+	resolveOCIIndexTarget   = alchemy_oci.ResolveIndexTarget
+	loadOCITrustPolicy      = alchemy_oci.LoadTrustPolicy
 )
 
 func isOCISupported(vm alchemy_build.VirtualMachineConfig) bool {
@@ -194,6 +225,54 @@ func resolveOCIVirtualMachine(hostOsValue string, osName string, osTypeValue str
 	)
 }
 
+// resolveOCIPullVirtualMachine selects the local target of a pull. Without
+// --os, the target is the best entry of the multi-target index at reference
+// for this host: the flags the user set narrow the candidates, and targets of
+// the host architecture are preferred.
+func resolveOCIPullVirtualMachine(cmd *cobra.Command, reference string, options alchemy_oci.RegistryOptions) (alchemy_build.VirtualMachineConfig, error) {
+	if ociOS != "" {
+		return resolveOCIVirtualMachine(ociHostOS, ociOS, ociType, ociArch, ociEngine)
+	}
+	hostOs, err := parseHostOS(ociHostOS)
+	if err != nil {
+		return alchemy_build.VirtualMachineConfig{}, err
+	}
+
+	var candidates []alchemy_build.VirtualMachineConfig
+	for _, vm := range availableOCIVirtualMachinesForHostOS(hostOs) {
+		if cmd.Flags().Changed("type") && vm.OS == "ubuntu" && vm.UbuntuType != ociType {
+			continue
+		}
+		if cmd.Flags().Changed("arch") && vm.Arch != ociArch {
+			continue
+		}
+		if cmd.Flags().Changed("engine") && vm.VirtualizationEngine != alchemy_build.VirtualizationEngine(strings.ToLower(ociEngine)) {
+			continue
+		}
+		candidates = append(candidates, vm)
+	}
+	hostArch := currentHostArchitectureFunc()
+	slices.SortStableFunc(candidates, func(a, b alchemy_build.VirtualMachineConfig) int {
+		return cmp.Compare(boolRank(a.Arch != hostArch), boolRank(b.Arch != hostArch))
+	})
+
+	vm, isIndex, err := resolveOCIIndexTarget(cmd.Context(), reference, options, candidates)
+	if err != nil {
+		return alchemy_build.VirtualMachineConfig{}, err
+	}
+	if !isIndex {
+		return alchemy_build.VirtualMachineConfig{}, fmt.Errorf("missing required --os value; %s is a single-target artifact, not a multi-target index", reference)
+	}
+	return vm, nil
+}
+
+func boolRank(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
 func parseHostOS(value string) (alchemy_build.HostOsType, error) {
 	if value == "" {
 		return alchemy_build.GetCurrentHostOs(), nil
@@ -211,10 +290,87 @@ func parseHostOS(value string) (alchemy_build.HostOsType, error) {
 	}
 }
 
-func ociRegistryOptions(cmd *cobra.Command) (alchemy_oci.RegistryOptions, error) {
-	password := ociPassword
-	if ociPasswordStdin {
-		data, err := io.ReadAll(cmd.InOrStdin())
+func ociPushChunkSize() int64 {
+	if !ociChunked && !ociContentDefinedChunks {
+		return 0
+	}
+	return ociChunkSizeMiB << 20
+}
+
+func ociPushSigningKey() (ed25519.PrivateKey, error) {
+	if !ociSign {
+		return nil, nil
+	}
+	return alchemy_oci.LoadSigningKey(ociSigningKeyFile)
+}
+
+// ociPushRecordingPath returns the VNC recording of vm's build when
+// --attach-recording is set.
+func ociPushRecordingPath(vm alchemy_build.VirtualMachineConfig) (string, error) {
+	if !ociAttachRecording {
+		return "", nil
+	}
+	path := alchemy_build.VncRecordingVideoPath(vm)
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("--attach-recording needs the VNC recording of the build at %s: %w", path, err)
+	}
+	return path, nil
+}
+
+// ociPullVerificationKeys returns the keys a pull of reference must be signed
+// with: the --key files, or else the keys of the trust policy scope matching
+// the repository. Without either, the pull is not verified unless --verify
+// demands it.
+func ociPullVerificationKeys(reference string) ([]ed25519.PublicKey, error) {
+	if len(ociVerificationKeyFiles) > 0 {
+		keys := make([]ed25519.PublicKey, 0, len(ociVerificationKeyFiles))
+		for _, path := range ociVerificationKeyFiles {
+			key, err := alchemy_oci.LoadVerificationKey(path)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+		return keys, nil
+	}
+
+	policy, policyPath, exists, err := loadOCITrustPolicy()
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		keys, ok, err := policy.KeysFor(reference)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return keys, nil
+		}
+	}
+	if ociVerify {
+		return nil, fmt.Errorf("--verify needs --key or a trust policy scope in %s matching %s", policyPath, reference)
+	}
+	return nil, nil
+}
+
+// ociRegistryFlags are the registry connection and credential flags of a
+// command, or of one side of "oci copy".
+type ociRegistryFlags struct {
+	plainHTTP                bool
+	insecureSkipTLSVerify    bool
+	caFile                   string
+	username                 string
+	password                 string
+	passwordStdin            bool
+	accessToken              string
+	refreshToken             string
+	disableDockerCredentials bool
+}
+
+func (flags ociRegistryFlags) options(stdin io.Reader) (alchemy_oci.RegistryOptions, error) {
+	password := flags.password
+	if flags.passwordStdin {
+		data, err := io.ReadAll(stdin)
 		if err != nil {
 			return alchemy_oci.RegistryOptions{}, fmt.Errorf("read OCI registry password from stdin: %w", err)
 		}
@@ -222,17 +378,21 @@ func ociRegistryOptions(cmd *cobra.Command) (alchemy_oci.RegistryOptions, error)
 	}
 
 	return alchemy_oci.RegistryOptions{
-		PlainHTTP:                ociPlainHTTP,
-		InsecureSkipTLSVerify:    ociInsecureSkipTLSVerify,
-		CAFile:                   ociCAFile,
-		Username:                 ociUsername,
+		PlainHTTP:                flags.plainHTTP,
+		InsecureSkipTLSVerify:    flags.insecureSkipTLSVerify,
+		CAFile:                   flags.caFile,
+		Username:                 flags.username,
 		Password:                 password,
-		AccessToken:              ociAccessToken,
-		RefreshToken:             ociRefreshToken,
-		DisableDockerCredentials: ociDisableDockerCredentials,
+		AccessToken:              flags.accessToken,
+		RefreshToken:             flags.refreshToken,
+		DisableDockerCredentials: flags.disableDockerCredentials,
 	}, nil
 }
 
+func ociRegistryOptions(cmd *cobra.Command) (alchemy_oci.RegistryOptions, error) {
+	return ociRegistry.options(cmd.InOrStdin())
+}
+
 func confirmOCIForeignArtifactUse(cmd *cobra.Command) alchemy_oci.ForeignArtifactConfirmation {
 	return func(ctx context.Context, foreign alchemy_oci.ForeignArtifact) (bool, error) {
 		if err := ctx.Err(); err != nil {
@@ -368,6 +528,33 @@ func printOCITransferResult(action string, result alchemy_oci.TransferResult) {
 	for _, artifact := range result.Artifacts {
 		fmt.Printf("Artifact: %s -> %s\n", artifact.Name, artifact.Path)
 	}
+	if result.Index != "" {
+		fmt.Printf("Index: %s\n", result.Index)
+	}
+	if result.Signature != "" {
+		fmt.Printf("Signature: %s\n", result.Signature)
+	}
+	for _, referrer := range result.Referrers {
+		fmt.Printf("Referrer: %s %s (%s)\n", referrer.Kind, referrer.Digest, referrer.Title)
+	}
+	if result.ResumedBytes > 0 {
+		fmt.Printf("Resumed: %s already staged by an interrupted pull\n", alchemy_build.FormatByteSize(result.ResumedBytes))
+	}
+	if result.ReusedBytes > 0 {
+		total := result.TransferredBytes + result.ReusedBytes + result.ResumedBytes
+		fmt.Printf(
+			"Transferred: %s, reused: %s of %s (%.0f%% saved)\n",
+			alchemy_build.FormatByteSize(result.TransferredBytes),
+			alchemy_build.FormatByteSize(result.ReusedBytes),
+			alchemy_build.FormatByteSize(total),
+			float64(result.ReusedBytes)*100/float64(total),
+		)
+	}
+}
+
+var ociCmd = &cobra.Command{
+	Use:   "oci",
+	Short: "Work with OCI build artifacts outside of push and pull",
 }
 
 var pushCmd = &cobra.Command{
@@ -378,9 +565,35 @@ var pushCmd = &cobra.Command{
 Examples:
   alchemy push localhost:5000/dev-alchemy/ubuntu-server-amd64:qemu --plain-http --os ubuntu --type server --arch amd64
   alchemy push ghcr.io/example/dev-alchemy/windows11-amd64:hyperv --os windows11 --arch amd64 --engine hyperv --host-os windows
+  alchemy push ghcr.io/example/dev-alchemy/windows11-amd64:qemu --os windows11 --arch amd64 --chunked --chunk-size-mib 128
+  alchemy push ghcr.io/example/dev-alchemy/ubuntu-server-amd64:qemu --os ubuntu --type server --arch amd64 --content-defined-chunks
+
+With --chunked, each artifact is split into zstd-compressed chunk layers that
+are reassembled and verified on pull. Pulls accept both layer formats.
+With --content-defined-chunks, chunk boundaries follow the artifact content so
+unchanged regions of a rebuilt image keep their chunk digests: the registry
+skips chunks it already has, and pull reuses chunks from the previously pulled
+artifact in the local cache. --chunk-size-mib is then the average chunk size.
+With --sign --key <file>, the manifest is signed with the Ed25519 private key
+and the signature is attached as an OCI referrer. "alchemy oci keygen" creates
+a key pair.
+--attach-build-log <file>, --attach-provenance and --attach-recording attach
+the build log, a provenance document with the target and the build metadata of
+each artifact, and the VNC recording of the build as OCI referrers. List and
+download them with "alchemy oci referrers" and "alchemy oci fetch-referrer".
+With --index, the manifest is pushed by digest and added to the multi-target
+OCI index under the tag, replacing the entry of the same target. Push each
+target of a release to the same tag, one push after another; pull then
+selects the entry for its host.
 `,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if (ociChunked || ociContentDefinedChunks) && ociChunkSizeMiB <= 0 {
+			return fmt.Errorf("--chunk-size-mib must be positive, got %d", ociChunkSizeMiB)
+		}
+		if ociSign && ociSigningKeyFile == "" {
+			return fmt.Errorf("--sign needs --key with the private signing key")
+		}
 		result, err := runOCITransfer(cmd, args[0], "pushing", runOCIPush)
 		if err != nil {
 			return err
@@ -407,10 +620,33 @@ var pullCmd = &cobra.Command{
 Examples:
   alchemy pull localhost:5000/dev-alchemy/ubuntu-server-amd64:qemu --plain-http --os ubuntu --type server --arch amd64
   alchemy pull ghcr.io/example/dev-alchemy/windows11-amd64:hyperv --os windows11 --arch amd64 --engine hyperv --host-os windows
+  alchemy pull ghcr.io/example/dev-alchemy/ubuntu-server:2026.10
+
+When the tag holds a multi-target index published with "alchemy push --index",
+--os may be omitted: pull selects the index entry for the host OS, preferring
+the host architecture. --type, --arch and --engine narrow the selection.
+
+Downloaded blobs are staged by digest below the local artifact root. If a pull
+is interrupted, running it again resumes from the staged data.
+
+With --verify --key <file>, the pull refuses an artifact without a valid
+signature from the Ed25519 public key before any layer is downloaded. --key
+may be repeated. Without --key, the keys come from the trust policy
+oci-trust-policy.yml in the Dev Alchemy config directory, or the file named by
+DEV_ALCHEMY_OCI_TRUST_POLICY; a repository matching a policy scope is always
+verified.
 `,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		result, err := runOCITransfer(cmd, args[0], "pulling", runOCIPull)
+		options, err := ociRegistryOptions(cmd)
+		if err != nil {
+			return err
+		}
+		vm, err := resolveOCIPullVirtualMachine(cmd, args[0], options)
+		if err != nil {
+			return err
+		}
+		result, err := runOCIPull(cmd, cmd.Context(), vm, args[0], options, newOCIProgressReporter("pulling", cmd.ErrOrStderr()))
 		if err != nil {
 			return err
 		}
@@ -430,19 +666,33 @@ var pullListCmd = &cobra.Command{
 
 func addOCIFlags(command *cobra.Command) {
 	command.Flags().StringVar(&ociOS, "os", "", "Target operating system for the build artifact (e.g., ubuntu, windows11)")
+	addOCITargetFlags(command)
+	addOCIRegistryFlags(command)
+}
+
+func addOCITargetFlags(command *cobra.Command) {
 	command.Flags().StringVarP(&ociType, "type", "t", "server", "Type of OS for Ubuntu artifacts (e.g., server, desktop)")
 	command.Flags().StringVarP(&ociArch, "arch", "a", "amd64", "Target architecture (e.g., amd64, arm64)")
 	command.Flags().StringVar(&ociEngine, "engine", "", "Virtualization engine for the build artifact (e.g., qemu, utm, hyperv, virtualbox)")
 	command.Flags().StringVar(&ociHostOS, "host-os", string(alchemy_build.GetCurrentHostOs()), "Host OS that owns the build artifact shape (linux/debian, windows, darwin/macos)")
-	command.Flags().BoolVar(&ociPlainHTTP, "plain-http", false, "Use plain HTTP for the OCI registry")
-	command.Flags().BoolVar(&ociInsecureSkipTLSVerify, "insecure-skip-tls-verify", false, "Skip TLS certificate verification for HTTPS OCI registries")
-	command.Flags().StringVar(&ociCAFile, "ca-file", "", "Path to a PEM CA certificate bundle to trust for HTTPS OCI registries")
-	command.Flags().StringVar(&ociUsername, "username", "", "OCI registry username")
-	command.Flags().StringVar(&ociPassword, "password", "", "OCI registry password")
-	command.Flags().BoolVar(&ociPasswordStdin, "password-stdin", false, "Read OCI registry password from stdin")
-	command.Flags().StringVar(&ociAccessToken, "access-token", "", "OCI registry bearer access token")
-	command.Flags().StringVar(&ociRefreshToken, "refresh-token", "", "OCI registry refresh token")
-	command.Flags().BoolVar(&ociDisableDockerCredentials, "no-docker-credentials", false, "Do not read credentials from Docker's credential store")
+}
+
+func addOCIRegistryFlags(command *cobra.Command) {
+	addOCIRegistryFlagSet(command, "", "the OCI registry", &ociRegistry)
+}
+
+// addOCIRegistryFlagSet registers the registry flags of flags on command,
+// each name prefixed with prefix and each usage naming registry.
+func addOCIRegistryFlagSet(command *cobra.Command, prefix string, registry string, flags *ociRegistryFlags) {
+	command.Flags().BoolVar(&flags.plainHTTP, prefix+"plain-http", false, "Use plain HTTP for "+registry)
+	command.Flags().BoolVar(&flags.insecureSkipTLSVerify, prefix+"insecure-skip-tls-verify", false, "Skip TLS certificate verification for "+registry)
+	command.Flags().StringVar(&flags.caFile, prefix+"ca-file", "", "Path to a PEM CA certificate bundle to trust for "+registry)
+	command.Flags().StringVar(&flags.username, prefix+"username", "", "Username for "+registry)
+	command.Flags().StringVar(&flags.password, prefix+"password", "", "Password for "+registry)
+	command.Flags().BoolVar(&flags.passwordStdin, prefix+"password-stdin", false, "Read the password for "+registry+" from stdin")
+	command.Flags().StringVar(&flags.accessToken, prefix+"access-token", "", "Bearer access token for "+registry)
+	command.Flags().StringVar(&flags.refreshToken, prefix+"refresh-token", "", "Refresh token for "+registry)
+	command.Flags().BoolVar(&flags.disableDockerCredentials, prefix+"no-docker-credentials", false, "Do not read credentials for "+registry+" from Docker's credential store")
 }
 
 func addOCIListFlags(command *cobra.Command) {
@@ -452,11 +702,23 @@ func addOCIListFlags(command *cobra.Command) {
 func init() {
 	rootCmd.AddCommand(pushCmd)
 	rootCmd.AddCommand(pullCmd)
+	rootCmd.AddCommand(ociCmd)
 	pushCmd.AddCommand(pushListCmd)
 	pullCmd.AddCommand(pullListCmd)
 	addOCIFlags(pushCmd)
 	addOCIFlags(pullCmd)
+	pushCmd.Flags().BoolVar(&ociChunked, "chunked", false, "Push each artifact as zstd-compressed chunk layers instead of a single layer")
+	pushCmd.Flags().Int64Var(&ociChunkSizeMiB, "chunk-size-mib", alchemy_oci.DefaultChunkSize>>20, "Uncompressed size of each chunk in MiB when --chunked is set, or the average size with --content-defined-chunks")
+	pushCmd.Flags().BoolVar(&ociContentDefinedChunks, "content-defined-chunks", false, "Push chunk layers cut at content-defined boundaries so unchanged chunks are shared between image versions (implies --chunked)")
+	pushCmd.Flags().BoolVar(&ociSign, "sign", false, "Sign the pushed manifest and attach the signature as an OCI referrer")
+	pushCmd.Flags().StringVar(&ociSigningKeyFile, "key", "", "PEM Ed25519 private key used with --sign")
+	pushCmd.Flags().StringVar(&ociAttachBuildLog, "attach-build-log", "", "Attach this build log file as an OCI referrer")
+	pushCmd.Flags().BoolVar(&ociAttachProvenance, "attach-provenance", false, "Attach a provenance document with the target and artifact build metadata as an OCI referrer")
+	pushCmd.Flags().BoolVar(&ociIndex, "index", false, "Add the pushed manifest to the multi-target OCI index under the tag instead of tagging it directly")
+	pushCmd.Flags().BoolVar(&ociAttachRecording, "attach-recording", false, "Attach the VNC recording of the build as an OCI referrer")
 	pullCmd.Flags().BoolVarP(&ociAssumeYes, "yes", "y", false, "Accept compatible foreign darwin/linux OCI build artifacts without prompting")
+	pullCmd.Flags().BoolVar(&ociVerify, "verify", false, "Refuse artifacts without a valid signature from a trusted key")
+	pullCmd.Flags().StringArrayVar(&ociVerificationKeyFiles, "key", nil, "PEM Ed25519 public key trusted for signature verification (repeatable)")
 	addOCIListFlags(pushListCmd)
 	addOCIListFlags(pullListCmd)
 }
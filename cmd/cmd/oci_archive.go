@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	alchemy_oci "github.com/csautter/dev-alchemy/pkg/oci"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ociExportOutput        string
+	ociImportAllowUnsigned bool
+
+	runOCIExport = func(_ *cobra.Command, ctx context.Context, vm alchemy_build.VirtualMachineConfig, outputPath string, progress alchemy_oci.TransferProgress) (alchemy_oci.TransferResult, error) {
+		return alchemy_oci.Export(ctx, vm, outputPath, alchemy_oci.ExportOptions{Progress: progress, ChunkSize: ociPushChunkSize(), ContentDefinedChunking: ociContentDefinedChunks})
+	}
+	runOCIImport = func(cmd *cobra.Command, ctx context.Context, vm alchemy_build.VirtualMachineConfig, archivePath string, progress alchemy_oci.TransferProgress) (alchemy_oci.TransferResult, error) {
+		return alchemy_oci.Import(ctx, vm, archivePath, alchemy_oci.ImportOptions{
+			Progress:                  progress,
+			ConfirmForeignArtifactUse: confirmOCIForeignArtifactUse(cmd),
+		})
+	}
+	readOCIArchiveTarget = alchemy_oci.ReadArchiveTarget
+)
+
+// checkOCIImportTrustPolicy refuses an import when the trust policy requires
+// signatures for every repository. Archives carry no signature referrers, so
+// such an import can only go ahead with --allow-unsigned.
+func checkOCIImportTrustPolicy(archivePath string) error {
+	if ociImportAllowUnsigned {
+		return nil
+	}
+	policy, policyPath, exists, err := loadOCITrustPolicy()
+	if err != nil {
+		return err
+	}
+	if exists && policy.CoversEveryRepository() {
+		return fmt.Errorf("the trust policy in %s requires signatures for every repository, but %s carries none; pass --allow-unsigned to import it anyway", policyPath, archivePath)
+	}
+	return nil
+}
+
+// resolveOCIImportVirtualMachine selects the local target of an import. Target
+// flags the user did not set are taken from the archive annotations, and the
+// archived engine is only used when the archive was built for this host OS.
+func resolveOCIImportVirtualMachine(cmd *cobra.Command, archived alchemy_oci.ArchiveTarget) (alchemy_build.VirtualMachineConfig, error) {
+	osName, osType, arch, engine := ociOS, ociType, ociArch, ociEngine
+	if !cmd.Flags().Changed("os") {
+		osName = archived.OS
+	}
+	if !cmd.Flags().Changed("type") && archived.UbuntuType != "" {
+		osType = archived.UbuntuType
+	}
+	if !cmd.Flags().Changed("arch") && archived.Arch != "" {
+		arch = archived.Arch
+	}
+	if !cmd.Flags().Changed("engine") {
+		hostOs, err := parseHostOS(ociHostOS)
+		if err != nil {
+			return alchemy_build.VirtualMachineConfig{}, err
+		}
+		if archived.HostOS == string(hostOs) {
+			engine = archived.VirtualizationEngine
+		}
+	}
+	return resolveOCIVirtualMachine(ociHostOS, osName, osType, arch, engine)
+}
+
+var ociExportCmd = &cobra.Command{
+	Use:   "export <osname>",
+	Short: "Export VM build artifacts to an OCI image-layout archive",
+	Long: `Writes the final VM build artifact for a selected Dev Alchemy target to an OCI
+image-layout tar archive with the same manifest and annotations that push would
+publish, so the image can be moved to another machine without a registry.
+
+Examples:
+  alchemy oci export ubuntu --type server --arch amd64 --output ubuntu-server-amd64.tar
+  alchemy oci export windows11 --arch amd64 --engine hyperv --host-os windows --output windows11.tar --chunked
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if (ociChunked || ociContentDefinedChunks) && ociChunkSizeMiB <= 0 {
+			return fmt.Errorf("--chunk-size-mib must be positive, got %d", ociChunkSizeMiB)
+		}
+		vm, err := resolveOCIVirtualMachine(ociHostOS, args[0], ociType, ociArch, ociEngine)
+		if err != nil {
+			return err
+		}
+		result, err := runOCIExport(cmd, cmd.Context(), vm, ociExportOutput, newOCIProgressReporter("exporting", cmd.ErrOrStderr()))
+		if err != nil {
+			return err
+		}
+		printOCITransferResult("Exported", result)
+		return nil
+	},
+}
+
+var ociImportCmd = &cobra.Command{
+	Use:   "import <image.tar>",
+	Short: "Import VM build artifacts from an OCI image-layout archive",
+	Long: `Imports an OCI image-layout tar archive written by "alchemy oci export" into the
+local artifact cache. The archive is validated like a pull, including the
+confirmation for compatible foreign darwin/linux build artifacts.
+
+Archives carry no signatures, so an import is never verified. When the trust
+policy has a "*" scope that requires signatures for every repository, import
+refuses the archive unless --allow-unsigned is set.
+
+The target is read from the archive annotations; --os, --type, --arch, --engine
+and --host-os override it.
+
+Examples:
+  alchemy oci import ubuntu-server-amd64.tar
+  alchemy oci import ubuntu-server-arm64.tar --engine qemu --yes
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkOCIImportTrustPolicy(args[0]); err != nil {
+			return err
+		}
+		archived, err := readOCIArchiveTarget(cmd.Context(), args[0])
+		if err != nil {
+			return err
+		}
+		vm, err := resolveOCIImportVirtualMachine(cmd, archived)
+		if err != nil {
+			return err
+		}
+		result, err := runOCIImport(cmd, cmd.Context(), vm, args[0], newOCIProgressReporter("importing", cmd.ErrOrStderr()))
+		if err != nil {
+			return err
+		}
+		printOCITransferResult("Imported", result)
+		return nil
+	},
+}
+
+func init() {
+	ociCmd.AddCommand(ociExportCmd)
+	ociCmd.AddCommand(ociImportCmd)
+	addOCITargetFlags(ociExportCmd)
+	ociExportCmd.Flags().StringVarP(&ociExportOutput, "output", "o", "", "Path of the OCI image-layout tar archive to write")
+	_ = ociExportCmd.MarkFlagRequired("output")
+	ociExportCmd.Flags().BoolVar(&ociChunked, "chunked", false, "Export each artifact as zstd-compressed chunk layers instead of a single layer")
+	ociExportCmd.Flags().Int64Var(&ociChunkSizeMiB, "chunk-size-mib", alchemy_oci.DefaultChunkSize>>20, "Uncompressed size of each chunk in MiB when --chunked is set, or the average size with --content-defined-chunks")
+	ociExportCmd.Flags().BoolVar(&ociContentDefinedChunks, "content-defined-chunks", false, "Export chunk layers cut at content-defined boundaries (implies --chunked)")
+	ociImportCmd.Flags().StringVar(&ociOS, "os", "", "Target operating system for the build artifact; defaults to the archived target")
+	addOCITargetFlags(ociImportCmd)
+	ociImportCmd.Flags().BoolVarP(&ociAssumeYes, "yes", "y", false, "Accept compatible foreign darwin/linux OCI build artifacts without prompting")
+	ociImportCmd.Flags().BoolVar(&ociImportAllowUnsigned, "allow-unsigned", false, "Import the unsigned archive even when the trust policy requires signatures for every repository")
+}
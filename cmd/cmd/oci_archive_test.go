@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	alchemy_oci "github.com/csautter/dev-alchemy/pkg/oci"
+	"github.com/spf13/cobra"
+)
+
+func TestResolveOCIImportVirtualMachineUsesArchivedTarget(t *testing.T) {
+	command := testOCIImportCommand(t, "--host-os", "linux")
+
+	vm, err := resolveOCIImportVirtualMachine(command, alchemy_oci.ArchiveTarget{
+		OS:                   "ubuntu",
+		UbuntuType:           "desktop",
+		Arch:                 "arm64",
+		HostOS:               string(alchemy_build.HostOsDarwin),
+		VirtualizationEngine: string(alchemy_build.VirtualizationEngineUtm),
+	})
+	if err != nil {
+		t.Fatalf("expected archived target to resolve: %v", err)
+	}
+	if vm.OS != "ubuntu" || vm.UbuntuType != "desktop" || vm.Arch != "arm64" {
+		t.Fatalf("expected archived ubuntu desktop arm64 target, got %s %s %s", vm.OS, vm.UbuntuType, vm.Arch)
+	}
+	if vm.HostOs != alchemy_build.HostOsLinux || vm.VirtualizationEngine != alchemy_build.VirtualizationEngineQemu {
+		t.Fatalf("expected the linux qemu target for a foreign archive, got %s %s", vm.HostOs, vm.VirtualizationEngine)
+	}
+}
+
+func TestResolveOCIImportVirtualMachinePrefersFlags(t *testing.T) {
+	command := testOCIImportCommand(t, "--host-os", "windows", "--os", "windows11", "--engine", "virtualbox")
+
+	vm, err := resolveOCIImportVirtualMachine(command, alchemy_oci.ArchiveTarget{
+		OS:                   "windows11",
+		Arch:                 "amd64",
+		HostOS:               string(alchemy_build.HostOsWindows),
+		VirtualizationEngine: string(alchemy_build.VirtualizationEngineHyperv),
+	})
+	if err != nil {
+		t.Fatalf("expected flagged target to resolve: %v", err)
+	}
+	if vm.VirtualizationEngine != alchemy_build.VirtualizationEngineVirtualBox {
+		t.Fatalf("expected --engine to override the archived engine, got %q", vm.VirtualizationEngine)
+	}
+}
+
+func TestOCICommandIncludesArchiveSubcommands(t *testing.T) {
+	for _, name := range []string{"export", "import"} {
+		command, _, err := ociCmd.Find([]string{name})
+		if err != nil || command.Name() != name {
+			t.Fatalf("expected oci command to include %s subcommand", name)
+		}
+	}
+	if ociImportCmd.Flags().Lookup("yes") == nil {
+		t.Fatal("expected oci import command to include --yes")
+	}
+}
+
+func TestOCIImportCommandRespectsTrustPolicyForEveryRepository(t *testing.T) {
+	previousPolicy, previousRead, previousImport, previousAllow := loadOCITrustPolicy, readOCIArchiveTarget, runOCIImport, ociImportAllowUnsigned
+	t.Cleanup(func() {
+		loadOCITrustPolicy, readOCIArchiveTarget, runOCIImport, ociImportAllowUnsigned = previousPolicy, previousRead, previousImport, previousAllow
+	})
+	loadOCITrustPolicy = func() (alchemy_oci.TrustPolicy, string, bool, error) {
+		return alchemy_oci.TrustPolicy{Repositories: []alchemy_oci.TrustPolicyRepository{
+			{Scope: "*", Keys: []string{"keys/team.pub"}},
+		}}, "oci-trust-policy.yml", true, nil
+	}
+	readOCIArchiveTarget = func(context.Context, string) (alchemy_oci.ArchiveTarget, error) {
+		return alchemy_oci.ArchiveTarget{OS: "ubuntu", UbuntuType: "server", Arch: "amd64"}, nil
+	}
+	imported := 0
+	runOCIImport = func(*cobra.Command, context.Context, alchemy_build.VirtualMachineConfig, string, alchemy_oci.TransferProgress) (alchemy_oci.TransferResult, error) {
+		imported++
+		return alchemy_oci.TransferResult{}, nil
+	}
+	command := &cobra.Command{}
+	command.SetContext(context.Background())
+
+	ociImportAllowUnsigned = false
+	err := ociImportCmd.RunE(command, []string{"ubuntu-server-amd64.tar"})
+	if err == nil || !strings.Contains(err.Error(), "--allow-unsigned") || imported != 0 {
+		t.Fatalf("expected the import to be refused, got %v after %d imports", err, imported)
+	}
+
+	ociImportAllowUnsigned = true
+	if err := ociImportCmd.RunE(command, []string{"ubuntu-server-amd64.tar"}); err != nil || imported != 1 {
+		t.Fatalf("expected --allow-unsigned to import the archive, got %v after %d imports", err, imported)
+	}
+}
+
+func testOCIImportCommand(t *testing.T, args ...string) *cobra.Command {
+	t.Helper()
+
+	previousOS, previousType, previousArch, previousEngine, previousHostOS := ociOS, ociType, ociArch, ociEngine, ociHostOS
+	t.Cleanup(func() {
+		ociOS, ociType, ociArch, ociEngine, ociHostOS = previousOS, previousType, previousArch, previousEngine, previousHostOS
+	})
+
+	command := &cobra.Command{}
+	command.Flags().StringVar(&ociOS, "os", "", "")
+	addOCITargetFlags(command)
+	if err := command.ParseFlags(args); err != nil {
+		t.Fatalf("failed to parse flags: %v", err)
+	}
+	return command
+}
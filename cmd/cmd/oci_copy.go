@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	alchemy_oci "github.com/csautter/dev-alchemy/pkg/oci"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ociCopySource      ociRegistryFlags
+	ociCopyDestination ociRegistryFlags
+	ociCopyTags        []string
+
+	copyOCIArtifact = alchemy_oci.Copy
+)
+
+func printOCICopyResult(writer io.Writer, result alchemy_oci.CopyResult) {
+	fmt.Fprintf(writer, "✅ Copied OCI artifact: %s -> %s\n", result.Source, result.Destination)
+	fmt.Fprintf(writer, "Digest: %s\n", result.Digest)
+	fmt.Fprintf(writer, "Tags: %s\n", strings.Join(result.Tags, ", "))
+	fmt.Fprintf(writer, "Referrers: %d\n", result.Referrers)
+	fmt.Fprintf(
+		writer,
+		"Transferred: %s, reused: %s\n",
+		alchemy_build.FormatByteSize(result.TransferredBytes),
+		alchemy_build.FormatByteSize(result.ReusedBytes),
+	)
+}
+
+var ociCopyCmd = &cobra.Command{
+	Use:   "copy <src-ref> <dst-ref>",
+	Short: "Copy an OCI artifact and its referrers between registries",
+	Long: `Copies an OCI artifact, a single-target manifest or a multi-target index, from
+one registry to another together with the signatures, build logs and other
+referrers of each manifest. Blobs are streamed between the registries without
+being stored in the local artifact cache, and manifests are copied unchanged,
+so digests, annotations and signatures stay valid.
+
+Registry settings are given per side with --src-* and --dst-* flags. --tag
+adds further tags in the destination repository and may be repeated.
+
+Examples:
+  alchemy oci copy staging.example.com/dev-alchemy/ubuntu-server:2026.10 ghcr.io/example/ubuntu-server:2026.10 --tag stable
+  alchemy oci copy localhost:5000/dev-alchemy/ubuntu:nightly registry.example.com/dev-alchemy/ubuntu:nightly --src-plain-http --dst-username ci --dst-password-stdin
+`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if ociCopySource.passwordStdin && ociCopyDestination.passwordStdin {
+			return fmt.Errorf("--src-password-stdin and --dst-password-stdin cannot be combined")
+		}
+		source, err := ociCopySource.options(cmd.InOrStdin())
+		if err != nil {
+			return err
+		}
+		destination, err := ociCopyDestination.options(cmd.InOrStdin())
+		if err != nil {
+			return err
+		}
+		result, err := copyOCIArtifact(cmd.Context(), args[0], args[1], alchemy_oci.CopyOptions{
+			Source:      source,
+			Destination: destination,
+			Tags:        ociCopyTags,
+			Progress:    newOCIProgressReporter("copying", cmd.ErrOrStderr()),
+		})
+		if err != nil {
+			return err
+		}
+		if ociJSONOutput {
+			return writeOCIJSON(cmd.OutOrStdout(), result)
+		}
+		printOCICopyResult(cmd.OutOrStdout(), result)
+		return nil
+	},
+}
+
+func init() {
+	ociCmd.AddCommand(ociCopyCmd)
+	addOCIRegistryFlagSet(ociCopyCmd, "src-", "the source OCI registry", &ociCopySource)
+	addOCIRegistryFlagSet(ociCopyCmd, "dst-", "the destination OCI registry", &ociCopyDestination)
+	ociCopyCmd.Flags().StringArrayVar(&ociCopyTags, "tag", nil, "Additional tag for the copied artifact in the destination repository (repeatable)")
+	ociCopyCmd.Flags().BoolVar(&ociJSONOutput, "json", false, "Print the result as JSON")
+}
@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	alchemy_oci "github.com/csautter/dev-alchemy/pkg/oci"
+)
+
+func TestOCICopyCommandPassesSeparateRegistryOptionsAndTags(t *testing.T) {
+	previousCopy := copyOCIArtifact
+	previousSource, previousDestination, previousTags := ociCopySource, ociCopyDestination, ociCopyTags
+	t.Cleanup(func() {
+		copyOCIArtifact = previousCopy
+		ociCopySource, ociCopyDestination, ociCopyTags = previousSource, previousDestination, previousTags
+	})
+	ociCopySource = ociRegistryFlags{plainHTTP: true, username: "staging"}
+	ociCopyDestination = ociRegistryFlags{username: "production", passwordStdin: true}
+	ociCopyTags = []string{"stable"}
+
+	var got alchemy_oci.CopyOptions
+	copyOCIArtifact = func(_ context.Context, srcRef string, dstRef string, opts alchemy_oci.CopyOptions) (alchemy_oci.CopyResult, error) {
+		got = opts
+		return alchemy_oci.CopyResult{Source: srcRef, Destination: dstRef, Digest: "sha256:abc", Tags: []string{"2026.10", "stable"}, Referrers: 2}, nil
+	}
+
+	var output bytes.Buffer
+	ociCopyCmd.SetIn(bytes.NewBufferString("secret\n"))
+	ociCopyCmd.SetOut(&output)
+	ociCopyCmd.SetErr(&bytes.Buffer{})
+	t.Cleanup(func() {
+		ociCopyCmd.SetIn(nil)
+		ociCopyCmd.SetOut(nil)
+		ociCopyCmd.SetErr(nil)
+	})
+	if err := ociCopyCmd.RunE(ociCopyCmd, []string{"localhost:5000/dev-alchemy/ubuntu:2026.10", "ghcr.io/example/ubuntu:2026.10"}); err != nil {
+		t.Fatalf("copy command failed: %v", err)
+	}
+
+	if !got.Source.PlainHTTP || got.Source.Username != "staging" || got.Source.Password != "" {
+		t.Fatalf("unexpected source registry options %+v", got.Source)
+	}
+	if got.Destination.PlainHTTP || got.Destination.Username != "production" || got.Destination.Password != "secret" {
+		t.Fatalf("unexpected destination registry options %+v", got.Destination)
+	}
+	if len(got.Tags) != 1 || got.Tags[0] != "stable" {
+		t.Fatalf("expected --tag to be passed, got %v", got.Tags)
+	}
+	if !strings.Contains(output.String(), "Tags: 2026.10, stable") || !strings.Contains(output.String(), "Referrers: 2") {
+		t.Fatalf("unexpected copy output:\n%s", output.String())
+	}
+}
+
+func TestOCICopyCommandRejectsTwoPasswordStdinFlags(t *testing.T) {
+	previousSource, previousDestination := ociCopySource, ociCopyDestination
+	t.Cleanup(func() {
+		ociCopySource, ociCopyDestination = previousSource, previousDestination
+	})
+	ociCopySource = ociRegistryFlags{passwordStdin: true}
+	ociCopyDestination = ociRegistryFlags{passwordStdin: true}
+
+	err := ociCopyCmd.RunE(ociCopyCmd, []string{"localhost:5000/a:1", "localhost:5001/a:1"})
+	if err == nil || !strings.Contains(err.Error(), "cannot be combined") {
+		t.Fatalf("expected combined password stdin error, got %v", err)
+	}
+}
+
+func TestOCICopyCommandIncludesSideRegistryFlags(t *testing.T) {
+	for _, name := range []string{"src-plain-http", "src-username", "dst-ca-file", "dst-password-stdin", "tag", "json"} {
+		if ociCopyCmd.Flags().Lookup(name) == nil {
+			t.Fatalf("expected copy command to include --%s", name)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	alchemy_oci "github.com/csautter/dev-alchemy/pkg/oci"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ociJSONOutput bool
+
+	listOCITags        = alchemy_oci.ListTags
+	inspectOCIArtifact = alchemy_oci.Inspect
+)
+
+func writeOCIJSON(writer io.Writer, value any) error {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(value)
+}
+
+func printOCIInspection(writer io.Writer, hostOs alchemy_build.HostOsType, inspection alchemy_oci.ArtifactInspection) error {
+	fmt.Fprintf(writer, "Reference: %s\n", inspection.Reference)
+	fmt.Fprintf(writer, "Digest: %s\n", inspection.Digest)
+	fmt.Fprintf(writer, "Artifact type: %s\n", inspection.ArtifactType)
+	if inspection.Created != "" {
+		fmt.Fprintf(writer, "Created: %s\n", inspection.Created)
+	}
+	fmt.Fprintf(writer, "Total size: %s\n", alchemy_build.FormatByteSize(inspection.TotalSize))
+
+	if inspection.MediaType == ocispec.MediaTypeImageIndex {
+		if err := printOCIIndexEntries(writer, inspection.Entries); err != nil {
+			return err
+		}
+		return printOCICompatibility(writer, hostOs, inspection.Compatibility)
+	}
+
+	fmt.Fprintln(writer, "\nAnnotations:")
+	for _, key := range slices.Sorted(maps.Keys(inspection.Annotations)) {
+		fmt.Fprintf(writer, "  %s=%s\n", key, inspection.Annotations[key])
+	}
+
+	fmt.Fprintln(writer, "\nArtifacts:")
+	tw := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"Name", "Media type", "Size", "Layers", "Layer size", "Chunking"}, "\t"))
+	for _, artifact := range inspection.Artifacts {
+		chunking := artifact.Chunking
+		if chunking == "" {
+			chunking = "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\t%s\n",
+			artifact.Name,
+			artifact.MediaType,
+			alchemy_build.FormatByteSize(artifact.Size),
+			artifact.Layers,
+			alchemy_build.FormatByteSize(artifact.LayerSize),
+			chunking,
+		)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return printOCICompatibility(writer, hostOs, inspection.Compatibility)
+}
+
+// printOCIIndexEntries lists the targets of a multi-target index and how the
+// host can pull each of them.
+func printOCIIndexEntries(writer io.Writer, entries []alchemy_oci.InspectedIndexEntry) error {
+	fmt.Fprintln(writer, "\nIndex entries:")
+	tw := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"Target", "Size", "Pull on this host", "Digest"}, "\t"))
+	for _, entry := range entries {
+		var statuses []string
+		for _, target := range entry.Compatibility {
+			if !slices.Contains(statuses, target.Status) {
+				statuses = append(statuses, target.Status)
+			}
+		}
+		status := strings.Join(statuses, ", ")
+		if status == "" {
+			status = "no"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", entry.Target, alchemy_build.FormatByteSize(entry.TotalSize), status, entry.Digest)
+	}
+	return tw.Flush()
+}
+
+func printOCICompatibility(writer io.Writer, hostOs alchemy_build.HostOsType, compatibility []alchemy_oci.TargetCompatibility) error {
+	if len(compatibility) == 0 {
+		fmt.Fprintf(writer, "\nNo target on host OS %s can pull this artifact.\n", hostOs)
+		return nil
+	}
+	fmt.Fprintf(writer, "\nCompatible targets for host OS: %s\n", hostOs)
+	tw := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join([]string{"OS", "Type", "Arch", "Engine", "Status"}, "\t"))
+	for _, target := range compatibility {
+		targetType := target.UbuntuType
+		if targetType == "" {
+			targetType = "-"
+		}
+		status := target.Status
+		if status == alchemy_oci.CompatibilityForeign {
+			status += " (requires confirmation)"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", target.OS, targetType, target.Arch, target.VirtualizationEngine, status)
+	}
+	return tw.Flush()
+}
+
+var ociTagsCmd = &cobra.Command{
+	Use:   "tags <registry>/<repository>",
+	Short: "List the tags of an OCI repository",
+	Long: `Lists the tags of an OCI repository.
+
+Examples:
+  alchemy oci tags ghcr.io/csautter/ubuntu-24
+  alchemy oci tags localhost:5000/dev-alchemy/ubuntu-server-amd64 --plain-http --json
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		options, err := ociRegistryOptions(cmd)
+		if err != nil {
+			return err
+		}
+		tags, err := listOCITags(cmd.Context(), args[0], options)
+		if err != nil {
+			return err
+		}
+		if ociJSONOutput {
+			if tags == nil {
+				tags = []string{}
+			}
+			return writeOCIJSON(cmd.OutOrStdout(), tags)
+		}
+		for _, tag := range tags {
+			fmt.Fprintln(cmd.OutOrStdout(), tag)
+		}
+		return nil
+	},
+}
+
+var ociInspectCmd = &cobra.Command{
+	Use:   "inspect <registry>/<repository>[:tag|@digest]",
+	Short: "Show the target annotations, layers and host compatibility of an OCI artifact",
+	Long: `Shows the Dev Alchemy target annotations, creation time and artifact sizes of an
+OCI artifact, and which targets of the host OS could pull it. Compatibility uses
+the same target checks as pull: "native" targets match the artifact exactly,
+"foreign" targets accept a compatible darwin/linux artifact after confirmation.
+For a multi-target index, each entry is listed with how this host can pull it.
+
+Examples:
+  alchemy oci inspect ghcr.io/csautter/ubuntu-24:server-amd64-linux-build
+  alchemy oci inspect localhost:5000/dev-alchemy/ubuntu-server-amd64:qemu --plain-http --host-os darwin --json
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hostOs, err := parseHostOS(ociHostOS)
+		if err != nil {
+			return err
+		}
+		options, err := ociRegistryOptions(cmd)
+		if err != nil {
+			return err
+		}
+		inspection, err := inspectOCIArtifact(cmd.Context(), args[0], alchemy_oci.InspectOptions{
+			RegistryOptions: options,
+			Targets:         availableOCIVirtualMachinesForHostOS(hostOs),
+		})
+		if err != nil {
+			return err
+		}
+		if ociJSONOutput {
+			return writeOCIJSON(cmd.OutOrStdout(), inspection)
+		}
+		return printOCIInspection(cmd.OutOrStdout(), hostOs, inspection)
+	},
+}
+
+func init() {
+	ociCmd.AddCommand(ociTagsCmd)
+	ociCmd.AddCommand(ociInspectCmd)
+	for _, command := range []*cobra.Command{ociTagsCmd, ociInspectCmd} {
+		addOCIRegistryFlags(command)
+		command.Flags().BoolVar(&ociJSONOutput, "json", false, "Print the result as JSON")
+	}
+	addOCIListFlags(ociInspectCmd)
+}
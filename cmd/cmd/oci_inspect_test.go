@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	alchemy_oci "github.com/csautter/dev-alchemy/pkg/oci"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPrintOCIInspectionShowsAnnotationsArtifactsAndCompatibility(t *testing.T) {
+	var output bytes.Buffer
+	if err := printOCIInspection(&output, alchemy_build.HostOsLinux, testOCIInspection()); err != nil {
+		t.Fatalf("failed to print inspection: %v", err)
+	}
+
+	for _, want := range []string{
+		"Created: 2026-10-01T02:00:00Z",
+		"dev.alchemy.vm.arch=amd64",
+		"image.qcow2",
+		"content-defined",
+		"foreign (requires confirmation)",
+	} {
+		if !strings.Contains(output.String(), want) {
+			t.Fatalf("expected inspection output to contain %q, got:\n%s", want, output.String())
+		}
+	}
+}
+
+func TestPrintOCIInspectionReportsIncompatibleHost(t *testing.T) {
+	inspection := testOCIInspection()
+	inspection.Compatibility = nil
+
+	var output bytes.Buffer
+	if err := printOCIInspection(&output, alchemy_build.HostOsWindows, inspection); err != nil {
+		t.Fatalf("failed to print inspection: %v", err)
+	}
+	if !strings.Contains(output.String(), "No target on host OS windows can pull this artifact.") {
+		t.Fatalf("expected incompatible host message, got:\n%s", output.String())
+	}
+}
+
+func TestPrintOCIInspectionListsIndexEntries(t *testing.T) {
+	entry := testOCIInspection()
+	entry.Compatibility = entry.Compatibility[:1]
+	inspection := alchemy_oci.ArtifactInspection{
+		Reference:     "localhost:5000/dev-alchemy/ubuntu:nightly",
+		Digest:        "sha256:" + strings.Repeat("c", 64),
+		MediaType:     ocispec.MediaTypeImageIndex,
+		ArtifactType:  alchemy_oci.ArtifactType,
+		TotalSize:     entry.TotalSize,
+		Compatibility: entry.Compatibility,
+		Entries: []alchemy_oci.InspectedIndexEntry{
+			{Target: "ubuntu-server-amd64 on debian/qemu", ArtifactInspection: entry},
+			{Target: "windows11-amd64 on windows/hyperv", ArtifactInspection: alchemy_oci.ArtifactInspection{Digest: "sha256:" + strings.Repeat("d", 64)}},
+		},
+	}
+
+	var output bytes.Buffer
+	if err := printOCIInspection(&output, alchemy_build.HostOsLinux, inspection); err != nil {
+		t.Fatalf("failed to print inspection: %v", err)
+	}
+	for _, want := range []string{
+		"Index entries:",
+		"ubuntu-server-amd64 on debian/qemu",
+		"windows11-amd64 on windows/hyperv",
+		"native",
+		"Compatible targets for host OS: debian",
+	} {
+		if !strings.Contains(output.String(), want) {
+			t.Fatalf("expected index output to contain %q, got:\n%s", want, output.String())
+		}
+	}
+	if strings.Contains(output.String(), "Artifacts:") {
+		t.Fatalf("expected the index to list entries instead of artifacts, got:\n%s", output.String())
+	}
+}
+
+func TestOCIInspectCommandWritesJSON(t *testing.T) {
+	previousInspect := inspectOCIArtifact
+	previousJSON := ociJSONOutput
+	previousHostOS := ociHostOS
+	t.Cleanup(func() {
+		inspectOCIArtifact = previousInspect
+		ociJSONOutput = previousJSON
+		ociHostOS = previousHostOS
+	})
+
+	var targets []alchemy_build.VirtualMachineConfig
+	inspectOCIArtifact = func(_ context.Context, reference string, opts alchemy_oci.InspectOptions) (alchemy_oci.ArtifactInspection, error) {
+		targets = opts.Targets
+		return testOCIInspection(), nil
+	}
+	ociJSONOutput = true
+	ociHostOS = "linux"
+
+	var output bytes.Buffer
+	ociInspectCmd.SetOut(&output)
+	t.Cleanup(func() { ociInspectCmd.SetOut(nil) })
+	if err := ociInspectCmd.RunE(ociInspectCmd, []string{"localhost:5000/dev-alchemy/ubuntu:nightly"}); err != nil {
+		t.Fatalf("inspect command failed: %v", err)
+	}
+
+	if len(targets) == 0 {
+		t.Fatal("expected the host OS targets to be checked for compatibility")
+	}
+	var decoded alchemy_oci.ArtifactInspection
+	if err := json.Unmarshal(output.Bytes(), &decoded); err != nil {
+		t.Fatalf("expected JSON output, got %q: %v", output.String(), err)
+	}
+	if decoded.Digest != testOCIInspection().Digest || len(decoded.Compatibility) != 2 {
+		t.Fatalf("unexpected decoded inspection %+v", decoded)
+	}
+}
+
+func TestOCICommandIncludesInspectionSubcommands(t *testing.T) {
+	for _, name := range []string{"tags", "inspect"} {
+		command, _, err := ociCmd.Find([]string{name})
+		if err != nil || command.Name() != name {
+			t.Fatalf("expected oci command to include %s subcommand", name)
+		}
+		for _, flagName := range []string{"json", "plain-http", "ca-file"} {
+			if command.Flags().Lookup(flagName) == nil {
+				t.Fatalf("expected oci %s command to include --%s", name, flagName)
+			}
+		}
+	}
+}
+
+func testOCIInspection() alchemy_oci.ArtifactInspection {
+	return alchemy_oci.ArtifactInspection{
+		Reference:    "localhost:5000/dev-alchemy/ubuntu:nightly",
+		Digest:       "sha256:" + strings.Repeat("a", 64),
+		ArtifactType: alchemy_oci.ArtifactType,
+		Created:      "2026-10-01T02:00:00Z",
+		Annotations: map[string]string{
+			alchemy_oci.AnnotationVMOS:   "ubuntu",
+			alchemy_oci.AnnotationVMArch: "amd64",
+		},
+		Artifacts: []alchemy_oci.InspectedArtifact{
+			{Name: "image.qcow2", MediaType: alchemy_oci.MediaTypeQCOW2, Size: 4 << 30, LayerSize: 2 << 30, Layers: 64, Chunking: alchemy_oci.ChunkingContentDefined},
+		},
+		TotalSize: 2 << 30,
+		Compatibility: []alchemy_oci.TargetCompatibility{
+			{OS: "ubuntu", UbuntuType: "server", Arch: "amd64", HostOS: "debian", VirtualizationEngine: "qemu", Status: alchemy_oci.CompatibilityNative},
+			{OS: "ubuntu", UbuntuType: "server", Arch: "amd64", HostOS: "darwin", VirtualizationEngine: "utm", Status: alchemy_oci.CompatibilityForeign},
+		},
+	}
+}
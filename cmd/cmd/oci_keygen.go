@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"fmt"
+
+	alchemy_oci "github.com/csautter/dev-alchemy/pkg/oci"
+	"github.com/spf13/cobra"
+)
+
+var generateOCISigningKeyPair = alchemy_oci.GenerateSigningKeyPair
+
+var ociKeygenCmd = &cobra.Command{
+	Use:   "keygen <name>",
+	Short: "Generate an Ed25519 key pair for signing OCI artifacts",
+	Long: `Writes a new Ed25519 key pair as <name>.key (PEM PKCS #8 private key) and
+<name>.pub (PEM public key). Existing files are never overwritten.
+
+Examples:
+  alchemy oci keygen ~/.config/dev-alchemy/keys/release
+  alchemy push ghcr.io/example/dev-alchemy/ubuntu-server-amd64:qemu --os ubuntu --sign --key ~/.config/dev-alchemy/keys/release.key
+  alchemy pull ghcr.io/example/dev-alchemy/ubuntu-server-amd64:qemu --os ubuntu --verify --key release.pub
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		privatePath, publicPath := args[0]+".key", args[0]+".pub"
+		if err := generateOCISigningKeyPair(privatePath, publicPath); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "✅ Signing key: %s\n", privatePath)
+		fmt.Fprintf(cmd.OutOrStdout(), "Public key: %s\n", publicPath)
+		return nil
+	},
+}
+
+func init() {
+	ociCmd.AddCommand(ociKeygenCmd)
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	alchemy_oci "github.com/csautter/dev-alchemy/pkg/oci"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ociPruneKeepLast  int
+	ociPruneKeepTags  []string
+	ociPruneOlderThan time.Duration
+	ociPruneDryRun    bool
+
+	pruneOCIRepository = alchemy_oci.PruneRepository
+)
+
+func printOCIPruneResult(writer io.Writer, result alchemy_oci.PruneResult) {
+	if len(result.Deleted) == 0 {
+		fmt.Fprintln(writer, "Nothing to prune.")
+	}
+	verb := "Deleted"
+	if result.DryRun {
+		verb = "Would delete"
+	}
+	for _, artifact := range result.Deleted {
+		fmt.Fprintf(writer, "%s %s (%s): %s\n", verb, artifact.Digest, strings.Join(artifact.Tags, ", "), artifact.Reason)
+		for _, manifest := range artifact.Manifests {
+			fmt.Fprintf(writer, "  index entry %s\n", manifest)
+		}
+		for _, referrer := range artifact.Referrers {
+			fmt.Fprintf(writer, "  referrer %s\n", referrer)
+		}
+	}
+	for _, artifact := range result.Kept {
+		fmt.Fprintf(writer, "Kept %s (%s): %s\n", artifact.Digest, strings.Join(artifact.Tags, ", "), artifact.Reason)
+	}
+	if len(result.Deleted) > 0 {
+		fmt.Fprintf(writer, "%s %d artifact(s), kept %d\n", verb, len(result.Deleted), len(result.Kept))
+	}
+}
+
+var ociPruneCmd = &cobra.Command{
+	Use:   "prune <registry>/<repository>",
+	Short: "Delete old OCI artifacts from a repository by retention rules",
+	Long: `Deletes tagged artifacts from an OCI repository that no retention rule keeps,
+together with their signatures and other referrers and the entries of deleted
+multi-target indexes that no kept artifact uses.
+
+--keep-last keeps the newest artifacts by their org.opencontainers.image.created
+annotation, --older-than only deletes artifacts older than the given age, and
+--keep-tag keeps every artifact with a tag matching the pattern (repeatable).
+Artifacts kept by --keep-tag do not count towards --keep-last, and artifacts
+without a created annotation or that are entries of a kept index are always
+kept. At least --keep-last or --older-than is required.
+
+Only manifests are deleted; the registry reclaims their blobs in its own garbage
+collection, and it must allow manifest deletion. Use --dry-run to list what
+would be deleted first.
+
+Examples:
+  alchemy oci prune ghcr.io/example/ubuntu-server --keep-last 5 --keep-tag 'v*' --keep-tag stable --dry-run
+  alchemy oci prune localhost:5000/dev-alchemy/ubuntu --older-than 720h --plain-http
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		options, err := ociRegistryOptions(cmd)
+		if err != nil {
+			return err
+		}
+		result, err := pruneOCIRepository(cmd.Context(), args[0], alchemy_oci.PrunePolicy{
+			KeepLast:  ociPruneKeepLast,
+			KeepTags:  ociPruneKeepTags,
+			OlderThan: ociPruneOlderThan,
+			DryRun:    ociPruneDryRun,
+		}, options)
+		if err != nil {
+			return err
+		}
+		if ociJSONOutput {
+			return writeOCIJSON(cmd.OutOrStdout(), result)
+		}
+		printOCIPruneResult(cmd.OutOrStdout(), result)
+		return nil
+	},
+}
+
+func init() {
+	ociCmd.AddCommand(ociPruneCmd)
+	addOCIRegistryFlags(ociPruneCmd)
+	ociPruneCmd.Flags().IntVar(&ociPruneKeepLast, "keep-last", -1, "Keep the newest N artifacts by creation time (-1 disables the rule)")
+	ociPruneCmd.Flags().StringArrayVar(&ociPruneKeepTags, "keep-tag", nil, "Keep artifacts with a tag matching this pattern, such as 'v*' (repeatable)")
+	ociPruneCmd.Flags().DurationVar(&ociPruneOlderThan, "older-than", 0, "Only delete artifacts created longer ago than this, such as 720h")
+	ociPruneCmd.Flags().BoolVar(&ociPruneDryRun, "dry-run", false, "Only report what would be deleted")
+	ociPruneCmd.Flags().BoolVar(&ociJSONOutput, "json", false, "Print the result as JSON")
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	alchemy_oci "github.com/csautter/dev-alchemy/pkg/oci"
+)
+
+func TestOCIPruneCommandPassesPolicyAndPrintsDryRun(t *testing.T) {
+	previousPrune := pruneOCIRepository
+	previousKeepLast, previousKeepTags, previousOlderThan, previousDryRun := ociPruneKeepLast, ociPruneKeepTags, ociPruneOlderThan, ociPruneDryRun
+	t.Cleanup(func() {
+		pruneOCIRepository = previousPrune
+		ociPruneKeepLast, ociPruneKeepTags, ociPruneOlderThan, ociPruneDryRun = previousKeepLast, previousKeepTags, previousOlderThan, previousDryRun
+	})
+	ociPruneKeepLast = 5
+	ociPruneKeepTags = []string{"v*", "stable"}
+	ociPruneOlderThan = 720 * time.Hour
+	ociPruneDryRun = true
+
+	var gotRepository string
+	var got alchemy_oci.PrunePolicy
+	pruneOCIRepository = func(_ context.Context, repository string, policy alchemy_oci.PrunePolicy, _ alchemy_oci.RegistryOptions) (alchemy_oci.PruneResult, error) {
+		gotRepository, got = repository, policy
+		return alchemy_oci.PruneResult{
+			Repository: repository,
+			DryRun:     true,
+			Deleted: []alchemy_oci.PrunedArtifact{{
+				Digest:    "sha256:old",
+				Tags:      []string{"nightly-1"},
+				Reason:    "not one of the newest 5 and older than 720h0m0s",
+				Referrers: []string{"sha256:sig"},
+			}},
+			Kept: []alchemy_oci.PrunedArtifact{{Digest: "sha256:release", Tags: []string{"v1.0.0"}, Reason: "tag v1.0.0 matches v*"}},
+		}, nil
+	}
+
+	var output bytes.Buffer
+	ociPruneCmd.SetOut(&output)
+	t.Cleanup(func() { ociPruneCmd.SetOut(nil) })
+	if err := ociPruneCmd.RunE(ociPruneCmd, []string{"localhost:5000/dev-alchemy/ubuntu"}); err != nil {
+		t.Fatalf("prune command failed: %v", err)
+	}
+
+	if gotRepository != "localhost:5000/dev-alchemy/ubuntu" || got.KeepLast != 5 || got.OlderThan != 720*time.Hour || !got.DryRun || !slices.Equal(got.KeepTags, []string{"v*", "stable"}) {
+		t.Fatalf("unexpected prune policy %+v for %s", got, gotRepository)
+	}
+	for _, want := range []string{
+		"Would delete sha256:old (nightly-1): not one of the newest 5",
+		"  referrer sha256:sig",
+		"Kept sha256:release (v1.0.0): tag v1.0.0 matches v*",
+		"Would delete 1 artifact(s), kept 1",
+	} {
+		if !strings.Contains(output.String(), want) {
+			t.Fatalf("expected prune output to contain %q, got:\n%s", want, output.String())
+		}
+	}
+}
+
+func TestPrintOCIPruneResultReportsNothingToPrune(t *testing.T) {
+	var output bytes.Buffer
+	printOCIPruneResult(&output, alchemy_oci.PruneResult{})
+	if !strings.Contains(output.String(), "Nothing to prune.") {
+		t.Fatalf("expected empty prune message, got:\n%s", output.String())
+	}
+}
+
+func TestOCIPruneCommandIncludesRetentionFlags(t *testing.T) {
+	for _, name := range []string{"keep-last", "keep-tag", "older-than", "dry-run", "plain-http", "json"} {
+		if ociPruneCmd.Flags().Lookup(name) == nil {
+			t.Fatalf("expected prune command to include --%s", name)
+		}
+	}
+	if value := ociPruneCmd.Flags().Lookup("keep-last").DefValue; value != "-1" {
+		t.Fatalf("expected --keep-last to be disabled by default, got %s", value)
+	}
+}
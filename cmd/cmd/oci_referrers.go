@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	alchemy_oci "github.com/csautter/dev-alchemy/pkg/oci"
+	"github.com/spf13/cobra"
+)
+
+var (
+	ociReferrerOutput string
+
+	listOCIReferrers = alchemy_oci.ListReferrers
+	fetchOCIReferrer = alchemy_oci.FetchReferrer
+)
+
+func printOCIReferrers(writer io.Writer, referrers []alchemy_oci.Referrer) error {
+	if len(referrers) == 0 {
+		fmt.Fprintln(writer, "No referrers are attached to this OCI artifact.")
+		return nil
+	}
+	withTargets := slices.ContainsFunc(referrers, func(referrer alchemy_oci.Referrer) bool {
+		return referrer.Target != ""
+	})
+	tw := tabwriter.NewWriter(writer, 0, 0, 2, ' ', 0)
+	columns := []string{"Kind", "Title", "Created", "Digest"}
+	if withTargets {
+		columns = append([]string{"Target"}, columns...)
+	}
+	fmt.Fprintln(tw, strings.Join(columns, "\t"))
+	for _, referrer := range referrers {
+		kind := referrer.Kind
+		if kind == "" {
+			kind = referrer.ArtifactType
+		}
+		if withTargets {
+			target := referrer.Target
+			if target == "" {
+				target = "index"
+			}
+			fmt.Fprintf(tw, "%s\t", target)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", kind, valueOrDash(referrer.Title), valueOrDash(referrer.Created), referrer.Digest)
+	}
+	return tw.Flush()
+}
+
+func valueOrDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+var ociReferrersCmd = &cobra.Command{
+	Use:   "referrers <registry>/<repository>[:tag|@digest]",
+	Short: "List the build logs, provenance, recordings and signatures attached to an OCI artifact",
+	Long: `Lists the OCI referrers of an artifact manifest, oldest first. "alchemy push"
+attaches them with --attach-build-log, --attach-provenance, --attach-recording
+and --sign. For a multi-target index, the referrers of every entry are listed
+with the target of the entry.
+
+Examples:
+  alchemy oci referrers ghcr.io/csautter/ubuntu-24:server-amd64-linux-build
+  alchemy oci referrers localhost:5000/dev-alchemy/ubuntu-server-amd64:qemu --plain-http --json
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		options, err := ociRegistryOptions(cmd)
+		if err != nil {
+			return err
+		}
+		referrers, err := listOCIReferrers(cmd.Context(), args[0], options)
+		if err != nil {
+			return err
+		}
+		if ociJSONOutput {
+			return writeOCIJSON(cmd.OutOrStdout(), referrers)
+		}
+		return printOCIReferrers(cmd.OutOrStdout(), referrers)
+	},
+}
+
+var ociFetchReferrerCmd = &cobra.Command{
+	Use:   "fetch-referrer <registry>/<repository>[:tag|@digest] <digest|kind>",
+	Short: "Download a referrer attached to an OCI artifact",
+	Long: `Downloads the content of an OCI referrer. The referrer is selected by its
+manifest digest, or by kind (build-log, provenance, vnc-recording, signature),
+which selects the newest referrer of that kind. For a multi-target index, a
+kind attached to several entries is ambiguous; select the referrer by digest.
+Without --output, the file is written to the working directory under the name
+it was attached with.
+
+Examples:
+  alchemy oci fetch-referrer ghcr.io/csautter/ubuntu-24:server-amd64-linux-build build-log
+  alchemy oci fetch-referrer localhost:5000/dev-alchemy/ubuntu-server-amd64:qemu vnc-recording --plain-http -o build.mp4
+`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		options, err := ociRegistryOptions(cmd)
+		if err != nil {
+			return err
+		}
+		referrer, err := fetchOCIReferrer(cmd.Context(), args[0], args[1], ociReferrerOutput, alchemy_oci.FetchReferrerOptions{
+			RegistryOptions: options,
+			Progress:        newOCIProgressReporter("fetching", cmd.ErrOrStderr()),
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "✅ Fetched %s referrer %s: %s\n", valueOrDash(referrer.Kind), referrer.Digest, referrer.Path)
+		return nil
+	},
+}
+
+func init() {
+	ociCmd.AddCommand(ociReferrersCmd)
+	ociCmd.AddCommand(ociFetchReferrerCmd)
+	addOCIRegistryFlags(ociReferrersCmd)
+	addOCIRegistryFlags(ociFetchReferrerCmd)
+	ociReferrersCmd.Flags().BoolVar(&ociJSONOutput, "json", false, "Print the result as JSON")
+	ociFetchReferrerCmd.Flags().StringVarP(&ociReferrerOutput, "output", "o", "", "Path to write the referrer content to")
+}
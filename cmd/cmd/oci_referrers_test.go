@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	alchemy_oci "github.com/csautter/dev-alchemy/pkg/oci"
+)
+
+func TestPrintOCIReferrersListsKindsAndDigests(t *testing.T) {
+	var output bytes.Buffer
+	if err := printOCIReferrers(&output, testOCIReferrers()); err != nil {
+		t.Fatalf("failed to print referrers: %v", err)
+	}
+	for _, want := range []string{"build-log", "build.log", "vnc-recording", "qemu.vnc.mp4", "sha256:" + strings.Repeat("b", 64)} {
+		if !strings.Contains(output.String(), want) {
+			t.Fatalf("expected referrers output to contain %q, got:\n%s", want, output.String())
+		}
+	}
+
+	if strings.Contains(output.String(), "Target") {
+		t.Fatalf("expected no target column for a single manifest, got:\n%s", output.String())
+	}
+
+	output.Reset()
+	referrers := testOCIReferrers()
+	referrers[1].Target = "ubuntu-server-amd64 on darwin/utm"
+	if err := printOCIReferrers(&output, referrers); err != nil {
+		t.Fatalf("failed to print index referrers: %v", err)
+	}
+	for _, want := range []string{"Target", "index", "ubuntu-server-amd64 on darwin/utm"} {
+		if !strings.Contains(output.String(), want) {
+			t.Fatalf("expected index referrers output to contain %q, got:\n%s", want, output.String())
+		}
+	}
+
+	output.Reset()
+	if err := printOCIReferrers(&output, nil); err != nil {
+		t.Fatalf("failed to print empty referrers: %v", err)
+	}
+	if !strings.Contains(output.String(), "No referrers") {
+		t.Fatalf("expected empty referrers message, got:\n%s", output.String())
+	}
+}
+
+func TestOCIReferrersCommandWritesJSON(t *testing.T) {
+	previousList := listOCIReferrers
+	previousJSON := ociJSONOutput
+	t.Cleanup(func() {
+		listOCIReferrers = previousList
+		ociJSONOutput = previousJSON
+	})
+	listOCIReferrers = func(_ context.Context, reference string, _ alchemy_oci.RegistryOptions) ([]alchemy_oci.Referrer, error) {
+		return testOCIReferrers(), nil
+	}
+	ociJSONOutput = true
+
+	var output bytes.Buffer
+	ociReferrersCmd.SetOut(&output)
+	t.Cleanup(func() { ociReferrersCmd.SetOut(nil) })
+	if err := ociReferrersCmd.RunE(ociReferrersCmd, []string{"localhost:5000/dev-alchemy/ubuntu:nightly"}); err != nil {
+		t.Fatalf("referrers command failed: %v", err)
+	}
+	var decoded []alchemy_oci.Referrer
+	if err := json.Unmarshal(output.Bytes(), &decoded); err != nil {
+		t.Fatalf("expected JSON output, got %q: %v", output.String(), err)
+	}
+	if len(decoded) != 2 || decoded[0].Kind != alchemy_oci.ReferrerKindBuildLog {
+		t.Fatalf("unexpected decoded referrers %+v", decoded)
+	}
+}
+
+func TestOCIFetchReferrerCommandPassesSelectorAndOutput(t *testing.T) {
+	previousFetch := fetchOCIReferrer
+	previousOutput := ociReferrerOutput
+	t.Cleanup(func() {
+		fetchOCIReferrer = previousFetch
+		ociReferrerOutput = previousOutput
+	})
+	var gotSelector, gotOutput string
+	fetchOCIReferrer = func(_ context.Context, reference string, selector string, outputPath string, _ alchemy_oci.FetchReferrerOptions) (alchemy_oci.Referrer, error) {
+		gotSelector, gotOutput = selector, outputPath
+		referrer := testOCIReferrers()[1]
+		referrer.Path = outputPath
+		return referrer, nil
+	}
+	ociReferrerOutput = "build.mp4"
+
+	var output bytes.Buffer
+	ociFetchReferrerCmd.SetOut(&output)
+	ociFetchReferrerCmd.SetErr(&bytes.Buffer{})
+	t.Cleanup(func() {
+		ociFetchReferrerCmd.SetOut(nil)
+		ociFetchReferrerCmd.SetErr(nil)
+	})
+	if err := ociFetchReferrerCmd.RunE(ociFetchReferrerCmd, []string{"localhost:5000/dev-alchemy/ubuntu:nightly", "vnc-recording"}); err != nil {
+		t.Fatalf("fetch-referrer command failed: %v", err)
+	}
+	if gotSelector != "vnc-recording" || gotOutput != "build.mp4" {
+		t.Fatalf("expected selector vnc-recording and output build.mp4, got %q and %q", gotSelector, gotOutput)
+	}
+	if !strings.Contains(output.String(), "Fetched vnc-recording referrer") {
+		t.Fatalf("unexpected fetch output:\n%s", output.String())
+	}
+}
+
+func TestOCIPushRecordingPathRequiresRecording(t *testing.T) {
+	previous := ociAttachRecording
+	t.Cleanup(func() { ociAttachRecording = previous })
+	vm := alchemy_build.VirtualMachineConfig{OS: "dev-alchemy-test-no-recording", UbuntuType: "server", Arch: "amd64", HostOs: alchemy_build.HostOsLinux, VirtualizationEngine: alchemy_build.VirtualizationEngineQemu}
+
+	ociAttachRecording = false
+	if path, err := ociPushRecordingPath(vm); err != nil || path != "" {
+		t.Fatalf("expected no recording without --attach-recording, got %q, %v", path, err)
+	}
+
+	ociAttachRecording = true
+	if _, err := ociPushRecordingPath(vm); err == nil || !strings.Contains(err.Error(), "--attach-recording needs the VNC recording") {
+		t.Fatalf("expected missing recording error, got %v", err)
+	}
+}
+
+func testOCIReferrers() []alchemy_oci.Referrer {
+	return []alchemy_oci.Referrer{
+		{
+			Kind:         alchemy_oci.ReferrerKindBuildLog,
+			ArtifactType: alchemy_oci.ArtifactTypeBuildLog,
+			Digest:       "sha256:" + strings.Repeat("a", 64),
+			Title:        "build.log",
+			Created:      "2026-10-01T02:00:00Z",
+		},
+		{
+			Kind:         alchemy_oci.ReferrerKindVNCRecording,
+			ArtifactType: alchemy_oci.ArtifactTypeVNCRecording,
+			Digest:       "sha256:" + strings.Repeat("b", 64),
+			Title:        "qemu.vnc.mp4",
+			Created:      "2026-10-01T02:00:01Z",
+		},
+	}
+}
@@ -45,18 +45,12 @@ func TestResolveOCIVirtualMachineRequiresOS(t *testing.T) {
 }
 
 func TestOCIRegistryOptionsReadsPasswordStdin(t *testing.T) {
-	previousPassword := ociPassword
-	previousPasswordStdin := ociPasswordStdin
-	previousUsername := ociUsername
+	previousRegistry := ociRegistry
 	t.Cleanup(func() {
-		ociPassword = previousPassword
-		ociPasswordStdin = previousPasswordStdin
-		ociUsername = previousUsername
+		ociRegistry = previousRegistry
 	})
 
-	ociUsername = "user"
-	ociPassword = ""
-	ociPasswordStdin = true
+	ociRegistry = ociRegistryFlags{username: "user", passwordStdin: true}
 
 	command := &cobra.Command{}
 	command.SetIn(bytes.NewBufferString("secret\n"))
@@ -74,15 +68,12 @@ func TestOCIRegistryOptionsReadsPasswordStdin(t *testing.T) {
 }
 
 func TestOCIRegistryOptionsIncludeTLSSettings(t *testing.T) {
-	previousInsecureSkipTLSVerify := ociInsecureSkipTLSVerify
-	previousCAFile := ociCAFile
+	previousRegistry := ociRegistry
 	t.Cleanup(func() {
-		ociInsecureSkipTLSVerify = previousInsecureSkipTLSVerify
-		ociCAFile = previousCAFile
+		ociRegistry = previousRegistry
 	})
 
-	ociInsecureSkipTLSVerify = true
-	ociCAFile = "/tmp/dev-alchemy-test-ca.pem"
+	ociRegistry = ociRegistryFlags{insecureSkipTLSVerify: true, caFile: "/tmp/dev-alchemy-test-ca.pem"}
 
 	options, err := ociRegistryOptions(&cobra.Command{})
 	if err != nil {
@@ -307,6 +298,93 @@ func TestPullCommandIncludesForeignArtifactYesFlag(t *testing.T) {
 	}
 }
 
+func TestOCIPullVerificationKeysPrefersKeyFlagsOverTrustPolicy(t *testing.T) {
+	publicPath := testOCIPublicKey(t)
+	stubOCIVerificationFlags(t, false, []string{publicPath})
+	loadOCITrustPolicy = func() (alchemy_oci.TrustPolicy, string, bool, error) {
+		t.Fatal("did not expect the trust policy to be read when --key is set")
+		return alchemy_oci.TrustPolicy{}, "", false, nil
+	}
+
+	keys, err := ociPullVerificationKeys("ghcr.io/example/ubuntu-24:latest")
+	if err != nil {
+		t.Fatalf("expected --key to load: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("expected one verification key, got %d", len(keys))
+	}
+}
+
+func TestOCIPullVerificationKeysUsesMatchingTrustPolicyScope(t *testing.T) {
+	publicPath := testOCIPublicKey(t)
+	stubOCIVerificationFlags(t, false, nil)
+	loadOCITrustPolicy = func() (alchemy_oci.TrustPolicy, string, bool, error) {
+		return alchemy_oci.TrustPolicy{Repositories: []alchemy_oci.TrustPolicyRepository{
+			{Scope: "ghcr.io/example/*", Keys: []string{publicPath}},
+		}}, "oci-trust-policy.yml", true, nil
+	}
+
+	keys, err := ociPullVerificationKeys("ghcr.io/example/ubuntu-24:latest")
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("expected the policy key for a matching scope, got %d keys and %v", len(keys), err)
+	}
+	keys, err = ociPullVerificationKeys("ghcr.io/other/ubuntu-24:latest")
+	if err != nil || keys != nil {
+		t.Fatalf("expected no verification outside the policy scopes, got %d keys and %v", len(keys), err)
+	}
+}
+
+func TestOCIPullVerificationKeysRequiresKeysWithVerifyFlag(t *testing.T) {
+	stubOCIVerificationFlags(t, true, nil)
+	loadOCITrustPolicy = func() (alchemy_oci.TrustPolicy, string, bool, error) {
+		return alchemy_oci.TrustPolicy{}, "oci-trust-policy.yml", false, nil
+	}
+
+	_, err := ociPullVerificationKeys("ghcr.io/example/ubuntu-24:latest")
+	if err == nil || !strings.Contains(err.Error(), "--verify needs --key") {
+		t.Fatalf("expected --verify without keys to fail, got %v", err)
+	}
+}
+
+func TestOCICommandsIncludeSigningFlags(t *testing.T) {
+	for command, flagNames := range map[*cobra.Command][]string{
+		pushCmd: {"sign", "key", "attach-build-log", "attach-provenance", "attach-recording", "index"},
+		pullCmd: {"verify", "key"},
+	} {
+		for _, flagName := range flagNames {
+			if command.Flags().Lookup(flagName) == nil {
+				t.Fatalf("expected %s command to include --%s", command.Name(), flagName)
+			}
+		}
+	}
+}
+
+func stubOCIVerificationFlags(t *testing.T, verify bool, keyFiles []string) {
+	t.Helper()
+
+	previousVerify := ociVerify
+	previousKeyFiles := ociVerificationKeyFiles
+	previousLoadTrustPolicy := loadOCITrustPolicy
+	t.Cleanup(func() {
+		ociVerify = previousVerify
+		ociVerificationKeyFiles = previousKeyFiles
+		loadOCITrustPolicy = previousLoadTrustPolicy
+	})
+	ociVerify = verify
+	ociVerificationKeyFiles = keyFiles
+}
+
+func testOCIPublicKey(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	publicPath := filepath.Join(dir, "signing.pub")
+	if err := alchemy_oci.GenerateSigningKeyPair(filepath.Join(dir, "signing.key"), publicPath); err != nil {
+		t.Fatalf("failed to generate key pair: %v", err)
+	}
+	return publicPath
+}
+
 func testForeignOCIArtifact() alchemy_oci.ForeignArtifact {
 	return alchemy_oci.ForeignArtifact{
 		OS:                             "ubuntu",
@@ -320,3 +398,44 @@ func testForeignOCIArtifact() alchemy_oci.ForeignArtifact {
 		SourceVirtualizationAnnotation: "utm",
 	}
 }
+
+func TestResolveOCIPullVirtualMachineUsesIndexWithoutOSFlag(t *testing.T) {
+	previousResolve, previousArch := resolveOCIIndexTarget, currentHostArchitectureFunc
+	t.Cleanup(func() {
+		resolveOCIIndexTarget, currentHostArchitectureFunc = previousResolve, previousArch
+	})
+	currentHostArchitectureFunc = func() string { return "arm64" }
+	var candidates []alchemy_build.VirtualMachineConfig
+	resolveOCIIndexTarget = func(_ context.Context, reference string, _ alchemy_oci.RegistryOptions, got []alchemy_build.VirtualMachineConfig) (alchemy_build.VirtualMachineConfig, bool, error) {
+		candidates = got
+		return got[0], true, nil
+	}
+	command := testOCIImportCommand(t, "--host-os", "linux", "--type", "server")
+
+	vm, err := resolveOCIPullVirtualMachine(command, "localhost:5000/dev-alchemy/ubuntu-server:2026.10", alchemy_oci.RegistryOptions{})
+	if err != nil {
+		t.Fatalf("expected index target to resolve: %v", err)
+	}
+	if vm.Arch != "arm64" {
+		t.Fatalf("expected host architecture candidates first, got %s", vm.Arch)
+	}
+	for _, candidate := range candidates {
+		if candidate.OS == "ubuntu" && candidate.UbuntuType != "server" {
+			t.Fatalf("expected --type to narrow the candidates, got %+v", candidate)
+		}
+	}
+}
+
+func TestResolveOCIPullVirtualMachineRequiresOSForSingleTargetArtifact(t *testing.T) {
+	previousResolve := resolveOCIIndexTarget
+	t.Cleanup(func() { resolveOCIIndexTarget = previousResolve })
+	resolveOCIIndexTarget = func(context.Context, string, alchemy_oci.RegistryOptions, []alchemy_build.VirtualMachineConfig) (alchemy_build.VirtualMachineConfig, bool, error) {
+		return alchemy_build.VirtualMachineConfig{}, false, nil
+	}
+	command := testOCIImportCommand(t, "--host-os", "linux")
+
+	_, err := resolveOCIPullVirtualMachine(command, "localhost:5000/dev-alchemy/ubuntu:qemu", alchemy_oci.RegistryOptions{})
+	if err == nil || !strings.Contains(err.Error(), "missing required --os value") {
+		t.Fatalf("expected missing --os error for a single-target artifact, got %v", err)
+	}
+}
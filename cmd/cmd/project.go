@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	"github.com/spf13/cobra"
+)
+
+var (
+	projectDiffPatch      bool
+	projectOverlaysAccept bool
+)
+
+var (
+	ejectProject      = alchemy_build.EjectProject
+	projectDiff       = alchemy_build.ProjectDiff
+	projectFilePatch  = alchemy_build.ProjectFilePatch
+	currentProjectDir = func() string {
+		return alchemy_build.GetDirectoriesInstance().ProjectDir
+	}
+	projectOverlays          = alchemy_build.ProjectOverlays
+	acceptProjectOverlays    = alchemy_build.AcceptProjectOverlays
+	currentProjectOverlayDir = func() string {
+		return alchemy_build.GetDirectoriesInstance().ProjectOverlayDir
+	}
+)
+
+var projectDiffStatusMarks = map[alchemy_build.ProjectFileStatus]string{
+	alchemy_build.ProjectFileModified: "M",
+	alchemy_build.ProjectFileAdded:    "A",
+	alchemy_build.ProjectFileMissing:  "D",
+}
+
+// writeProjectDiff lists the changes below the path prefixes, or all of them
+// without prefixes, followed by their patches when patch is set.
+func writeProjectDiff(writer io.Writer, projectDir string, changes []alchemy_build.ProjectFileChange, prefixes []string, patch bool) error {
+	fmt.Fprintf(writer, "Comparing %s with the embedded project assets\n", projectDir)
+	shown := 0
+	for _, change := range changes {
+		if !alchemy_build.MatchesSlashPathPrefix(change.Path, prefixes) {
+			continue
+		}
+		shown++
+		if !patch {
+			fmt.Fprintf(writer, "%s %s\n", projectDiffStatusMarks[change.Status], change.Path)
+			continue
+		}
+		diff, err := projectFilePatch(projectDir, change.Path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(writer, diff)
+	}
+	if shown == 0 {
+		fmt.Fprintln(writer, "No differences.")
+	}
+	return nil
+}
+
+// writeProjectOverlays lists the overlay files below the path prefixes, or
+// all of them without prefixes.
+func writeProjectOverlays(writer io.Writer, overlayDir string, files []alchemy_build.ProjectOverlayFile, prefixes []string) {
+	fmt.Fprintf(writer, "Overlay dir: %s\n", overlayDir)
+	shown, changed := 0, 0
+	for _, file := range files {
+		if !alchemy_build.MatchesSlashPathPrefix(file.Path, prefixes) {
+			continue
+		}
+		shown++
+		if file.Status == alchemy_build.ProjectOverlayOriginalChanged {
+			changed++
+		}
+		fmt.Fprintf(writer, "%-16s  %s\n", file.Status, file.Path)
+	}
+	if shown == 0 {
+		fmt.Fprintln(writer, "No overlay files.")
+	}
+	if changed > 0 {
+		fmt.Fprintf(writer, "%d embedded original(s) changed under the overlay. Review them with \"alchemy project diff --patch\" and run \"alchemy project overlays --accept\".\n", changed)
+	}
+}
+
+var projectCmd = &cobra.Command{
+	Use:   "project",
+	Short: "Inspect and take ownership of the playbooks, roles, and templates",
+	Long: `Outside a git checkout, alchemy runs from a copy of its embedded playbooks,
+roles, Packer templates, and scripts in the app data dir, and replaces that
+copy whenever a new release ships different assets. These commands show how
+the project on disk differs from the embedded assets and write an editable
+copy that alchemy uses instead.
+
+Examples:
+  alchemy project diff
+  alchemy project diff --patch roles/base
+  alchemy project eject ~/dev-alchemy-project
+  alchemy project overlays
+`,
+}
+
+var projectEjectCmd = &cobra.Command{
+	Use:   "eject <dir>",
+	Short: "Write an editable copy of the embedded project and use it from now on",
+	Long: `Writes the embedded playbooks, roles, Packer templates, and scripts to an
+empty or missing directory and records it as project_dir in directories.yml
+in the config directory. Later runs use that directory instead of the git
+checkout or the managed copy, and never overwrite it. DEV_ALCHEMY_PROJECT_DIR
+takes precedence over the recorded directory.
+
+Use "alchemy project diff" after an upgrade to see what changed upstream.
+Remove project_dir from directories.yml to go back to the managed copy.
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ejected, err := ejectProject(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "Ejected %d file(s) to %s.\n", ejected.Files, ejected.Dir)
+		fmt.Fprintf(cmd.OutOrStdout(), "Recorded it as the project dir in %s.\n", ejected.ConfigPath)
+		if ejected.OverriddenBy != "" {
+			fmt.Fprintf(cmd.OutOrStdout(), "%s is set and still selects another project dir; unset it to use the ejected project.\n", ejected.OverriddenBy)
+		}
+		return nil
+	},
+}
+
+var projectDiffCmd = &cobra.Command{
+	Use:   "diff [path...]",
+	Short: "Show how the project on disk differs from the embedded assets",
+	Long: `Lists files of the project dir that differ from the embedded assets of this
+alchemy binary: M for modified, A for added inside an embedded directory, and
+D for deleted. Paths limit the output to files below them. With --patch, a
+unified diff from the embedded to the on-disk version is printed instead.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		projectDir := currentProjectDir()
+		changes, err := projectDiff(projectDir)
+		if err != nil {
+			return err
+		}
+		return writeProjectDiff(cmd.OutOrStdout(), projectDir, changes, args, projectDiffPatch)
+	},
+}
+
+var projectOverlaysCmd = &cobra.Command{
+	Use:   "overlays [path...]",
+	Short: "List the overlay files layered on top of the embedded project",
+	Long: `Lists the files of the project overlay dir, project-overlay in the config
+directory unless project_overlay_dir in directories.yml or
+DEV_ALCHEMY_PROJECT_OVERLAY_DIR selects another one. Each sync of the managed
+project copies them over the embedded assets:
+
+  shadows           the file replaces an embedded file
+  original changed  the embedded file changed since the overlay was accepted
+  adds              the file has no embedded original
+
+With --accept, the current embedded originals of the listed files are
+recorded, so they no longer count as changed. Paths limit both.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		overlayDir := currentProjectOverlayDir()
+		if projectOverlaysAccept {
+			accepted, err := acceptProjectOverlays(overlayDir, args)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Accepted the embedded originals of %d overlay file(s).\n", len(accepted))
+		}
+		files, err := projectOverlays(overlayDir)
+		if err != nil {
+			return err
+		}
+		writeProjectOverlays(cmd.OutOrStdout(), overlayDir, files, args)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(projectCmd)
+	projectCmd.AddCommand(projectEjectCmd, projectDiffCmd, projectOverlaysCmd)
+
+	projectDiffCmd.Flags().BoolVar(&projectDiffPatch, "patch", false, "Print unified diffs instead of the file list")
+	projectOverlaysCmd.Flags().BoolVar(&projectOverlaysAccept, "accept", false, "Record the current embedded originals of the overlay files")
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+)
+
+func TestWriteProjectDiffListsChangesBelowPrefixes(t *testing.T) {
+	changes := []alchemy_build.ProjectFileChange{
+		{Path: "ansible.cfg", Status: alchemy_build.ProjectFileModified},
+		{Path: "roles/base/files/extra.txt", Status: alchemy_build.ProjectFileAdded},
+		{Path: "roles/base/tasks/main.yml", Status: alchemy_build.ProjectFileMissing},
+	}
+
+	var output bytes.Buffer
+	if err := writeProjectDiff(&output, "/project", changes, []string{"roles/base/"}, false); err != nil {
+		t.Fatalf("writeProjectDiff returned error: %v", err)
+	}
+	want := "Comparing /project with the embedded project assets\nA roles/base/files/extra.txt\nD roles/base/tasks/main.yml\n"
+	if output.String() != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", output.String(), want)
+	}
+
+	output.Reset()
+	if err := writeProjectDiff(&output, "/project", changes, []string{"playbooks"}, false); err != nil {
+		t.Fatalf("writeProjectDiff returned error: %v", err)
+	}
+	if !strings.Contains(output.String(), "No differences.") {
+		t.Fatalf("expected no differences below playbooks, got:\n%s", output.String())
+	}
+}
+
+func TestWriteProjectDiffPrintsPatches(t *testing.T) {
+	previousPatch := projectFilePatch
+	t.Cleanup(func() { projectFilePatch = previousPatch })
+	projectFilePatch = func(projectDir string, path string) (string, error) {
+		return "--- embedded/" + path + "\n+++ project/" + path + "\n", nil
+	}
+
+	var output bytes.Buffer
+	changes := []alchemy_build.ProjectFileChange{{Path: "ansible.cfg", Status: alchemy_build.ProjectFileModified}}
+	if err := writeProjectDiff(&output, "/project", changes, nil, true); err != nil {
+		t.Fatalf("writeProjectDiff returned error: %v", err)
+	}
+	if !strings.Contains(output.String(), "--- embedded/ansible.cfg\n+++ project/ansible.cfg\n") {
+		t.Fatalf("expected the patch in the output, got:\n%s", output.String())
+	}
+}
+
+func TestProjectEjectCommandWarnsAboutEnvironmentOverride(t *testing.T) {
+	previousEject := ejectProject
+	t.Cleanup(func() { ejectProject = previousEject })
+	ejectProject = func(dir string) (alchemy_build.EjectedProject, error) {
+		return alchemy_build.EjectedProject{Dir: "/home/me/project", Files: 42, ConfigPath: "/config/directories.yml", OverriddenBy: "DEV_ALCHEMY_PROJECT_DIR"}, nil
+	}
+
+	var output bytes.Buffer
+	projectEjectCmd.SetOut(&output)
+	t.Cleanup(func() { projectEjectCmd.SetOut(nil) })
+	if err := projectEjectCmd.RunE(projectEjectCmd, []string{"project"}); err != nil {
+		t.Fatalf("project eject returned error: %v", err)
+	}
+	for _, want := range []string{
+		"Ejected 42 file(s) to /home/me/project.",
+		"Recorded it as the project dir in /config/directories.yml.",
+		"DEV_ALCHEMY_PROJECT_DIR is set",
+	} {
+		if !strings.Contains(output.String(), want) {
+			t.Fatalf("expected output to contain %q, got:\n%s", want, output.String())
+		}
+	}
+}
+
+func TestProjectOverlaysCommandAcceptsAndListsOverlayFiles(t *testing.T) {
+	previousOverlays, previousAccept, previousDir := projectOverlays, acceptProjectOverlays, currentProjectOverlayDir
+	previousAcceptFlag := projectOverlaysAccept
+	t.Cleanup(func() {
+		projectOverlays, acceptProjectOverlays, currentProjectOverlayDir = previousOverlays, previousAccept, previousDir
+		projectOverlaysAccept = previousAcceptFlag
+	})
+	currentProjectOverlayDir = func() string { return "/config/project-overlay" }
+	var acceptedPrefixes []string
+	acceptProjectOverlays = func(overlayDir string, prefixes []string) ([]string, error) {
+		acceptedPrefixes = prefixes
+		return []string{"build/packer/http/user-data"}, nil
+	}
+	projectOverlays = func(overlayDir string) ([]alchemy_build.ProjectOverlayFile, error) {
+		return []alchemy_build.ProjectOverlayFile{
+			{Path: "build/packer/http/extra.sh", Status: alchemy_build.ProjectOverlayAdds},
+			{Path: "build/packer/http/user-data", Status: alchemy_build.ProjectOverlayOriginalChanged},
+			{Path: "scripts/macos/setup.sh", Status: alchemy_build.ProjectOverlayShadows},
+		}, nil
+	}
+	projectOverlaysAccept = true
+
+	var output bytes.Buffer
+	projectOverlaysCmd.SetOut(&output)
+	t.Cleanup(func() { projectOverlaysCmd.SetOut(nil) })
+	if err := projectOverlaysCmd.RunE(projectOverlaysCmd, []string{"build/packer"}); err != nil {
+		t.Fatalf("project overlays returned error: %v", err)
+	}
+	if strings.Join(acceptedPrefixes, ",") != "build/packer" {
+		t.Fatalf("expected the paths to limit --accept, got %v", acceptedPrefixes)
+	}
+	want := "Accepted the embedded originals of 1 overlay file(s).\n" +
+		"Overlay dir: /config/project-overlay\n" +
+		"adds              build/packer/http/extra.sh\n" +
+		"original changed  build/packer/http/user-data\n" +
+		"1 embedded original(s) changed under the overlay. Review them with \"alchemy project diff --patch\" and run \"alchemy project overlays --accept\".\n"
+	if output.String() != want {
+		t.Fatalf("unexpected output:\n%s\nwant:\n%s", output.String(), want)
+	}
+}
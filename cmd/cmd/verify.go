@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	alchemy_provision "github.com/csautter/dev-alchemy/pkg/provision"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyPlaybook    string
+	verifyBootTimeout time.Duration
+)
+
+var (
+	runVerifyFunc             = alchemy_provision.RunVerify
+	readBuildArtifactMetadata = alchemy_build.ReadBuildArtifactMetadata
+)
+
+func isVerifySupported(vm alchemy_build.VirtualMachineConfig) bool {
+	return alchemy_provision.SupportsVerify(vm)
+}
+
+func availableVerifyVirtualMachines() []alchemy_build.VirtualMachineConfig {
+	var supported []alchemy_build.VirtualMachineConfig
+	for _, vm := range alchemy_build.AvailableVirtualMachineConfigsForCurrentHostOS() {
+		if isVerifySupported(vm) {
+			supported = append(supported, vm)
+		}
+	}
+	return supported
+}
+
+func verifyOptions() alchemy_provision.VerifyOptions {
+	return alchemy_provision.VerifyOptions{
+		CheckPlaybookPath: verifyPlaybook,
+		BootTimeout:       verifyBootTimeout,
+	}
+}
+
+// verifyState describes the recorded verification of the first build artifact
+// of vm.
+func verifyState(vm alchemy_build.VirtualMachineConfig) (string, error) {
+	if len(vm.ExpectedBuildArtifacts) == 0 {
+		return "-", nil
+	}
+	metadata, _, err := readBuildArtifactMetadata(vm.ExpectedBuildArtifacts[0])
+	if err != nil {
+		return "", err
+	}
+	if metadata.Verification == nil {
+		return "not verified", nil
+	}
+	return "verified " + metadata.Verification.VerifiedAt.Local().Format(time.DateTime), nil
+}
+
+func printAvailableVerifyCombinations() error {
+	vms := availableVerifyVirtualMachines()
+	return printVirtualMachineCombinationTable(
+		os.Stdout,
+		fmt.Sprintf("Available verify combinations for host OS: %s", alchemy_build.GetCurrentHostOs()),
+		"No verify combinations are available for the current host OS.",
+		vms,
+		[]string{"OS", "Type", "Arch", "Build", "Verified"},
+		func(vm alchemy_build.VirtualMachineConfig) ([]string, error) {
+			artifactState, err := buildArtifactState(vm)
+			if err != nil {
+				return nil, err
+			}
+			verified, err := verifyState(vm)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read verification for OS=%s, type=%s, arch=%s: %w", vm.OS, vm.UbuntuType, vm.Arch, err)
+			}
+			return []string{vm.OS, displayVirtualMachineType(vm), vm.Arch, artifactState, verified}, nil
+		},
+	)
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <osname>",
+	Short: "Boot test a build artifact and mark it verified",
+	Long: `Boots a throwaway copy-on-write overlay of a build artifact with QEMU,
+waits until SSH (Ubuntu) or WinRM (Windows) answers, optionally runs a check
+playbook against the guest, and tears it down again. On success the artifact
+is marked verified in its .dev-alchemy.json metadata. The artifact itself is
+never modified.
+
+Verification is available for QEMU targets on Linux hosts.
+
+Examples:
+  alchemy verify ubuntu --type server --arch amd64
+  alchemy verify windows11 --arch amd64 --boot-timeout 40m
+  alchemy verify ubuntu --type desktop --arch amd64 --playbook ./playbooks/check.yml
+  alchemy build ubuntu --type server --arch amd64 --verify
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		osName := args[0]
+
+		if osName != "ubuntu" {
+			osType = ""
+		}
+
+		vm, err := resolveBuildVirtualMachine(availableVerifyVirtualMachines(), osName, osType, arch, "")
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("🔍 Verifying build artifact for OS: %s, Type: %s, Architecture: %s\n", osName, osType, arch)
+		if err := runVerifyFunc(vm, verifyOptions()); err != nil {
+			return fmt.Errorf("verification failed for OS=%s, type=%s, arch=%s: %w", osName, osType, arch, err)
+		}
+		fmt.Printf("✅ Build artifact verified for OS: %s, Type: %s, Architecture: %s\n", osName, osType, arch)
+		return nil
+	},
+}
+
+var verifyListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List verify combinations and their recorded verification",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return printAvailableVerifyCombinations()
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.AddCommand(verifyListCmd)
+
+	verifyCmd.Flags().StringVarP(&arch, "arch", "a", "amd64", "Target architecture (e.g., amd64, arm64)")
+	verifyCmd.Flags().StringVarP(&osType, "type", "t", "server", "Type of OS (e.g., server, desktop)")
+	verifyCmd.Flags().StringVar(&verifyPlaybook, "playbook", "", "Optional check playbook to run against the booted guest")
+	verifyCmd.Flags().DurationVar(&verifyBootTimeout, "boot-timeout", 20*time.Minute, "How long the guest may take until SSH or WinRM answers")
+}
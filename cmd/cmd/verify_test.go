@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	alchemy_provision "github.com/csautter/dev-alchemy/pkg/provision"
+)
+
+func stubVerify(t *testing.T, verifyErr error) *[]alchemy_build.VirtualMachineConfig {
+	t.Helper()
+	previousVerify := runVerifyFunc
+	previousBuildVerify := buildVerify
+	t.Cleanup(func() {
+		runVerifyFunc = previousVerify
+		buildVerify = previousBuildVerify
+	})
+
+	var verified []alchemy_build.VirtualMachineConfig
+	runVerifyFunc = func(vm alchemy_build.VirtualMachineConfig, options alchemy_provision.VerifyOptions) error {
+		verified = append(verified, vm)
+		return verifyErr
+	}
+	return &verified
+}
+
+func TestBuildAndVerifyVerifiesAfterSuccessfulBuild(t *testing.T) {
+	verified := stubVerify(t, nil)
+	buildVerify = true
+
+	if err := buildAndVerify(ubuntuQemuBuildTarget(), func(alchemy_build.VirtualMachineConfig) error { return nil }); err != nil {
+		t.Fatalf("buildAndVerify returned error: %v", err)
+	}
+	if len(*verified) != 1 {
+		t.Fatalf("expected one verification, got %d", len(*verified))
+	}
+}
+
+func TestBuildAndVerifySkipsVerificationWhenBuildFails(t *testing.T) {
+	verified := stubVerify(t, nil)
+	buildVerify = true
+
+	err := buildAndVerify(ubuntuQemuBuildTarget(), func(alchemy_build.VirtualMachineConfig) error { return errors.New("packer failed") })
+	if err == nil || err.Error() != "packer failed" {
+		t.Fatalf("expected build error, got %v", err)
+	}
+	if len(*verified) != 0 {
+		t.Fatalf("expected no verification after a failed build, got %d", len(*verified))
+	}
+}
+
+func TestBuildAndVerifyReportsVerificationFailure(t *testing.T) {
+	stubVerify(t, errors.New("ssh unreachable"))
+	buildVerify = true
+
+	err := buildAndVerify(ubuntuQemuBuildTarget(), func(alchemy_build.VirtualMachineConfig) error { return nil })
+	if err == nil || !strings.Contains(err.Error(), "build succeeded but verification failed: ssh unreachable") {
+		t.Fatalf("expected verification failure, got %v", err)
+	}
+}
+
+func TestBuildAndVerifySkipsUnsupportedTargets(t *testing.T) {
+	verified := stubVerify(t, nil)
+	buildVerify = true
+
+	vm := alchemy_build.VirtualMachineConfig{
+		OS:                   "windows11",
+		Arch:                 "amd64",
+		HostOs:               alchemy_build.HostOsWindows,
+		VirtualizationEngine: alchemy_build.VirtualizationEngineHyperv,
+	}
+	if err := buildAndVerify(vm, func(alchemy_build.VirtualMachineConfig) error { return nil }); err != nil {
+		t.Fatalf("buildAndVerify returned error: %v", err)
+	}
+	if len(*verified) != 0 {
+		t.Fatalf("expected unsupported target to skip verification, got %d", len(*verified))
+	}
+}
+
+func TestVerifyStateReadsRecordedVerification(t *testing.T) {
+	artifact := filepath.Join(t.TempDir(), "ubuntu.qcow2")
+	if err := os.WriteFile(artifact, []byte("qcow2"), 0o600); err != nil {
+		t.Fatalf("failed to write artifact: %v", err)
+	}
+	vm := ubuntuQemuBuildTarget()
+	vm.ExpectedBuildArtifacts = []string{artifact}
+
+	if state, err := verifyState(vm); err != nil || state != "not verified" {
+		t.Fatalf("expected not verified, got %q err=%v", state, err)
+	}
+
+	verifiedAt := time.Date(2026, 3, 4, 5, 6, 7, 0, time.Local)
+	if err := alchemy_build.MarkBuildArtifactVerified(artifact, alchemy_build.ArtifactVerification{VerifiedAt: verifiedAt, Protocol: "ssh"}); err != nil {
+		t.Fatalf("failed to mark artifact verified: %v", err)
+	}
+	if state, err := verifyState(vm); err != nil || state != "verified 2026-03-04 05:06:07" {
+		t.Fatalf("expected verified state, got %q err=%v", state, err)
+	}
+}
+
+func TestBuildHelpIncludesVerifyFlags(t *testing.T) {
+	output := buildCmd.Flags().FlagUsages()
+	for _, want := range []string{"--verify", "--verify-playbook", "--verify-boot-timeout"} {
+		if !strings.Contains(output, want) {
+			t.Fatalf("expected %s flag in build flags, got %q", want, output)
+		}
+	}
+}
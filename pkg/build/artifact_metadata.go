@@ -0,0 +1,163 @@
+package build
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// buildArtifactMetadataSuffix names the sidecar file written next to each
+// build artifact. OCI transfers use explicit artifact paths, so the sidecar
+// never leaks into pushed layers.
+const buildArtifactMetadataSuffix = ".dev-alchemy.json"
+
+// BuildArtifactMetadata records what happened to a build artifact after
+// Packer produced it. SizeBytes, ModTime and Inode identify the artifact file
+// the metadata describes, so it is not attributed to a file that later
+// replaced it.
+type BuildArtifactMetadata struct {
+	BuiltAt      time.Time             `json:"built_at"`
+	SizeBytes    int64                 `json:"size_bytes,omitempty"`
+	ModTime      time.Time             `json:"mod_time"`
+	Inode        uint64                `json:"inode,omitempty"`
+	Optimization *ArtifactOptimization `json:"optimization,omitempty"`
+	Verification *ArtifactVerification `json:"verification,omitempty"`
+}
+
+// ArtifactOptimization records the result of the post-build optimization step.
+type ArtifactOptimization struct {
+	OriginalSizeBytes  int64     `json:"original_size_bytes"`
+	OptimizedSizeBytes int64     `json:"optimized_size_bytes"`
+	Sparsified         bool      `json:"sparsified"`
+	OptimizedAt        time.Time `json:"optimized_at"`
+}
+
+// ArtifactVerification records a successful boot smoke test of the artifact.
+type ArtifactVerification struct {
+	VerifiedAt    time.Time `json:"verified_at"`
+	Protocol      string    `json:"protocol"`
+	CheckPlaybook string    `json:"check_playbook,omitempty"`
+}
+
+// Describes reports whether the metadata was recorded for artifact as it is
+// now: its size, modification time and inode are unchanged since.
+func (m BuildArtifactMetadata) Describes(artifact string) (bool, error) {
+	current, err := describeBuildArtifact(artifact)
+	if err != nil {
+		return false, err
+	}
+	return !m.ModTime.IsZero() && m.SizeBytes == current.SizeBytes &&
+		m.ModTime.Equal(current.ModTime) && m.Inode == current.Inode, nil
+}
+
+// BuildArtifactMetadataPath returns the sidecar metadata path for artifact.
+func BuildArtifactMetadataPath(artifact string) string {
+	return artifact + buildArtifactMetadataSuffix
+}
+
+// ReadBuildArtifactMetadata loads the sidecar metadata for artifact. A missing
+// sidecar is not an error; exists reports whether one was found.
+func ReadBuildArtifactMetadata(artifact string) (BuildArtifactMetadata, bool, error) {
+	metadataPath := BuildArtifactMetadataPath(artifact)
+	content, err := os.ReadFile(metadataPath) // #nosec G304 -- metadataPath is derived from a managed build artifact path.
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return BuildArtifactMetadata{}, false, nil
+		}
+		return BuildArtifactMetadata{}, false, fmt.Errorf("read build artifact metadata %s: %w", metadataPath, err)
+	}
+
+	var metadata BuildArtifactMetadata
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		return BuildArtifactMetadata{}, true, fmt.Errorf("parse build artifact metadata %s: %w", metadataPath, err)
+	}
+	return metadata, true, nil
+}
+
+// WriteBuildArtifactMetadata replaces the sidecar metadata for artifact.
+func WriteBuildArtifactMetadata(artifact string, metadata BuildArtifactMetadata) error {
+	content, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	metadataPath := BuildArtifactMetadataPath(artifact)
+	tempPath := metadataPath + ".tmp"
+	if err := os.WriteFile(tempPath, append(content, '\n'), 0o644); err != nil { // #nosec G306 -- metadata sits next to world-readable cache artifacts.
+		return fmt.Errorf("write build artifact metadata %s: %w", metadataPath, err)
+	}
+	if err := os.Rename(tempPath, metadataPath); err != nil {
+		_ = os.Remove(tempPath)
+		return fmt.Errorf("write build artifact metadata %s: %w", metadataPath, err)
+	}
+	return nil
+}
+
+// RemoveBuildArtifactMetadata removes the sidecar metadata of artifact, for
+// artifacts replaced by something other than a local build. A missing sidecar
+// is not an error.
+func RemoveBuildArtifactMetadata(artifact string) error {
+	metadataPath := BuildArtifactMetadataPath(artifact)
+	if err := os.Remove(metadataPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("remove build artifact metadata %s: %w", metadataPath, err)
+	}
+	return nil
+}
+
+// MarkBuildArtifactVerified records verification in the sidecar metadata of
+// artifact and keeps everything else recorded for the current build. Metadata
+// recorded for another file at the same path is dropped.
+func MarkBuildArtifactVerified(artifact string, verification ArtifactVerification) error {
+	metadata, _, err := ReadBuildArtifactMetadata(artifact)
+	if err != nil {
+		return err
+	}
+	describes, err := metadata.Describes(artifact)
+	if err != nil {
+		return err
+	}
+	if !describes {
+		if metadata, err = describeBuildArtifact(artifact); err != nil {
+			return err
+		}
+	}
+	metadata.Verification = &verification
+	return WriteBuildArtifactMetadata(artifact, metadata)
+}
+
+// recordBuiltArtifacts resets the metadata of freshly built artifacts so
+// results recorded for a previous build of the same path do not linger.
+func recordBuiltArtifacts(artifacts []string, optimizations map[string]ArtifactOptimization) error {
+	var errs []error
+	builtAt := time.Now().UTC()
+	for _, artifact := range artifacts {
+		metadata, err := describeBuildArtifact(artifact)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		metadata.BuiltAt = builtAt
+		if optimization, ok := optimizations[artifact]; ok {
+			metadata.Optimization = &optimization
+		}
+		if err := WriteBuildArtifactMetadata(artifact, metadata); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// describeBuildArtifact returns metadata that identifies artifact as it is
+// now by its size, modification time and inode, without hashing it.
+func describeBuildArtifact(artifact string) (BuildArtifactMetadata, error) {
+	info, err := os.Stat(artifact)
+	if err != nil {
+		return BuildArtifactMetadata{}, fmt.Errorf("describe build artifact %s: %w", artifact, err)
+	}
+	inode, err := fileInode(artifact, info)
+	if err != nil {
+		return BuildArtifactMetadata{}, fmt.Errorf("describe build artifact %s: %w", artifact, err)
+	}
+	return BuildArtifactMetadata{SizeBytes: info.Size(), ModTime: info.ModTime().UTC(), Inode: inode}, nil
+}
@@ -0,0 +1,127 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadBuildArtifactMetadataMissingSidecarIsNotAnError(t *testing.T) {
+	metadata, exists, err := ReadBuildArtifactMetadata(filepath.Join(t.TempDir(), "artifact.qcow2"))
+	if err != nil {
+		t.Fatalf("expected missing metadata to load without error: %v", err)
+	}
+	if exists {
+		t.Fatal("expected missing metadata to report exists=false")
+	}
+	if !metadata.BuiltAt.IsZero() || metadata.Optimization != nil {
+		t.Fatalf("expected empty metadata, got %+v", metadata)
+	}
+}
+
+func TestRecordBuiltArtifactsWritesOptimizationResults(t *testing.T) {
+	tempDir := t.TempDir()
+	optimized := filepath.Join(tempDir, "optimized.qcow2")
+	plain := filepath.Join(tempDir, "plain.box")
+	writeDependencyFile(t, optimized, "optimized")
+	writeDependencyFile(t, plain, "plain")
+	optimizedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	err := recordBuiltArtifacts([]string{optimized, plain}, map[string]ArtifactOptimization{
+		optimized: {OriginalSizeBytes: 100, OptimizedSizeBytes: 40, Sparsified: true, OptimizedAt: optimizedAt},
+	})
+	if err != nil {
+		t.Fatalf("recordBuiltArtifacts returned error: %v", err)
+	}
+
+	metadata, exists, err := ReadBuildArtifactMetadata(optimized)
+	if err != nil || !exists {
+		t.Fatalf("expected optimized artifact metadata, exists=%t err=%v", exists, err)
+	}
+	if metadata.BuiltAt.IsZero() {
+		t.Fatal("expected built_at to be recorded")
+	}
+	if describes, err := metadata.Describes(optimized); err != nil || !describes {
+		t.Fatalf("expected the size, mtime and inode of the artifact to be recorded, got %+v err=%v", metadata, err)
+	}
+	want := ArtifactOptimization{OriginalSizeBytes: 100, OptimizedSizeBytes: 40, Sparsified: true, OptimizedAt: optimizedAt}
+	if metadata.Optimization == nil || *metadata.Optimization != want {
+		t.Fatalf("expected optimization %+v, got %+v", want, metadata.Optimization)
+	}
+
+	metadata, exists, err = ReadBuildArtifactMetadata(plain)
+	if err != nil || !exists {
+		t.Fatalf("expected plain artifact metadata, exists=%t err=%v", exists, err)
+	}
+	if metadata.Optimization != nil {
+		t.Fatalf("expected no optimization for plain artifact, got %+v", metadata.Optimization)
+	}
+}
+
+func TestReadBuildArtifactMetadataRejectsInvalidJSON(t *testing.T) {
+	artifact := filepath.Join(t.TempDir(), "artifact.qcow2")
+	if err := os.WriteFile(BuildArtifactMetadataPath(artifact), []byte("{"), 0o600); err != nil {
+		t.Fatalf("failed to write metadata: %v", err)
+	}
+
+	if _, exists, err := ReadBuildArtifactMetadata(artifact); err == nil || !exists {
+		t.Fatalf("expected parse error for existing sidecar, exists=%t err=%v", exists, err)
+	}
+}
+
+func TestMarkBuildArtifactVerifiedKeepsBuildMetadata(t *testing.T) {
+	artifact := filepath.Join(t.TempDir(), "artifact.qcow2")
+	writeDependencyFile(t, artifact, "artifact")
+	optimization := map[string]ArtifactOptimization{artifact: {OriginalSizeBytes: 10, OptimizedSizeBytes: 5}}
+	if err := recordBuiltArtifacts([]string{artifact}, optimization); err != nil {
+		t.Fatalf("recordBuiltArtifacts returned error: %v", err)
+	}
+
+	verifiedAt := time.Date(2026, 2, 3, 4, 5, 6, 0, time.UTC)
+	if err := MarkBuildArtifactVerified(artifact, ArtifactVerification{VerifiedAt: verifiedAt, Protocol: "ssh"}); err != nil {
+		t.Fatalf("MarkBuildArtifactVerified returned error: %v", err)
+	}
+
+	metadata, _, err := ReadBuildArtifactMetadata(artifact)
+	if err != nil {
+		t.Fatalf("failed to read metadata: %v", err)
+	}
+	if metadata.Optimization == nil || metadata.BuiltAt.IsZero() {
+		t.Fatalf("expected build metadata to be kept, got %+v", metadata)
+	}
+	if metadata.Verification == nil || !metadata.Verification.VerifiedAt.Equal(verifiedAt) {
+		t.Fatalf("expected verification to be recorded, got %+v", metadata.Verification)
+	}
+
+	if err := recordBuiltArtifacts([]string{artifact}, nil); err != nil {
+		t.Fatalf("recordBuiltArtifacts returned error: %v", err)
+	}
+	if metadata, _, _ := ReadBuildArtifactMetadata(artifact); metadata.Verification != nil {
+		t.Fatalf("expected a rebuild to clear the verification, got %+v", metadata.Verification)
+	}
+}
+
+func TestMarkBuildArtifactVerifiedDropsMetadataOfReplacedFile(t *testing.T) {
+	artifact := filepath.Join(t.TempDir(), "artifact.qcow2")
+	writeDependencyFile(t, artifact, "built")
+	optimization := map[string]ArtifactOptimization{artifact: {OriginalSizeBytes: 10, OptimizedSizeBytes: 5}}
+	if err := recordBuiltArtifacts([]string{artifact}, optimization); err != nil {
+		t.Fatalf("recordBuiltArtifacts returned error: %v", err)
+	}
+	writeDependencyFile(t, artifact, "replaced")
+
+	if err := MarkBuildArtifactVerified(artifact, ArtifactVerification{VerifiedAt: time.Now().UTC(), Protocol: "ssh"}); err != nil {
+		t.Fatalf("MarkBuildArtifactVerified returned error: %v", err)
+	}
+	metadata, _, err := ReadBuildArtifactMetadata(artifact)
+	if err != nil {
+		t.Fatalf("failed to read metadata: %v", err)
+	}
+	if metadata.Optimization != nil || !metadata.BuiltAt.IsZero() {
+		t.Fatalf("expected the build metadata of the replaced file to be dropped, got %+v", metadata)
+	}
+	if describes, err := metadata.Describes(artifact); err != nil || metadata.Verification == nil || !describes {
+		t.Fatalf("expected the verification to describe the replacement, got %+v err=%v", metadata, err)
+	}
+}
@@ -0,0 +1,100 @@
+package build
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	qemuImgExecutable      = "qemu-img"
+	virtSparsifyExecutable = "virt-sparsify"
+)
+
+var (
+	optimizeLookPath   = exec.LookPath
+	runOptimizeCommand = func(executable string, args []string) error {
+		_, err := RunCliCommand("", executable, args)
+		return err
+	}
+)
+
+// optimizeBuildArtifacts shrinks the QCOW2 artifacts of a successful build in
+// place. With virt-sparsify on PATH, unused guest filesystem blocks are
+// discarded first; qemu-img convert then rewrites the image with zstd
+// compression and without zero clusters. Optimization is best effort: a
+// failure keeps the unoptimized artifact and is only logged, because the
+// build itself succeeded. Results are keyed by finalArtifacts so they can be
+// recorded after staged artifacts are promoted.
+func optimizeBuildArtifacts(artifacts []string, finalArtifacts []string) map[string]ArtifactOptimization {
+	results := make(map[string]ArtifactOptimization, len(artifacts))
+	for i, artifact := range artifacts {
+		if !strings.EqualFold(filepath.Ext(artifact), ".qcow2") {
+			log.Printf("Skipping optimization for %s: only QCOW2 artifacts are optimized", artifact)
+			continue
+		}
+		result, err := optimizeQcow2Artifact(artifact)
+		if err != nil {
+			log.Printf("Artifact optimization failed for %s; keeping the unoptimized artifact: %v", artifact, err)
+			continue
+		}
+		log.Printf(
+			"Optimized build artifact %s: %s -> %s",
+			artifact,
+			FormatByteSize(result.OriginalSizeBytes),
+			FormatByteSize(result.OptimizedSizeBytes),
+		)
+		results[finalArtifacts[i]] = result
+	}
+	return results
+}
+
+func optimizeQcow2Artifact(artifact string) (ArtifactOptimization, error) {
+	info, err := os.Stat(artifact)
+	if err != nil {
+		return ArtifactOptimization{}, err
+	}
+	result := ArtifactOptimization{OriginalSizeBytes: info.Size()}
+
+	if _, err := optimizeLookPath(virtSparsifyExecutable); err == nil {
+		if err := runOptimizeCommand(virtSparsifyExecutable, []string{"--in-place", artifact}); err != nil {
+			log.Printf("virt-sparsify failed for %s; continuing with qemu-img only: %v", artifact, err)
+		} else {
+			result.Sparsified = true
+		}
+	}
+
+	if _, err := optimizeLookPath(qemuImgExecutable); err != nil {
+		return ArtifactOptimization{}, fmt.Errorf("%s is required to optimize artifacts: %w", qemuImgExecutable, err)
+	}
+	optimizedPath := stagedBuildArtifactPath(artifact, time.Now().UnixNano())
+	args := []string{"convert", "-O", "qcow2", "-c", "-o", "compression_type=zstd", artifact, optimizedPath}
+	if err := runOptimizeCommand(qemuImgExecutable, args); err != nil {
+		_ = os.Remove(optimizedPath)
+		return ArtifactOptimization{}, fmt.Errorf("qemu-img convert failed: %w", err)
+	}
+
+	optimizedInfo, err := os.Stat(optimizedPath)
+	if err != nil {
+		_ = os.Remove(optimizedPath)
+		return ArtifactOptimization{}, fmt.Errorf("inspect optimized artifact %s: %w", optimizedPath, err)
+	}
+	if optimizedInfo.Size() >= result.OriginalSizeBytes {
+		_ = os.Remove(optimizedPath)
+		result.OptimizedSizeBytes = result.OriginalSizeBytes
+		result.OptimizedAt = time.Now().UTC()
+		return result, nil
+	}
+	if err := os.Rename(optimizedPath, artifact); err != nil {
+		_ = os.Remove(optimizedPath)
+		return ArtifactOptimization{}, fmt.Errorf("replace artifact with optimized image: %w", err)
+	}
+
+	result.OptimizedSizeBytes = optimizedInfo.Size()
+	result.OptimizedAt = time.Now().UTC()
+	return result, nil
+}
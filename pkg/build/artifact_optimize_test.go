@@ -0,0 +1,155 @@
+package build
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type optimizeStub struct {
+	missing       map[string]bool
+	convertOutput []byte
+	convertErr    error
+	calls         [][]string
+}
+
+func stubArtifactOptimizer(t *testing.T, stub *optimizeStub) {
+	t.Helper()
+	previousLookPath := optimizeLookPath
+	previousRunCommand := runOptimizeCommand
+	t.Cleanup(func() {
+		optimizeLookPath = previousLookPath
+		runOptimizeCommand = previousRunCommand
+	})
+
+	optimizeLookPath = func(name string) (string, error) {
+		if stub.missing[name] {
+			return "", errors.New("not found")
+		}
+		return "/usr/bin/" + name, nil
+	}
+	runOptimizeCommand = func(executable string, args []string) error {
+		stub.calls = append(stub.calls, append([]string{executable}, args...))
+		if executable != qemuImgExecutable {
+			return nil
+		}
+		if stub.convertErr != nil {
+			return stub.convertErr
+		}
+		return os.WriteFile(args[len(args)-1], stub.convertOutput, 0o600)
+	}
+}
+
+func writeOptimizeArtifact(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write artifact: %v", err)
+	}
+}
+
+func TestOptimizeBuildArtifactsReplacesQcow2WithSmallerImage(t *testing.T) {
+	stub := &optimizeStub{convertOutput: []byte("small")}
+	stubArtifactOptimizer(t, stub)
+
+	tempDir := t.TempDir()
+	staged := filepath.Join(tempDir, "disk.dev-alchemy-build-1.qcow2")
+	final := filepath.Join(tempDir, "disk.qcow2")
+	writeOptimizeArtifact(t, staged, "a much larger unoptimized image")
+
+	results := optimizeBuildArtifacts([]string{staged}, []string{final})
+
+	result, ok := results[final]
+	if !ok {
+		t.Fatalf("expected optimization result keyed by final artifact, got %+v", results)
+	}
+	if result.OriginalSizeBytes != 31 || result.OptimizedSizeBytes != 5 || !result.Sparsified {
+		t.Fatalf("unexpected optimization result %+v", result)
+	}
+	content, err := os.ReadFile(staged)
+	if err != nil || string(content) != "small" {
+		t.Fatalf("expected artifact to be replaced by optimized image, got %q err=%v", content, err)
+	}
+	if len(stub.calls) != 2 || !reflect.DeepEqual(stub.calls[0], []string{virtSparsifyExecutable, "--in-place", staged}) {
+		t.Fatalf("expected virt-sparsify before qemu-img, got %v", stub.calls)
+	}
+	if got := stub.calls[1][:7]; !reflect.DeepEqual(got, []string{qemuImgExecutable, "convert", "-O", "qcow2", "-c", "-o", "compression_type=zstd"}) {
+		t.Fatalf("unexpected qemu-img invocation %v", stub.calls[1])
+	}
+}
+
+func TestOptimizeBuildArtifactsSkipsSparsifyWhenUnavailable(t *testing.T) {
+	stub := &optimizeStub{missing: map[string]bool{virtSparsifyExecutable: true}, convertOutput: []byte("x")}
+	stubArtifactOptimizer(t, stub)
+
+	artifact := filepath.Join(t.TempDir(), "disk.qcow2")
+	writeOptimizeArtifact(t, artifact, "unoptimized")
+
+	results := optimizeBuildArtifacts([]string{artifact}, []string{artifact})
+
+	if results[artifact].Sparsified {
+		t.Fatal("expected sparsified=false without virt-sparsify")
+	}
+	if len(stub.calls) != 1 || stub.calls[0][0] != qemuImgExecutable {
+		t.Fatalf("expected only qemu-img to run, got %v", stub.calls)
+	}
+}
+
+func TestOptimizeBuildArtifactsKeepsOriginalWhenNotSmaller(t *testing.T) {
+	stub := &optimizeStub{convertOutput: []byte("a larger converted image")}
+	stubArtifactOptimizer(t, stub)
+
+	artifact := filepath.Join(t.TempDir(), "disk.qcow2")
+	writeOptimizeArtifact(t, artifact, "original")
+
+	results := optimizeBuildArtifacts([]string{artifact}, []string{artifact})
+
+	if got := results[artifact]; got.OriginalSizeBytes != 8 || got.OptimizedSizeBytes != 8 {
+		t.Fatalf("expected unchanged sizes to be recorded, got %+v", got)
+	}
+	assertOnlyArtifactRemains(t, artifact, "original")
+}
+
+func TestOptimizeBuildArtifactsKeepsOriginalOnFailure(t *testing.T) {
+	stub := &optimizeStub{convertErr: errors.New("convert failed")}
+	stubArtifactOptimizer(t, stub)
+
+	artifact := filepath.Join(t.TempDir(), "disk.qcow2")
+	writeOptimizeArtifact(t, artifact, "original")
+
+	if results := optimizeBuildArtifacts([]string{artifact}, []string{artifact}); len(results) != 0 {
+		t.Fatalf("expected no results after a failed optimization, got %+v", results)
+	}
+	assertOnlyArtifactRemains(t, artifact, "original")
+}
+
+func TestOptimizeBuildArtifactsIgnoresNonQcow2Artifacts(t *testing.T) {
+	stub := &optimizeStub{}
+	stubArtifactOptimizer(t, stub)
+
+	artifact := filepath.Join(t.TempDir(), "disk.box")
+	writeOptimizeArtifact(t, artifact, "vagrant box")
+
+	if results := optimizeBuildArtifacts([]string{artifact}, []string{artifact}); len(results) != 0 {
+		t.Fatalf("expected non-qcow2 artifact to be skipped, got %+v", results)
+	}
+	if len(stub.calls) != 0 {
+		t.Fatalf("expected no optimize commands, got %v", stub.calls)
+	}
+}
+
+func assertOnlyArtifactRemains(t *testing.T, artifact string, want string) {
+	t.Helper()
+	content, err := os.ReadFile(artifact)
+	if err != nil || string(content) != want {
+		t.Fatalf("expected original artifact %q to remain, got %q err=%v", want, content, err)
+	}
+	entries, err := os.ReadDir(filepath.Dir(artifact))
+	if err != nil {
+		t.Fatalf("failed to list artifact dir: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected temporary optimize files to be removed, found %v", entries)
+	}
+}
@@ -0,0 +1,140 @@
+package build
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	buildCatalogConfigEnvVar = "DEV_ALCHEMY_BUILD_CATALOG_CONFIG"
+	buildCatalogConfigFile   = "build-catalog.yml"
+)
+
+// BuildCatalog holds user-supplied per-target build settings that are layered
+// on top of the built-in virtual machine configurations.
+type BuildCatalog struct {
+	Targets []BuildCatalogEntry `json:"targets" yaml:"targets"`
+}
+
+// BuildCatalogEntry selects build targets by OS, type, arch, engine and host OS.
+// Empty selector fields match every target.
+type BuildCatalogEntry struct {
+	OS             string            `json:"os" yaml:"os"`
+	Type           string            `json:"type" yaml:"type"`
+	Arch           string            `json:"arch" yaml:"arch"`
+	Engine         string            `json:"engine" yaml:"engine"`
+	HostOS         string            `json:"host_os" yaml:"host_os"`
+	PackerVars     map[string]string `json:"packer_vars" yaml:"packer_vars"`
+	PackerVarFiles []string          `json:"packer_var_files" yaml:"packer_var_files"`
+	// Optimize enables or disables post-build artifact optimization. Nil
+	// leaves the value set by earlier entries untouched. The --optimize flag
+	// enables optimization for every target regardless of the catalog.
+	Optimize *bool `json:"optimize,omitempty" yaml:"optimize"`
+}
+
+// BuildCatalogConfigPath returns the build catalog location, honoring the
+// DEV_ALCHEMY_BUILD_CATALOG_CONFIG override.
+func BuildCatalogConfigPath(directories *Directories) string {
+	if override := strings.TrimSpace(os.Getenv(buildCatalogConfigEnvVar)); override != "" {
+		return filepath.Clean(override)
+	}
+
+	return directories.ConfigPath(buildCatalogConfigFile)
+}
+
+// LoadBuildCatalog reads the build catalog from the managed config directory.
+// A missing catalog is not an error; exists reports whether a file was found.
+func LoadBuildCatalog() (BuildCatalog, string, bool, error) {
+	configPath := BuildCatalogConfigPath(GetDirectoriesInstance())
+	catalog, exists, err := loadBuildCatalog(configPath)
+	return catalog, configPath, exists, err
+}
+
+func loadBuildCatalog(configPath string) (BuildCatalog, bool, error) {
+	content, err := os.ReadFile(configPath) // #nosec G304 -- configPath is the documented user-selected build catalog file.
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return BuildCatalog{}, false, nil
+		}
+		return BuildCatalog{}, false, fmt.Errorf("read build catalog %q: %w", configPath, err)
+	}
+
+	catalog := BuildCatalog{}
+	if strings.TrimSpace(string(content)) == "" {
+		return catalog, true, nil
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&catalog); err != nil && !errors.Is(err, io.EOF) {
+		return BuildCatalog{}, true, fmt.Errorf("parse build catalog %q: %w", configPath, err)
+	}
+
+	configDir := filepath.Dir(configPath)
+	for i := range catalog.Targets {
+		for j, varFile := range catalog.Targets[i].PackerVarFiles {
+			varFile = strings.TrimSpace(varFile)
+			if varFile == "" {
+				return BuildCatalog{}, true, fmt.Errorf("%q target %d has an empty packer_var_files entry", configPath, i)
+			}
+			if !filepath.IsAbs(varFile) {
+				varFile = filepath.Join(configDir, varFile)
+			}
+			catalog.Targets[i].PackerVarFiles[j] = filepath.Clean(varFile)
+		}
+	}
+
+	return catalog, true, nil
+}
+
+// Matches reports whether the entry selects the given virtual machine config.
+func (entry BuildCatalogEntry) Matches(config VirtualMachineConfig) bool {
+	return catalogSelectorMatches(entry.OS, config.OS) &&
+		catalogSelectorMatches(entry.Type, config.UbuntuType) &&
+		catalogSelectorMatches(entry.Arch, config.Arch) &&
+		catalogSelectorMatches(entry.Engine, string(config.VirtualizationEngine)) &&
+		catalogHostOSMatches(entry.HostOS, config.HostOs)
+}
+
+func catalogSelectorMatches(selector string, value string) bool {
+	selector = strings.TrimSpace(selector)
+	return selector == "" || strings.EqualFold(selector, value)
+}
+
+func catalogHostOSMatches(selector string, hostOs HostOsType) bool {
+	switch strings.ToLower(strings.TrimSpace(selector)) {
+	case "":
+		return true
+	case "linux", string(HostOsLinux):
+		return hostOs == HostOsLinux
+	case "macos", string(HostOsDarwin):
+		return hostOs == HostOsDarwin
+	case string(HostOsWindows):
+		return hostOs == HostOsWindows
+	default:
+		return false
+	}
+}
+
+// Apply layers every matching catalog entry onto config in file order, so
+// later entries override packer_vars and optimize set by earlier, broader
+// entries.
+func (catalog BuildCatalog) Apply(config VirtualMachineConfig) VirtualMachineConfig {
+	for _, entry := range catalog.Targets {
+		if !entry.Matches(config) {
+			continue
+		}
+		config = WithPackerVariables(config, entry.PackerVars, entry.PackerVarFiles)
+		if entry.Optimize != nil {
+			config.Optimize = *entry.Optimize
+		}
+	}
+	return config
+}
@@ -0,0 +1,111 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadBuildCatalogMissingFileIsNotAnError(t *testing.T) {
+	catalog, exists, err := loadBuildCatalog(filepath.Join(t.TempDir(), buildCatalogConfigFile))
+	if err != nil {
+		t.Fatalf("expected missing catalog to load without error: %v", err)
+	}
+	if exists {
+		t.Fatal("expected missing catalog to report exists=false")
+	}
+	if len(catalog.Targets) != 0 {
+		t.Fatalf("expected empty catalog, got %+v", catalog)
+	}
+}
+
+func TestLoadBuildCatalogParsesPackerVarsAndResolvesVarFiles(t *testing.T) {
+	configDir := t.TempDir()
+	configPath := filepath.Join(configDir, buildCatalogConfigFile)
+	writeBuildCatalog(t, configPath, `
+targets:
+  - os: ubuntu
+    type: server
+    engine: qemu
+    packer_vars:
+      cpus: 6
+      headless: true
+    packer_var_files:
+      - ubuntu.pkrvars.hcl
+`)
+
+	catalog, exists, err := loadBuildCatalog(configPath)
+	if err != nil {
+		t.Fatalf("loadBuildCatalog returned error: %v", err)
+	}
+	if !exists || len(catalog.Targets) != 1 {
+		t.Fatalf("expected one catalog target, got exists=%t %+v", exists, catalog)
+	}
+	entry := catalog.Targets[0]
+	if !reflect.DeepEqual(entry.PackerVars, map[string]string{"cpus": "6", "headless": "true"}) {
+		t.Fatalf("unexpected packer_vars %v", entry.PackerVars)
+	}
+	if want := []string{filepath.Join(configDir, "ubuntu.pkrvars.hcl")}; !reflect.DeepEqual(entry.PackerVarFiles, want) {
+		t.Fatalf("expected var files %v, got %v", want, entry.PackerVarFiles)
+	}
+}
+
+func TestLoadBuildCatalogRejectsUnknownFields(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), buildCatalogConfigFile)
+	writeBuildCatalog(t, configPath, "targets:\n  - os: ubuntu\n    packer_var: {}\n")
+
+	_, _, err := loadBuildCatalog(configPath)
+	if err == nil || !strings.Contains(err.Error(), "packer_var") {
+		t.Fatalf("expected unknown field error, got %v", err)
+	}
+}
+
+func TestBuildCatalogApplyLayersMatchingEntriesInOrder(t *testing.T) {
+	catalog := BuildCatalog{Targets: []BuildCatalogEntry{
+		{PackerVars: map[string]string{"cpus": "2", "headless": "true"}},
+		{OS: "ubuntu", HostOS: "linux", PackerVars: map[string]string{"cpus": "6"}},
+		{OS: "windows11", PackerVars: map[string]string{"memory": "16384"}},
+		{OS: "ubuntu", HostOS: "macos", PackerVars: map[string]string{"cpus": "12"}},
+	}}
+
+	got := catalog.Apply(linuxUbuntuServerConfig())
+
+	if want := map[string]string{"cpus": "6", "headless": "true"}; !reflect.DeepEqual(got.PackerVars, want) {
+		t.Fatalf("expected %v, got %v", want, got.PackerVars)
+	}
+}
+
+func TestBuildCatalogApplyEnablesOptimizeOnlyWhenSet(t *testing.T) {
+	enabled := true
+	disabled := false
+	catalog := BuildCatalog{Targets: []BuildCatalogEntry{
+		{OS: "ubuntu", Optimize: &enabled},
+		{OS: "windows11", Optimize: &disabled},
+		{OS: "ubuntu", Type: "desktop", Optimize: &disabled},
+	}}
+
+	if got := catalog.Apply(linuxUbuntuServerConfig()); !got.Optimize {
+		t.Fatal("expected matching catalog entry to enable optimization")
+	}
+	if got := (BuildCatalog{}).Apply(linuxUbuntuServerConfig()); got.Optimize {
+		t.Fatal("expected optimization to stay disabled without a catalog entry")
+	}
+}
+
+func TestBuildCatalogConfigPathHonorsOverride(t *testing.T) {
+	override := filepath.Join(t.TempDir(), "catalog.yml")
+	t.Setenv(buildCatalogConfigEnvVar, override)
+
+	if got := BuildCatalogConfigPath(&Directories{ConfigDir: t.TempDir()}); got != override {
+		t.Fatalf("expected override %q, got %q", override, got)
+	}
+}
+
+func writeBuildCatalog(t *testing.T, configPath string, content string) {
+	t.Helper()
+	if err := os.WriteFile(configPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write build catalog: %v", err)
+	}
+}
@@ -0,0 +1,333 @@
+package build
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// BuildPlanAction describes what a build would do with a target.
+type BuildPlanAction string
+
+const (
+	// BuildPlanActionSkip means all expected artifacts exist and the build is skipped.
+	BuildPlanActionSkip BuildPlanAction = "skip"
+	// BuildPlanActionBuild means at least one expected artifact is missing.
+	BuildPlanActionBuild BuildPlanAction = "build"
+	// BuildPlanActionRebuild means --no-cache replaces existing artifacts.
+	BuildPlanActionRebuild BuildPlanAction = "rebuild"
+)
+
+const dependencySizeProbeTimeout = 15 * time.Second
+
+var (
+	// probeDependencySize reports the remote size of a dependency source, or -1
+	// when the server does not advertise one. Tests replace it to stay offline.
+	probeDependencySize = headContentLength
+	// vncPortAvailable reports whether a local VNC port can be bound right now.
+	vncPortAvailable = localPortAvailable
+)
+
+// BuildPlan describes what RunBuildScript would do for one target without
+// starting Packer.
+type BuildPlan struct {
+	Config       VirtualMachineConfig
+	Action       BuildPlanAction
+	Artifacts    []BuildPlanArtifact
+	Dependencies []BuildPlanDependency
+	// VncPort is the first free port at plan time, or 0 when the engine has no
+	// VNC console. The build picks its port again when it starts, the same way.
+	VncPort    int
+	Executable string
+	// Args are sanitized the same way the build logs them.
+	Args []string
+	// EstimatedDiskBytes covers pending downloads plus the expected artifact
+	// size when the target will be built.
+	EstimatedDiskBytes int64
+}
+
+// BuildPlanArtifact is an expected build artifact and its cache state.
+type BuildPlanArtifact struct {
+	Path      string
+	Exists    bool
+	SizeBytes int64
+	// Stale reports that the artifact is older than the target's Packer
+	// template. Builds still reuse it unless --no-cache is set.
+	Stale bool
+}
+
+// BuildPlanDependency is a web file dependency and whether it must be downloaded.
+type BuildPlanDependency struct {
+	LocalPath string
+	// Source is empty when the download URL is only resolved at build time.
+	Source string
+	Cached bool
+	// SizeBytes is -1 when the size is unknown.
+	SizeBytes int64
+}
+
+// PlanBuilds resolves the build plan for each config in order. VNC ports are
+// reserved across the returned plans so parallel builds do not collide.
+func PlanBuilds(configs []VirtualMachineConfig) ([]BuildPlan, error) {
+	planner := newBuildPlanner()
+	plans := make([]BuildPlan, 0, len(configs))
+	for _, config := range configs {
+		plan, err := planner.plan(config)
+		if err != nil {
+			return nil, fmt.Errorf("plan build for OS=%s type=%s arch=%s engine=%s: %w", config.OS, config.UbuntuType, config.Arch, config.VirtualizationEngine, err)
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
+
+// EstimatedPlanDiskBytes sums the disk estimate of all plans, counting a
+// dependency shared by several targets only once.
+func EstimatedPlanDiskBytes(plans []BuildPlan) int64 {
+	var total int64
+	counted := map[string]bool{}
+	for _, plan := range plans {
+		if plan.Action == BuildPlanActionSkip {
+			continue
+		}
+		total += plan.EstimatedDiskBytes
+		for _, dep := range plan.Dependencies {
+			if dep.Cached || dep.SizeBytes <= 0 {
+				continue
+			}
+			if counted[dep.LocalPath] {
+				total -= dep.SizeBytes
+			}
+			counted[dep.LocalPath] = true
+		}
+	}
+	return total
+}
+
+type buildPlanner struct {
+	reservedPorts map[int]bool
+	dependencies  map[string]BuildPlanDependency
+}
+
+func newBuildPlanner() *buildPlanner {
+	return &buildPlanner{
+		reservedPorts: map[int]bool{},
+		dependencies:  map[string]BuildPlanDependency{},
+	}
+}
+
+func (planner *buildPlanner) plan(config VirtualMachineConfig) (BuildPlan, error) {
+	config, err := withStagedBuildArtifactsForNoCache(config)
+	if err != nil {
+		return BuildPlan{}, err
+	}
+
+	plan := BuildPlan{Config: config}
+	plan.Artifacts, err = planBuildArtifacts(config)
+	if err != nil {
+		return BuildPlan{}, err
+	}
+	plan.Action = planBuildAction(config, plan.Artifacts)
+
+	if buildEngineUsesVnc(config.VirtualizationEngine) {
+		config.VncPort = planner.reserveVncPort(config.VncPort)
+		plan.VncPort = config.VncPort
+	}
+
+	executable, args, err := buildCommandForConfig(config)
+	if err != nil {
+		return BuildPlan{}, err
+	}
+	plan.Executable = executable
+	plan.Args = sanitizeCommandArgs(args)
+
+	if plan.Action == BuildPlanActionSkip {
+		return plan, nil
+	}
+
+	for _, dep := range webFileDependenciesForVMConfig(config) {
+		planned := planner.planDependency(dep)
+		plan.Dependencies = append(plan.Dependencies, planned)
+		if !planned.Cached && planned.SizeBytes > 0 {
+			plan.EstimatedDiskBytes += planned.SizeBytes
+		}
+	}
+	plan.EstimatedDiskBytes += estimatedBuildArtifactBytes(config, plan.Artifacts)
+	return plan, nil
+}
+
+func planBuildArtifacts(config VirtualMachineConfig) ([]BuildPlanArtifact, error) {
+	artifacts, err := resolveExpectedBuildArtifacts(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var templateModTime time.Time
+	if template, err := PackerTemplateForConfig(config); err == nil {
+		if info, err := os.Stat(filepath.Join(GetDirectoriesInstance().GetDirectories().ProjectDir, filepath.FromSlash(template))); err == nil {
+			templateModTime = info.ModTime()
+		}
+	}
+
+	planned := make([]BuildPlanArtifact, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		entry := BuildPlanArtifact{Path: artifact}
+		info, err := os.Stat(artifact)
+		switch {
+		case err == nil:
+			entry.Exists = true
+			entry.SizeBytes = info.Size()
+			entry.Stale = !templateModTime.IsZero() && info.ModTime().Before(templateModTime)
+		case !os.IsNotExist(err):
+			return nil, fmt.Errorf("failed to inspect build artifact %s: %w", artifact, err)
+		}
+		planned = append(planned, entry)
+	}
+	return planned, nil
+}
+
+func planBuildAction(config VirtualMachineConfig, artifacts []BuildPlanArtifact) BuildPlanAction {
+	allExist, anyExist := true, false
+	for _, artifact := range artifacts {
+		allExist = allExist && artifact.Exists
+		anyExist = anyExist || artifact.Exists
+	}
+	switch {
+	case config.NoCache && anyExist:
+		return BuildPlanActionRebuild
+	case !config.NoCache && allExist:
+		return BuildPlanActionSkip
+	default:
+		return BuildPlanActionBuild
+	}
+}
+
+func (planner *buildPlanner) reserveVncPort(start int) int {
+	port := nextFreeVncPort(start, planner.reservedPorts)
+	planner.reservedPorts[port] = true
+	return port
+}
+
+// nextFreeVncPort returns the first port from start on that is not reserved
+// and can be bound right now. Plans and builds both select ports with it.
+func nextFreeVncPort(start int, reserved map[int]bool) int {
+	port := start
+	for reserved[port] || !vncPortAvailable(port) {
+		port++
+	}
+	return port
+}
+
+func (planner *buildPlanner) planDependency(dep WebFileDependency) BuildPlanDependency {
+	if planned, ok := planner.dependencies[dep.LocalPath]; ok {
+		return planned
+	}
+
+	planned := BuildPlanDependency{LocalPath: dep.LocalPath, Source: dep.Source, SizeBytes: -1}
+	if info, err := os.Stat(dep.LocalPath); err == nil {
+		planned.SizeBytes = info.Size()
+	}
+	planned.Cached = checkIfWebFileDependencyExists(dep)
+	if !planned.Cached {
+		planned.SizeBytes = -1
+		if planned.Source != "" {
+			planned.SizeBytes = probeDependencySize(planned.Source)
+		}
+	}
+	planner.dependencies[dep.LocalPath] = planned
+	return planned
+}
+
+func buildEngineUsesVnc(engine VirtualizationEngine) bool {
+	return engine == VirtualizationEngineQemu || engine == VirtualizationEngineUtm
+}
+
+// buildCommandForConfig returns the executable and arguments the build for
+// config would run, mirroring the dispatch of the Run*Build* entry points.
+func buildCommandForConfig(config VirtualMachineConfig) (string, []string, error) {
+	switch config.HostOs {
+	case HostOsDarwin:
+		if config.VirtualizationEngine == VirtualizationEngineUtm {
+			switch config.OS {
+			case "ubuntu":
+				return "bash", qemuUbuntuBuildArgs(config, "build/packer/linux/ubuntu/linux-ubuntu-on-macos.sh"), nil
+			case "windows11":
+				return "bash", qemuWindowsBuildArgs(config, "build/packer/windows/windows11-on-macos.sh"), nil
+			}
+		}
+	case HostOsLinux:
+		if config.VirtualizationEngine == VirtualizationEngineQemu {
+			switch config.OS {
+			case "ubuntu":
+				return "bash", qemuUbuntuBuildArgs(config, "build/packer/linux/ubuntu/linux-ubuntu-on-linux.sh"), nil
+			case "windows11":
+				return "bash", qemuWindowsBuildArgs(config, "build/packer/windows/windows11-on-linux.sh"), nil
+			}
+		}
+	case HostOsWindows:
+		switch {
+		case config.VirtualizationEngine == VirtualizationEngineHyperv && config.OS == "windows11":
+			return packerExecutable, buildPackerArgs(config, hypervPackerFile), nil
+		case config.VirtualizationEngine == VirtualizationEngineHyperv && config.OS == "ubuntu":
+			return packerExecutable, hypervUbuntuBuildArgs(config), nil
+		case config.VirtualizationEngine == VirtualizationEngineVirtualBox && config.OS == "windows11":
+			return packerExecutable, buildPackerArgs(config, virtualBoxPackerFile), nil
+		}
+	}
+	return "", nil, fmt.Errorf("build is not implemented for OS=%s host_os=%s virtualization_engine=%s", config.OS, config.HostOs, config.VirtualizationEngine)
+}
+
+// estimatedBuildArtifactBytes approximates the space a build writes to the
+// cache. Existing artifacts are the best measure for a rebuild; otherwise a
+// typical finished image size per OS is used, because the templates' sparse
+// virtual disks are far larger than what a build actually writes.
+func estimatedBuildArtifactBytes(config VirtualMachineConfig, artifacts []BuildPlanArtifact) int64 {
+	var existing int64
+	for _, artifact := range artifacts {
+		existing += artifact.SizeBytes
+	}
+	if existing > 0 {
+		return existing
+	}
+
+	switch {
+	case config.OS == "windows11":
+		return 32 << 30
+	case config.OS == "ubuntu" && config.UbuntuType == "desktop":
+		return 16 << 30
+	default:
+		return 8 << 30
+	}
+}
+
+func headContentLength(source string) int64 {
+	ctx, cancel := context.WithTimeout(context.Background(), dependencySizeProbeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, source, nil)
+	if err != nil {
+		return -1
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return -1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return -1
+	}
+	return resp.ContentLength
+}
+
+func localPortAvailable(port int) bool {
+	ln, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return false
+	}
+	_ = ln.Close()
+	return true
+}
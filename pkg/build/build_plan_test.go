@@ -0,0 +1,174 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPlanBuildsReportsDownloadsCommandAndDiskEstimate(t *testing.T) {
+	withRepoProjectDir(t)
+	withPlanCacheDir(t)
+	stubBuildPlanProbes(t, map[int]bool{5901: true}, 3<<30)
+
+	config := linuxUbuntuServerConfig()
+	config.VncPort = 5901
+	config.ExpectedBuildArtifacts = []string{filepath.Join(t.TempDir(), "ubuntu.qcow2")}
+	config.PackerVars = map[string]string{"boot_wait": "5s"}
+
+	plans, err := PlanBuilds([]VirtualMachineConfig{config})
+	if err != nil {
+		t.Fatalf("PlanBuilds returned error: %v", err)
+	}
+	plan := plans[0]
+
+	if plan.Action != BuildPlanActionBuild {
+		t.Fatalf("expected build action, got %q", plan.Action)
+	}
+	if plan.VncPort != 5902 {
+		t.Fatalf("expected busy port 5901 to be skipped, got %d", plan.VncPort)
+	}
+	if plan.Executable != "bash" || !strings.HasSuffix(plan.Args[0], "linux-ubuntu-on-linux.sh") {
+		t.Fatalf("unexpected command %s %v", plan.Executable, plan.Args)
+	}
+	args := strings.Join(plan.Args, " ")
+	for _, want := range []string{"--vnc-port 5902", "--packer-var boot_wait=5s"} {
+		if !strings.Contains(args, want) {
+			t.Fatalf("expected args to contain %q, got %q", want, args)
+		}
+	}
+	if len(plan.Dependencies) != 1 || plan.Dependencies[0].Cached || plan.Dependencies[0].SizeBytes != 3<<30 {
+		t.Fatalf("expected one pending 3 GiB ISO download, got %+v", plan.Dependencies)
+	}
+	if want := int64(3<<30) + 8<<30; plan.EstimatedDiskBytes != want {
+		t.Fatalf("expected estimate %d, got %d", want, plan.EstimatedDiskBytes)
+	}
+}
+
+func TestPlanBuildsSkipsCachedArtifactsAndFlagsStaleOnes(t *testing.T) {
+	withRepoProjectDir(t)
+	withPlanCacheDir(t)
+	stubBuildPlanProbes(t, nil, -1)
+
+	artifact := filepath.Join(t.TempDir(), "ubuntu.qcow2")
+	if err := os.WriteFile(artifact, []byte("qcow2"), 0o600); err != nil {
+		t.Fatalf("failed to write artifact: %v", err)
+	}
+	old := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(artifact, old, old); err != nil {
+		t.Fatalf("failed to age artifact: %v", err)
+	}
+
+	config := linuxUbuntuServerConfig()
+	config.ExpectedBuildArtifacts = []string{artifact}
+
+	plans, err := PlanBuilds([]VirtualMachineConfig{config})
+	if err != nil {
+		t.Fatalf("PlanBuilds returned error: %v", err)
+	}
+	plan := plans[0]
+	if plan.Action != BuildPlanActionSkip {
+		t.Fatalf("expected skip action, got %q", plan.Action)
+	}
+	if !plan.Artifacts[0].Exists || !plan.Artifacts[0].Stale || plan.Artifacts[0].SizeBytes != 5 {
+		t.Fatalf("expected existing stale artifact, got %+v", plan.Artifacts[0])
+	}
+	if len(plan.Dependencies) != 0 || plan.EstimatedDiskBytes != 0 {
+		t.Fatalf("expected skipped target to need no downloads or disk, got %+v", plan)
+	}
+
+	config.NoCache = true
+	plans, err = PlanBuilds([]VirtualMachineConfig{config})
+	if err != nil {
+		t.Fatalf("PlanBuilds returned error: %v", err)
+	}
+	if plans[0].Action != BuildPlanActionRebuild {
+		t.Fatalf("expected rebuild action with --no-cache, got %q", plans[0].Action)
+	}
+	if !strings.Contains(strings.Join(plans[0].Args, " "), "--artifact-output-path") {
+		t.Fatalf("expected staged artifact arg for --no-cache plan, got %v", plans[0].Args)
+	}
+}
+
+func TestPlanBuildsReservesVncPortsAndCountsSharedDownloadsOnce(t *testing.T) {
+	withRepoProjectDir(t)
+	withPlanCacheDir(t)
+	stubBuildPlanProbes(t, nil, 1<<30)
+
+	server := linuxUbuntuServerConfig()
+	server.VncPort = 5921
+	server.ExpectedBuildArtifacts = []string{filepath.Join(t.TempDir(), "server.qcow2")}
+	desktop := server
+	desktop.UbuntuType = "desktop"
+	desktop.ExpectedBuildArtifacts = []string{filepath.Join(t.TempDir(), "desktop.qcow2")}
+
+	plans, err := PlanBuilds([]VirtualMachineConfig{server, desktop})
+	if err != nil {
+		t.Fatalf("PlanBuilds returned error: %v", err)
+	}
+	if plans[0].VncPort != 5921 || plans[1].VncPort != 5922 {
+		t.Fatalf("expected distinct VNC ports, got %d and %d", plans[0].VncPort, plans[1].VncPort)
+	}
+	if want := int64(1<<30) + 8<<30 + 16<<30; EstimatedPlanDiskBytes(plans) != want {
+		t.Fatalf("expected total estimate %d, got %d", want, EstimatedPlanDiskBytes(plans))
+	}
+}
+
+func TestEstimatedBuildArtifactBytesPrefersExistingArtifacts(t *testing.T) {
+	config := linuxUbuntuServerConfig()
+	if got := estimatedBuildArtifactBytes(config, []BuildPlanArtifact{{Path: "a"}}); got != 8<<30 {
+		t.Fatalf("expected typical ubuntu server estimate, got %d", got)
+	}
+	if got := estimatedBuildArtifactBytes(config, []BuildPlanArtifact{{Path: "a", Exists: true, SizeBytes: 5 << 30}}); got != 5<<30 {
+		t.Fatalf("expected existing artifact size, got %d", got)
+	}
+}
+
+func TestBuildCommandForConfigMatchesEveryBuildableTarget(t *testing.T) {
+	withRepoProjectDir(t)
+	for _, config := range AvailableVirtualMachineConfigs() {
+		if config.VirtualizationEngine == VirtualizationEngineTart {
+			continue
+		}
+		executable, args, err := buildCommandForConfig(config)
+		if err != nil {
+			t.Fatalf("expected build command for %s/%s/%s on %s: %v", config.OS, config.UbuntuType, config.Arch, config.HostOs, err)
+		}
+		if executable == "" || len(args) == 0 {
+			t.Fatalf("expected non-empty command for %s/%s/%s on %s", config.OS, config.UbuntuType, config.Arch, config.HostOs)
+		}
+	}
+}
+
+func withPlanCacheDir(t *testing.T) {
+	t.Helper()
+
+	dirs := GetDirectoriesInstance()
+	originalCacheDir := dirs.CacheDir
+	originalConfigDir := dirs.ConfigDir
+	originalRecordingsDir := dirs.RecordingsDir
+	dirs.CacheDir = t.TempDir()
+	dirs.ConfigDir = t.TempDir()
+	dirs.RecordingsDir = dirs.CacheDir
+	t.Setenv(dependencyLockEnvVar, "")
+	t.Cleanup(func() {
+		dirs.CacheDir = originalCacheDir
+		dirs.ConfigDir = originalConfigDir
+		dirs.RecordingsDir = originalRecordingsDir
+	})
+}
+
+func stubBuildPlanProbes(t *testing.T, busyPorts map[int]bool, dependencySize int64) {
+	t.Helper()
+
+	previousProbe := probeDependencySize
+	previousPortCheck := vncPortAvailable
+	t.Cleanup(func() {
+		probeDependencySize = previousProbe
+		vncPortAvailable = previousPortCheck
+	})
+	probeDependencySize = func(string) int64 { return dependencySize }
+	vncPortAvailable = func(port int) bool { return !busyPorts[port] }
+}
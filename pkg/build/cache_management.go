@@ -0,0 +1,487 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CacheCategory groups managed data for disk usage reports and garbage
+// collection.
+type CacheCategory string
+
+const (
+	CacheCategoryArtifacts     CacheCategory = "artifacts"
+	CacheCategoryDependencies  CacheCategory = "dependencies"
+	CacheCategoryRecordings    CacheCategory = "recordings"
+	CacheCategoryStaged        CacheCategory = "staged artifacts"
+	CacheCategoryRoleSources   CacheCategory = "role sources"
+	CacheCategoryLibvirtImages CacheCategory = "libvirt images"
+	CacheCategoryPacker        CacheCategory = "packer cache"
+	CacheCategoryVagrant       CacheCategory = "vagrant"
+	CacheCategoryOther         CacheCategory = "other"
+)
+
+// cacheCategoryOrder is the order categories are reported in.
+var cacheCategoryOrder = []CacheCategory{
+	CacheCategoryArtifacts,
+	CacheCategoryDependencies,
+	CacheCategoryRecordings,
+	CacheCategoryStaged,
+	CacheCategoryRoleSources,
+	CacheCategoryLibvirtImages,
+	CacheCategoryPacker,
+	CacheCategoryVagrant,
+	CacheCategoryOther,
+}
+
+var (
+	// recordingDirPattern matches the per-target VNC recording directories
+	// created by RunVncSnapshotProcess and captures the target slug.
+	recordingDirPattern = regexp.MustCompile(`^qemu-out-(.+)-vncsnapshot$`)
+	// stagedArtifactPattern matches names produced by stagedBuildArtifactPath.
+	stagedArtifactPattern = regexp.MustCompile(`\.dev-alchemy-build-\d+`)
+)
+
+const (
+	// OCIPullStagingDirName is the directory below an artifact root in which
+	// OCI pulls stage their blobs by digest, kept across interrupted pulls.
+	OCIPullStagingDirName = ".dev-alchemy-oci-pull-staging"
+	// OCIPullStagingMaxAge is how long blobs of an unfinished pull are kept
+	// for a retry before a later pull or garbage collection removes them.
+	OCIPullStagingMaxAge = 7 * 24 * time.Hour
+)
+
+// CacheCategoryRoot assigns a directory to a category, for data that other
+// packages keep in or next to the managed directories.
+type CacheCategoryRoot struct {
+	Category CacheCategory
+	Path     string
+}
+
+// CacheUsage is the disk usage of one category.
+type CacheUsage struct {
+	Category  CacheCategory
+	Paths     []string
+	SizeBytes int64
+	// Targets breaks SizeBytes down by target slug where the data belongs to
+	// one build target, sorted by slug.
+	Targets []CacheTargetUsage
+}
+
+// CacheTargetUsage is the part of a category's usage that belongs to one
+// build target.
+type CacheTargetUsage struct {
+	Target    string
+	SizeBytes int64
+}
+
+// CacheRemoval is a file or directory removed, or with a dry run only
+// selected, by garbage collection.
+type CacheRemoval struct {
+	Category  CacheCategory
+	Path      string
+	SizeBytes int64
+	Reason    string
+}
+
+// CacheGCPolicy selects what CollectCacheGarbage removes.
+type CacheGCPolicy struct {
+	// KeepRecordings is how many of the newest VNC recordings are kept. A
+	// negative value keeps all of them.
+	KeepRecordings int
+	// StagedMinAge protects staged artifacts that changed more recently,
+	// because they may belong to a build that is still running.
+	StagedMinAge time.Duration
+	DryRun       bool
+}
+
+// CacheUsageReport measures the cache, recordings, Packer cache and Vagrant
+// directories plus roots, split by category. Build artifacts and recordings
+// are broken down by the targets of configs. Unreadable entries are skipped.
+func CacheUsageReport(configs []VirtualMachineConfig, roots []CacheCategoryRoot) ([]CacheUsage, error) {
+	dirs := GetDirectoriesInstance()
+	cacheDir := filepath.Clean(dirs.CacheDir)
+
+	usage := map[CacheCategory]*CacheUsage{}
+	targets := map[CacheCategory]map[string]int64{}
+	addPath := func(category CacheCategory, path string) {
+		entry, ok := usage[category]
+		if !ok {
+			entry = &CacheUsage{Category: category}
+			usage[category] = entry
+		}
+		if path != "" && !slices.Contains(entry.Paths, path) {
+			entry.Paths = append(entry.Paths, path)
+		}
+	}
+	add := func(category CacheCategory, target string, size int64) {
+		addPath(category, "")
+		usage[category].SizeBytes += size
+		if target == "" {
+			return
+		}
+		if targets[category] == nil {
+			targets[category] = map[string]int64{}
+		}
+		targets[category][target] += size
+	}
+
+	artifacts := map[string]string{}
+	for _, config := range configs {
+		slug := GenerateVirtualMachineSlug(&config)
+		for _, artifact := range config.ExpectedBuildArtifacts {
+			artifacts[filepath.Clean(artifact)] = slug
+			artifacts[filepath.Clean(BuildArtifactMetadataPath(artifact))] = slug
+		}
+	}
+	dependencies := map[string]bool{filepath.Clean(dependencyVerificationCachePath()): true}
+	for _, dep := range webFileDependencyCatalog() {
+		path := filepath.Clean(dep.LocalPath)
+		dependencies[path] = true
+		dependencies[path+dependencyPartSuffix] = true
+		dependencies[dependencyPartStatePath(path+dependencyPartSuffix)] = true
+	}
+	for _, path := range derivedDependencyFiles() {
+		dependencies[filepath.Clean(path)] = true
+	}
+
+	rootsInCache := map[string]CacheCategory{}
+	for _, root := range roots {
+		path := filepath.Clean(root.Path)
+		addPath(root.Category, path)
+		if isWithinDir(cacheDir, path) {
+			rootsInCache[path] = root.Category
+			continue
+		}
+		size, err := CachePathSize(path)
+		if err != nil {
+			return nil, err
+		}
+		add(root.Category, "", size)
+	}
+
+	recordingsDir := filepath.Clean(dirs.RecordingsDir)
+	for _, category := range []CacheCategory{CacheCategoryArtifacts, CacheCategoryDependencies, CacheCategoryRecordings, CacheCategoryStaged, CacheCategoryOther} {
+		if category != CacheCategoryRecordings || recordingsDir == cacheDir {
+			addPath(category, cacheDir)
+		}
+	}
+	err := walkCache(cacheDir, func(path string, entry fs.DirEntry) error {
+		if path == cacheDir {
+			return nil
+		}
+		category, target, whole := classifyCachePath(path, entry, rootsInCache, artifacts, dependencies)
+		if entry.IsDir() {
+			if !whole {
+				return nil
+			}
+			size, err := CachePathSize(path)
+			if err != nil {
+				return err
+			}
+			add(category, target, size)
+			return filepath.SkipDir
+		}
+		if info, err := entry.Info(); err == nil {
+			add(category, target, info.Size())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if recordingsDir != cacheDir {
+		addPath(CacheCategoryRecordings, recordingsDir)
+		err := walkCache(recordingsDir, func(path string, entry fs.DirEntry) error {
+			if path == recordingsDir {
+				return nil
+			}
+			if entry.IsDir() {
+				match := recordingDirPattern.FindStringSubmatch(entry.Name())
+				if match == nil {
+					return nil
+				}
+				size, err := CachePathSize(path)
+				if err != nil {
+					return err
+				}
+				add(CacheCategoryRecordings, match[1], size)
+				return filepath.SkipDir
+			}
+			if info, err := entry.Info(); err == nil {
+				add(CacheCategoryRecordings, "", info.Size())
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for category, path := range map[CacheCategory]string{CacheCategoryPacker: dirs.PackerCacheDir, CacheCategoryVagrant: dirs.VagrantDir} {
+		size, err := CachePathSize(path)
+		if err != nil {
+			return nil, err
+		}
+		addPath(category, filepath.Clean(path))
+		add(category, "", size)
+	}
+
+	report := make([]CacheUsage, 0, len(usage))
+	for _, category := range cacheCategoryOrder {
+		entry, ok := usage[category]
+		if !ok {
+			continue
+		}
+		for target, size := range targets[category] {
+			entry.Targets = append(entry.Targets, CacheTargetUsage{Target: target, SizeBytes: size})
+		}
+		sort.Slice(entry.Targets, func(i, j int) bool {
+			return entry.Targets[i].Target < entry.Targets[j].Target
+		})
+		report = append(report, *entry)
+	}
+	return report, nil
+}
+
+// classifyCachePath returns the category and target of a path in the cache
+// dir. whole reports that a directory belongs to the category as a whole, so
+// its contents need no further classification.
+func classifyCachePath(path string, entry fs.DirEntry, rootsInCache map[string]CacheCategory, artifacts map[string]string, dependencies map[string]bool) (CacheCategory, string, bool) {
+	if category, ok := rootsInCache[path]; ok {
+		return category, "", true
+	}
+	if entry.IsDir() {
+		if match := recordingDirPattern.FindStringSubmatch(entry.Name()); match != nil {
+			return CacheCategoryRecordings, match[1], true
+		}
+	}
+	if stagedArtifactPattern.MatchString(entry.Name()) || (entry.IsDir() && entry.Name() == OCIPullStagingDirName) {
+		return CacheCategoryStaged, "", true
+	}
+	if target, ok := artifacts[path]; ok {
+		return CacheCategoryArtifacts, target, true
+	}
+	if dependencies[path] {
+		return CacheCategoryDependencies, "", true
+	}
+	return CacheCategoryOther, "", false
+}
+
+// CollectCacheGarbage removes old VNC recordings, staged artifacts left by
+// interrupted no-cache builds, OCI pull blobs unchanged for longer than
+// OCIPullStagingMaxAge, and cached downloads no dependency references,
+// following policy. With policy.DryRun everything is reported but kept.
+func CollectCacheGarbage(policy CacheGCPolicy) ([]CacheRemoval, error) {
+	var removals []CacheRemoval
+	var errs []error
+
+	recordings, err := staleRecordings(policy.KeepRecordings)
+	if err != nil {
+		return nil, err
+	}
+	staged, err := orphanedStagedArtifacts(policy.StagedMinAge)
+	if err != nil {
+		return nil, err
+	}
+	pullBlobs, err := agedOCIPullStagingBlobs(OCIPullStagingMaxAge)
+	if err != nil {
+		return nil, err
+	}
+	for _, removal := range slices.Concat(recordings, staged, pullBlobs) {
+		if !policy.DryRun {
+			if err := os.RemoveAll(removal.Path); err != nil {
+				errs = append(errs, fmt.Errorf("remove %s: %w", removal.Path, err))
+				continue
+			}
+		}
+		removals = append(removals, removal)
+	}
+
+	pruned, err := PruneDependencies(policy.DryRun)
+	for _, dep := range pruned {
+		removals = append(removals, CacheRemoval{
+			Category:  CacheCategoryDependencies,
+			Path:      dep.Path,
+			SizeBytes: dep.SizeBytes,
+			Reason:    "no dependency references it",
+		})
+	}
+	if err != nil {
+		errs = append(errs, err)
+	}
+	return removals, errors.Join(errs...)
+}
+
+// staleRecordings returns the recording directories beyond the keep newest.
+func staleRecordings(keep int) ([]CacheRemoval, error) {
+	if keep < 0 {
+		return nil, nil
+	}
+	matches, err := filepath.Glob(GetDirectoriesInstance().RecordingsPath("*", "qemu-out-*-vncsnapshot"))
+	if err != nil {
+		return nil, err
+	}
+
+	type recording struct {
+		path    string
+		modTime time.Time
+	}
+	var recordings []recording
+	for _, path := range matches {
+		modTime, err := newestModTime(path)
+		if err != nil {
+			return nil, err
+		}
+		recordings = append(recordings, recording{path: path, modTime: modTime})
+	}
+	sort.Slice(recordings, func(i, j int) bool {
+		return recordings[i].modTime.After(recordings[j].modTime)
+	})
+
+	var stale []CacheRemoval
+	for i, recording := range recordings {
+		if i < keep {
+			continue
+		}
+		size, err := CachePathSize(recording.path)
+		if err != nil {
+			return nil, err
+		}
+		stale = append(stale, CacheRemoval{
+			Category:  CacheCategoryRecordings,
+			Path:      recording.path,
+			SizeBytes: size,
+			Reason:    fmt.Sprintf("older than the %d newest recordings", keep),
+		})
+	}
+	return stale, nil
+}
+
+// orphanedStagedArtifacts returns staged build artifacts that have not
+// changed for minAge. Successful builds move them into place, so the rest
+// are left over from interrupted or failed no-cache builds.
+func orphanedStagedArtifacts(minAge time.Duration) ([]CacheRemoval, error) {
+	cacheDir := filepath.Clean(GetDirectoriesInstance().CacheDir)
+	cutoff := time.Now().Add(-minAge)
+	var orphaned []CacheRemoval
+	err := walkCache(cacheDir, func(path string, entry fs.DirEntry) error {
+		if path == cacheDir || !stagedArtifactPattern.MatchString(entry.Name()) {
+			return nil
+		}
+		modTime, err := newestModTime(path)
+		if err != nil {
+			return err
+		}
+		if modTime.Before(cutoff) {
+			size, err := CachePathSize(path)
+			if err != nil {
+				return err
+			}
+			orphaned = append(orphaned, CacheRemoval{
+				Category:  CacheCategoryStaged,
+				Path:      path,
+				SizeBytes: size,
+				Reason:    fmt.Sprintf("unchanged for more than %s", minAge),
+			})
+		}
+		if entry.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return orphaned, err
+}
+
+// agedOCIPullStagingBlobs returns the blobs in OCI pull staging directories
+// below the cache dir that have not been written to for longer than maxAge,
+// the rule pulls apply to their own staging directory.
+func agedOCIPullStagingBlobs(maxAge time.Duration) ([]CacheRemoval, error) {
+	cacheDir := filepath.Clean(GetDirectoriesInstance().CacheDir)
+	cutoff := time.Now().Add(-maxAge)
+	var aged []CacheRemoval
+	err := walkCache(cacheDir, func(path string, entry fs.DirEntry) error {
+		if !entry.IsDir() || entry.Name() != OCIPullStagingDirName {
+			return nil
+		}
+		err := walkCache(path, func(blob string, entry fs.DirEntry) error {
+			if !entry.Type().IsRegular() {
+				return nil
+			}
+			info, err := entry.Info()
+			if err != nil || !info.ModTime().Before(cutoff) {
+				return nil
+			}
+			aged = append(aged, CacheRemoval{
+				Category:  CacheCategoryStaged,
+				Path:      blob,
+				SizeBytes: info.Size(),
+				Reason:    fmt.Sprintf("OCI pull blob unchanged for more than %s", maxAge),
+			})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return filepath.SkipDir
+	})
+	return aged, err
+}
+
+// walkCache walks root like filepath.WalkDir, skipping a missing root and
+// unreadable entries.
+func walkCache(root string, visit func(path string, entry fs.DirEntry) error) error {
+	return filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+				if entry != nil && entry.IsDir() && path != root {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			return err
+		}
+		return visit(path, entry)
+	})
+}
+
+// CachePathSize returns the total size of the regular files at or below path.
+func CachePathSize(path string) (int64, error) {
+	var size int64
+	err := walkCache(path, func(_ string, entry fs.DirEntry) error {
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// newestModTime returns the latest modification time at or below path.
+func newestModTime(path string) (time.Time, error) {
+	var newest time.Time
+	err := walkCache(path, func(_ string, entry fs.DirEntry) error {
+		if info, err := entry.Info(); err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		return nil
+	})
+	return newest, err
+}
+
+func isWithinDir(dir string, path string) bool {
+	relativePath, err := filepath.Rel(dir, path)
+	return err == nil && relativePath != "." && relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator))
+}
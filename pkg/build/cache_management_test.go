@@ -0,0 +1,223 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setCacheModTime(t *testing.T, path string, modTime time.Time) {
+	t.Helper()
+	err := filepath.WalkDir(path, func(entryPath string, _ os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Chtimes(entryPath, modTime, modTime)
+	})
+	if err != nil {
+		t.Fatalf("failed to set mtime of %s: %v", path, err)
+	}
+}
+
+func TestCacheUsageReportSplitsCategoriesAndTargets(t *testing.T) {
+	withPlanCacheDir(t)
+	dirs := GetDirectoriesInstance()
+	originalPackerCacheDir, originalVagrantDir := dirs.PackerCacheDir, dirs.VagrantDir
+	dirs.PackerCacheDir, dirs.VagrantDir = t.TempDir(), t.TempDir()
+	t.Cleanup(func() {
+		dirs.PackerCacheDir, dirs.VagrantDir = originalPackerCacheDir, originalVagrantDir
+	})
+
+	artifact := dirs.CachePath("ubuntu", "qemu-ubuntu-server-packer-amd64.qcow2")
+	iso := dirs.CachePath("linux", "ubuntu.iso")
+	stubDependencyCatalog(t, []WebFileDependency{{LocalPath: iso}})
+	writeDependencyFile(t, artifact, "artifact")
+	writeDependencyFile(t, BuildArtifactMetadataPath(artifact), "{}")
+	writeDependencyFile(t, iso, "iso")
+	writeDependencyFile(t, dirs.CachePath("ubuntu", "qemu-out-ubuntu-server-amd64-vncsnapshot", "qemu.vnc.mp4"), "video")
+	writeDependencyFile(t, dirs.CachePath("ubuntu", "qemu-ubuntu-server-packer-amd64.dev-alchemy-build-42.qcow2"), "staged")
+	writeDependencyFile(t, dirs.CachePath("ansible-role-sources", "base", "README.md"), "roles")
+	writeDependencyFile(t, dirs.CachePath("notes.txt"), "other")
+	writeDependencyFile(t, filepath.Join(dirs.PackerCacheDir, "packer.iso"), "packer")
+	libvirtDir := t.TempDir()
+	writeDependencyFile(t, filepath.Join(libvirtDir, "ubuntu.qcow2"), "libvirt")
+
+	config := VirtualMachineConfig{OS: "ubuntu", UbuntuType: "server", Arch: "amd64", ExpectedBuildArtifacts: []string{artifact}}
+	usage, err := CacheUsageReport([]VirtualMachineConfig{config}, []CacheCategoryRoot{
+		{Category: CacheCategoryRoleSources, Path: dirs.CachePath("ansible-role-sources")},
+		{Category: CacheCategoryLibvirtImages, Path: libvirtDir},
+	})
+	if err != nil {
+		t.Fatalf("CacheUsageReport returned error: %v", err)
+	}
+
+	byCategory := map[CacheCategory]CacheUsage{}
+	var order []CacheCategory
+	for _, category := range usage {
+		byCategory[category.Category] = category
+		order = append(order, category.Category)
+	}
+	for category, want := range map[CacheCategory]int64{
+		CacheCategoryArtifacts:     int64(len("artifact") + len("{}")),
+		CacheCategoryDependencies:  int64(len("iso")),
+		CacheCategoryRecordings:    int64(len("video")),
+		CacheCategoryStaged:        int64(len("staged")),
+		CacheCategoryRoleSources:   int64(len("roles")),
+		CacheCategoryLibvirtImages: int64(len("libvirt")),
+		CacheCategoryPacker:        int64(len("packer")),
+		CacheCategoryVagrant:       0,
+		CacheCategoryOther:         int64(len("other")),
+	} {
+		if got := byCategory[category].SizeBytes; got != want {
+			t.Fatalf("expected %s to use %d bytes, got %d (report %+v)", category, want, got, usage)
+		}
+	}
+	if order[0] != CacheCategoryArtifacts || order[len(order)-1] != CacheCategoryOther {
+		t.Fatalf("expected categories in report order, got %v", order)
+	}
+	if targets := byCategory[CacheCategoryArtifacts].Targets; len(targets) != 1 || targets[0].Target != "ubuntu-server-amd64" {
+		t.Fatalf("expected artifact usage per target, got %+v", targets)
+	}
+	if targets := byCategory[CacheCategoryRecordings].Targets; len(targets) != 1 || targets[0].Target != "ubuntu-server-amd64" {
+		t.Fatalf("expected recording usage per target, got %+v", targets)
+	}
+}
+
+func TestCollectCacheGarbageFollowsPolicy(t *testing.T) {
+	withPlanCacheDir(t)
+	dirs := GetDirectoriesInstance()
+	now := time.Now()
+
+	var recordings []string
+	for i, slug := range []string{"ubuntu-server-amd64", "ubuntu-desktop-amd64", "windows11-amd64"} {
+		osName, _, _ := strings.Cut(slug, "-")
+		recording := dirs.CachePath(osName, "qemu-out-"+slug+"-vncsnapshot")
+		writeDependencyFile(t, filepath.Join(recording, "qemu.vnc.mp4"), "video")
+		setCacheModTime(t, recording, now.Add(-time.Duration(i)*time.Hour))
+		recordings = append(recordings, recording)
+	}
+	oldStaged := dirs.CachePath("ubuntu", "qemu-ubuntu-server-packer-amd64.dev-alchemy-build-1.qcow2")
+	runningStaged := dirs.CachePath("ubuntu", "qemu-ubuntu-server-packer-amd64.dev-alchemy-build-2.qcow2")
+	writeDependencyFile(t, oldStaged, "staged")
+	writeDependencyFile(t, runningStaged, "staged")
+	setCacheModTime(t, oldStaged, now.Add(-48*time.Hour))
+	unused := dirs.CachePath("linux", "old-ubuntu.iso")
+	writeDependencyFile(t, unused, "old")
+	stubDependencyCatalog(t, []WebFileDependency{{LocalPath: dirs.CachePath("linux", "ubuntu.iso")}})
+
+	policy := CacheGCPolicy{KeepRecordings: 1, StagedMinAge: 24 * time.Hour, DryRun: true}
+	removals, err := CollectCacheGarbage(policy)
+	if err != nil {
+		t.Fatalf("CollectCacheGarbage returned error: %v", err)
+	}
+	var removed []string
+	for _, removal := range removals {
+		removed = append(removed, removal.Path)
+	}
+	want := []string{recordings[1], recordings[2], oldStaged, unused}
+	if len(removed) != len(want) {
+		t.Fatalf("expected removals %v, got %v", want, removed)
+	}
+	for i := range want {
+		if removed[i] != want[i] {
+			t.Fatalf("expected removals %v, got %v", want, removed)
+		}
+	}
+	if _, err := os.Stat(recordings[2]); err != nil {
+		t.Fatalf("expected dry run to keep files, got %v", err)
+	}
+
+	policy.DryRun = false
+	if _, err := CollectCacheGarbage(policy); err != nil {
+		t.Fatalf("CollectCacheGarbage returned error: %v", err)
+	}
+	for _, path := range want {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed, got %v", path, err)
+		}
+	}
+	for _, path := range []string{recordings[0], runningStaged} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("expected %s to be kept, got %v", path, err)
+		}
+	}
+}
+
+func TestCollectCacheGarbageKeepsAllRecordingsWhenNegative(t *testing.T) {
+	withPlanCacheDir(t)
+	stubDependencyCatalog(t, nil)
+	writeDependencyFile(t, GetDirectoriesInstance().CachePath("ubuntu", "qemu-out-ubuntu-server-amd64-vncsnapshot", "qemu.vnc.mp4"), "video")
+
+	removals, err := CollectCacheGarbage(CacheGCPolicy{KeepRecordings: -1, DryRun: true})
+	if err != nil || len(removals) != 0 {
+		t.Fatalf("expected every recording to be kept, got %+v err=%v", removals, err)
+	}
+}
+
+func TestCacheUsageReportMeasuresRecordingsOutsideCache(t *testing.T) {
+	withPlanCacheDir(t)
+	dirs := GetDirectoriesInstance()
+	dirs.RecordingsDir = t.TempDir()
+	stubDependencyCatalog(t, nil)
+	writeDependencyFile(t, dirs.RecordingsPath("ubuntu", "qemu-out-ubuntu-server-amd64-vncsnapshot", "qemu.vnc.mp4"), "video")
+
+	usage, err := CacheUsageReport(nil, nil)
+	if err != nil {
+		t.Fatalf("CacheUsageReport returned error: %v", err)
+	}
+	for _, category := range usage {
+		if category.Category != CacheCategoryRecordings {
+			continue
+		}
+		if category.SizeBytes != int64(len("video")) || len(category.Paths) != 1 || category.Paths[0] != dirs.RecordingsDir {
+			t.Fatalf("expected recordings to be measured in their own dir, got %+v", category)
+		}
+		if len(category.Targets) != 1 || category.Targets[0].Target != "ubuntu-server-amd64" {
+			t.Fatalf("expected recordings to be split by target, got %+v", category.Targets)
+		}
+		return
+	}
+	t.Fatalf("expected a recordings category, got %+v", usage)
+}
+
+func TestCacheManagementTreatsOCIPullStagingAsStaged(t *testing.T) {
+	withPlanCacheDir(t)
+	dirs := GetDirectoriesInstance()
+	stubDependencyCatalog(t, nil)
+	staging := dirs.CachePath("ubuntu", OCIPullStagingDirName)
+	agedBlob := filepath.Join(staging, "sha256", "aged")
+	partialBlob := filepath.Join(staging, "sha256", "partial.partial")
+	writeDependencyFile(t, agedBlob, "aged blob")
+	writeDependencyFile(t, partialBlob, "partial")
+	setCacheModTime(t, agedBlob, time.Now().Add(-OCIPullStagingMaxAge-time.Hour))
+
+	usage, err := CacheUsageReport(nil, nil)
+	if err != nil {
+		t.Fatalf("CacheUsageReport returned error: %v", err)
+	}
+	for _, category := range usage {
+		want := int64(0)
+		if category.Category == CacheCategoryStaged {
+			want = int64(len("aged blob") + len("partial"))
+		}
+		if category.SizeBytes != want {
+			t.Fatalf("expected %s to use %d bytes, got %d (report %+v)", category.Category, want, category.SizeBytes, usage)
+		}
+	}
+
+	removals, err := CollectCacheGarbage(CacheGCPolicy{KeepRecordings: -1})
+	if err != nil {
+		t.Fatalf("CollectCacheGarbage returned error: %v", err)
+	}
+	if len(removals) != 1 || removals[0].Path != agedBlob || removals[0].Category != CacheCategoryStaged {
+		t.Fatalf("expected only the aged pull blob to be removed, got %+v", removals)
+	}
+	if _, err := os.Stat(agedBlob); !os.IsNotExist(err) {
+		t.Fatalf("expected the aged pull blob to be removed, got %v", err)
+	}
+	if _, err := os.Stat(partialBlob); err != nil {
+		t.Fatalf("expected the recent partial blob to be kept, got %v", err)
+	}
+}
@@ -0,0 +1,296 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const dataDirMoveStagingSuffix = ".dev-alchemy-move"
+
+// DataDirMove reports how MoveDataDir relocated a data directory.
+type DataDirMove struct {
+	Category DataDirCategory
+	From     string
+	To       string
+	// Copied is set when the data crossed filesystems and was copied and
+	// verified before the original was removed.
+	Copied     bool
+	SizeBytes  int64
+	ConfigPath string
+}
+
+type dataDirMoveEntry struct {
+	from string
+	to   string
+}
+
+// MoveDataDir moves the data of category from the from directory to the to
+// directory and records to in directories.yml. The data is renamed when both
+// are on one filesystem and copied otherwise; copies are verified and the
+// originals are only removed after the config was updated. It refuses to run
+// when an environment variable selects the directory, when to is not empty,
+// and for a non-empty images dir, whose disks libvirt domains reference by
+// path. A returned error with a non-empty move means the data was moved but
+// an original could not be removed.
+func MoveDataDir(category DataDirCategory, from string, to string) (DataDirMove, error) {
+	spec, err := lookupDataDirSpec(category)
+	if err != nil {
+		return DataDirMove{}, err
+	}
+	if value := strings.TrimSpace(os.Getenv(spec.envVar)); value != "" {
+		return DataDirMove{}, fmt.Errorf("%s selects the %s dir; unset it before moving the directory", spec.envVar, category)
+	}
+	if !filepath.IsAbs(to) {
+		return DataDirMove{}, fmt.Errorf("target %q must be an absolute path", to)
+	}
+	from, to = filepath.Clean(from), filepath.Clean(to)
+	if from == to {
+		return DataDirMove{}, fmt.Errorf("%s dir is already %q", category, to)
+	}
+	if dirsOverlap(from, to) {
+		return DataDirMove{}, fmt.Errorf("target %q must not be inside %q or contain it", to, from)
+	}
+
+	directories := GetDirectoriesInstance()
+	directories.GetDirectories()
+	recordingsFollowCache := category == DataDirCache && directories.RecordingsDir == directories.CacheDir
+	candidate := *directories
+	*spec.field(&candidate) = to
+	if recordingsFollowCache {
+		candidate.RecordingsDir = to
+	}
+	if err := validateDataDirs(candidate); err != nil {
+		return DataDirMove{}, err
+	}
+	if empty, err := isEmptyOrMissingDir(to); err != nil {
+		return DataDirMove{}, err
+	} else if !empty {
+		return DataDirMove{}, fmt.Errorf("target %q must be empty", to)
+	}
+	if category == DataDirImages {
+		if empty, err := isEmptyOrMissingDir(from); err != nil {
+			return DataDirMove{}, err
+		} else if !empty {
+			return DataDirMove{}, fmt.Errorf("images dir %q holds VM disks that libvirt domains reference by path; destroy the VMs before moving it", from)
+		}
+	}
+
+	entries, err := dataDirMoveEntries(category, from, to, directories)
+	if err != nil {
+		return DataDirMove{}, err
+	}
+	move := DataDirMove{Category: category, From: from, To: to, ConfigPath: DataDirsConfigPath(directories)}
+	if err := os.MkdirAll(to, managedDirPermission); err != nil {
+		return DataDirMove{}, err
+	}
+
+	var renamed, copied []dataDirMoveEntry
+	undo := func() {
+		for _, entry := range renamed {
+			_ = os.Rename(entry.to, entry.from)
+		}
+		for _, entry := range copied {
+			_ = os.RemoveAll(entry.to)
+		}
+	}
+	for _, entry := range entries {
+		size, err := CachePathSize(entry.from)
+		if err != nil {
+			undo()
+			return DataDirMove{}, err
+		}
+		move.SizeBytes += size
+		wasCopied, err := moveDataDirEntry(entry)
+		if err != nil {
+			undo()
+			return DataDirMove{}, err
+		}
+		if wasCopied {
+			move.Copied = true
+			copied = append(copied, entry)
+		} else {
+			renamed = append(renamed, entry)
+		}
+	}
+
+	if err := setDataDirConfig(move.ConfigPath, category, to); err != nil {
+		undo()
+		return DataDirMove{}, fmt.Errorf("record %s dir in %q: %w", category, move.ConfigPath, err)
+	}
+	*spec.field(directories) = to
+	if recordingsFollowCache {
+		directories.RecordingsDir = to
+	}
+
+	var errs []error
+	for _, entry := range copied {
+		if err := os.RemoveAll(entry.from); err != nil {
+			errs = append(errs, fmt.Errorf("remove the original %q after copying it: %w", entry.from, err))
+		}
+	}
+	return move, errors.Join(errs...)
+}
+
+// dataDirMoveEntries returns what to move. Recordings kept in the cache dir
+// are moved one recording at a time so the rest of the cache stays put.
+func dataDirMoveEntries(category DataDirCategory, from string, to string, directories *Directories) ([]dataDirMoveEntry, error) {
+	if category == DataDirRecordings && from == filepath.Clean(directories.CacheDir) {
+		matches, err := filepath.Glob(filepath.Join(from, "*", "qemu-out-*-vncsnapshot"))
+		if err != nil {
+			return nil, err
+		}
+		entries := make([]dataDirMoveEntry, 0, len(matches))
+		for _, match := range matches {
+			relativePath, err := filepath.Rel(from, match)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, dataDirMoveEntry{from: match, to: filepath.Join(to, relativePath)})
+		}
+		return entries, nil
+	}
+
+	if _, err := os.Stat(from); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return []dataDirMoveEntry{{from: from, to: to}}, nil
+}
+
+// moveDataDirEntry renames entry, or copies it through a staging directory
+// when the rename fails, for example across filesystems.
+func moveDataDirEntry(entry dataDirMoveEntry) (bool, error) {
+	if err := os.MkdirAll(filepath.Dir(entry.to), managedDirPermission); err != nil {
+		return false, err
+	}
+	// An empty target dir blocks the rename on Windows.
+	if err := os.Remove(entry.to); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, fmt.Errorf("prepare %q: %w", entry.to, err)
+	}
+	if err := os.Rename(entry.from, entry.to); err == nil {
+		return false, nil
+	}
+
+	staging := entry.to + dataDirMoveStagingSuffix
+	if err := os.RemoveAll(staging); err != nil {
+		return false, err
+	}
+	if err := copyDataTree(entry.from, staging); err != nil {
+		_ = os.RemoveAll(staging)
+		return false, fmt.Errorf("copy %q to %q: %w", entry.from, entry.to, err)
+	}
+	if err := verifyDataTreeCopy(entry.from, staging); err != nil {
+		_ = os.RemoveAll(staging)
+		return false, err
+	}
+	if err := os.Rename(staging, entry.to); err != nil {
+		_ = os.RemoveAll(staging)
+		return false, err
+	}
+	return true, nil
+}
+
+// copyDataTree copies from to to, keeping file modes, modification times
+// and symlinks.
+func copyDataTree(from string, to string) error {
+	return filepath.WalkDir(from, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(to, relativePath)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			return os.MkdirAll(target, info.Mode().Perm()|0o700)
+		case entry.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case entry.Type().IsRegular():
+			return copyDataFile(path, target, info.Mode().Perm(), info.ModTime())
+		default:
+			return fmt.Errorf("cannot copy %q: unsupported file type %s", path, entry.Type())
+		}
+	})
+}
+
+func copyDataFile(from string, to string, mode fs.FileMode, modTime time.Time) error {
+	source, err := os.Open(from) // #nosec G304 -- from is inside the managed data dir being moved.
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+
+	target, err := os.OpenFile(to, os.O_CREATE|os.O_EXCL|os.O_WRONLY, mode) // #nosec G304 -- to is inside the staging copy of the target dir.
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(target, source); err != nil {
+		_ = target.Close()
+		return err
+	}
+	if err := target.Sync(); err != nil {
+		_ = target.Close()
+		return err
+	}
+	if err := target.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(to, modTime, modTime)
+}
+
+// verifyDataTreeCopy checks that every entry of from exists in to, with the
+// same size for regular files.
+func verifyDataTreeCopy(from string, to string) error {
+	return filepath.WalkDir(from, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		copiedInfo, err := os.Lstat(filepath.Join(to, relativePath))
+		if err != nil {
+			return fmt.Errorf("verify copy of %q: %w", path, err)
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if copiedInfo.Size() != info.Size() {
+			return fmt.Errorf("verify copy of %q: copied %d of %d bytes", path, copiedInfo.Size(), info.Size())
+		}
+		return nil
+	})
+}
+
+func isEmptyOrMissingDir(path string) (bool, error) {
+	entries, err := os.ReadDir(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("inspect %q: %w", path, err)
+	}
+	return len(entries) == 0, nil
+}
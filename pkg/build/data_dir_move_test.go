@@ -0,0 +1,144 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func withMovableDataDirs(t *testing.T) *Directories {
+	t.Helper()
+	withPlanCacheDir(t)
+	dirs := GetDirectoriesInstance()
+	originalImagesDir := dirs.ImagesDir
+	t.Cleanup(func() {
+		dirs.ImagesDir = originalImagesDir
+	})
+	for _, spec := range dataDirSpecs {
+		t.Setenv(spec.envVar, "")
+	}
+	return dirs
+}
+
+func TestMoveDataDirRenamesCacheAndRecordsLocation(t *testing.T) {
+	dirs := withMovableDataDirs(t)
+	from := dirs.CacheDir
+	writeDependencyFile(t, dirs.CachePath("linux", "ubuntu.iso"), "iso")
+	to := filepath.Join(t.TempDir(), "cache")
+
+	move, err := MoveDataDir(DataDirCache, from, to)
+	if err != nil {
+		t.Fatalf("MoveDataDir returned error: %v", err)
+	}
+	if move.Copied || move.SizeBytes != int64(len("iso")) {
+		t.Fatalf("expected a rename of 3 bytes, got %+v", move)
+	}
+	if content, err := os.ReadFile(filepath.Join(to, "linux", "ubuntu.iso")); err != nil || string(content) != "iso" {
+		t.Fatalf("expected the dependency in the new cache dir, got %q err=%v", content, err)
+	}
+	if _, err := os.Stat(from); !os.IsNotExist(err) {
+		t.Fatalf("expected the old cache dir to be gone, got %v", err)
+	}
+	if dirs.CacheDir != to || dirs.RecordingsDir != to {
+		t.Fatalf("expected cache and default recordings dir to follow, got %q and %q", dirs.CacheDir, dirs.RecordingsDir)
+	}
+	config, err := loadDataDirsConfig(DataDirsConfigPath(dirs))
+	if err != nil || config.CacheDir != to {
+		t.Fatalf("expected the config to record %q, got %+v err=%v", to, config, err)
+	}
+}
+
+func TestMoveDataDirMovesOnlyRecordingsOutOfCache(t *testing.T) {
+	dirs := withMovableDataDirs(t)
+	recording := dirs.CachePath("ubuntu", "qemu-out-ubuntu-server-amd64-vncsnapshot", "qemu.vnc.mp4")
+	iso := dirs.CachePath("ubuntu", "ubuntu.iso")
+	writeDependencyFile(t, recording, "video")
+	writeDependencyFile(t, iso, "iso")
+	to := filepath.Join(t.TempDir(), "recordings")
+
+	if _, err := MoveDataDir(DataDirRecordings, dirs.CacheDir, to); err != nil {
+		t.Fatalf("MoveDataDir returned error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(to, "ubuntu", "qemu-out-ubuntu-server-amd64-vncsnapshot", "qemu.vnc.mp4")); err != nil {
+		t.Fatalf("expected the recording in the new dir: %v", err)
+	}
+	if _, err := os.Stat(iso); err != nil {
+		t.Fatalf("expected the rest of the cache to stay: %v", err)
+	}
+	if _, err := os.Stat(recording); !os.IsNotExist(err) {
+		t.Fatalf("expected the recording to leave the cache, got %v", err)
+	}
+	if dirs.RecordingsDir != to {
+		t.Fatalf("expected recordings dir %q, got %q", to, dirs.RecordingsDir)
+	}
+}
+
+func TestMoveDataDirRefusesUnsafeMoves(t *testing.T) {
+	dirs := withMovableDataDirs(t)
+	occupied := t.TempDir()
+	writeDependencyFile(t, filepath.Join(occupied, "keep.txt"), "keep")
+	images := t.TempDir()
+	writeDependencyFile(t, filepath.Join(images, "ubuntu.qcow2"), "disk")
+
+	for name, move := range map[string]func() error{
+		"non-empty target": func() error { _, err := MoveDataDir(DataDirVagrant, dirs.VagrantDir, occupied); return err },
+		"nested target": func() error {
+			_, err := MoveDataDir(DataDirCache, dirs.CacheDir, filepath.Join(dirs.CacheDir, "x"))
+			return err
+		},
+		"relative target": func() error { _, err := MoveDataDir(DataDirCache, dirs.CacheDir, "cache"); return err },
+		"images in use": func() error {
+			_, err := MoveDataDir(DataDirImages, images, filepath.Join(t.TempDir(), "images"))
+			return err
+		},
+		"environment override": func() error {
+			t.Setenv(devAlchemyPackerCacheEnvVar, dirs.PackerCacheDir)
+			_, err := MoveDataDir(DataDirPackerCache, dirs.PackerCacheDir, filepath.Join(t.TempDir(), "packer"))
+			return err
+		},
+	} {
+		if err := move(); err == nil {
+			t.Fatalf("%s: expected the move to be refused", name)
+		}
+	}
+	if _, err := os.Stat(DataDirsConfigPath(dirs)); !os.IsNotExist(err) {
+		t.Fatalf("expected no config to be written for refused moves, got %v", err)
+	}
+}
+
+func TestCopyDataTreeKeepsContentModTimeAndLinks(t *testing.T) {
+	from := t.TempDir()
+	file := filepath.Join(from, "ubuntu", "qemu.vnc.mp4")
+	writeDependencyFile(t, file, "video")
+	modTime := time.Now().Add(-48 * time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatalf("failed to set mtime: %v", err)
+	}
+	if err := os.Symlink("qemu.vnc.mp4", filepath.Join(from, "ubuntu", "latest.mp4")); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+	to := filepath.Join(t.TempDir(), "copy")
+
+	if err := copyDataTree(from, to); err != nil {
+		t.Fatalf("copyDataTree returned error: %v", err)
+	}
+	if err := verifyDataTreeCopy(from, to); err != nil {
+		t.Fatalf("verifyDataTreeCopy returned error: %v", err)
+	}
+	info, err := os.Stat(filepath.Join(to, "ubuntu", "qemu.vnc.mp4"))
+	if err != nil || !info.ModTime().Equal(modTime) {
+		t.Fatalf("expected the copy to keep mtime %v, got %v err=%v", modTime, info, err)
+	}
+	if link, err := os.Readlink(filepath.Join(to, "ubuntu", "latest.mp4")); err != nil || link != "qemu.vnc.mp4" {
+		t.Fatalf("expected the symlink to be recreated, got %q err=%v", link, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(to, "ubuntu", "qemu.vnc.mp4"), []byte("vid"), 0o600); err != nil {
+		t.Fatalf("failed to truncate copy: %v", err)
+	}
+	if err := verifyDataTreeCopy(from, to); err == nil || !strings.Contains(err.Error(), "copied 3 of 5 bytes") {
+		t.Fatalf("expected a size mismatch, got %v", err)
+	}
+}
@@ -0,0 +1,293 @@
+package build
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	devAlchemyRecordingsEnvVar = "DEV_ALCHEMY_RECORDINGS_DIR"
+	devAlchemyImagesEnvVar     = "DEV_ALCHEMY_IMAGES_DIR"
+	devAlchemyProjectEnvVar    = "DEV_ALCHEMY_PROJECT_DIR"
+	projectDirConfigKey        = "project_dir"
+	dataDirsConfigFile         = "directories.yml"
+)
+
+// DataDirCategory names a data directory that can be relocated on its own.
+type DataDirCategory string
+
+const (
+	DataDirCache       DataDirCategory = "cache"
+	DataDirPackerCache DataDirCategory = "packer-cache"
+	DataDirVagrant     DataDirCategory = "vagrant"
+	DataDirRecordings  DataDirCategory = "recordings"
+	DataDirImages      DataDirCategory = "images"
+)
+
+// DataDirs holds the data directory locations configured in directories.yml.
+// Empty fields keep the default location.
+type DataDirs struct {
+	CacheDir       string `json:"cache_dir" yaml:"cache_dir"`
+	PackerCacheDir string `json:"packer_cache_dir" yaml:"packer_cache_dir"`
+	VagrantDir     string `json:"vagrant_dir" yaml:"vagrant_dir"`
+	RecordingsDir  string `json:"recordings_dir" yaml:"recordings_dir"`
+	ImagesDir      string `json:"images_dir" yaml:"images_dir"`
+	// ProjectDir selects an ejected project instead of the git checkout or
+	// the embedded project. It is not a data dir and cannot be moved.
+	ProjectDir string `json:"project_dir" yaml:"project_dir"`
+	// ProjectOverlayDir holds files layered on top of the embedded project.
+	ProjectOverlayDir string `json:"project_overlay_dir" yaml:"project_overlay_dir"`
+}
+
+type dataDirSpec struct {
+	category DataDirCategory
+	envVar   string
+	key      string
+	config   func(*DataDirs) *string
+	field    func(*Directories) *string
+}
+
+var dataDirSpecs = []dataDirSpec{
+	{DataDirCache, devAlchemyCacheEnvVar, "cache_dir", func(d *DataDirs) *string { return &d.CacheDir }, func(d *Directories) *string { return &d.CacheDir }},
+	{DataDirPackerCache, devAlchemyPackerCacheEnvVar, "packer_cache_dir", func(d *DataDirs) *string { return &d.PackerCacheDir }, func(d *Directories) *string { return &d.PackerCacheDir }},
+	{DataDirVagrant, devAlchemyVagrantEnvVar, "vagrant_dir", func(d *DataDirs) *string { return &d.VagrantDir }, func(d *Directories) *string { return &d.VagrantDir }},
+	{DataDirRecordings, devAlchemyRecordingsEnvVar, "recordings_dir", func(d *DataDirs) *string { return &d.RecordingsDir }, func(d *Directories) *string { return &d.RecordingsDir }},
+	{DataDirImages, devAlchemyImagesEnvVar, "images_dir", func(d *DataDirs) *string { return &d.ImagesDir }, func(d *Directories) *string { return &d.ImagesDir }},
+}
+
+// DataDirCategories returns the relocatable data directory categories.
+func DataDirCategories() []DataDirCategory {
+	categories := make([]DataDirCategory, 0, len(dataDirSpecs))
+	for _, spec := range dataDirSpecs {
+		categories = append(categories, spec.category)
+	}
+	return categories
+}
+
+func lookupDataDirSpec(category DataDirCategory) (dataDirSpec, error) {
+	for _, spec := range dataDirSpecs {
+		if spec.category == category {
+			return spec, nil
+		}
+	}
+	names := make([]string, 0, len(dataDirSpecs))
+	for _, spec := range dataDirSpecs {
+		names = append(names, string(spec.category))
+	}
+	return dataDirSpec{}, fmt.Errorf("unknown data directory %q; use one of %s", category, strings.Join(names, ", "))
+}
+
+// DataDirsConfigPath returns the location of directories.yml in the config
+// dir.
+func DataDirsConfigPath(directories *Directories) string {
+	return directories.ConfigPath(dataDirsConfigFile)
+}
+
+// DataDir returns the directory of category. The images dir is empty unless
+// it was configured, because its default depends on the deploy engine.
+func (u *Directories) DataDir(category DataDirCategory) string {
+	u.GetDirectories()
+	spec, err := lookupDataDirSpec(category)
+	if err != nil {
+		return ""
+	}
+	return *spec.field(u)
+}
+
+// loadDataDirOverrides reads directories.yml and applies the environment
+// overrides on top. Config paths may be relative to the config file;
+// environment paths must be absolute.
+func loadDataDirOverrides(configPath string, getenv func(string) string) (DataDirs, error) {
+	overrides, err := loadDataDirsConfig(configPath)
+	if err != nil {
+		return DataDirs{}, err
+	}
+	for _, spec := range dataDirSpecs {
+		if err := applyDirEnvOverride(spec.config(&overrides), spec.envVar, getenv); err != nil {
+			return DataDirs{}, err
+		}
+	}
+	if err := applyDirEnvOverride(&overrides.ProjectDir, devAlchemyProjectEnvVar, getenv); err != nil {
+		return DataDirs{}, err
+	}
+	if err := applyDirEnvOverride(&overrides.ProjectOverlayDir, devAlchemyProjectOverlayEnvVar, getenv); err != nil {
+		return DataDirs{}, err
+	}
+	return overrides, nil
+}
+
+func applyDirEnvOverride(target *string, envVar string, getenv func(string) string) error {
+	value := strings.TrimSpace(getenv(envVar))
+	if value == "" {
+		return nil
+	}
+	if !filepath.IsAbs(value) {
+		return fmt.Errorf("%s must be an absolute path, got %q", envVar, value)
+	}
+	*target = filepath.Clean(value)
+	return nil
+}
+
+func loadDataDirsConfig(configPath string) (DataDirs, error) {
+	content, err := os.ReadFile(configPath) // #nosec G304 -- configPath is the managed directories config.
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return DataDirs{}, nil
+		}
+		return DataDirs{}, fmt.Errorf("read data directories %q: %w", configPath, err)
+	}
+
+	config := DataDirs{}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && !errors.Is(err, io.EOF) {
+		return DataDirs{}, fmt.Errorf("parse data directories %q: %w", configPath, err)
+	}
+	for _, value := range append(dataDirConfigValues(&config), &config.ProjectDir, &config.ProjectOverlayDir) {
+		*value = strings.TrimSpace(*value)
+		if *value == "" {
+			continue
+		}
+		if !filepath.IsAbs(*value) {
+			*value = filepath.Join(filepath.Dir(configPath), *value)
+		}
+		*value = filepath.Clean(*value)
+	}
+	return config, nil
+}
+
+func dataDirConfigValues(config *DataDirs) []*string {
+	values := make([]*string, 0, len(dataDirSpecs))
+	for _, spec := range dataDirSpecs {
+		values = append(values, spec.config(config))
+	}
+	return values
+}
+
+// setDataDirConfig records dir for category in directories.yml.
+func setDataDirConfig(configPath string, category DataDirCategory, dir string) error {
+	spec, err := lookupDataDirSpec(category)
+	if err != nil {
+		return err
+	}
+	return setDirectoriesConfigValue(configPath, spec.key, dir)
+}
+
+// setDirectoriesConfigValue sets key to value in directories.yml, keeping the
+// other entries and comments of an existing file.
+func setDirectoriesConfigValue(configPath string, key string, value string) error {
+	var document yaml.Node
+	content, err := os.ReadFile(configPath) // #nosec G304 -- configPath is the managed directories config.
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("read data directories %q: %w", configPath, err)
+	}
+	if err := yaml.Unmarshal(content, &document); err != nil {
+		return fmt.Errorf("parse data directories %q: %w", configPath, err)
+	}
+	if document.Kind == 0 {
+		document = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	mapping := document.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return fmt.Errorf("data directories %q must be a mapping", configPath)
+	}
+
+	valueNode := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value}
+	replaced := false
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = valueNode
+			replaced = true
+		}
+	}
+	if !replaced {
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, valueNode)
+	}
+
+	updated, err := yaml.Marshal(&document)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(configPath), managedDirPermission); err != nil {
+		return err
+	}
+	tempPath := configPath + ".tmp"
+	if err := os.WriteFile(tempPath, updated, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tempPath, configPath)
+}
+
+// validateDataDirs checks that the data directories are absolute, do not
+// hold the app data, config or project dirs, and do not overlap each other.
+// Recordings may share the cache dir, which is their default location.
+func validateDataDirs(directories Directories) error {
+	for i, spec := range dataDirSpecs {
+		dir := *spec.field(&directories)
+		if dir == "" {
+			continue
+		}
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("%s dir %q must be an absolute path", spec.category, dir)
+		}
+		for _, protected := range []string{directories.AppDataDir, directories.ConfigDir, directories.ProjectDir} {
+			if protected != "" && (dir == filepath.Clean(protected) || isWithinDir(dir, protected)) {
+				return fmt.Errorf("%s dir %q must not contain %q", spec.category, dir, protected)
+			}
+		}
+		for _, other := range dataDirSpecs[i+1:] {
+			otherDir := *other.field(&directories)
+			if otherDir == "" || !dirsOverlap(dir, otherDir) {
+				continue
+			}
+			if spec.category == DataDirCache && other.category == DataDirRecordings && dir == otherDir {
+				continue
+			}
+			return fmt.Errorf("%s dir %q overlaps %s dir %q", spec.category, dir, other.category, otherDir)
+		}
+	}
+	return nil
+}
+
+// ensureDataDirsWritable checks that the configured directories accept new
+// files. The images dir is only checked when it exists, as the deploy step
+// creates it with the permissions libvirt needs.
+func ensureDataDirsWritable(overrides DataDirs) error {
+	for _, spec := range dataDirSpecs {
+		dir := *spec.config(&overrides)
+		if dir == "" {
+			continue
+		}
+		info, err := os.Stat(dir)
+		if spec.category == DataDirImages && errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("inspect %s dir %q: %w", spec.category, dir, err)
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s dir %q is not a directory", spec.category, dir)
+		}
+		probe, err := os.CreateTemp(dir, ".dev-alchemy-write-check-*")
+		if err != nil {
+			return fmt.Errorf("%s dir %q is not writable: %w", spec.category, dir, err)
+		}
+		_ = probe.Close()
+		if err := os.Remove(probe.Name()); err != nil {
+			return fmt.Errorf("clean up write check in %s dir %q: %w", spec.category, dir, err)
+		}
+	}
+	return nil
+}
+
+func dirsOverlap(a string, b string) bool {
+	a, b = filepath.Clean(a), filepath.Clean(b)
+	return a == b || isWithinDir(a, b) || isWithinDir(b, a)
+}
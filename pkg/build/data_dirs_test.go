@@ -0,0 +1,136 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDataDirOverridesResolvesConfigAndEnvironment(t *testing.T) {
+	configDir := t.TempDir()
+	configPath := filepath.Join(configDir, dataDirsConfigFile)
+	writeDependencyFile(t, configPath, "cache_dir: /srv/dev-alchemy/cache\nrecordings_dir: recordings\n")
+	env := map[string]string{devAlchemyImagesEnvVar: "/srv/images"}
+
+	overrides, err := loadDataDirOverrides(configPath, func(key string) string { return env[key] })
+	if err != nil {
+		t.Fatalf("loadDataDirOverrides returned error: %v", err)
+	}
+	want := DataDirs{
+		CacheDir:      filepath.Clean("/srv/dev-alchemy/cache"),
+		RecordingsDir: filepath.Join(configDir, "recordings"),
+		ImagesDir:     filepath.Clean("/srv/images"),
+	}
+	if overrides != want {
+		t.Fatalf("expected %+v, got %+v", want, overrides)
+	}
+
+	env[devAlchemyCacheEnvVar] = "relative/cache"
+	if _, err := loadDataDirOverrides(configPath, func(key string) string { return env[key] }); err == nil || !strings.Contains(err.Error(), devAlchemyCacheEnvVar) {
+		t.Fatalf("expected a relative environment override to be rejected, got %v", err)
+	}
+}
+
+func TestLoadDataDirOverridesRejectsUnknownKeys(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), dataDirsConfigFile)
+	writeDependencyFile(t, configPath, "cache: /srv/cache\n")
+
+	if _, err := loadDataDirOverrides(configPath, func(string) string { return "" }); err == nil {
+		t.Fatal("expected an unknown key to be rejected")
+	}
+}
+
+func TestValidateDataDirsRejectsOverlapsAndProtectedDirs(t *testing.T) {
+	base := Directories{
+		AppDataDir:     filepath.FromSlash("/data/dev-alchemy"),
+		ConfigDir:      filepath.FromSlash("/config/dev-alchemy"),
+		ProjectDir:     filepath.FromSlash("/data/dev-alchemy/project"),
+		CacheDir:       filepath.FromSlash("/data/dev-alchemy/cache"),
+		PackerCacheDir: filepath.FromSlash("/data/dev-alchemy/packer_cache"),
+		VagrantDir:     filepath.FromSlash("/data/dev-alchemy/.vagrant"),
+		RecordingsDir:  filepath.FromSlash("/data/dev-alchemy/cache"),
+	}
+	if err := validateDataDirs(base); err != nil {
+		t.Fatalf("expected the default layout to be valid, got %v", err)
+	}
+
+	nested := base
+	nested.PackerCacheDir = filepath.Join(base.CacheDir, "packer")
+	if err := validateDataDirs(nested); err == nil || !strings.Contains(err.Error(), "overlaps") {
+		t.Fatalf("expected nested data dirs to be rejected, got %v", err)
+	}
+
+	recordingsInCache := base
+	recordingsInCache.RecordingsDir = filepath.Join(base.CacheDir, "recordings")
+	if err := validateDataDirs(recordingsInCache); err == nil {
+		t.Fatal("expected recordings below the cache dir to be rejected")
+	}
+
+	containsConfig := base
+	containsConfig.VagrantDir = filepath.FromSlash("/config")
+	if err := validateDataDirs(containsConfig); err == nil || !strings.Contains(err.Error(), "must not contain") {
+		t.Fatalf("expected a data dir holding the config dir to be rejected, got %v", err)
+	}
+}
+
+func TestEnsureDataDirsWritableRejectsFiles(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache")
+	writeDependencyFile(t, file, "not a dir")
+
+	if err := ensureDataDirsWritable(DataDirs{CacheDir: file}); err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Fatalf("expected a file to be rejected, got %v", err)
+	}
+	if err := ensureDataDirsWritable(DataDirs{PackerCacheDir: t.TempDir(), ImagesDir: filepath.Join(t.TempDir(), "missing")}); err != nil {
+		t.Fatalf("expected a writable dir and a missing images dir to pass, got %v", err)
+	}
+}
+
+func TestSetDataDirConfigKeepsOtherEntriesAndComments(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), dataDirsConfigFile)
+	writeDependencyFile(t, configPath, "# big disk\nvagrant_dir: /srv/vagrant\ncache_dir: /old/cache\n")
+
+	if err := setDataDirConfig(configPath, DataDirCache, "/srv/cache"); err != nil {
+		t.Fatalf("setDataDirConfig returned error: %v", err)
+	}
+	if err := setDataDirConfig(configPath, DataDirRecordings, "/srv/recordings"); err != nil {
+		t.Fatalf("setDataDirConfig returned error: %v", err)
+	}
+
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatalf("failed to read config: %v", err)
+	}
+	if !strings.Contains(string(content), "# big disk") {
+		t.Fatalf("expected comments to be kept, got %q", content)
+	}
+	config, err := loadDataDirsConfig(configPath)
+	if err != nil {
+		t.Fatalf("loadDataDirsConfig returned error: %v", err)
+	}
+	want := DataDirs{CacheDir: filepath.Clean("/srv/cache"), VagrantDir: filepath.Clean("/srv/vagrant"), RecordingsDir: filepath.Clean("/srv/recordings")}
+	if config != want {
+		t.Fatalf("expected %+v, got %+v", want, config)
+	}
+}
+
+func TestManagedEnvExportsDataDirs(t *testing.T) {
+	withPlanCacheDir(t)
+	dirs := GetDirectoriesInstance()
+	originalImagesDir := dirs.ImagesDir
+	dirs.ImagesDir = t.TempDir()
+	t.Cleanup(func() {
+		dirs.ImagesDir = originalImagesDir
+	})
+
+	env := strings.Join(dirs.ManagedEnv(), "\n")
+	for _, want := range []string{
+		devAlchemyCacheEnvVar + "=" + dirs.CacheDir,
+		devAlchemyRecordingsEnvVar + "=" + dirs.RecordingsDir,
+		devAlchemyImagesEnvVar + "=" + dirs.ImagesDir,
+	} {
+		if !strings.Contains(env, want) {
+			t.Fatalf("expected %q in managed env, got %q", want, env)
+		}
+	}
+}
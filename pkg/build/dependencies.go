@@ -4,13 +4,10 @@ import (
 	"bufio"
 	"compress/gzip"
 	"context"
-	"crypto/sha256"
-	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -18,13 +15,12 @@ import (
 	"strings"
 	"time"
 
-	"github.com/hashicorp/go-getter"
 	"github.com/vbauerster/mpb/v8"
 	"github.com/vbauerster/mpb/v8/decor"
 )
 
-// ProgressBarListener implements getter.ProgressListener using an mpb container
-// so that concurrent downloads render their bars cleanly on separate lines.
+// ProgressBarListener tracks dependency downloads using an mpb container so
+// that concurrent downloads render their bars cleanly on separate lines.
 type ProgressBarListener struct {
 	progress *mpb.Progress
 	bar      *mpb.Bar
@@ -48,6 +44,10 @@ func (p *ProgressBarListener) TrackProgress(src string, current, total int64, r
 			decor.EwmaETA(decor.ET_STYLE_GO, 30),
 		),
 	)
+	if current > 0 {
+		// A resumed download starts at the size of the partial file.
+		p.bar.SetCurrent(current)
+	}
 	return &progressReader{
 		reader:   r,
 		bar:      p.bar,
@@ -152,14 +152,35 @@ func virtioWinISOURLs(version string) []string {
 	}
 }
 
+// selectFastestVirtioWinISOURL probes the virtio-win sources through the
+// configured mirrors and returns the upstream URL of the fastest one, which the
+// download rewrites again.
 func selectFastestVirtioWinISOURL(version string) (string, error) {
-	urls := virtioWinISOURLs(version)
-	selected, err := selectFastestDownloadURL(urls, virtioWinProbeBytes, virtioWinProbeTimeout)
+	mirrors, _, _, err := loadDependencyMirrorsFunc()
+	if err != nil {
+		return "", err
+	}
+	client, err := mirrors.HTTPClient()
+	if err != nil {
+		return "", err
+	}
+
+	upstream := map[string]string{}
+	var urls []string
+	for _, candidate := range virtioWinISOURLs(version) {
+		mirrored := mirrors.Rewrite(candidate)
+		if _, seen := upstream[mirrored]; seen {
+			continue
+		}
+		upstream[mirrored] = candidate
+		urls = append(urls, mirrored)
+	}
+	selected, err := selectFastestDownloadURL(client, urls, virtioWinProbeBytes, virtioWinProbeTimeout)
 	if err != nil {
 		return "", fmt.Errorf("failed to select fastest virtio-win download URL: %w", err)
 	}
 	log.Printf("Selected fastest virtio-win download URL: %s", selected)
-	return selected, nil
+	return upstream[selected], nil
 }
 
 type downloadProbeResult struct {
@@ -169,7 +190,7 @@ type downloadProbeResult struct {
 	Err      error
 }
 
-func selectFastestDownloadURL(urls []string, probeBytes int64, timeout time.Duration) (string, error) {
+func selectFastestDownloadURL(client *http.Client, urls []string, probeBytes int64, timeout time.Duration) (string, error) {
 	if len(urls) == 0 {
 		return "", fmt.Errorf("no download URLs to probe")
 	}
@@ -178,7 +199,6 @@ func selectFastestDownloadURL(urls []string, probeBytes int64, timeout time.Dura
 	defer cancel()
 
 	results := make(chan downloadProbeResult, len(urls))
-	client := &http.Client{}
 
 	for _, candidate := range urls {
 		candidate := candidate
@@ -264,11 +284,20 @@ func resolveDebianPackageURL(suite, packageName string) (string, error) {
 		return "", fmt.Errorf("invalid Debian package lookup: suite=%q package=%q", suite, packageName)
 	}
 
-	packagesURL := fmt.Sprintf("https://deb.debian.org/debian/dists/%s/main/binary-all/Packages.gz", suite)
+	mirrors, _, _, err := loadDependencyMirrorsFunc()
+	if err != nil {
+		return "", err
+	}
+	client, err := mirrors.HTTPClient()
+	if err != nil {
+		return "", err
+	}
+
+	packagesURL := mirrors.Rewrite(fmt.Sprintf("https://deb.debian.org/debian/dists/%s/main/binary-all/Packages.gz", suite))
 	log.Printf("Resolving latest Debian package URL for %s from %s", packageName, packagesURL)
 
-	// #nosec G107 -- the host is fixed to deb.debian.org and the path segments are validated above.
-	resp, err := http.Get(packagesURL)
+	// #nosec G107 -- the host is deb.debian.org or its configured mirror and the path segments are validated above.
+	resp, err := client.Get(packagesURL)
 	if err != nil {
 		return "", fmt.Errorf("failed to fetch Debian package index: %w", err)
 	}
@@ -314,23 +343,9 @@ outer:
 	return url, nil
 }
 
-func DependencyReconciliation(vmconfig VirtualMachineConfig) {
-	p := mpb.New(mpb.WithWidth(80))
-	defer p.Wait()
-
-	for _, dep := range webFileDependenciesForVMConfig(vmconfig) {
-		if !checkIfWebFileDependencyExists(dep) {
-			err := downloadWebFileDependency(p, dep)
-			if err != nil {
-				log.Fatalf("Failed to download web file dependency: %v", err)
-			}
-		}
-	}
-}
-
 func webFileDependenciesForVMConfig(vmconfig VirtualMachineConfig) []WebFileDependency {
 	var deps []WebFileDependency
-	for _, dep := range getWebFileDependencies() {
+	for _, dep := range webFileDependencyCatalog() {
 		if webFileDependencyMatchesVMConfig(dep, vmconfig) {
 			deps = append(deps, dep)
 		}
@@ -849,7 +864,18 @@ func getWebFileDependencies() []WebFileDependency {
 }
 
 func downloadWebFileDependency(p *mpb.Progress, dep WebFileDependency) error {
-	if dep.BeforeHook != nil {
+	mirrors, _, _, err := loadDependencyMirrorsFunc()
+	if err != nil {
+		return err
+	}
+	client, err := mirrors.HTTPClient()
+	if err != nil {
+		return err
+	}
+
+	// An override replaces every source, so the hook that would look up the
+	// upstream URL is skipped; it may need hosts the mirror stands in for.
+	if _, overridden := mirrors.Override(dep.LocalPath); !overridden && dep.BeforeHook != nil {
 		newSource, err := dep.BeforeHook()
 		if err != nil {
 			return err
@@ -862,14 +888,14 @@ func downloadWebFileDependency(p *mpb.Progress, dep WebFileDependency) error {
 		dep.Source = newSource
 	}
 
-	sources := downloadSources(dep.Source, dep.FallbackSources)
+	sources, _ := mirrors.dependencySources(dep, dep.Source)
 	if len(sources) == 0 {
 		return fmt.Errorf("no download source configured for %s", dep.LocalPath)
 	}
 
 	var failures []string
 	for index, source := range sources {
-		err := downloadWebFileDependencyFromSource(p, dep, source)
+		err := downloadWebFileDependencyFromSource(p, dep, source, client)
 		if err == nil {
 			return nil
 		}
@@ -889,33 +915,6 @@ func downloadWebFileDependency(p *mpb.Progress, dep WebFileDependency) error {
 	return fmt.Errorf("failed to download web file dependency from all sources: %s", strings.Join(failures, "; "))
 }
 
-func downloadWebFileDependencyFromSource(p *mpb.Progress, dep WebFileDependency, source string) error {
-	src := sourceWithChecksum(source, dep.Checksum)
-	listener := &ProgressBarListener{progress: p}
-	client := &getter.Client{
-		Src:              src,
-		Dst:              dep.LocalPath,
-		Mode:             getter.ClientModeFile,
-		ProgressListener: listener,
-	}
-	err := client.Get()
-	if err != nil {
-		// delete the file if it was partially downloaded
-		_ = os.Remove(dep.LocalPath)
-		if listener.bar != nil {
-			listener.bar.Abort(false)
-		}
-		log.Printf("Failed to download web file dependency from %s to %s: %v", source, dep.LocalPath, err)
-		return err
-	}
-	if listener.bar != nil {
-		// Mark bar complete; mpb renders it as done and removes it from the live display.
-		listener.bar.SetTotal(listener.bar.Current(), true)
-	}
-	log.Printf("Successfully downloaded web file dependency from %s to %s", source, dep.LocalPath)
-	return nil
-}
-
 func downloadSources(primary string, fallbacks []string) []string {
 	seen := map[string]bool{}
 	sources := make([]string, 0, 1+len(fallbacks))
@@ -929,59 +928,21 @@ func downloadSources(primary string, fallbacks []string) []string {
 	return sources
 }
 
-func sourceWithChecksum(source, checksum string) string {
-	if checksum == "" {
-		return source
-	}
-	parsed, err := url.Parse(source)
-	if err == nil && parsed.Scheme != "" {
-		query := parsed.Query()
-		query.Set("checksum", checksum)
-		parsed.RawQuery = query.Encode()
-		return parsed.String()
-	}
-
-	separator := "?"
-	if strings.Contains(source, "?") {
-		separator = "&"
-	}
-	return source + separator + "checksum=" + url.QueryEscape(checksum)
-}
-
-func isChecksumMismatch(err error) bool {
-	var checksumErr *getter.ChecksumError
-	return errors.As(err, &checksumErr)
-}
-
 func checkIfWebFileDependencyExists(dep WebFileDependency) bool {
 	_, err := filepath.Abs(dep.LocalPath)
 	if err != nil {
 		return false
 	}
-	_, err = os.Stat(dep.LocalPath)
-	if os.IsNotExist(err) {
+	if _, err := os.Stat(dep.LocalPath); err != nil {
 		return false
 	}
 
-	// check sha256 checksums if dep.Checksum begins with "sha256:"
-	if dep.Checksum != "" && len(dep.Checksum) > 7 && dep.Checksum[:7] == "sha256:" {
-		file, err := os.Open(dep.LocalPath)
-		if err != nil {
-			return false
-		}
-		defer file.Close()
-
-		hasher := sha256.New()
-		if _, err := io.Copy(hasher, file); err != nil {
-			return false
-		}
-		computedChecksum := fmt.Sprintf("sha256:%x", hasher.Sum(nil))
-
-		if computedChecksum != dep.Checksum {
-			return false
-		}
+	// check the catalog checksum, or the one recorded in the dependency lock
+	checksum, _, err := pinnedDependencyChecksum(dep)
+	if err != nil || verifyDependencyChecksumCached(dep.LocalPath, checksum, false) != nil {
+		return false
 	}
 
 	log.Printf("File exists and checksum matches for %s", dep.LocalPath)
-	return err == nil
+	return true
 }
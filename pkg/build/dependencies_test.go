@@ -204,7 +204,9 @@ func TestIntegrationDependencyReconciliation(t *testing.T) {
 	}
 
 	for _, vmconfig := range tests {
-		DependencyReconciliation(vmconfig)
+		if err := DependencyReconciliation(vmconfig); err != nil {
+			t.Fatalf("DependencyReconciliation returned error for %+v: %v", vmconfig, err)
+		}
 	}
 }
 
@@ -342,7 +344,7 @@ func TestSelectFastestDownloadURL(t *testing.T) {
 
 	fastURL := fast.URL + "/artifact.iso"
 	slowURL := slow.URL + "/artifact.iso"
-	selected, err := selectFastestDownloadURL([]string{slowURL, fastURL}, 128, 2*time.Second)
+	selected, err := selectFastestDownloadURL(&http.Client{}, []string{slowURL, fastURL}, 128, 2*time.Second)
 	if err != nil {
 		t.Fatalf("selectFastestDownloadURL returned error: %v", err)
 	}
@@ -372,7 +374,7 @@ func TestDownloadWebFileDependencySelectsFastestURL(t *testing.T) {
 		LocalPath: destPath,
 		Source:    slowURL,
 		BeforeHook: func() (string, error) {
-			return selectFastestDownloadURL([]string{slowURL, fastURL}, 128, 2*time.Second)
+			return selectFastestDownloadURL(&http.Client{}, []string{slowURL, fastURL}, 128, 2*time.Second)
 		},
 	}
 
@@ -0,0 +1,333 @@
+package build
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// dependencyBundleManifestName is the tar entry that lists the bundled files.
+// It is written last so export streams every dependency exactly once.
+const dependencyBundleManifestName = "manifest.json"
+
+const dependencyBundleFormatVersion = 1
+
+// dependencyBundleImportSuffix marks files that are still being imported. They
+// only replace cached dependencies once the whole bundle has been checked.
+const dependencyBundleImportSuffix = ".dev-alchemy-import"
+
+// DependencyBundleManifest describes the files of an offline dependency
+// bundle.
+type DependencyBundleManifest struct {
+	Version   int                    `json:"version"`
+	CreatedAt time.Time              `json:"created_at"`
+	Files     []DependencyBundleFile `json:"files"`
+}
+
+// DependencyBundleFile is one cached dependency inside a bundle. Path is
+// slash-separated and relative to the cache dir.
+type DependencyBundleFile struct {
+	Path      string `json:"path"`
+	SizeBytes int64  `json:"size_bytes"`
+	Checksum  string `json:"checksum"`
+}
+
+// ExportDependencyBundle fetches the web file dependencies of configs and packs
+// them into a tar archive at bundlePath, together with a manifest of their
+// SHA256 checksums. Dependencies whose source is resolved at fetch time, such
+// as the Debian firmware package, are resolved while fetching.
+func ExportDependencyBundle(configs []VirtualMachineConfig, bundlePath string) (DependencyBundleManifest, error) {
+	if err := FetchDependencies(configs); err != nil {
+		return DependencyBundleManifest{}, fmt.Errorf("fetch dependencies for bundle: %w", err)
+	}
+
+	cacheDir := GetDirectoriesInstance().CacheDir
+	deps := webFileDependenciesForVMConfigs(configs)
+	manifest := DependencyBundleManifest{Version: dependencyBundleFormatVersion, CreatedAt: time.Now().UTC()}
+
+	tempPath := bundlePath + ".tmp"
+	file, err := os.Create(tempPath) // #nosec G304 -- bundlePath is chosen by the user running the export.
+	if err != nil {
+		return DependencyBundleManifest{}, fmt.Errorf("create dependency bundle %s: %w", bundlePath, err)
+	}
+	removeTemp := true
+	defer func() {
+		if removeTemp {
+			_ = os.Remove(tempPath)
+		}
+	}()
+
+	writer := tar.NewWriter(file)
+	for _, dep := range deps {
+		bundleFile, err := writeDependencyBundleEntry(writer, cacheDir, dep.LocalPath)
+		if err != nil {
+			_ = file.Close()
+			return DependencyBundleManifest{}, err
+		}
+		manifest.Files = append(manifest.Files, bundleFile)
+	}
+
+	content, err := json.MarshalIndent(manifest, "", "  ")
+	if err == nil {
+		content = append(content, '\n')
+		err = writer.WriteHeader(&tar.Header{
+			Name:    dependencyBundleManifestName,
+			Mode:    0o644,
+			Size:    int64(len(content)),
+			ModTime: manifest.CreatedAt,
+		})
+	}
+	if err == nil {
+		_, err = writer.Write(content)
+	}
+	if err == nil {
+		err = writer.Close()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return DependencyBundleManifest{}, fmt.Errorf("write dependency bundle %s: %w", bundlePath, err)
+	}
+
+	if err := os.Rename(tempPath, bundlePath); err != nil {
+		return DependencyBundleManifest{}, fmt.Errorf("write dependency bundle %s: %w", bundlePath, err)
+	}
+	removeTemp = false
+	return manifest, nil
+}
+
+func writeDependencyBundleEntry(writer *tar.Writer, cacheDir string, localPath string) (DependencyBundleFile, error) {
+	relativePath, err := filepath.Rel(cacheDir, localPath)
+	if err != nil || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return DependencyBundleFile{}, fmt.Errorf("dependency %s is outside the cache dir %s", localPath, cacheDir)
+	}
+	name := filepath.ToSlash(relativePath)
+
+	source, err := os.Open(localPath) // #nosec G304 -- localPath is a managed dependency path in the cache dir.
+	if err != nil {
+		return DependencyBundleFile{}, fmt.Errorf("open dependency %s: %w", localPath, err)
+	}
+	defer source.Close()
+	info, err := source.Stat()
+	if err != nil {
+		return DependencyBundleFile{}, fmt.Errorf("inspect dependency %s: %w", localPath, err)
+	}
+
+	if err := writer.WriteHeader(&tar.Header{
+		Name:    name,
+		Mode:    0o644,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}); err != nil {
+		return DependencyBundleFile{}, fmt.Errorf("add %s to dependency bundle: %w", name, err)
+	}
+	hasher := sha256.New()
+	if _, err := io.Copy(writer, io.TeeReader(source, hasher)); err != nil {
+		return DependencyBundleFile{}, fmt.Errorf("add %s to dependency bundle: %w", name, err)
+	}
+	return DependencyBundleFile{
+		Path:      name,
+		SizeBytes: info.Size(),
+		Checksum:  fmt.Sprintf("sha256:%x", hasher.Sum(nil)),
+	}, nil
+}
+
+type stagedDependencyBundleFile struct {
+	tempPath  string
+	finalPath string
+	sizeBytes int64
+	checksum  string
+}
+
+// ImportDependencyBundle unpacks a bundle written by ExportDependencyBundle
+// into the cache dir. Only files of the dependency catalog are accepted, and
+// entries for any other path are rejected before anything is written. Every
+// file is checked against the bundle manifest and, where the catalog pins one,
+// against the catalog checksum before any cached dependency is replaced, so a
+// truncated or altered bundle leaves the cache untouched. The checksums of unpinned dependencies are recorded in
+// the dependency lock, so strict builds accept the imported files.
+func ImportDependencyBundle(bundlePath string) (DependencyBundleManifest, error) {
+	file, err := os.Open(bundlePath) // #nosec G304 -- bundlePath is chosen by the user running the import.
+	if err != nil {
+		return DependencyBundleManifest{}, fmt.Errorf("open dependency bundle %s: %w", bundlePath, err)
+	}
+	defer file.Close()
+
+	cacheDir := GetDirectoriesInstance().CacheDir
+	catalog := dependencyBundleCatalog()
+	staged := map[string]stagedDependencyBundleFile{}
+	defer func() {
+		for _, entry := range staged {
+			_ = os.Remove(entry.tempPath)
+		}
+	}()
+
+	var manifest *DependencyBundleManifest
+	reader := tar.NewReader(file)
+	for {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return DependencyBundleManifest{}, fmt.Errorf("read dependency bundle %s: %w", bundlePath, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			return DependencyBundleManifest{}, fmt.Errorf("dependency bundle entry %q is not a regular file", header.Name)
+		}
+
+		if header.Name == dependencyBundleManifestName {
+			var parsed DependencyBundleManifest
+			if err := json.NewDecoder(reader).Decode(&parsed); err != nil {
+				return DependencyBundleManifest{}, fmt.Errorf("parse dependency bundle manifest: %w", err)
+			}
+			manifest = &parsed
+			continue
+		}
+
+		finalPath, err := dependencyBundleEntryPath(cacheDir, header.Name)
+		if err != nil {
+			return DependencyBundleManifest{}, err
+		}
+		if _, ok := catalog[header.Name]; !ok {
+			return DependencyBundleManifest{}, fmt.Errorf("dependency bundle entry %q is not a dependency in the catalog", header.Name)
+		}
+		if _, ok := staged[header.Name]; ok {
+			return DependencyBundleManifest{}, fmt.Errorf("dependency bundle contains %q more than once", header.Name)
+		}
+		entry, err := stageDependencyBundleEntry(reader, finalPath)
+		if err != nil {
+			return DependencyBundleManifest{}, fmt.Errorf("import %s: %w", header.Name, err)
+		}
+		staged[header.Name] = entry
+	}
+
+	if manifest == nil {
+		return DependencyBundleManifest{}, fmt.Errorf("dependency bundle %s has no %s", bundlePath, dependencyBundleManifestName)
+	}
+	if manifest.Version != dependencyBundleFormatVersion {
+		return DependencyBundleManifest{}, fmt.Errorf("unsupported dependency bundle version %d; expected %d", manifest.Version, dependencyBundleFormatVersion)
+	}
+	if err := checkDependencyBundleFiles(*manifest, staged, catalog); err != nil {
+		return DependencyBundleManifest{}, err
+	}
+	lockEntries, err := dependencyBundleLockEntries(*manifest)
+	if err != nil {
+		return DependencyBundleManifest{}, err
+	}
+
+	for _, bundleFile := range manifest.Files {
+		entry := staged[bundleFile.Path]
+		if err := os.Rename(entry.tempPath, entry.finalPath); err != nil {
+			return DependencyBundleManifest{}, fmt.Errorf("place %s in cache: %w", bundleFile.Path, err)
+		}
+		delete(staged, bundleFile.Path)
+	}
+	if err := recordDependencyLockEntries(lockEntries); err != nil {
+		return DependencyBundleManifest{}, err
+	}
+	return *manifest, nil
+}
+
+// dependencyBundleCatalog returns the dependencies of the catalog by their
+// slash-separated path relative to the cache dir, the paths a bundle may hold.
+func dependencyBundleCatalog() map[string]WebFileDependency {
+	catalog := map[string]WebFileDependency{}
+	for _, dep := range webFileDependencyCatalog() {
+		if key, ok := dependencyLockKey(dep.LocalPath); ok {
+			catalog[key] = dep
+		}
+	}
+	return catalog
+}
+
+// dependencyBundleEntryPath maps a bundle entry name to its cache path and
+// rejects names that would escape the cache dir.
+func dependencyBundleEntryPath(cacheDir string, name string) (string, error) {
+	if name == "" || path.IsAbs(name) || strings.Contains(name, `\`) || path.Clean(name) != name ||
+		name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("dependency bundle entry %q is not a relative cache path", name)
+	}
+	return filepath.Join(cacheDir, filepath.FromSlash(name)), nil
+}
+
+func stageDependencyBundleEntry(reader io.Reader, finalPath string) (stagedDependencyBundleFile, error) {
+	if err := os.MkdirAll(filepath.Dir(finalPath), 0o755); err != nil {
+		return stagedDependencyBundleFile{}, err
+	}
+	tempPath := finalPath + dependencyBundleImportSuffix
+	target, err := os.Create(tempPath) // #nosec G304 -- tempPath is checked to stay inside the cache dir.
+	if err != nil {
+		return stagedDependencyBundleFile{}, err
+	}
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(target, hasher), reader)
+	if closeErr := target.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return stagedDependencyBundleFile{}, err
+	}
+	return stagedDependencyBundleFile{
+		tempPath:  tempPath,
+		finalPath: finalPath,
+		sizeBytes: size,
+		checksum:  fmt.Sprintf("sha256:%x", hasher.Sum(nil)),
+	}, nil
+}
+
+func checkDependencyBundleFiles(manifest DependencyBundleManifest, staged map[string]stagedDependencyBundleFile, catalog map[string]WebFileDependency) error {
+	listed := map[string]bool{}
+	for _, bundleFile := range manifest.Files {
+		listed[bundleFile.Path] = true
+		dep, ok := catalog[bundleFile.Path]
+		if !ok {
+			return fmt.Errorf("dependency bundle manifest lists %s, which is not a dependency in the catalog", bundleFile.Path)
+		}
+		entry, ok := staged[bundleFile.Path]
+		if !ok {
+			return fmt.Errorf("dependency bundle is missing %s", bundleFile.Path)
+		}
+		if entry.sizeBytes != bundleFile.SizeBytes || !strings.EqualFold(entry.checksum, bundleFile.Checksum) {
+			return fmt.Errorf("dependency bundle file %s does not match its manifest checksum", bundleFile.Path)
+		}
+		if err := checkDependencyBundleCatalogChecksum(entry, dep.Checksum); err != nil {
+			return fmt.Errorf("dependency bundle file %s does not match its catalog checksum: %w", bundleFile.Path, err)
+		}
+	}
+	for name := range staged {
+		if !listed[name] {
+			return fmt.Errorf("dependency bundle file %s is not listed in its manifest", name)
+		}
+	}
+	return nil
+}
+
+// checkDependencyBundleCatalogChecksum checks a staged file against the
+// checksum the catalog pins, reusing its sha256 when the catalog pins one.
+func checkDependencyBundleCatalogChecksum(entry stagedDependencyBundleFile, checksum string) error {
+	if checksum == "" {
+		return nil
+	}
+	algorithm, digest, err := parseDependencyChecksum(checksum)
+	if err != nil {
+		return err
+	}
+	if algorithm == "sha256" {
+		if !strings.EqualFold(entry.checksum, algorithm+":"+digest) {
+			return fmt.Errorf("expected %s, got %s", checksum, entry.checksum)
+		}
+		return nil
+	}
+	return verifyDependencyChecksum(entry.tempPath, checksum)
+}
@@ -0,0 +1,215 @@
+package build
+
+import (
+	"archive/tar"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func writeTestTar(t *testing.T, path string, entries map[string]string, order []string) {
+	t.Helper()
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create tar: %v", err)
+	}
+	defer file.Close()
+	writer := tar.NewWriter(file)
+	for _, name := range order {
+		content := entries[name]
+		if err := writer.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatalf("failed to write tar header: %v", err)
+		}
+		if _, err := writer.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write tar entry: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to close tar: %v", err)
+	}
+}
+
+func TestDependencyBundleRoundTripsIntoAnEmptyCache(t *testing.T) {
+	withPlanCacheDir(t)
+	dirs := GetDirectoriesInstance()
+	iso := dirs.CachePath("linux", "ubuntu.iso")
+	deb := dirs.CachePath("qemu-efi-aarch64_all.deb")
+	writeDependencyFile(t, iso, "ubuntu")
+	catalog := []WebFileDependency{
+		{LocalPath: iso, Checksum: sha256Checksum("ubuntu"), RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+		{LocalPath: deb, RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+	}
+	downloads := stubDependencyCatalog(t, catalog)
+
+	bundle := filepath.Join(t.TempDir(), "bundle.tar")
+	manifest, err := ExportDependencyBundle([]VirtualMachineConfig{dependencyTestTarget}, bundle)
+	if err != nil {
+		t.Fatalf("ExportDependencyBundle returned error: %v", err)
+	}
+	if len(*downloads) != 1 || (*downloads)[0] != deb {
+		t.Fatalf("expected export to fetch the missing package first, got %v", *downloads)
+	}
+	if len(manifest.Files) != 2 || manifest.Files[0].Path != "linux/ubuntu.iso" || manifest.Files[1].Path != "qemu-efi-aarch64_all.deb" {
+		t.Fatalf("unexpected manifest files: %+v", manifest.Files)
+	}
+	if manifest.Files[0].Checksum != sha256Checksum("ubuntu") {
+		t.Fatalf("expected manifest checksum of the ISO, got %s", manifest.Files[0].Checksum)
+	}
+
+	dirs.CacheDir = t.TempDir()
+	stubDependencyCatalog(t, []WebFileDependency{
+		{LocalPath: dirs.CachePath("linux", "ubuntu.iso"), Checksum: sha256Checksum("ubuntu")},
+		{LocalPath: dirs.CachePath("qemu-efi-aarch64_all.deb")},
+	})
+	imported, err := ImportDependencyBundle(bundle)
+	if err != nil {
+		t.Fatalf("ImportDependencyBundle returned error: %v", err)
+	}
+	if len(imported.Files) != 2 {
+		t.Fatalf("expected two imported files, got %+v", imported.Files)
+	}
+	if !checkIfWebFileDependencyExists(WebFileDependency{LocalPath: dirs.CachePath("linux", "ubuntu.iso"), Checksum: sha256Checksum("ubuntu")}) {
+		t.Fatal("expected the imported ISO to satisfy its dependency checksum")
+	}
+	if content, err := os.ReadFile(dirs.CachePath("qemu-efi-aarch64_all.deb")); err != nil || string(content) != "downloaded" {
+		t.Fatalf("expected imported package, got %q err=%v", content, err)
+	}
+}
+
+func TestImportDependencyBundleRejectsTamperedFileWithoutTouchingCache(t *testing.T) {
+	withPlanCacheDir(t)
+	dirs := GetDirectoriesInstance()
+	existing := dirs.CachePath("linux", "ubuntu.iso")
+	writeDependencyFile(t, existing, "original")
+	stubDependencyCatalog(t, []WebFileDependency{{LocalPath: existing}})
+
+	bundle := filepath.Join(t.TempDir(), "bundle.tar")
+	manifest := `{"version":1,"files":[` +
+		`{"path":"linux/ubuntu.iso","size_bytes":8,"checksum":"` + sha256Checksum("expected") + `"}]}`
+	writeTestTar(t, bundle, map[string]string{
+		"linux/ubuntu.iso":           "tampered",
+		dependencyBundleManifestName: manifest,
+	}, []string{"linux/ubuntu.iso", dependencyBundleManifestName})
+
+	_, err := ImportDependencyBundle(bundle)
+	if err == nil || !strings.Contains(err.Error(), "does not match its manifest checksum") {
+		t.Fatalf("expected checksum error, got %v", err)
+	}
+	if content, _ := os.ReadFile(existing); string(content) != "original" {
+		t.Fatalf("expected cached file to stay untouched, got %q", content)
+	}
+	if _, err := os.Stat(existing + dependencyBundleImportSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected staged import file to be removed, got %v", err)
+	}
+}
+
+func TestImportDependencyBundleRecordsUnpinnedChecksumsInLock(t *testing.T) {
+	withPlanCacheDir(t)
+	iso := GetDirectoriesInstance().CachePath("windows11", "iso", "win11.iso")
+	stubDependencyCatalog(t, []WebFileDependency{{LocalPath: iso, RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}}})
+	writeBundle := func(content string) string {
+		bundle := filepath.Join(t.TempDir(), "bundle.tar")
+		manifest := `{"version":1,"files":[` +
+			`{"path":"windows11/iso/win11.iso","size_bytes":` + strconv.Itoa(len(content)) + `,"checksum":"` + sha256Checksum(content) + `"}]}`
+		writeTestTar(t, bundle, map[string]string{
+			"windows11/iso/win11.iso":    content,
+			dependencyBundleManifestName: manifest,
+		}, []string{"windows11/iso/win11.iso", dependencyBundleManifestName})
+		return bundle
+	}
+
+	if _, err := ImportDependencyBundle(writeBundle("windows")); err != nil {
+		t.Fatalf("ImportDependencyBundle returned error: %v", err)
+	}
+	unpinned, err := unpinnedDependencies([]WebFileDependency{{LocalPath: iso}})
+	if err != nil || len(unpinned) != 0 {
+		t.Fatalf("expected the imported ISO to be pinned for strict builds, got %v err=%v", unpinned, err)
+	}
+	checksum, locked, err := pinnedDependencyChecksum(WebFileDependency{LocalPath: iso})
+	if err != nil || !locked || checksum != sha256Checksum("windows") {
+		t.Fatalf("expected the manifest checksum in the lock, got %q locked=%t err=%v", checksum, locked, err)
+	}
+
+	_, err = ImportDependencyBundle(writeBundle("other windows"))
+	if err == nil || !strings.Contains(err.Error(), "records") {
+		t.Fatalf("expected a conflicting lock entry to be rejected, got %v", err)
+	}
+	if content, _ := os.ReadFile(iso); string(content) != "windows" {
+		t.Fatalf("expected the cached ISO to stay untouched, got %q", content)
+	}
+}
+
+func TestImportDependencyBundleRejectsPathsOutsideCache(t *testing.T) {
+	withPlanCacheDir(t)
+	bundle := filepath.Join(t.TempDir(), "bundle.tar")
+	writeTestTar(t, bundle, map[string]string{"../escape.iso": "x"}, []string{"../escape.iso"})
+
+	if _, err := ImportDependencyBundle(bundle); err == nil || !strings.Contains(err.Error(), "not a relative cache path") {
+		t.Fatalf("expected path traversal to be rejected, got %v", err)
+	}
+}
+
+func TestImportDependencyBundleRejectsFilesOutsideTheCatalog(t *testing.T) {
+	withPlanCacheDir(t)
+	dirs := GetDirectoriesInstance()
+	stubDependencyCatalog(t, []WebFileDependency{{LocalPath: dirs.CachePath("linux", "ubuntu.iso")}})
+	lock := dirs.CachePath(dependencyVerificationCacheFile)
+	writeDependencyFile(t, lock, "original")
+
+	bundle := filepath.Join(t.TempDir(), "bundle.tar")
+	manifest := `{"version":1,"files":[` +
+		`{"path":"` + dependencyVerificationCacheFile + `","size_bytes":8,"checksum":"` + sha256Checksum("replaced") + `"}]}`
+	writeTestTar(t, bundle, map[string]string{
+		dependencyVerificationCacheFile: "replaced",
+		dependencyBundleManifestName:    manifest,
+	}, []string{dependencyVerificationCacheFile, dependencyBundleManifestName})
+
+	_, err := ImportDependencyBundle(bundle)
+	if err == nil || !strings.Contains(err.Error(), "not a dependency in the catalog") {
+		t.Fatalf("expected a non-dependency file to be rejected, got %v", err)
+	}
+	if content, _ := os.ReadFile(lock); string(content) != "original" {
+		t.Fatalf("expected the cache file to stay untouched, got %q", content)
+	}
+	if _, err := os.Stat(lock + dependencyBundleImportSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing to be staged, got %v", err)
+	}
+}
+
+func TestImportDependencyBundleChecksCatalogChecksums(t *testing.T) {
+	withPlanCacheDir(t)
+	iso := GetDirectoriesInstance().CachePath("linux", "ubuntu.iso")
+	stubDependencyCatalog(t, []WebFileDependency{{LocalPath: iso, Checksum: sha256Checksum("ubuntu")}})
+
+	bundle := filepath.Join(t.TempDir(), "bundle.tar")
+	manifest := `{"version":1,"files":[` +
+		`{"path":"linux/ubuntu.iso","size_bytes":5,"checksum":"` + sha256Checksum("other") + `"}]}`
+	writeTestTar(t, bundle, map[string]string{
+		"linux/ubuntu.iso":           "other",
+		dependencyBundleManifestName: manifest,
+	}, []string{"linux/ubuntu.iso", dependencyBundleManifestName})
+
+	_, err := ImportDependencyBundle(bundle)
+	if err == nil || !strings.Contains(err.Error(), "does not match its catalog checksum") {
+		t.Fatalf("expected the catalog checksum to be enforced, got %v", err)
+	}
+	if _, err := os.Stat(iso); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing to be imported, got %v", err)
+	}
+}
+
+func TestImportDependencyBundleRequiresManifest(t *testing.T) {
+	withPlanCacheDir(t)
+	stubDependencyCatalog(t, []WebFileDependency{{LocalPath: GetDirectoriesInstance().CachePath("linux", "ubuntu.iso")}})
+	bundle := filepath.Join(t.TempDir(), "bundle.tar")
+	writeTestTar(t, bundle, map[string]string{"linux/ubuntu.iso": "x"}, []string{"linux/ubuntu.iso"})
+
+	if _, err := ImportDependencyBundle(bundle); err == nil || !strings.Contains(err.Error(), "has no manifest.json") {
+		t.Fatalf("expected missing manifest error, got %v", err)
+	}
+	if _, err := os.Stat(GetDirectoriesInstance().CachePath("linux", "ubuntu.iso")); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing to be imported, got %v", err)
+	}
+}
@@ -0,0 +1,42 @@
+package build
+
+import (
+	"crypto/sha512"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDependencyChecksumAcceptsSHA256AndSHA512(t *testing.T) {
+	sha512Digest := fmt.Sprintf("%x", sha512.Sum512([]byte("iso")))
+	for checksum, wantAlgorithm := range map[string]string{
+		sha256Checksum("iso"):                     "sha256",
+		"SHA512:" + sha512Digest:                  "sha512",
+		"sha512:" + strings.ToUpper(sha512Digest): "sha512",
+	} {
+		algorithm, digest, err := parseDependencyChecksum(checksum)
+		if err != nil || algorithm != wantAlgorithm || digest != strings.ToLower(digest) {
+			t.Fatalf("parseDependencyChecksum(%q) = %q, %q, %v", checksum, algorithm, digest, err)
+		}
+	}
+
+	for _, checksum := range []string{"md5:0cc175b9c0f1b6a831c399e269772661", "sha256:abc", "sha512:" + strings.Repeat("z", 128), "deadbeef"} {
+		if _, _, err := parseDependencyChecksum(checksum); err == nil {
+			t.Fatalf("expected %q to be rejected", checksum)
+		}
+	}
+}
+
+func TestVerifyDependencyChecksumSupportsSHA512(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "firmware.deb")
+	writeDependencyFile(t, path, "firmware")
+
+	if err := verifyDependencyChecksum(path, fmt.Sprintf("sha512:%x", sha512.Sum512([]byte("firmware")))); err != nil {
+		t.Fatalf("expected sha512 checksum to match, got %v", err)
+	}
+	err := verifyDependencyChecksum(path, fmt.Sprintf("sha512:%x", sha512.Sum512([]byte("other"))))
+	if !isChecksumMismatch(err) {
+		t.Fatalf("expected sha512 checksum mismatch, got %v", err)
+	}
+}
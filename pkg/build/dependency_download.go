@@ -0,0 +1,298 @@
+package build
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/vbauerster/mpb/v8"
+)
+
+const (
+	// dependencyPartSuffix names the file a dependency is downloaded into.
+	// It is kept after a failed download so the next attempt can resume.
+	dependencyPartSuffix = ".part"
+
+	dependencyDownloadWorkersEnvVar  = "DEV_ALCHEMY_DEPENDENCY_DOWNLOAD_WORKERS"
+	defaultDependencyDownloadWorkers = 3
+)
+
+// dependencyDownloadLocks serializes downloads of the same local path, for
+// example when parallel builds share the virtio-win ISO.
+var dependencyDownloadLocks sync.Map
+
+type dependencyPartState struct {
+	Source string `json:"source"`
+	// Validator is the strong ETag or Last-Modified value of the response the
+	// partial file came from. It is sent as If-Range when resuming, so a
+	// changed upstream file restarts the download instead of being spliced.
+	Validator string `json:"validator"`
+}
+
+// checksumMismatchError reports a completed download whose content does not
+// match the pinned checksum.
+type checksumMismatchError struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (e *checksumMismatchError) Error() string {
+	return fmt.Sprintf("checksum mismatch for %s: expected %s, got %s", e.Path, e.Expected, e.Actual)
+}
+
+func isChecksumMismatch(err error) bool {
+	var checksumErr *checksumMismatchError
+	return errors.As(err, &checksumErr)
+}
+
+// dependencyDownloadWorkers returns how many dependencies are downloaded at
+// once, honoring DEV_ALCHEMY_DEPENDENCY_DOWNLOAD_WORKERS.
+func dependencyDownloadWorkers() int {
+	if value := strings.TrimSpace(os.Getenv(dependencyDownloadWorkersEnvVar)); value != "" {
+		if workers, err := strconv.Atoi(value); err == nil && workers > 0 {
+			return workers
+		}
+		log.Printf("Ignoring invalid %s=%q; using %d workers", dependencyDownloadWorkersEnvVar, value, defaultDependencyDownloadWorkers)
+	}
+	return defaultDependencyDownloadWorkers
+}
+
+var dependencyDownloadWorkersFunc = dependencyDownloadWorkers
+
+// ensureWebFileDependencies downloads the missing or invalid deps with a
+// bounded number of workers that share one progress container. With failFast,
+// queued downloads are skipped after the first failure; downloads already
+// running are finished. With strictChecksums, nothing is downloaded when a
+// dependency has no pinned checksum, and no checksum is recorded on first use.
+func ensureWebFileDependencies(deps []WebFileDependency, failFast bool, strictChecksums bool) error {
+	if strictChecksums {
+		unpinned, err := unpinnedDependencies(deps)
+		if err != nil {
+			return err
+		}
+		if len(unpinned) > 0 {
+			return fmt.Errorf("strict checksums: %d dependencies have no pinned checksum: %s; run `alchemy deps fetch` without --strict-checksums to record them in %s",
+				len(unpinned), strings.Join(unpinned, ", "), DependencyLockPath(GetDirectoriesInstance()))
+		}
+	}
+
+	p := mpb.New(mpb.WithWidth(80))
+	defer p.Wait()
+
+	workers := min(dependencyDownloadWorkersFunc(), len(deps))
+	jobs := make(chan WebFileDependency)
+	var (
+		mu     sync.Mutex
+		errs   []error
+		failed atomic.Bool
+		wg     sync.WaitGroup
+	)
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dep := range jobs {
+				if failFast && failed.Load() {
+					continue
+				}
+				if err := ensureWebFileDependency(p, dep, !strictChecksums); err != nil {
+					failed.Store(true)
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("%s: %w", dep.LocalPath, err))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, dep := range deps {
+		jobs <- dep
+	}
+	close(jobs)
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// ensureWebFileDependency downloads dep unless a file matching its pinned
+// checksum is present. With recordChecksum, the checksum of an unpinned dep
+// is recorded in the dependency lock once the file is in place.
+func ensureWebFileDependency(p *mpb.Progress, dep WebFileDependency, recordChecksum bool) error {
+	lock, _ := dependencyDownloadLocks.LoadOrStore(dep.LocalPath, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	checksum, locked, err := pinnedDependencyChecksum(dep)
+	if err != nil {
+		return err
+	}
+	if !checkIfWebFileDependencyExists(dep) {
+		dep.Checksum = checksum
+		if err := downloadWebFileDependencyFunc(p, dep); err != nil {
+			if locked && isChecksumMismatch(err) {
+				return fmt.Errorf("download no longer matches the checksum recorded in %s; if the upstream file changed on purpose, run `alchemy deps fetch --repin`: %w",
+					DependencyLockPath(GetDirectoriesInstance()), err)
+			}
+			return fmt.Errorf("failed to download web file dependency: %w", err)
+		}
+	}
+	if recordChecksum && checksum == "" {
+		return recordDependencyChecksum(dep)
+	}
+	return nil
+}
+
+// downloadWebFileDependencyFromSource downloads source into the .part file of
+// dep, resuming a partial download of the same source, verifies the checksum,
+// and moves the file into place. A resumed file that fails its checksum is
+// downloaded once more from the start.
+func downloadWebFileDependencyFromSource(p *mpb.Progress, dep WebFileDependency, source string, client *http.Client) error {
+	partPath := dep.LocalPath + dependencyPartSuffix
+	for attempt := 0; ; attempt++ {
+		resumed, err := downloadToPartFile(p, client, source, partPath)
+		if err != nil {
+			log.Printf("Failed to download web file dependency from %s to %s: %v", source, dep.LocalPath, err)
+			return err
+		}
+
+		err = verifyDependencyChecksum(partPath, dep.Checksum)
+		if err == nil {
+			break
+		}
+		removeDependencyPartFile(partPath)
+		if resumed && attempt == 0 && isChecksumMismatch(err) {
+			log.Printf("Resumed download of %s failed its checksum; downloading it again from the start", dep.LocalPath)
+			continue
+		}
+		return err
+	}
+
+	if err := os.Rename(partPath, dep.LocalPath); err != nil {
+		return fmt.Errorf("move %s into place: %w", dep.LocalPath, err)
+	}
+	_ = os.Remove(dependencyPartStatePath(partPath))
+	recordDependencyVerification(dep.LocalPath, dep.Checksum)
+	log.Printf("Successfully downloaded web file dependency from %s to %s", source, dep.LocalPath)
+	return nil
+}
+
+// downloadToPartFile fetches source into partPath. It resumes with an HTTP
+// range request when partPath holds a partial download of the same source
+// and the server validates it with If-Range; otherwise it starts over.
+func downloadToPartFile(p *mpb.Progress, client *http.Client, source string, partPath string) (bool, error) {
+	if err := os.MkdirAll(filepath.Dir(partPath), 0o755); err != nil {
+		return false, err
+	}
+	statePath := dependencyPartStatePath(partPath)
+
+	var offset int64
+	var state dependencyPartState
+	if content, err := os.ReadFile(statePath); err == nil && json.Unmarshal(content, &state) == nil &&
+		state.Source == source && state.Validator != "" {
+		if info, err := os.Stat(partPath); err == nil {
+			offset = info.Size()
+		}
+	}
+	if offset == 0 {
+		removeDependencyPartFile(partPath)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, source, nil)
+	if err != nil {
+		return false, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", state.Validator)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case offset > 0 && resp.StatusCode == http.StatusPartialContent && contentRangeStartsAt(resp.Header.Get("Content-Range"), offset):
+		flags |= os.O_APPEND
+		log.Printf("Resuming download of %s at %s", source, FormatByteSize(offset))
+	case offset > 0 && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable &&
+		resp.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", offset):
+		// The partial file already holds the whole response.
+		return true, nil
+	case resp.StatusCode == http.StatusOK:
+		flags |= os.O_TRUNC
+		offset = 0
+	case resp.StatusCode == http.StatusUnauthorized:
+		removeDependencyPartFile(partPath)
+		return false, fmt.Errorf("HTTP status 401: add a login for %s to the netrc file (~/.netrc or the file named by NETRC)", req.URL.Hostname())
+	default:
+		removeDependencyPartFile(partPath)
+		return false, fmt.Errorf("unexpected HTTP status %d", resp.StatusCode)
+	}
+
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		validator = resp.Header.Get("Last-Modified")
+	}
+	content, err := json.Marshal(dependencyPartState{Source: source, Validator: validator})
+	if err != nil {
+		return false, err
+	}
+	if err := os.WriteFile(statePath, content, 0o644); err != nil { // #nosec G306 -- the sidecar sits next to world-readable cache downloads.
+		return false, err
+	}
+
+	file, err := os.OpenFile(partPath, flags, 0o644) // #nosec G302,G304 -- partPath is a managed dependency path in the cache dir.
+	if err != nil {
+		return false, err
+	}
+
+	listener := &ProgressBarListener{progress: p}
+	total := int64(0)
+	if resp.ContentLength > 0 {
+		total = offset + resp.ContentLength
+	}
+	body := listener.TrackProgress(source, offset, total, resp.Body)
+	written, err := io.Copy(file, body)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && resp.ContentLength >= 0 && written < resp.ContentLength {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		if listener.bar != nil {
+			listener.bar.Abort(false)
+		}
+		return false, err
+	}
+	if listener.bar != nil {
+		// Mark bar complete; mpb renders it as done and removes it from the live display.
+		listener.bar.SetTotal(listener.bar.Current(), true)
+	}
+	return offset > 0, nil
+}
+
+// dependencyPartStatePath returns the sidecar that records which source and
+// response validator the partial download at partPath belongs to.
+func dependencyPartStatePath(partPath string) string {
+	return partPath + ".json"
+}
+
+func contentRangeStartsAt(contentRange string, offset int64) bool {
+	return strings.HasPrefix(contentRange, fmt.Sprintf("bytes %d-", offset))
+}
+
+func removeDependencyPartFile(partPath string) {
+	_ = os.Remove(partPath)
+	_ = os.Remove(dependencyPartStatePath(partPath))
+}
@@ -0,0 +1,197 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vbauerster/mpb/v8"
+)
+
+func stubDependencyDownloadWorkers(t *testing.T, workers int) {
+	t.Helper()
+	previous := dependencyDownloadWorkersFunc
+	t.Cleanup(func() {
+		dependencyDownloadWorkersFunc = previous
+	})
+	dependencyDownloadWorkersFunc = func() int { return workers }
+}
+
+// rangeServer serves payload with a strong ETag and honors Range requests
+// whose If-Range matches it.
+func rangeServer(t *testing.T, payload []byte, etag string, ranges *[]string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		w.Header().Set("ETag", etag)
+		var start int
+		if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && r.Header.Get("If-Range") == etag {
+			_, _ = fmt.Sscanf(rangeHeader, "bytes=%d-", &start)
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(payload)-1, len(payload)))
+			w.Header().Set("Content-Length", fmt.Sprint(len(payload)-start))
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		_, _ = w.Write(payload[start:])
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func writePartialDownload(t *testing.T, localPath string, content string, state dependencyPartState) {
+	t.Helper()
+	partPath := localPath + dependencyPartSuffix
+	if err := os.WriteFile(partPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write partial download: %v", err)
+	}
+	stateContent := fmt.Sprintf(`{"source":%q,"validator":%q}`, state.Source, state.Validator)
+	if err := os.WriteFile(dependencyPartStatePath(partPath), []byte(stateContent), 0o600); err != nil {
+		t.Fatalf("failed to write partial download state: %v", err)
+	}
+}
+
+func TestDownloadWebFileDependencyResumesPartialDownload(t *testing.T) {
+	stubDependencyMirrors(t, DependencyMirrors{})
+	payload := []byte("0123456789abcdefghij")
+	var ranges []string
+	server := rangeServer(t, payload, `"v1"`, &ranges)
+	source := server.URL + "/ubuntu.iso"
+
+	localPath := filepath.Join(t.TempDir(), "ubuntu.iso")
+	writePartialDownload(t, localPath, "0123456789", dependencyPartState{Source: source, Validator: `"v1"`})
+
+	dep := WebFileDependency{LocalPath: localPath, Source: source, Checksum: sha256Checksum(string(payload))}
+	if err := downloadWebFileDependency(nil, dep); err != nil {
+		t.Fatalf("downloadWebFileDependency returned error: %v", err)
+	}
+
+	if len(ranges) != 1 || ranges[0] != "bytes=10-" {
+		t.Fatalf("expected a single range request from byte 10, got %q", ranges)
+	}
+	if content, _ := os.ReadFile(localPath); !bytes.Equal(content, payload) {
+		t.Fatalf("expected resumed file to match payload, got %q", content)
+	}
+	for _, leftover := range []string{localPath + dependencyPartSuffix, dependencyPartStatePath(localPath + dependencyPartSuffix)} {
+		if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed after completion, got %v", leftover, err)
+		}
+	}
+}
+
+func TestDownloadWebFileDependencyRestartsWhenUpstreamChanged(t *testing.T) {
+	stubDependencyMirrors(t, DependencyMirrors{})
+	payload := []byte("new upstream content")
+	var ranges []string
+	server := rangeServer(t, payload, `"v2"`, &ranges)
+	source := server.URL + "/tools.iso"
+
+	localPath := filepath.Join(t.TempDir(), "tools.iso")
+	writePartialDownload(t, localPath, "old upstr", dependencyPartState{Source: source, Validator: `"v1"`})
+
+	if err := downloadWebFileDependency(nil, WebFileDependency{LocalPath: localPath, Source: source}); err != nil {
+		t.Fatalf("downloadWebFileDependency returned error: %v", err)
+	}
+	if content, _ := os.ReadFile(localPath); !bytes.Equal(content, payload) {
+		t.Fatalf("expected a fresh download after the validator changed, got %q", content)
+	}
+}
+
+func TestDownloadWebFileDependencyRetriesFromStartWhenResumedChecksumFails(t *testing.T) {
+	stubDependencyMirrors(t, DependencyMirrors{})
+	payload := []byte("0123456789abcdefghij")
+	var ranges []string
+	server := rangeServer(t, payload, `"v1"`, &ranges)
+	source := server.URL + "/virtio-win.iso"
+
+	localPath := filepath.Join(t.TempDir(), "virtio-win.iso")
+	writePartialDownload(t, localPath, "corrupted!", dependencyPartState{Source: source, Validator: `"v1"`})
+
+	dep := WebFileDependency{LocalPath: localPath, Source: source, Checksum: sha256Checksum(string(payload))}
+	if err := downloadWebFileDependency(nil, dep); err != nil {
+		t.Fatalf("downloadWebFileDependency returned error: %v", err)
+	}
+	if len(ranges) != 2 || ranges[0] != "bytes=10-" || ranges[1] != "" {
+		t.Fatalf("expected a resumed attempt followed by a full download, got %q", ranges)
+	}
+	if content, _ := os.ReadFile(localPath); !bytes.Equal(content, payload) {
+		t.Fatalf("expected the full download to be kept, got %q", content)
+	}
+}
+
+func TestDownloadWebFileDependencyKeepsPartialFileAfterInterruptedTransfer(t *testing.T) {
+	stubDependencyMirrors(t, DependencyMirrors{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Length", "20")
+		_, _ = w.Write([]byte("0123456789"))
+	}))
+	defer server.Close()
+
+	localPath := filepath.Join(t.TempDir(), "ubuntu.iso")
+	err := downloadWebFileDependency(nil, WebFileDependency{LocalPath: localPath, Source: server.URL + "/ubuntu.iso"})
+	if err == nil {
+		t.Fatal("expected the truncated transfer to fail")
+	}
+	if content, err := os.ReadFile(localPath + dependencyPartSuffix); err != nil || string(content) != "0123456789" {
+		t.Fatalf("expected partial file to be kept for resume, got %q err=%v", content, err)
+	}
+	if _, err := os.Stat(localPath); !os.IsNotExist(err) {
+		t.Fatalf("expected no completed file, got %v", err)
+	}
+}
+
+func TestEnsureWebFileDependenciesBoundsConcurrentDownloads(t *testing.T) {
+	dir := t.TempDir()
+	var deps []WebFileDependency
+	for i := range 6 {
+		deps = append(deps, WebFileDependency{LocalPath: filepath.Join(dir, fmt.Sprintf("dep-%d.iso", i))})
+	}
+	stubDependencyCatalog(t, deps)
+	stubDependencyDownloadWorkers(t, 2)
+
+	var running, peak atomic.Int32
+	var mu sync.Mutex
+	var downloaded []string
+	downloadWebFileDependencyFunc = func(p *mpb.Progress, dep WebFileDependency) error {
+		current := running.Add(1)
+		for {
+			previous := peak.Load()
+			if current <= previous || peak.CompareAndSwap(previous, current) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		running.Add(-1)
+		mu.Lock()
+		downloaded = append(downloaded, dep.LocalPath)
+		mu.Unlock()
+		return nil
+	}
+
+	if err := ensureWebFileDependencies(deps, false, false); err != nil {
+		t.Fatalf("ensureWebFileDependencies returned error: %v", err)
+	}
+	if len(downloaded) != len(deps) {
+		t.Fatalf("expected every dependency to be downloaded, got %v", downloaded)
+	}
+	if got := peak.Load(); got != 2 {
+		t.Fatalf("expected two concurrent downloads at most and at least once, got %d", got)
+	}
+}
+
+func TestDependencyDownloadWorkersHonorsEnvironment(t *testing.T) {
+	t.Setenv(dependencyDownloadWorkersEnvVar, "5")
+	if got := dependencyDownloadWorkers(); got != 5 {
+		t.Fatalf("expected 5 workers, got %d", got)
+	}
+	t.Setenv(dependencyDownloadWorkersEnvVar, "zero")
+	if got := dependencyDownloadWorkers(); got != defaultDependencyDownloadWorkers {
+		t.Fatalf("expected default workers for an invalid value, got %d", got)
+	}
+}
@@ -0,0 +1,267 @@
+package build
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dependencyLockEnvVar  = "DEV_ALCHEMY_DEPENDENCY_LOCK"
+	dependencyLockFile    = "dependency-lock.json"
+	dependencyLockVersion = 1
+	// dependencyLockAlgorithm is used for checksums recorded on first use.
+	dependencyLockAlgorithm = "sha256"
+)
+
+// DependencyLock records the checksums of web file dependencies that have no
+// checksum in the catalog, such as the Windows ISO. The first download is
+// trusted and later downloads and builds must match it.
+type DependencyLock struct {
+	Version int `json:"version"`
+	// Dependencies maps a dependency path relative to the cache dir to the
+	// checksum observed when it was first used.
+	Dependencies map[string]DependencyLockEntry `json:"dependencies"`
+}
+
+// DependencyLockEntry is one recorded dependency checksum.
+type DependencyLockEntry struct {
+	Checksum   string    `json:"checksum"`
+	SizeBytes  int64     `json:"size_bytes"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// dependencyLockMu serializes read-modify-write cycles on the lockfile,
+// because dependencies are recorded by parallel download workers.
+var dependencyLockMu sync.Mutex
+
+// DependencyLockPath returns the lockfile location, honoring the
+// DEV_ALCHEMY_DEPENDENCY_LOCK override.
+func DependencyLockPath(directories *Directories) string {
+	if override := strings.TrimSpace(os.Getenv(dependencyLockEnvVar)); override != "" {
+		return filepath.Clean(override)
+	}
+
+	return directories.ConfigPath(dependencyLockFile)
+}
+
+func loadDependencyLock(lockPath string) (DependencyLock, error) {
+	lock := DependencyLock{Version: dependencyLockVersion, Dependencies: map[string]DependencyLockEntry{}}
+	content, err := os.ReadFile(lockPath) // #nosec G304 -- lockPath is the managed or user-selected lockfile.
+	if errors.Is(err, os.ErrNotExist) {
+		return lock, nil
+	}
+	if err != nil {
+		return lock, fmt.Errorf("read dependency lock %q: %w", lockPath, err)
+	}
+	if err := json.Unmarshal(content, &lock); err != nil {
+		return lock, fmt.Errorf("parse dependency lock %q: %w", lockPath, err)
+	}
+	if lock.Version != dependencyLockVersion {
+		return lock, fmt.Errorf("dependency lock %q has unsupported version %d", lockPath, lock.Version)
+	}
+	if lock.Dependencies == nil {
+		lock.Dependencies = map[string]DependencyLockEntry{}
+	}
+	for file, entry := range lock.Dependencies {
+		if _, _, err := parseDependencyChecksum(entry.Checksum); err != nil {
+			return lock, fmt.Errorf("dependency lock %q entry %q: %w", lockPath, file, err)
+		}
+	}
+	return lock, nil
+}
+
+func writeDependencyLock(lockPath string, lock DependencyLock) error {
+	content, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(lockPath), 0o755); err != nil {
+		return err
+	}
+	tempPath := lockPath + ".tmp"
+	if err := os.WriteFile(tempPath, append(content, '\n'), 0o644); err != nil { // #nosec G306 -- the lockfile holds public checksums and may be committed.
+		return err
+	}
+	return os.Rename(tempPath, lockPath)
+}
+
+// dependencyLockKey returns the cache-relative slash path that identifies
+// localPath in the lockfile. Dependencies outside the cache dir cannot be
+// locked.
+func dependencyLockKey(localPath string) (string, bool) {
+	relativePath, err := filepath.Rel(GetDirectoriesInstance().CacheDir, localPath)
+	if err != nil || relativePath == "." || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.ToSlash(relativePath), true
+}
+
+// pinnedDependencyChecksum returns the checksum dep must match: the catalog
+// checksum, otherwise the one recorded in the lockfile. locked reports that
+// it came from the lockfile; an empty checksum means dep is unpinned.
+func pinnedDependencyChecksum(dep WebFileDependency) (checksum string, locked bool, err error) {
+	if dep.Checksum != "" {
+		return dep.Checksum, false, nil
+	}
+	key, ok := dependencyLockKey(dep.LocalPath)
+	if !ok {
+		return "", false, nil
+	}
+	dependencyLockMu.Lock()
+	defer dependencyLockMu.Unlock()
+	lock, err := loadDependencyLock(DependencyLockPath(GetDirectoriesInstance()))
+	if err != nil {
+		return "", false, err
+	}
+	entry, ok := lock.Dependencies[key]
+	return entry.Checksum, ok, nil
+}
+
+// recordDependencyChecksum trusts the present file of an unpinned dep and
+// records its checksum in the lockfile, unless an entry already exists.
+func recordDependencyChecksum(dep WebFileDependency) error {
+	if dep.Checksum != "" {
+		return nil
+	}
+	key, ok := dependencyLockKey(dep.LocalPath)
+	if !ok {
+		return nil
+	}
+
+	dependencyLockMu.Lock()
+	defer dependencyLockMu.Unlock()
+	lockPath := DependencyLockPath(GetDirectoriesInstance())
+	lock, err := loadDependencyLock(lockPath)
+	if err != nil {
+		return err
+	}
+	if _, ok := lock.Dependencies[key]; ok {
+		return nil
+	}
+	info, err := os.Stat(dep.LocalPath)
+	if err != nil {
+		return err
+	}
+	checksum, err := fileChecksum(dep.LocalPath, dependencyLockAlgorithm)
+	if err != nil {
+		return err
+	}
+	lock.Dependencies[key] = DependencyLockEntry{Checksum: checksum, SizeBytes: info.Size(), RecordedAt: time.Now().UTC()}
+	if err := writeDependencyLock(lockPath, lock); err != nil {
+		return fmt.Errorf("record checksum of %s in %s: %w", dep.LocalPath, lockPath, err)
+	}
+	recordDependencyVerification(dep.LocalPath, checksum)
+	log.Printf("Recorded %s for %s in %s", checksum, dep.LocalPath, lockPath)
+	return nil
+}
+
+// dependencyBundleLockEntries returns the lockfile entries for the files of
+// an imported bundle that are unpinned catalog dependencies without an entry
+// yet, using the verified manifest checksums. It fails when a file conflicts
+// with a checksum already recorded in the lockfile.
+func dependencyBundleLockEntries(manifest DependencyBundleManifest) (map[string]DependencyLockEntry, error) {
+	unpinned := map[string]bool{}
+	for _, dep := range webFileDependencyCatalog() {
+		if key, ok := dependencyLockKey(dep.LocalPath); ok && dep.Checksum == "" {
+			unpinned[key] = true
+		}
+	}
+
+	dependencyLockMu.Lock()
+	defer dependencyLockMu.Unlock()
+	lockPath := DependencyLockPath(GetDirectoriesInstance())
+	lock, err := loadDependencyLock(lockPath)
+	if err != nil {
+		return nil, err
+	}
+	entries := map[string]DependencyLockEntry{}
+	recordedAt := time.Now().UTC()
+	for _, bundleFile := range manifest.Files {
+		if !unpinned[bundleFile.Path] {
+			continue
+		}
+		if existing, ok := lock.Dependencies[bundleFile.Path]; ok {
+			if !strings.EqualFold(existing.Checksum, bundleFile.Checksum) {
+				return nil, fmt.Errorf("dependency bundle file %s has checksum %s, but %s records %s", bundleFile.Path, bundleFile.Checksum, lockPath, existing.Checksum)
+			}
+			continue
+		}
+		entries[bundleFile.Path] = DependencyLockEntry{Checksum: strings.ToLower(bundleFile.Checksum), SizeBytes: bundleFile.SizeBytes, RecordedAt: recordedAt}
+	}
+	return entries, nil
+}
+
+// recordDependencyLockEntries adds entries to the lockfile, keeping entries
+// that were recorded in the meantime.
+func recordDependencyLockEntries(entries map[string]DependencyLockEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	dependencyLockMu.Lock()
+	defer dependencyLockMu.Unlock()
+	lockPath := DependencyLockPath(GetDirectoriesInstance())
+	lock, err := loadDependencyLock(lockPath)
+	if err != nil {
+		return err
+	}
+	for key, entry := range entries {
+		if _, ok := lock.Dependencies[key]; !ok {
+			lock.Dependencies[key] = entry
+		}
+	}
+	if err := writeDependencyLock(lockPath, lock); err != nil {
+		return fmt.Errorf("record imported checksums in %s: %w", lockPath, err)
+	}
+	return nil
+}
+
+// unpinnedDependencies returns the local paths of deps that have neither a
+// catalog checksum nor a lockfile entry.
+func unpinnedDependencies(deps []WebFileDependency) ([]string, error) {
+	var unpinned []string
+	for _, dep := range deps {
+		checksum, _, err := pinnedDependencyChecksum(dep)
+		if err != nil {
+			return nil, err
+		}
+		if checksum == "" {
+			unpinned = append(unpinned, dep.LocalPath)
+		}
+	}
+	return unpinned, nil
+}
+
+// RepinDependencies drops the lockfile entries of the dependencies of
+// configs, so their next use records the checksum again. It returns the
+// removed cache-relative paths.
+func RepinDependencies(configs []VirtualMachineConfig) ([]string, error) {
+	dependencyLockMu.Lock()
+	defer dependencyLockMu.Unlock()
+	lockPath := DependencyLockPath(GetDirectoriesInstance())
+	lock, err := loadDependencyLock(lockPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, dep := range webFileDependenciesForVMConfigs(configs) {
+		key, ok := dependencyLockKey(dep.LocalPath)
+		if _, locked := lock.Dependencies[key]; ok && locked {
+			delete(lock.Dependencies, key)
+			removed = append(removed, key)
+		}
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	sort.Strings(removed)
+	return removed, writeDependencyLock(lockPath, lock)
+}
@@ -0,0 +1,148 @@
+package build
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vbauerster/mpb/v8"
+)
+
+func TestEnsureWebFileDependenciesRecordsChecksumOnFirstDownload(t *testing.T) {
+	withPlanCacheDir(t)
+	iso := GetDirectoriesInstance().CachePath("windows11", "iso", "win11.iso")
+	if err := os.MkdirAll(filepath.Dir(iso), 0o755); err != nil {
+		t.Fatalf("failed to create dependency dir: %v", err)
+	}
+	deps := []WebFileDependency{{LocalPath: iso, RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}}}
+	downloads := stubDependencyCatalog(t, deps)
+
+	if err := ensureWebFileDependencies(deps, true, false); err != nil {
+		t.Fatalf("ensureWebFileDependencies returned error: %v", err)
+	}
+	lock, err := loadDependencyLock(DependencyLockPath(GetDirectoriesInstance()))
+	if err != nil {
+		t.Fatalf("loadDependencyLock returned error: %v", err)
+	}
+	entry, ok := lock.Dependencies["windows11/iso/win11.iso"]
+	if !ok || entry.Checksum != sha256Checksum("downloaded") || entry.SizeBytes != int64(len("downloaded")) {
+		t.Fatalf("expected the first download to be recorded, got %+v", lock)
+	}
+
+	// A later build uses the present file once it still matches the lock.
+	if err := ensureWebFileDependencies(deps, true, false); err != nil || len(*downloads) != 1 {
+		t.Fatalf("expected the locked file to be reused, downloads=%v err=%v", *downloads, err)
+	}
+
+	// A tampered file is downloaded again and must match the lock.
+	writeDependencyFile(t, iso, "tampered")
+	downloadWebFileDependencyFunc = func(p *mpb.Progress, dep WebFileDependency) error {
+		if dep.Checksum != sha256Checksum("downloaded") {
+			t.Fatalf("expected the download to be verified against the lock, got %q", dep.Checksum)
+		}
+		return &checksumMismatchError{Path: dep.LocalPath, Expected: dep.Checksum, Actual: sha256Checksum("changed upstream")}
+	}
+	err = ensureWebFileDependencies(deps, true, false)
+	if err == nil || !strings.Contains(err.Error(), "alchemy deps fetch --repin") {
+		t.Fatalf("expected a lock mismatch with a repin hint, got %v", err)
+	}
+}
+
+func TestEnsureWebFileDependenciesStrictChecksumsRejectsUnpinnedDependencies(t *testing.T) {
+	withPlanCacheDir(t)
+	dirs := GetDirectoriesInstance()
+	pinned := dirs.CachePath("linux", "ubuntu.iso")
+	unpinned := dirs.CachePath("windows11", "iso", "win11.iso")
+	writeDependencyFile(t, unpinned, "present")
+	if err := os.MkdirAll(filepath.Dir(pinned), 0o755); err != nil {
+		t.Fatalf("failed to create dependency dir: %v", err)
+	}
+	deps := []WebFileDependency{
+		{LocalPath: pinned, Checksum: sha256Checksum("downloaded"), RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+		{LocalPath: unpinned, RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+	}
+	downloads := stubDependencyCatalog(t, deps)
+
+	err := ensureWebFileDependencies(deps, true, true)
+	if err == nil || !strings.Contains(err.Error(), "1 dependencies have no pinned checksum: "+unpinned) {
+		t.Fatalf("expected strict checksums to reject the unpinned dependency, got %v", err)
+	}
+	if len(*downloads) != 0 {
+		t.Fatalf("expected nothing to be downloaded, got %v", *downloads)
+	}
+	if _, err := os.Stat(DependencyLockPath(dirs)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected strict mode not to record checksums, got %v", err)
+	}
+
+	// Once recorded, the lock pins the dependency for strict builds.
+	if err := ensureWebFileDependencies(deps, true, false); err != nil {
+		t.Fatalf("ensureWebFileDependencies returned error: %v", err)
+	}
+	if err := ensureWebFileDependencies(deps, true, true); err != nil {
+		t.Fatalf("expected strict checksums to accept locked dependencies, got %v", err)
+	}
+}
+
+func TestRepinDependenciesDropsLockEntriesOfSelectedTargets(t *testing.T) {
+	withPlanCacheDir(t)
+	dirs := GetDirectoriesInstance()
+	other := dependencyTestTarget
+	other.Arch = "arm64"
+	selected := dirs.CachePath("windows11", "iso", "win11.iso")
+	kept := dirs.CachePath("windows11", "iso", "win11-arm64.iso")
+	writeDependencyFile(t, selected, "amd64")
+	writeDependencyFile(t, kept, "arm64")
+	stubDependencyCatalog(t, []WebFileDependency{
+		{LocalPath: selected, RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+		{LocalPath: kept, RelatedVmConfigs: []VirtualMachineConfig{other}},
+	})
+	for _, path := range []string{selected, kept} {
+		if err := recordDependencyChecksum(WebFileDependency{LocalPath: path}); err != nil {
+			t.Fatalf("recordDependencyChecksum returned error: %v", err)
+		}
+	}
+
+	removed, err := RepinDependencies([]VirtualMachineConfig{dependencyTestTarget})
+	if err != nil || len(removed) != 1 || removed[0] != "windows11/iso/win11.iso" {
+		t.Fatalf("expected the selected entry to be removed, got %v err=%v", removed, err)
+	}
+	lock, err := loadDependencyLock(DependencyLockPath(dirs))
+	if err != nil {
+		t.Fatalf("loadDependencyLock returned error: %v", err)
+	}
+	if _, ok := lock.Dependencies["windows11/iso/win11-arm64.iso"]; !ok || len(lock.Dependencies) != 1 {
+		t.Fatalf("expected only the other target's entry to remain, got %+v", lock.Dependencies)
+	}
+}
+
+func TestLoadDependencyLockRejectsUnsupportedChecksums(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), dependencyLockFile)
+	content := `{"version":1,"dependencies":{"linux/ubuntu.iso":{"checksum":"md5:abc"}}}`
+	if err := os.WriteFile(lockPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write lock: %v", err)
+	}
+	if _, err := loadDependencyLock(lockPath); err == nil || !strings.Contains(err.Error(), "unsupported checksum") {
+		t.Fatalf("expected unsupported checksum to be rejected, got %v", err)
+	}
+}
+
+func TestDependencyStatusesReportLockedChecksums(t *testing.T) {
+	withPlanCacheDir(t)
+	iso := GetDirectoriesInstance().CachePath("windows11", "iso", "win11.iso")
+	writeDependencyFile(t, iso, "recorded")
+	stubDependencyCatalog(t, []WebFileDependency{{LocalPath: iso, RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}}})
+	if err := recordDependencyChecksum(WebFileDependency{LocalPath: iso}); err != nil {
+		t.Fatalf("recordDependencyChecksum returned error: %v", err)
+	}
+	writeDependencyFile(t, iso, "changed")
+
+	statuses, err := DependencyStatuses([]VirtualMachineConfig{dependencyTestTarget}, FullChecksumVerification)
+	if err != nil {
+		t.Fatalf("DependencyStatuses returned error: %v", err)
+	}
+	if len(statuses) != 1 || !statuses[0].ChecksumLocked || statuses[0].Checksum != sha256Checksum("recorded") || statuses[0].State != DependencyStateInvalid {
+		t.Fatalf("expected the locked checksum to be verified, got %+v", statuses)
+	}
+}
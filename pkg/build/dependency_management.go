@@ -0,0 +1,236 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// DependencyState describes the local copy of a web file dependency.
+type DependencyState string
+
+const (
+	DependencyStateMissing DependencyState = "missing"
+	// DependencyStatePresent means the file exists but its checksum was not
+	// checked, either because none is pinned or verification was not asked for.
+	DependencyStatePresent DependencyState = "present"
+	DependencyStateValid   DependencyState = "valid"
+	DependencyStateInvalid DependencyState = "invalid"
+)
+
+// DependencyStatus reports one web file dependency and the targets that use
+// it.
+type DependencyStatus struct {
+	LocalPath string
+	Source    string
+	// SourceResolvedAtFetch is set when the download URL is only known after
+	// a lookup at fetch time, such as the Windows ISO.
+	SourceResolvedAtFetch bool
+	FallbackSources       []string
+	// EffectiveSources are the download candidates after the mirror config
+	// rewrote or overrode Source and FallbackSources. It is empty when the
+	// mirror config changes nothing.
+	EffectiveSources []string
+	SourceOverridden bool
+	// Checksum is the catalog checksum, or the one recorded in the dependency
+	// lock when ChecksumLocked is set.
+	Checksum       string
+	ChecksumLocked bool
+	State          DependencyState
+	SizeBytes      int64
+	Targets        []VirtualMachineConfig
+}
+
+// PrunedDependency is a cached download that no dependency references.
+type PrunedDependency struct {
+	Path      string
+	SizeBytes int64
+}
+
+var (
+	webFileDependencyCatalog      = getWebFileDependencies
+	downloadWebFileDependencyFunc = downloadWebFileDependency
+)
+
+// prunableDependencyExtensions limits prune to download types, so build
+// artifacts that share a cache directory are never touched.
+var prunableDependencyExtensions = map[string]bool{".iso": true, ".deb": true}
+
+// derivedDependencyFiles are generated by the build scripts from downloaded
+// dependencies and are kept by prune.
+func derivedDependencyFiles() []string {
+	return []string{
+		GetDirectoriesInstance().CachePath("windows11", "iso", "Win11_ARM64_Unattended.iso"),
+	}
+}
+
+// DependencyReconciliation downloads the web file dependencies of vmconfig
+// that are missing or fail their checksum, several at a time. After the first
+// failure no further downloads are started. With vmconfig.StrictChecksums it
+// fails before downloading when a dependency has no pinned checksum.
+func DependencyReconciliation(vmconfig VirtualMachineConfig) error {
+	return ensureWebFileDependencies(webFileDependenciesForVMConfig(vmconfig), true, vmconfig.StrictChecksums)
+}
+
+// FetchDependencies downloads the missing or invalid web file dependencies of
+// every config ahead of a build. A failed download does not stop the others;
+// all failures are returned together. Strict checksums apply when any config
+// asks for them.
+func FetchDependencies(configs []VirtualMachineConfig) error {
+	strictChecksums := false
+	for _, config := range configs {
+		strictChecksums = strictChecksums || config.StrictChecksums
+	}
+	return ensureWebFileDependencies(webFileDependenciesForVMConfigs(configs), false, strictChecksums)
+}
+
+// DependencyStatuses reports the web file dependencies of configs, sorted by
+// path. Unless verification is SkipChecksumVerification, present files are
+// checked against their pinned checksums; only FullChecksumVerification reads
+// files that were verified before and have not changed since.
+func DependencyStatuses(configs []VirtualMachineConfig, verification ChecksumVerification) ([]DependencyStatus, error) {
+	mirrors, _, _, err := loadDependencyMirrorsFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	deps := webFileDependenciesForVMConfigs(configs)
+	statuses := make([]DependencyStatus, 0, len(deps))
+	for _, dep := range deps {
+		checksum, locked, err := pinnedDependencyChecksum(dep)
+		if err != nil {
+			return nil, err
+		}
+		status := DependencyStatus{
+			LocalPath:             dep.LocalPath,
+			Source:                dep.Source,
+			SourceResolvedAtFetch: dep.BeforeHook != nil,
+			FallbackSources:       dep.FallbackSources,
+			Checksum:              checksum,
+			ChecksumLocked:        locked,
+			State:                 DependencyStateMissing,
+		}
+		effective, overridden := mirrors.dependencySources(dep, dep.Source)
+		if overridden || !slices.Equal(effective, downloadSources(dep.Source, dep.FallbackSources)) {
+			status.EffectiveSources = effective
+			status.SourceOverridden = overridden
+			status.SourceResolvedAtFetch = status.SourceResolvedAtFetch && !overridden
+		}
+		for _, config := range configs {
+			if webFileDependencyMatchesVMConfig(dep, config) {
+				status.Targets = append(status.Targets, config)
+			}
+		}
+
+		info, err := os.Stat(dep.LocalPath)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+		case err != nil:
+			return nil, fmt.Errorf("inspect dependency %s: %w", dep.LocalPath, err)
+		default:
+			status.SizeBytes = info.Size()
+			status.State = DependencyStatePresent
+			if verification != SkipChecksumVerification && checksum != "" {
+				err := verifyDependencyChecksumCached(dep.LocalPath, checksum, verification == FullChecksumVerification)
+				switch {
+				case err == nil:
+					status.State = DependencyStateValid
+				case isChecksumMismatch(err):
+					status.State = DependencyStateInvalid
+				default:
+					return nil, fmt.Errorf("hash dependency %s: %w", dep.LocalPath, err)
+				}
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// PruneDependencies removes cached ISO and Debian package downloads that no
+// web file dependency references any more, for example an ISO left behind by
+// a version bump. Only the directories that hold dependencies are scanned.
+// With dryRun the files are reported but kept.
+func PruneDependencies(dryRun bool) ([]PrunedDependency, error) {
+	referenced := map[string]bool{}
+	dirs := map[string]bool{}
+	for _, dep := range webFileDependencyCatalog() {
+		referenced[filepath.Clean(dep.LocalPath)] = true
+		dirs[filepath.Dir(filepath.Clean(dep.LocalPath))] = true
+	}
+	for _, path := range derivedDependencyFiles() {
+		referenced[filepath.Clean(path)] = true
+	}
+
+	sortedDirs := make([]string, 0, len(dirs))
+	for dir := range dirs {
+		sortedDirs = append(sortedDirs, dir)
+	}
+	sort.Strings(sortedDirs)
+
+	var pruned []PrunedDependency
+	var errs []error
+	for _, dir := range sortedDirs {
+		entries, err := os.ReadDir(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return pruned, fmt.Errorf("list dependency dir %s: %w", dir, err)
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			if !entry.Type().IsRegular() ||
+				referenced[path] ||
+				!prunableDependencyExtensions[strings.ToLower(filepath.Ext(entry.Name()))] {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("inspect %s: %w", path, err))
+				continue
+			}
+			if !dryRun {
+				if err := os.Remove(path); err != nil {
+					errs = append(errs, fmt.Errorf("remove %s: %w", path, err))
+					continue
+				}
+			}
+			pruned = append(pruned, PrunedDependency{Path: path, SizeBytes: info.Size()})
+		}
+	}
+	return pruned, errors.Join(errs...)
+}
+
+// webFileDependenciesForVMConfigs returns the dependencies of any of configs,
+// merging catalog entries that share a local path, sorted by path.
+func webFileDependenciesForVMConfigs(configs []VirtualMachineConfig) []WebFileDependency {
+	byPath := map[string]int{}
+	var deps []WebFileDependency
+	for _, dep := range webFileDependencyCatalog() {
+		matches := false
+		for _, config := range configs {
+			if webFileDependencyMatchesVMConfig(dep, config) {
+				matches = true
+				break
+			}
+		}
+		if !matches {
+			continue
+		}
+		if index, ok := byPath[dep.LocalPath]; ok {
+			deps[index].RelatedVmConfigs = append(deps[index].RelatedVmConfigs, dep.RelatedVmConfigs...)
+			continue
+		}
+		byPath[dep.LocalPath] = len(deps)
+		deps = append(deps, dep)
+	}
+	sort.SliceStable(deps, func(i, j int) bool {
+		return deps[i].LocalPath < deps[j].LocalPath
+	})
+	return deps
+}
@@ -0,0 +1,218 @@
+package build
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vbauerster/mpb/v8"
+)
+
+var dependencyTestTarget = VirtualMachineConfig{
+	OS:                   "ubuntu",
+	UbuntuType:           "server",
+	Arch:                 "amd64",
+	HostOs:               HostOsLinux,
+	VirtualizationEngine: VirtualizationEngineQemu,
+}
+
+func sha256Checksum(content string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(content)))
+}
+
+func stubDependencyMirrors(t *testing.T, mirrors DependencyMirrors) {
+	t.Helper()
+	previousLoad := loadDependencyMirrorsFunc
+	t.Cleanup(func() {
+		loadDependencyMirrorsFunc = previousLoad
+	})
+	loadDependencyMirrorsFunc = func() (DependencyMirrors, string, bool, error) {
+		return mirrors, "dependency-mirrors.yml", true, nil
+	}
+}
+
+func stubDependencyCatalog(t *testing.T, deps []WebFileDependency) *[]string {
+	t.Helper()
+	previousCatalog := webFileDependencyCatalog
+	previousDownload := downloadWebFileDependencyFunc
+	t.Cleanup(func() {
+		webFileDependencyCatalog = previousCatalog
+		downloadWebFileDependencyFunc = previousDownload
+	})
+	stubDependencyMirrors(t, DependencyMirrors{})
+
+	var downloads []string
+	webFileDependencyCatalog = func() []WebFileDependency { return deps }
+	downloadWebFileDependencyFunc = func(p *mpb.Progress, dep WebFileDependency) error {
+		downloads = append(downloads, dep.LocalPath)
+		return os.WriteFile(dep.LocalPath, []byte("downloaded"), 0o600)
+	}
+	return &downloads
+}
+
+func writeDependencyFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("failed to create dependency dir: %v", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write dependency: %v", err)
+	}
+}
+
+func TestDependencyStatusesReportsStateAndMergesDuplicatePaths(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.iso")
+	invalid := filepath.Join(dir, "invalid.iso")
+	missing := filepath.Join(dir, "missing.iso")
+	writeDependencyFile(t, valid, "good")
+	writeDependencyFile(t, invalid, "tampered")
+
+	desktop := dependencyTestTarget
+	desktop.UbuntuType = "desktop"
+	stubDependencyCatalog(t, []WebFileDependency{
+		{LocalPath: valid, Checksum: sha256Checksum("good"), RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+		{LocalPath: valid, Checksum: sha256Checksum("good"), RelatedVmConfigs: []VirtualMachineConfig{desktop}},
+		{LocalPath: invalid, Checksum: sha256Checksum("good"), RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+		{LocalPath: missing, BeforeHook: func() (string, error) { return "", nil }, RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+	})
+
+	statuses, err := DependencyStatuses([]VirtualMachineConfig{dependencyTestTarget, desktop}, FullChecksumVerification)
+	if err != nil {
+		t.Fatalf("DependencyStatuses returned error: %v", err)
+	}
+	if len(statuses) != 3 {
+		t.Fatalf("expected duplicate paths to be merged into three statuses, got %+v", statuses)
+	}
+
+	byPath := map[string]DependencyStatus{}
+	for _, status := range statuses {
+		byPath[status.LocalPath] = status
+	}
+	if got := byPath[valid]; got.State != DependencyStateValid || len(got.Targets) != 2 || got.SizeBytes != 4 {
+		t.Fatalf("expected valid dependency used by two targets, got %+v", got)
+	}
+	if got := byPath[invalid]; got.State != DependencyStateInvalid {
+		t.Fatalf("expected invalid dependency, got %+v", got)
+	}
+	if got := byPath[missing]; got.State != DependencyStateMissing || !got.SourceResolvedAtFetch {
+		t.Fatalf("expected missing dependency resolved at fetch time, got %+v", got)
+	}
+
+	statuses, err = DependencyStatuses([]VirtualMachineConfig{dependencyTestTarget}, SkipChecksumVerification)
+	if err != nil {
+		t.Fatalf("DependencyStatuses returned error: %v", err)
+	}
+	for _, status := range statuses {
+		if status.State == DependencyStateInvalid || status.State == DependencyStateValid {
+			t.Fatalf("expected checksums to be skipped without verification, got %+v", status)
+		}
+	}
+}
+
+func TestFetchDependenciesDownloadsOnlyMissingOrInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "valid.iso")
+	invalid := filepath.Join(dir, "invalid.iso")
+	missing := filepath.Join(dir, "missing.iso")
+	writeDependencyFile(t, valid, "good")
+	writeDependencyFile(t, invalid, "tampered")
+
+	downloads := stubDependencyCatalog(t, []WebFileDependency{
+		{LocalPath: valid, Checksum: sha256Checksum("good"), RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+		{LocalPath: invalid, Checksum: sha256Checksum("good"), RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+		{LocalPath: missing, RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+	})
+
+	if err := FetchDependencies([]VirtualMachineConfig{dependencyTestTarget}); err != nil {
+		t.Fatalf("FetchDependencies returned error: %v", err)
+	}
+	if got := strings.Join(*downloads, ","); got != invalid+","+missing {
+		t.Fatalf("expected invalid and missing dependencies to be downloaded, got %v", *downloads)
+	}
+}
+
+func TestFetchDependenciesContinuesAfterFailure(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "a.iso")
+	second := filepath.Join(dir, "b.iso")
+	stubDependencyCatalog(t, []WebFileDependency{
+		{LocalPath: first, RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+		{LocalPath: second, RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+	})
+	var attempts int
+	downloadWebFileDependencyFunc = func(p *mpb.Progress, dep WebFileDependency) error {
+		attempts++
+		return errors.New("mirror unreachable")
+	}
+
+	err := FetchDependencies([]VirtualMachineConfig{dependencyTestTarget})
+	if err == nil || !strings.Contains(err.Error(), first) || !strings.Contains(err.Error(), second) {
+		t.Fatalf("expected both failures to be reported, got %v", err)
+	}
+	if attempts != 2 {
+		t.Fatalf("expected both downloads to be attempted, got %d", attempts)
+	}
+}
+
+func TestDependencyReconciliationReturnsDownloadError(t *testing.T) {
+	dir := t.TempDir()
+	stubDependencyCatalog(t, []WebFileDependency{
+		{LocalPath: filepath.Join(dir, "a.iso"), RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+		{LocalPath: filepath.Join(dir, "b.iso"), RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+	})
+	stubDependencyDownloadWorkers(t, 1)
+	var attempts int
+	downloadWebFileDependencyFunc = func(p *mpb.Progress, dep WebFileDependency) error {
+		attempts++
+		return errors.New("mirror unreachable")
+	}
+
+	err := DependencyReconciliation(dependencyTestTarget)
+	if err == nil || !strings.Contains(err.Error(), "mirror unreachable") {
+		t.Fatalf("expected download error, got %v", err)
+	}
+	if attempts != 1 {
+		t.Fatalf("expected reconciliation to stop at the first failure, got %d attempts", attempts)
+	}
+}
+
+func TestPruneDependenciesRemovesOnlyUnreferencedDownloads(t *testing.T) {
+	withPlanCacheDir(t)
+	dirs := GetDirectoriesInstance()
+	referenced := dirs.CachePath("windows11", "iso", "win11_25h2_english_amd64.iso")
+	derived := dirs.CachePath("windows11", "iso", "Win11_ARM64_Unattended.iso")
+	stale := dirs.CachePath("windows11", "iso", "win11_24h2_english_amd64.iso")
+	unrelated := dirs.CachePath("windows11", "iso", "notes.txt")
+	for _, path := range []string{referenced, derived, stale, unrelated} {
+		writeDependencyFile(t, path, "content")
+	}
+	stubDependencyCatalog(t, []WebFileDependency{{LocalPath: referenced}})
+
+	pruned, err := PruneDependencies(true)
+	if err != nil {
+		t.Fatalf("PruneDependencies returned error: %v", err)
+	}
+	if len(pruned) != 1 || pruned[0].Path != stale || pruned[0].SizeBytes != int64(len("content")) {
+		t.Fatalf("expected only the stale ISO to be pruned, got %+v", pruned)
+	}
+	if _, err := os.Stat(stale); err != nil {
+		t.Fatalf("expected dry run to keep the stale ISO: %v", err)
+	}
+
+	if _, err := PruneDependencies(false); err != nil {
+		t.Fatalf("PruneDependencies returned error: %v", err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Fatalf("expected stale ISO to be removed, got %v", err)
+	}
+	for _, path := range []string{referenced, derived, unrelated} {
+		if _, err := os.Stat(path); err != nil {
+			t.Fatalf("expected %s to be kept: %v", path, err)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package build
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	dependencyMirrorsConfigEnvVar = "DEV_ALCHEMY_DEPENDENCY_MIRRORS_CONFIG"
+	dependencyMirrorsConfigFile   = "dependency-mirrors.yml"
+)
+
+// DependencyMirrors redirects web file dependency downloads to internal
+// mirrors. Overrides replace every source of one dependency and skip any
+// fetch-time lookup; rewrites replace URL prefixes of all sources.
+type DependencyMirrors struct {
+	Rewrites []DependencyMirrorRewrite `json:"rewrites" yaml:"rewrites"`
+	// Overrides maps a dependency path relative to the cache dir, such as
+	// windows/virtio-win.iso, to the URL to download it from.
+	Overrides map[string]string `json:"overrides" yaml:"overrides"`
+	// CABundle is a PEM file with extra certificate authorities to trust for
+	// dependency downloads, for example a TLS-intercepting proxy.
+	CABundle string `json:"ca_bundle" yaml:"ca_bundle"`
+}
+
+// DependencyMirrorRewrite replaces Prefix with Replacement in source URLs.
+type DependencyMirrorRewrite struct {
+	Prefix      string `json:"prefix" yaml:"prefix"`
+	Replacement string `json:"replacement" yaml:"replacement"`
+}
+
+var loadDependencyMirrorsFunc = LoadDependencyMirrors
+
+// DependencyMirrorsConfigPath returns the mirror config location, honoring the
+// DEV_ALCHEMY_DEPENDENCY_MIRRORS_CONFIG override.
+func DependencyMirrorsConfigPath(directories *Directories) string {
+	if override := strings.TrimSpace(os.Getenv(dependencyMirrorsConfigEnvVar)); override != "" {
+		return filepath.Clean(override)
+	}
+
+	return directories.ConfigPath(dependencyMirrorsConfigFile)
+}
+
+// LoadDependencyMirrors reads the mirror config from the managed config
+// directory. A missing config is not an error; exists reports whether a file
+// was found.
+func LoadDependencyMirrors() (DependencyMirrors, string, bool, error) {
+	configPath := DependencyMirrorsConfigPath(GetDirectoriesInstance())
+	mirrors, exists, err := loadDependencyMirrors(configPath)
+	return mirrors, configPath, exists, err
+}
+
+func loadDependencyMirrors(configPath string) (DependencyMirrors, bool, error) {
+	content, err := os.ReadFile(configPath) // #nosec G304 -- configPath is the documented user-selected mirror config file.
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return DependencyMirrors{}, false, nil
+		}
+		return DependencyMirrors{}, false, fmt.Errorf("read dependency mirrors %q: %w", configPath, err)
+	}
+
+	mirrors := DependencyMirrors{}
+	if strings.TrimSpace(string(content)) == "" {
+		return mirrors, true, nil
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&mirrors); err != nil && !errors.Is(err, io.EOF) {
+		return DependencyMirrors{}, true, fmt.Errorf("parse dependency mirrors %q: %w", configPath, err)
+	}
+
+	for i, rewrite := range mirrors.Rewrites {
+		if strings.TrimSpace(rewrite.Prefix) == "" || !isDependencyMirrorURL(rewrite.Replacement) {
+			return DependencyMirrors{}, true, fmt.Errorf("%q rewrite %d needs a prefix and an http(s) replacement URL", configPath, i)
+		}
+	}
+	overrides := make(map[string]string, len(mirrors.Overrides))
+	for file, source := range mirrors.Overrides {
+		cleaned := path.Clean(filepath.ToSlash(strings.TrimSpace(file)))
+		if cleaned == "." || path.IsAbs(cleaned) || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+			return DependencyMirrors{}, true, fmt.Errorf("%q override %q must be a path relative to the cache dir", configPath, file)
+		}
+		if !isDependencyMirrorURL(source) {
+			return DependencyMirrors{}, true, fmt.Errorf("%q override for %q must be an http(s) URL", configPath, file)
+		}
+		overrides[cleaned] = source
+	}
+	mirrors.Overrides = overrides
+
+	if caBundle := strings.TrimSpace(mirrors.CABundle); caBundle != "" {
+		if !filepath.IsAbs(caBundle) {
+			caBundle = filepath.Join(filepath.Dir(configPath), caBundle)
+		}
+		mirrors.CABundle = filepath.Clean(caBundle)
+	}
+
+	return mirrors, true, nil
+}
+
+func isDependencyMirrorURL(value string) bool {
+	parsed, err := url.Parse(strings.TrimSpace(value))
+	return err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") && parsed.Host != ""
+}
+
+// Rewrite applies the longest matching prefix rewrite to source.
+func (mirrors DependencyMirrors) Rewrite(source string) string {
+	best := -1
+	for i, rewrite := range mirrors.Rewrites {
+		if strings.HasPrefix(source, rewrite.Prefix) && (best < 0 || len(rewrite.Prefix) > len(mirrors.Rewrites[best].Prefix)) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return source
+	}
+	return mirrors.Rewrites[best].Replacement + strings.TrimPrefix(source, mirrors.Rewrites[best].Prefix)
+}
+
+// Override returns the override URL configured for the dependency stored at
+// localPath.
+func (mirrors DependencyMirrors) Override(localPath string) (string, bool) {
+	relativePath, err := filepath.Rel(GetDirectoriesInstance().CacheDir, localPath)
+	if err != nil {
+		return "", false
+	}
+	source, ok := mirrors.Overrides[filepath.ToSlash(relativePath)]
+	return source, ok
+}
+
+// HTTPClient returns the client used for dependency downloads and lookups. It
+// honors HTTP_PROXY, HTTPS_PROXY, and NO_PROXY, trusts CABundle in addition
+// to the system certificate pool, and sends basic auth for hosts listed in
+// the netrc file of DependencyNetrcPath.
+func (mirrors DependencyMirrors) HTTPClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+
+	if mirrors.CABundle != "" {
+		pem, err := os.ReadFile(mirrors.CABundle) // #nosec G304 -- CABundle is the user-configured certificate file.
+		if err != nil {
+			return nil, fmt.Errorf("read dependency CA bundle: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("dependency CA bundle %s contains no PEM certificates", mirrors.CABundle)
+		}
+		transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: pool}
+	}
+
+	netrc, err := loadDependencyNetrc()
+	if err != nil {
+		return nil, err
+	}
+	if len(netrc.Machines) == 0 && netrc.Default == nil {
+		return &http.Client{Transport: transport}, nil
+	}
+	return &http.Client{Transport: netrcTransport{base: transport, netrc: netrc}}, nil
+}
+
+// dependencySources returns the download candidates of dep after applying
+// mirrors, and whether an override replaced them. source is the primary URL,
+// which for dependencies with a BeforeHook is only known after the hook ran.
+func (mirrors DependencyMirrors) dependencySources(dep WebFileDependency, source string) ([]string, bool) {
+	if override, ok := mirrors.Override(dep.LocalPath); ok {
+		return []string{override}, true
+	}
+	fallbacks := make([]string, 0, len(dep.FallbackSources))
+	for _, fallback := range dep.FallbackSources {
+		fallbacks = append(fallbacks, mirrors.Rewrite(fallback))
+	}
+	return downloadSources(mirrors.Rewrite(source), fallbacks), false
+}
@@ -0,0 +1,174 @@
+package build
+
+import (
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadDependencyMirrorsNormalizesOverridesAndCABundle(t *testing.T) {
+	configDir := t.TempDir()
+	configPath := filepath.Join(configDir, dependencyMirrorsConfigFile)
+	content := `rewrites:
+  - prefix: https://releases.ubuntu.com/
+    replacement: https://mirror.corp.example/ubuntu-releases/
+overrides:
+  ./windows/virtio-win.iso: https://artifacts.corp.example/virtio-win.iso
+ca_bundle: certs/corp-ca.pem
+`
+	if err := os.WriteFile(configPath, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write mirror config: %v", err)
+	}
+
+	mirrors, exists, err := loadDependencyMirrors(configPath)
+	if err != nil || !exists {
+		t.Fatalf("loadDependencyMirrors returned exists=%v err=%v", exists, err)
+	}
+	if got := mirrors.Overrides["windows/virtio-win.iso"]; got != "https://artifacts.corp.example/virtio-win.iso" {
+		t.Fatalf("expected normalized override key, got %+v", mirrors.Overrides)
+	}
+	if want := filepath.Join(configDir, "certs", "corp-ca.pem"); mirrors.CABundle != want {
+		t.Fatalf("expected CA bundle %s, got %s", want, mirrors.CABundle)
+	}
+}
+
+func TestLoadDependencyMirrorsRejectsInvalidEntries(t *testing.T) {
+	for name, content := range map[string]string{
+		"empty prefix":      "rewrites:\n  - prefix: ''\n    replacement: https://mirror.example/\n",
+		"relative mirror":   "rewrites:\n  - prefix: https://a/\n    replacement: mirror/\n",
+		"escaping override": "overrides:\n  ../outside.iso: https://mirror.example/x.iso\n",
+		"unknown field":     "mirrors: []\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), dependencyMirrorsConfigFile)
+			if err := os.WriteFile(configPath, []byte(content), 0o600); err != nil {
+				t.Fatalf("failed to write mirror config: %v", err)
+			}
+			if _, _, err := loadDependencyMirrors(configPath); err == nil {
+				t.Fatal("expected invalid mirror config to be rejected")
+			}
+		})
+	}
+}
+
+func TestDependencyMirrorsRewriteUsesLongestPrefix(t *testing.T) {
+	mirrors := DependencyMirrors{Rewrites: []DependencyMirrorRewrite{
+		{Prefix: "https://cdimage.ubuntu.com/", Replacement: "https://mirror.example/cdimage/"},
+		{Prefix: "https://cdimage.ubuntu.com/releases/", Replacement: "https://releases.mirror.example/"},
+	}}
+
+	if got := mirrors.Rewrite("https://cdimage.ubuntu.com/releases/24.04/ubuntu.iso"); got != "https://releases.mirror.example/24.04/ubuntu.iso" {
+		t.Fatalf("unexpected rewrite: %s", got)
+	}
+	if got := mirrors.Rewrite("https://example.com/file.iso"); got != "https://example.com/file.iso" {
+		t.Fatalf("expected unmatched URL to stay unchanged, got %s", got)
+	}
+}
+
+func TestDownloadWebFileDependencyUsesOverrideWithoutRunningHook(t *testing.T) {
+	withPlanCacheDir(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("mirrored firmware"))
+	}))
+	defer server.Close()
+	stubDependencyMirrors(t, DependencyMirrors{Overrides: map[string]string{
+		"qemu-efi-aarch64_all.deb": server.URL + "/qemu-efi-aarch64_all.deb",
+	}})
+
+	dep := WebFileDependency{
+		LocalPath: GetDirectoriesInstance().CachePath("qemu-efi-aarch64_all.deb"),
+		BeforeHook: func() (string, error) {
+			return "", errors.New("deb.debian.org is blocked")
+		},
+	}
+	if err := downloadWebFileDependency(nil, dep); err != nil {
+		t.Fatalf("downloadWebFileDependency returned error: %v", err)
+	}
+	if content, err := os.ReadFile(dep.LocalPath); err != nil || string(content) != "mirrored firmware" {
+		t.Fatalf("expected override download, got %q err=%v", content, err)
+	}
+}
+
+func TestDownloadWebFileDependencyRewritesSourceAndTrustsCABundle(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ubuntu/24.04/ubuntu.iso" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("mirrored iso"))
+	}))
+	defer server.Close()
+
+	caBundle := filepath.Join(t.TempDir(), "corp-ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(caBundle, certificate, 0o600); err != nil {
+		t.Fatalf("failed to write CA bundle: %v", err)
+	}
+	stubDependencyMirrors(t, DependencyMirrors{
+		Rewrites: []DependencyMirrorRewrite{{Prefix: "https://releases.ubuntu.com/", Replacement: server.URL + "/ubuntu/"}},
+		CABundle: caBundle,
+	})
+
+	dep := WebFileDependency{
+		LocalPath: filepath.Join(t.TempDir(), "ubuntu.iso"),
+		Source:    "https://releases.ubuntu.com/24.04/ubuntu.iso",
+	}
+	if err := downloadWebFileDependency(nil, dep); err != nil {
+		t.Fatalf("downloadWebFileDependency returned error: %v", err)
+	}
+	if content, err := os.ReadFile(dep.LocalPath); err != nil || string(content) != "mirrored iso" {
+		t.Fatalf("expected mirrored download, got %q err=%v", content, err)
+	}
+}
+
+func TestDependencyMirrorsHTTPClientRejectsBundleWithoutCertificates(t *testing.T) {
+	caBundle := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(caBundle, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatalf("failed to write CA bundle: %v", err)
+	}
+
+	_, err := DependencyMirrors{CABundle: caBundle}.HTTPClient()
+	if err == nil || !strings.Contains(err.Error(), "contains no PEM certificates") {
+		t.Fatalf("expected CA bundle error, got %v", err)
+	}
+}
+
+func TestDependencyStatusesShowEffectiveMirrorSources(t *testing.T) {
+	withPlanCacheDir(t)
+	dirs := GetDirectoriesInstance()
+	iso := dirs.CachePath("linux", "ubuntu.iso")
+	windows := dirs.CachePath("windows11", "iso", "win11.iso")
+	unchanged := dirs.CachePath("utm", "tools.iso")
+	stubDependencyCatalog(t, []WebFileDependency{
+		{LocalPath: iso, Source: "https://releases.ubuntu.com/ubuntu.iso", RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+		{LocalPath: windows, BeforeHook: func() (string, error) { return "", nil }, RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+		{LocalPath: unchanged, Source: "https://getutm.app/tools.iso", RelatedVmConfigs: []VirtualMachineConfig{dependencyTestTarget}},
+	})
+	stubDependencyMirrors(t, DependencyMirrors{
+		Rewrites:  []DependencyMirrorRewrite{{Prefix: "https://releases.ubuntu.com/", Replacement: "https://mirror.example/ubuntu/"}},
+		Overrides: map[string]string{"windows11/iso/win11.iso": "https://artifacts.example/win11.iso"},
+	})
+
+	statuses, err := DependencyStatuses([]VirtualMachineConfig{dependencyTestTarget}, SkipChecksumVerification)
+	if err != nil {
+		t.Fatalf("DependencyStatuses returned error: %v", err)
+	}
+	byPath := map[string]DependencyStatus{}
+	for _, status := range statuses {
+		byPath[status.LocalPath] = status
+	}
+	if got := byPath[iso]; len(got.EffectiveSources) != 1 || got.EffectiveSources[0] != "https://mirror.example/ubuntu/ubuntu.iso" || got.SourceOverridden {
+		t.Fatalf("expected rewritten ISO source, got %+v", got)
+	}
+	if got := byPath[windows]; !got.SourceOverridden || got.SourceResolvedAtFetch || got.EffectiveSources[0] != "https://artifacts.example/win11.iso" {
+		t.Fatalf("expected overridden Windows ISO, got %+v", got)
+	}
+	if got := byPath[unchanged]; got.EffectiveSources != nil {
+		t.Fatalf("expected no effective sources without a matching mirror, got %+v", got)
+	}
+}
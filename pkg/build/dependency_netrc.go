@@ -0,0 +1,156 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+const dependencyNetrcEnvVar = "NETRC"
+
+// netrcCredentials is the login of one machine in a netrc file.
+type netrcCredentials struct {
+	Login    string
+	Password string
+}
+
+// dependencyNetrc holds the logins of a netrc file by host. Default applies to
+// hosts without a machine entry.
+type dependencyNetrc struct {
+	Machines map[string]netrcCredentials
+	Default  *netrcCredentials
+}
+
+// DependencyNetrcPath returns the netrc file used for dependency downloads,
+// honoring the NETRC override like curl does.
+func DependencyNetrcPath() (string, error) {
+	if override := strings.TrimSpace(os.Getenv(dependencyNetrcEnvVar)); override != "" {
+		return filepath.Clean(override), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	name := ".netrc"
+	if runtime.GOOS == "windows" {
+		name = "_netrc"
+	}
+	return filepath.Join(home, name), nil
+}
+
+// loadDependencyNetrc reads the netrc file of DependencyNetrcPath. A missing
+// file or home directory yields no logins.
+func loadDependencyNetrc() (dependencyNetrc, error) {
+	netrcPath, err := DependencyNetrcPath()
+	if err != nil {
+		return dependencyNetrc{}, nil
+	}
+	content, err := os.ReadFile(netrcPath) // #nosec G304 -- netrcPath is the user's netrc file or the NETRC override.
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return dependencyNetrc{}, nil
+		}
+		return dependencyNetrc{}, fmt.Errorf("read netrc %s: %w", netrcPath, err)
+	}
+	return parseDependencyNetrc(string(content)), nil
+}
+
+// parseDependencyNetrc reads the machine, default, login and password tokens
+// of a netrc file. Macro definitions run until the next blank line and are
+// skipped.
+func parseDependencyNetrc(content string) dependencyNetrc {
+	netrc := dependencyNetrc{Machines: map[string]netrcCredentials{}}
+	var current *netrcCredentials
+	var host string
+	commit := func() {
+		if current == nil {
+			return
+		}
+		if host == "" {
+			netrc.Default = current
+		} else if _, ok := netrc.Machines[host]; !ok {
+			netrc.Machines[host] = *current
+		}
+		current = nil
+	}
+
+	inMacro := false
+	for _, line := range strings.Split(content, "\n") {
+		if inMacro {
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		tokens := strings.Fields(line)
+		for i := 0; i < len(tokens); i++ {
+			next := func() string {
+				if i+1 < len(tokens) {
+					i++
+					return tokens[i]
+				}
+				return ""
+			}
+			switch tokens[i] {
+			case "machine":
+				commit()
+				host = strings.ToLower(next())
+				current = &netrcCredentials{}
+			case "default":
+				commit()
+				host = ""
+				current = &netrcCredentials{}
+			case "login":
+				if login := next(); current != nil {
+					current.Login = login
+				}
+			case "password":
+				if password := next(); current != nil {
+					current.Password = password
+				}
+			case "account":
+				next()
+			case "macdef":
+				commit()
+				inMacro = true
+				i = len(tokens)
+			}
+		}
+	}
+	commit()
+	return netrc
+}
+
+// lookup returns the login for host, falling back to the default entry.
+func (netrc dependencyNetrc) lookup(host string) (netrcCredentials, bool) {
+	if credentials, ok := netrc.Machines[strings.ToLower(host)]; ok {
+		return credentials, true
+	}
+	if netrc.Default != nil {
+		return *netrc.Default, true
+	}
+	return netrcCredentials{}, false
+}
+
+// netrcTransport adds basic auth from a netrc file to each request without
+// credentials, by the host of that request, so a redirect to another host
+// never carries the login of the first one.
+type netrcTransport struct {
+	base  http.RoundTripper
+	netrc dependencyNetrc
+}
+
+func (t netrcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") == "" {
+		if credentials, ok := t.netrc.lookup(req.URL.Hostname()); ok {
+			req = req.Clone(req.Context())
+			req.SetBasicAuth(credentials.Login, credentials.Password)
+		}
+	}
+	return t.base.RoundTrip(req)
+}
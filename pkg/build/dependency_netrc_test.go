@@ -0,0 +1,75 @@
+package build
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDependencyNetrcReadsMachinesAndDefault(t *testing.T) {
+	netrc := parseDependencyNetrc(`# internal mirrors
+machine Mirror.Example.com login alice password s3cret
+machine other.example.com
+  login bob
+  password hunter2
+macdef init
+machine ignored.example.com login mallory password x
+
+default login anonymous password guest
+`)
+
+	if credentials, ok := netrc.lookup("mirror.example.com"); !ok || credentials != (netrcCredentials{Login: "alice", Password: "s3cret"}) {
+		t.Fatalf("expected the mirror login, got %+v ok=%t", credentials, ok)
+	}
+	if credentials, ok := netrc.lookup("other.example.com"); !ok || credentials.Login != "bob" || credentials.Password != "hunter2" {
+		t.Fatalf("expected the multi-line login, got %+v ok=%t", credentials, ok)
+	}
+	if _, ok := netrc.Machines["ignored.example.com"]; ok {
+		t.Fatal("expected the macro body to be skipped")
+	}
+	if credentials, ok := netrc.lookup("unknown.example.com"); !ok || credentials.Login != "anonymous" {
+		t.Fatalf("expected the default login, got %+v ok=%t", credentials, ok)
+	}
+}
+
+func TestDownloadWebFileDependencyUsesNetrcLoginForMirror(t *testing.T) {
+	withPlanCacheDir(t)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if login, password, ok := r.BasicAuth(); !ok || login != "alice" || password != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte("mirrored iso"))
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatalf("failed to parse server URL: %v", err)
+	}
+	stubDependencyMirrors(t, DependencyMirrors{Overrides: map[string]string{
+		"linux/ubuntu.iso": server.URL + "/ubuntu.iso",
+	}})
+	dep := WebFileDependency{LocalPath: GetDirectoriesInstance().CachePath("linux", "ubuntu.iso")}
+
+	t.Setenv(dependencyNetrcEnvVar, filepath.Join(t.TempDir(), "missing"))
+	err = downloadWebFileDependency(nil, dep)
+	if err == nil || !strings.Contains(err.Error(), "add a login for "+serverURL.Hostname()) {
+		t.Fatalf("expected a 401 to point at the netrc file, got %v", err)
+	}
+
+	netrc := filepath.Join(t.TempDir(), "netrc")
+	if err := os.WriteFile(netrc, []byte("machine "+serverURL.Hostname()+" login alice password s3cret\n"), 0o600); err != nil {
+		t.Fatalf("failed to write netrc: %v", err)
+	}
+	t.Setenv(dependencyNetrcEnvVar, netrc)
+	if err := downloadWebFileDependency(nil, dep); err != nil {
+		t.Fatalf("downloadWebFileDependency returned error: %v", err)
+	}
+	if content, err := os.ReadFile(dep.LocalPath); err != nil || string(content) != "mirrored iso" {
+		t.Fatalf("expected authenticated mirror download, got %q err=%v", content, err)
+	}
+}
@@ -0,0 +1,158 @@
+package build
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const dependencyVerificationCacheFile = "dependency-verification.json"
+
+// ChecksumVerification selects how DependencyStatuses checks present files.
+type ChecksumVerification int
+
+const (
+	// SkipChecksumVerification only reports whether files are present.
+	SkipChecksumVerification ChecksumVerification = iota
+	// CachedChecksumVerification trusts an earlier successful verification
+	// while the file's size, modification time and inode are unchanged.
+	CachedChecksumVerification
+	// FullChecksumVerification re-hashes every present file.
+	FullChecksumVerification
+)
+
+// dependencyVerification remembers that a file matched checksum while it had
+// this size, modification time and inode.
+type dependencyVerification struct {
+	Checksum  string    `json:"checksum"`
+	SizeBytes int64     `json:"size_bytes"`
+	ModTime   time.Time `json:"mod_time"`
+	Inode     uint64    `json:"inode"`
+}
+
+// dependencyVerificationMu serializes read-modify-write cycles on the
+// verification cache, because dependencies are verified by parallel workers.
+var dependencyVerificationMu sync.Mutex
+
+func dependencyVerificationCachePath() string {
+	return GetDirectoriesInstance().CachePath(dependencyVerificationCacheFile)
+}
+
+// loadDependencyVerifications reads the verification cache. An unreadable
+// cache is treated as empty, so every file is hashed again.
+func loadDependencyVerifications() map[string]dependencyVerification {
+	verifications := map[string]dependencyVerification{}
+	content, err := os.ReadFile(dependencyVerificationCachePath())
+	if err != nil {
+		return verifications
+	}
+	if err := json.Unmarshal(content, &verifications); err != nil {
+		log.Printf("Ignoring unreadable dependency verification cache: %v", err)
+		return map[string]dependencyVerification{}
+	}
+	return verifications
+}
+
+func writeDependencyVerifications(verifications map[string]dependencyVerification) error {
+	cachePath := dependencyVerificationCachePath()
+	content, err := json.MarshalIndent(verifications, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
+		return err
+	}
+	tempPath := cachePath + ".tmp"
+	if err := os.WriteFile(tempPath, content, 0o644); err != nil { // #nosec G306 -- the cache holds public checksums of world-readable downloads.
+		return err
+	}
+	return os.Rename(tempPath, cachePath)
+}
+
+// currentDependencyVerification describes path as it is now, for comparison
+// with or storage in the verification cache.
+func currentDependencyVerification(path string, checksum string) (dependencyVerification, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return dependencyVerification{}, err
+	}
+	inode, err := fileInode(path, info)
+	if err != nil {
+		return dependencyVerification{}, err
+	}
+	return dependencyVerification{Checksum: checksum, SizeBytes: info.Size(), ModTime: info.ModTime().UTC(), Inode: inode}, nil
+}
+
+// verifyDependencyChecksumCached checks path against checksum like
+// verifyDependencyChecksum, but skips hashing when the verification cache
+// shows the unchanged file matched the same checksum before. With full, the
+// file is always hashed. Successes are cached; failures clear the entry.
+// Only files in the cache dir are cached, keyed by their relative path.
+func verifyDependencyChecksumCached(path string, checksum string, full bool) error {
+	key, ok := dependencyLockKey(path)
+	if checksum == "" || !ok {
+		return verifyDependencyChecksum(path, checksum)
+	}
+	current, err := currentDependencyVerification(path, checksum)
+	if err != nil {
+		return err
+	}
+
+	if !full {
+		dependencyVerificationMu.Lock()
+		cached, ok := loadDependencyVerifications()[key]
+		dependencyVerificationMu.Unlock()
+		if ok && cached.Checksum == current.Checksum && cached.SizeBytes == current.SizeBytes &&
+			cached.ModTime.Equal(current.ModTime) && cached.Inode == current.Inode {
+			return nil
+		}
+	}
+
+	verifyErr := verifyDependencyChecksum(path, checksum)
+	if verifyErr == nil {
+		rememberDependencyVerification(key, current)
+	} else {
+		forgetDependencyVerification(key)
+	}
+	return verifyErr
+}
+
+// recordDependencyVerification caches that path, which was just hashed,
+// matches checksum.
+func recordDependencyVerification(path string, checksum string) {
+	key, ok := dependencyLockKey(path)
+	if checksum == "" || !ok {
+		return
+	}
+	current, err := currentDependencyVerification(path, checksum)
+	if err != nil {
+		return
+	}
+	rememberDependencyVerification(key, current)
+}
+
+func rememberDependencyVerification(key string, verification dependencyVerification) {
+	dependencyVerificationMu.Lock()
+	defer dependencyVerificationMu.Unlock()
+	verifications := loadDependencyVerifications()
+	verifications[key] = verification
+	if err := writeDependencyVerifications(verifications); err != nil {
+		log.Printf("Failed to update dependency verification cache: %v", err)
+	}
+}
+
+func forgetDependencyVerification(key string) {
+	dependencyVerificationMu.Lock()
+	defer dependencyVerificationMu.Unlock()
+	verifications := loadDependencyVerifications()
+	if _, ok := verifications[key]; !ok {
+		return
+	}
+	delete(verifications, key)
+	if err := writeDependencyVerifications(verifications); err != nil {
+		log.Printf("Failed to update dependency verification cache: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// rewriteKeepingStat overwrites path in place with same-length content and
+// restores its modification time, so only a re-hash can notice the change.
+func rewriteKeepingStat(t *testing.T, path string, content string) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("failed to stat %s: %v", path, err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("failed to open %s: %v", path, err)
+	}
+	if _, err := file.WriteString(content); err != nil {
+		t.Fatalf("failed to rewrite %s: %v", path, err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("failed to close %s: %v", path, err)
+	}
+	if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatalf("failed to restore mtime of %s: %v", path, err)
+	}
+}
+
+func TestVerifyDependencyChecksumCachedSkipsUnchangedFiles(t *testing.T) {
+	withPlanCacheDir(t)
+	iso := GetDirectoriesInstance().CachePath("linux", "ubuntu.iso")
+	writeDependencyFile(t, iso, "original")
+	checksum := sha256Checksum("original")
+
+	if err := verifyDependencyChecksumCached(iso, checksum, false); err != nil {
+		t.Fatalf("expected first verification to hash and match, got %v", err)
+	}
+	rewriteKeepingStat(t, iso, "tampered")
+
+	if err := verifyDependencyChecksumCached(iso, checksum, false); err != nil {
+		t.Fatalf("expected the cached verification to be trusted, got %v", err)
+	}
+	if err := verifyDependencyChecksumCached(iso, checksum, true); !isChecksumMismatch(err) {
+		t.Fatalf("expected a full verification to re-hash, got %v", err)
+	}
+	if _, ok := loadDependencyVerifications()["linux/ubuntu.iso"]; ok {
+		t.Fatal("expected the failed verification to clear the cache entry")
+	}
+}
+
+func TestVerifyDependencyChecksumCachedRehashesChangedFiles(t *testing.T) {
+	withPlanCacheDir(t)
+	dirs := GetDirectoriesInstance()
+	iso := dirs.CachePath("linux", "ubuntu.iso")
+	checksum := sha256Checksum("original")
+
+	for name, change := range map[string]func(){
+		"modification time": func() {
+			rewriteKeepingStat(t, iso, "tampered")
+			later := time.Now().Add(time.Hour)
+			if err := os.Chtimes(iso, later, later); err != nil {
+				t.Fatalf("failed to touch %s: %v", iso, err)
+			}
+		},
+		"size": func() {
+			writeDependencyFile(t, iso, "original plus more")
+		},
+		"inode": func() {
+			info, err := os.Stat(iso)
+			if err != nil {
+				t.Fatalf("failed to stat %s: %v", iso, err)
+			}
+			replacement := dirs.CachePath("linux", "replacement.iso")
+			writeDependencyFile(t, replacement, "tampered")
+			if err := os.Chtimes(replacement, info.ModTime(), info.ModTime()); err != nil {
+				t.Fatalf("failed to set mtime of %s: %v", replacement, err)
+			}
+			if err := os.Rename(replacement, iso); err != nil {
+				t.Fatalf("failed to replace %s: %v", iso, err)
+			}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			writeDependencyFile(t, iso, "original")
+			if err := verifyDependencyChecksumCached(iso, checksum, false); err != nil {
+				t.Fatalf("expected first verification to match, got %v", err)
+			}
+			change()
+			if err := verifyDependencyChecksumCached(iso, checksum, false); err == nil {
+				t.Fatal("expected the changed file to be hashed again and fail")
+			}
+		})
+	}
+}
+
+func TestVerifyDependencyChecksumCachedIgnoresFilesOutsideTheCacheDir(t *testing.T) {
+	withPlanCacheDir(t)
+	outside := filepath.Join(t.TempDir(), "ubuntu.iso")
+	writeDependencyFile(t, outside, "original")
+
+	if err := verifyDependencyChecksumCached(outside, sha256Checksum("original"), false); err != nil {
+		t.Fatalf("expected the file outside the cache dir to be verified, got %v", err)
+	}
+	if verifications := loadDependencyVerifications(); len(verifications) != 0 {
+		t.Fatalf("expected no cache entry for a file outside the cache dir, got %+v", verifications)
+	}
+}
+
+func TestVerifyDependencyChecksumCachedRequiresTheSameChecksum(t *testing.T) {
+	withPlanCacheDir(t)
+	iso := GetDirectoriesInstance().CachePath("linux", "ubuntu.iso")
+	writeDependencyFile(t, iso, "original")
+	recordDependencyVerification(iso, sha256Checksum("original"))
+
+	if err := verifyDependencyChecksumCached(iso, sha256Checksum("new release"), false); !isChecksumMismatch(err) {
+		t.Fatalf("expected a bumped checksum to be verified from scratch, got %v", err)
+	}
+}
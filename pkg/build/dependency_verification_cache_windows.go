@@ -0,0 +1,26 @@
+//go:build windows
+
+package build
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// fileInode returns the NTFS file index of path, the Windows counterpart of
+// an inode, so a file replaced in place with the same size and modification
+// time is noticed.
+func fileInode(path string, info os.FileInfo) (uint64, error) {
+	file, err := os.Open(path) // #nosec G304 -- path is a managed dependency path in the cache dir.
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var fileInfo windows.ByHandleFileInformation
+	if err := windows.GetFileInformationByHandle(windows.Handle(file.Fd()), &fileInfo); err != nil {
+		return 0, err
+	}
+	return uint64(fileInfo.FileIndexHigh)<<32 | uint64(fileInfo.FileIndexLow), nil
+}
@@ -26,6 +26,16 @@ type Directories struct {
 	CacheDir       string
 	VagrantDir     string
 	PackerCacheDir string
+	// RecordingsDir holds the VNC recordings and defaults to CacheDir.
+	RecordingsDir string
+	// ImagesDir holds the VM disks created from build artifacts. It is empty
+	// unless configured, as the default depends on the deploy engine.
+	ImagesDir string
+	// ProjectOverlayDir holds files that are layered on top of the embedded
+	// project. It defaults to project-overlay in the config dir.
+	ProjectOverlayDir string
+
+	dataDirsResolved bool
 }
 
 var (
@@ -53,13 +63,6 @@ func (u *Directories) GetDirectories() Directories {
 		}
 		u.AppDataDir = appDataDir
 	}
-	if u.ProjectDir == "" {
-		projectDir, err := ensureProjectDir(u.WorkingDir, u.AppDataDir)
-		if err != nil {
-			log.Fatalf("Project dir could not be determined: %v", err)
-		}
-		u.ProjectDir = projectDir
-	}
 	if u.ConfigDir == "" {
 		configDir, err := resolveDefaultConfigDir()
 		if err != nil {
@@ -67,6 +70,33 @@ func (u *Directories) GetDirectories() Directories {
 		}
 		u.ConfigDir = configDir
 	}
+	resolveDataDirs := !u.dataDirsResolved
+	var overrides DataDirs
+	if resolveDataDirs {
+		var err error
+		overrides, err = loadDataDirOverrides(filepath.Join(u.ConfigDir, dataDirsConfigFile), os.Getenv)
+		if err != nil {
+			log.Fatalf("Data directories could not be determined: %v", err)
+		}
+		for _, spec := range dataDirSpecs {
+			if field := spec.field(u); *field == "" {
+				*field = *spec.config(&overrides)
+			}
+		}
+	}
+	if u.ProjectOverlayDir == "" {
+		u.ProjectOverlayDir = overrides.ProjectOverlayDir
+		if u.ProjectOverlayDir == "" {
+			u.ProjectOverlayDir = filepath.Join(u.ConfigDir, projectOverlayDirName)
+		}
+	}
+	if u.ProjectDir == "" {
+		projectDir, err := resolveProjectDir(u.WorkingDir, u.AppDataDir, overrides.ProjectDir, u.ProjectOverlayDir)
+		if err != nil {
+			log.Fatalf("Project dir could not be determined: %v", err)
+		}
+		u.ProjectDir = projectDir
+	}
 	if u.CacheDir == "" {
 		u.CacheDir = filepath.Join(u.AppDataDir, "cache")
 	}
@@ -76,9 +106,21 @@ func (u *Directories) GetDirectories() Directories {
 	if u.PackerCacheDir == "" {
 		u.PackerCacheDir = filepath.Join(u.AppDataDir, "packer_cache")
 	}
-	if err := ensureDirectoriesExist(u.AppDataDir, u.ConfigDir, u.CacheDir, u.VagrantDir, u.PackerCacheDir); err != nil {
+	if u.RecordingsDir == "" {
+		u.RecordingsDir = u.CacheDir
+	}
+	if err := ensureDirectoriesExist(u.AppDataDir, u.ConfigDir, u.CacheDir, u.VagrantDir, u.PackerCacheDir, u.RecordingsDir); err != nil {
 		log.Fatalf("Managed application directories could not be created: %v", err)
 	}
+	if resolveDataDirs {
+		if err := validateDataDirs(*u); err != nil {
+			log.Fatalf("Data directories are invalid: %v", err)
+		}
+		if err := ensureDataDirsWritable(overrides); err != nil {
+			log.Fatalf("Data directories are invalid: %v", err)
+		}
+		u.dataDirsResolved = true
+	}
 	return *u
 }
 
@@ -114,16 +156,26 @@ func (u *Directories) PackerCachePath(paths ...string) string {
 	return filepath.Join(append([]string{u.PackerCacheDir}, paths...)...)
 }
 
+func (u *Directories) RecordingsPath(paths ...string) string {
+	u.GetDirectories()
+	return filepath.Join(append([]string{u.RecordingsDir}, paths...)...)
+}
+
 func (u *Directories) ManagedEnv() []string {
 	u.GetDirectories()
-	return []string{
+	env := []string{
 		devAlchemyAppDataEnvVar + "=" + u.AppDataDir,
 		devAlchemyConfigEnvVar + "=" + u.ConfigDir,
 		devAlchemyCacheEnvVar + "=" + u.CacheDir,
 		devAlchemyVagrantEnvVar + "=" + u.VagrantDir,
 		devAlchemyPackerCacheEnvVar + "=" + u.PackerCacheDir,
+		devAlchemyRecordingsEnvVar + "=" + u.RecordingsDir,
 		"PACKER_CACHE_DIR=" + u.PackerCacheDir,
 	}
+	if u.ImagesDir != "" {
+		env = append(env, devAlchemyImagesEnvVar+"="+u.ImagesDir)
+	}
+	return env
 }
 
 func resolveDefaultAppDataDir() (string, error) {
@@ -0,0 +1,15 @@
+//go:build unix
+
+package build
+
+import "golang.org/x/sys/unix"
+
+// freeDiskBytes returns the bytes available to unprivileged users on the
+// filesystem that holds path.
+func freeDiskBytes(path string) (uint64, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(path, &stat); err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil // #nosec G115 -- block size is always positive.
+}
@@ -0,0 +1,19 @@
+//go:build windows
+
+package build
+
+import "golang.org/x/sys/windows"
+
+// freeDiskBytes returns the bytes available to the current user on the
+// volume that holds path.
+func freeDiskBytes(path string) (uint64, error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var freeBytesAvailable uint64
+	if err := windows.GetDiskFreeSpaceEx(pathPtr, &freeBytesAvailable, nil, nil); err != nil {
+		return 0, err
+	}
+	return freeBytesAvailable, nil
+}
@@ -2,12 +2,15 @@ package build
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"io/fs"
+	"log"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 
 	runtimeassets "github.com/csautter/dev-alchemy"
@@ -16,14 +19,35 @@ import (
 const (
 	embeddedProjectDirName      = "project"
 	embeddedProjectManifestFile = ".embedded-assets.sha256"
+	// embeddedProjectFilesFile records the hash of every file as the last sync
+	// left it, so the next sync can tell user edits apart.
+	embeddedProjectFilesFile     = ".embedded-assets.files.sha256"
+	embeddedProjectProtectEnvVar = "DEV_ALCHEMY_PROTECT_PROJECT"
 )
 
-func ensureProjectDir(workingDir string, appDataDir string) (string, error) {
+// resolveProjectDir prefers an ejected project selected by project_dir in
+// directories.yml or DEV_ALCHEMY_PROJECT_DIR over the git checkout and the
+// embedded project. The overlay dir only applies to the embedded project.
+func resolveProjectDir(workingDir string, appDataDir string, ejectedDir string, overlayDir string) (string, error) {
+	if ejectedDir == "" {
+		return ensureProjectDir(workingDir, appDataDir, overlayDir)
+	}
+	info, err := os.Stat(ejectedDir)
+	if err != nil {
+		return "", fmt.Errorf("ejected project %q: %w; eject it again or remove %s from %s", ejectedDir, err, projectDirConfigKey, dataDirsConfigFile)
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("ejected project %q is not a directory", ejectedDir)
+	}
+	return ejectedDir, nil
+}
+
+func ensureProjectDir(workingDir string, appDataDir string, overlayDir string) (string, error) {
 	if projectDir := determineTopLevelDirWithGit(workingDir); projectDir != "" {
 		return projectDir, nil
 	}
 
-	return ensureEmbeddedProjectDir(appDataDir)
+	return ensureEmbeddedProjectDir(appDataDir, overlayDir)
 }
 
 func determineTopLevelDirWithGit(workingDir string) string {
@@ -51,7 +75,9 @@ func determineTopLevelDirWithGit(workingDir string) string {
 	return ""
 }
 
-func ensureEmbeddedProjectDir(appDataDir string) (string, error) {
+// ensureEmbeddedProjectDir syncs the embedded project assets, with the files
+// of overlayDir layered on top, into the app data dir.
+func ensureEmbeddedProjectDir(appDataDir string, overlayDir string) (string, error) {
 	projectDir := filepath.Join(appDataDir, embeddedProjectDirName)
 	if err := os.MkdirAll(projectDir, managedDirPermission); err != nil {
 		return "", fmt.Errorf("create embedded project directory: %w", err)
@@ -68,14 +94,54 @@ func ensureEmbeddedProjectDir(appDataDir string) (string, error) {
 		return "", fmt.Errorf("build embedded asset manifest: %w", err)
 	}
 
+	overlayHash, err := projectOverlayHash(overlayDir)
+	if err != nil {
+		return "", err
+	}
+	if overlayHash != "" {
+		manifestHash += " overlay:" + overlayHash
+	}
+
 	if manifestMatches(projectRoot, embeddedProjectManifestFile, manifestHash) {
 		return projectDir, nil
 	}
 
-	if err := syncEmbeddedProject(runtimeassets.FS(), projectRoot); err != nil {
+	source, err := newOverlayFS(runtimeassets.FS(), overlayDir)
+	if err != nil {
+		return "", err
+	}
+	if overlayHash != "" {
+		overlays, err := projectOverlays(runtimeassets.FS(), overlayDir)
+		if err != nil {
+			return "", err
+		}
+		warnChangedProjectOverlays(overlayDir, overlays)
+		if err := recordProjectOverlayBase(runtimeassets.FS(), overlayDir, overlays); err != nil {
+			log.Printf("Could not record the embedded originals of the project overlay: %v", err)
+		}
+	}
+
+	baseline, err := readEmbeddedProjectFiles(projectRoot)
+	if err != nil {
+		return "", fmt.Errorf("read embedded project file hashes: %w", err)
+	}
+	result, err := syncEmbeddedProject(source, projectRoot, embeddedProjectSync{
+		protect:  embeddedProjectProtected(),
+		baseline: baseline,
+	})
+	if err != nil {
 		return "", fmt.Errorf("extract embedded project assets: %w", err)
 	}
+	if len(result.kept) > 0 {
+		log.Printf(
+			"Kept %d user-modified file(s) in the embedded project at %s: %s. Run `alchemy project diff` to review them or `alchemy project eject` to take ownership of the project.",
+			len(result.kept), projectDir, strings.Join(result.kept, ", "),
+		)
+	}
 
+	if err := writeEmbeddedProjectFiles(projectRoot, result.files); err != nil {
+		return "", fmt.Errorf("write embedded project file hashes: %w", err)
+	}
 	if err := projectRoot.WriteFile(embeddedProjectManifestFile, []byte(manifestHash+"\n"), 0o600); err != nil {
 		return "", fmt.Errorf("write embedded asset manifest: %w", err)
 	}
@@ -83,6 +149,13 @@ func ensureEmbeddedProjectDir(appDataDir string) (string, error) {
 	return projectDir, nil
 }
 
+// embeddedProjectProtected reports whether DEV_ALCHEMY_PROTECT_PROJECT asks
+// syncs to keep user-modified files.
+func embeddedProjectProtected() bool {
+	protect, err := strconv.ParseBool(strings.TrimSpace(os.Getenv(embeddedProjectProtectEnvVar)))
+	return err == nil && protect
+}
+
 func manifestMatches(root *os.Root, path string, want string) bool {
 	content, err := root.ReadFile(path)
 	if err != nil {
@@ -92,17 +165,47 @@ func manifestMatches(root *os.Root, path string, want string) bool {
 	return strings.TrimSpace(string(content)) == want
 }
 
-func syncEmbeddedProject(source fs.FS, destination *os.Root) error {
+// embeddedProjectSync configures syncEmbeddedProject. With protect, files
+// that differ from their baseline hash, or have none, are user edits and are
+// neither overwritten nor pruned. A nil baseline, from a tree extracted by a
+// release that recorded no hashes, protects every file that differs from the
+// embedded content; only the files that match enter the baseline.
+type embeddedProjectSync struct {
+	protect  bool
+	baseline map[string]string
+}
+
+type embeddedProjectSyncResult struct {
+	// files maps the slash path of every file the sync manages to the hash
+	// it left on disk, or to the baseline hash of a kept user edit.
+	files map[string]string
+	kept  []string
+}
+
+func (s embeddedProjectSync) userModified(path string, content []byte) bool {
+	recorded, ok := s.baseline[path]
+	return !ok || recorded != contentSHA256(content)
+}
+
+func (s embeddedProjectSync) keep(result *embeddedProjectSyncResult, path string) {
+	result.kept = append(result.kept, path)
+	if recorded, ok := s.baseline[path]; ok {
+		result.files[path] = recorded
+	}
+}
+
+func syncEmbeddedProject(source fs.FS, destination *os.Root, options embeddedProjectSync) (embeddedProjectSyncResult, error) {
+	result := embeddedProjectSyncResult{files: map[string]string{}}
 	sourceEntries, err := embeddedProjectEntries(source)
 	if err != nil {
-		return err
+		return result, err
 	}
 
-	if err := pruneEmbeddedProject(destination, sourceEntries); err != nil {
-		return err
+	if err := pruneEmbeddedProject(destination, sourceEntries, options, &result); err != nil {
+		return result, err
 	}
 
-	return fs.WalkDir(source, ".", func(path string, d fs.DirEntry, err error) error {
+	err = fs.WalkDir(source, ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -133,12 +236,21 @@ func syncEmbeddedProject(source fs.FS, destination *os.Root) error {
 			}
 		}
 
-		if existing, err := destination.ReadFile(targetPath); err == nil && bytes.Equal(existing, content) {
+		existing, readErr := destination.ReadFile(targetPath)
+		if readErr == nil && bytes.Equal(existing, content) {
+			result.files[path] = contentSHA256(content)
 			return destination.Chmod(targetPath, mode)
 		}
+		if readErr == nil && options.protect && options.userModified(path, existing) {
+			options.keep(&result, path)
+			return nil
+		}
 
+		result.files[path] = contentSHA256(content)
 		return destination.WriteFile(targetPath, content, mode)
 	})
+	sort.Strings(result.kept)
+	return result, err
 }
 
 type embeddedProjectEntry struct {
@@ -171,14 +283,14 @@ type staleEmbeddedProjectEntry struct {
 	isDir bool
 }
 
-func pruneEmbeddedProject(destination *os.Root, sourceEntries map[string]embeddedProjectEntry) error {
+func pruneEmbeddedProject(destination *os.Root, sourceEntries map[string]embeddedProjectEntry, options embeddedProjectSync, result *embeddedProjectSyncResult) error {
 	var staleEntries []staleEmbeddedProjectEntry
 
 	if err := fs.WalkDir(destination.FS(), ".", func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
-		if path == "." || path == embeddedProjectManifestFile {
+		if path == "." || path == embeddedProjectManifestFile || path == embeddedProjectFilesFile {
 			return nil
 		}
 
@@ -200,12 +312,34 @@ func pruneEmbeddedProject(destination *os.Root, sourceEntries map[string]embedde
 		return strings.Count(staleEntries[i].path, "/") > strings.Count(staleEntries[j].path, "/")
 	})
 
+	keptBelow := func(dir string) bool {
+		for _, kept := range result.kept {
+			if strings.HasPrefix(kept, dir+"/") {
+				return true
+			}
+		}
+		return false
+	}
+
 	for _, entry := range staleEntries {
 		targetPath := filepath.FromSlash(entry.path)
 		var err error
-		if entry.isDir {
+		switch {
+		case !options.protect && entry.isDir:
 			err = destination.RemoveAll(targetPath)
-		} else {
+		case !options.protect:
+			err = destination.Remove(targetPath)
+		case entry.isDir:
+			if keptBelow(entry.path) {
+				continue
+			}
+			err = destination.RemoveAll(targetPath)
+		default:
+			content, readErr := destination.ReadFile(targetPath)
+			if readErr == nil && options.userModified(entry.path, content) {
+				options.keep(result, entry.path)
+				continue
+			}
 			err = destination.Remove(targetPath)
 		}
 		if err != nil && !errors.Is(err, fs.ErrNotExist) {
@@ -216,6 +350,53 @@ func pruneEmbeddedProject(destination *os.Root, sourceEntries map[string]embedde
 	return nil
 }
 
+func readEmbeddedProjectFiles(root *os.Root) (map[string]string, error) {
+	content, err := root.ReadFile(embeddedProjectFilesFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSHA256Sums(content), nil
+}
+
+func writeEmbeddedProjectFiles(root *os.Root, files map[string]string) error {
+	return root.WriteFile(embeddedProjectFilesFile, formatSHA256Sums(files), 0o600)
+}
+
+// parseSHA256Sums reads path hashes in sha256sum format.
+func parseSHA256Sums(content []byte) map[string]string {
+	files := map[string]string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		hash, path, ok := strings.Cut(line, "  ")
+		if ok {
+			files[path] = hash
+		}
+	}
+	return files
+}
+
+// formatSHA256Sums writes path hashes in sha256sum format, sorted by path.
+func formatSHA256Sums(files map[string]string) []byte {
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var content strings.Builder
+	for _, path := range paths {
+		fmt.Fprintf(&content, "%s  %s\n", files[path], path)
+	}
+	return []byte(content.String())
+}
+
+func contentSHA256(content []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(content))
+}
+
 func embeddedFileMode(path string, mode fs.FileMode) fs.FileMode {
 	perm := mode.Perm()
 	if strings.HasSuffix(path, ".sh") {
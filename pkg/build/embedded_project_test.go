@@ -4,7 +4,9 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+	"testing/fstest"
 )
 
 func TestEnsureProjectDir_PrefersGitCheckout(t *testing.T) {
@@ -17,7 +19,7 @@ func TestEnsureProjectDir_PrefersGitCheckout(t *testing.T) {
 		t.Fatalf("failed to create working directory: %v", err)
 	}
 
-	projectDir, err := ensureProjectDir(workingDir, t.TempDir())
+	projectDir, err := ensureProjectDir(workingDir, t.TempDir(), "")
 	if err != nil {
 		t.Fatalf("ensureProjectDir returned error: %v", err)
 	}
@@ -29,7 +31,7 @@ func TestEnsureProjectDir_PrefersGitCheckout(t *testing.T) {
 func TestEnsureProjectDir_FallsBackToEmbeddedProject(t *testing.T) {
 	appDataDir := t.TempDir()
 
-	projectDir, err := ensureProjectDir(t.TempDir(), appDataDir)
+	projectDir, err := ensureProjectDir(t.TempDir(), appDataDir, "")
 	if err != nil {
 		t.Fatalf("ensureProjectDir returned error: %v", err)
 	}
@@ -54,7 +56,7 @@ func TestEnsureProjectDir_FallsBackToEmbeddedProject(t *testing.T) {
 func TestEnsureEmbeddedProjectDir_SkipsSyncWhenManifestMatches(t *testing.T) {
 	appDataDir := t.TempDir()
 
-	projectDir, err := ensureEmbeddedProjectDir(appDataDir)
+	projectDir, err := ensureEmbeddedProjectDir(appDataDir, "")
 	if err != nil {
 		t.Fatalf("ensureEmbeddedProjectDir returned error: %v", err)
 	}
@@ -64,7 +66,7 @@ func TestEnsureEmbeddedProjectDir_SkipsSyncWhenManifestMatches(t *testing.T) {
 		t.Fatalf("failed to modify extracted asset: %v", err)
 	}
 
-	if _, err := ensureEmbeddedProjectDir(appDataDir); err != nil {
+	if _, err := ensureEmbeddedProjectDir(appDataDir, ""); err != nil {
 		t.Fatalf("second ensureEmbeddedProjectDir returned error: %v", err)
 	}
 
@@ -82,7 +84,7 @@ func TestEnsureEmbeddedProjectDir_MarksShellScriptsExecutableOnUnix(t *testing.T
 		t.Skip("POSIX execute bits are not portable on Windows")
 	}
 
-	projectDir, err := ensureEmbeddedProjectDir(t.TempDir())
+	projectDir, err := ensureEmbeddedProjectDir(t.TempDir(), "")
 	if err != nil {
 		t.Fatalf("ensureEmbeddedProjectDir returned error: %v", err)
 	}
@@ -101,7 +103,7 @@ func TestEnsureEmbeddedProjectDir_UsesManagedPermissionsForDirectories(t *testin
 		t.Skip("POSIX directory permissions are not portable on Windows")
 	}
 
-	projectDir, err := ensureEmbeddedProjectDir(t.TempDir())
+	projectDir, err := ensureEmbeddedProjectDir(t.TempDir(), "")
 	if err != nil {
 		t.Fatalf("ensureEmbeddedProjectDir returned error: %v", err)
 	}
@@ -118,7 +120,7 @@ func TestEnsureEmbeddedProjectDir_UsesManagedPermissionsForDirectories(t *testin
 func TestEnsureEmbeddedProjectDir_PrunesStaleEntriesWhenManifestChanges(t *testing.T) {
 	appDataDir := t.TempDir()
 
-	projectDir, err := ensureEmbeddedProjectDir(appDataDir)
+	projectDir, err := ensureEmbeddedProjectDir(appDataDir, "")
 	if err != nil {
 		t.Fatalf("ensureEmbeddedProjectDir returned error: %v", err)
 	}
@@ -147,7 +149,7 @@ func TestEnsureEmbeddedProjectDir_PrunesStaleEntriesWhenManifestChanges(t *testi
 		t.Fatalf("failed to write stale manifest: %v", err)
 	}
 
-	if _, err := ensureEmbeddedProjectDir(appDataDir); err != nil {
+	if _, err := ensureEmbeddedProjectDir(appDataDir, ""); err != nil {
 		t.Fatalf("second ensureEmbeddedProjectDir returned error: %v", err)
 	}
 
@@ -163,3 +165,134 @@ func TestEnsureEmbeddedProjectDir_PrunesStaleEntriesWhenManifestChanges(t *testi
 		t.Fatalf("expected conflicting path to be restored as file")
 	}
 }
+
+func TestSyncEmbeddedProject_ProtectKeepsUserModifiedFiles(t *testing.T) {
+	projectDir := t.TempDir()
+	root, err := os.OpenRoot(projectDir)
+	if err != nil {
+		t.Fatalf("failed to open project root: %v", err)
+	}
+	defer root.Close()
+
+	first := fstest.MapFS{
+		"roles/base/main.yml":   {Data: []byte("v1\n")},
+		"roles/base/edited.yml": {Data: []byte("v1\n")},
+		"roles/old/main.yml":    {Data: []byte("old\n")},
+	}
+	result, err := syncEmbeddedProject(first, root, embeddedProjectSync{})
+	if err != nil {
+		t.Fatalf("first sync returned error: %v", err)
+	}
+	writeDependencyFile(t, filepath.Join(projectDir, "roles", "base", "edited.yml"), "mine\n")
+	writeDependencyFile(t, filepath.Join(projectDir, "roles", "old", "notes.txt"), "mine\n")
+
+	second := fstest.MapFS{
+		"roles/base/main.yml":   {Data: []byte("v2\n")},
+		"roles/base/edited.yml": {Data: []byte("v2\n")},
+	}
+	result, err = syncEmbeddedProject(second, root, embeddedProjectSync{protect: true, baseline: result.files})
+	if err != nil {
+		t.Fatalf("protected sync returned error: %v", err)
+	}
+
+	if got := strings.Join(result.kept, ","); got != "roles/base/edited.yml,roles/old/notes.txt" {
+		t.Fatalf("expected the edited and the added file to be kept, got %q", got)
+	}
+	for path, want := range map[string]string{
+		filepath.Join("roles", "base", "main.yml"):   "v2\n",
+		filepath.Join("roles", "base", "edited.yml"): "mine\n",
+		filepath.Join("roles", "old", "notes.txt"):   "mine\n",
+	} {
+		if content, err := os.ReadFile(filepath.Join(projectDir, path)); err != nil || string(content) != want {
+			t.Fatalf("expected %s to contain %q, got %q err=%v", path, want, content, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, "roles", "old", "main.yml")); !os.IsNotExist(err) {
+		t.Fatalf("expected the unmodified stale file to be pruned, got %v", err)
+	}
+	if _, ok := result.files["roles/base/edited.yml"]; !ok {
+		t.Fatal("expected the kept file to keep its baseline hash so it stays marked as edited")
+	}
+
+	if _, err := syncEmbeddedProject(second, root, embeddedProjectSync{baseline: result.files}); err != nil {
+		t.Fatalf("unprotected sync returned error: %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(projectDir, "roles", "base", "edited.yml")); string(content) != "v2\n" {
+		t.Fatalf("expected an unprotected sync to overwrite the edit, got %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, "roles", "old")); !os.IsNotExist(err) {
+		t.Fatalf("expected an unprotected sync to prune the stale dir, got %v", err)
+	}
+}
+
+func TestSyncEmbeddedProject_ProtectKeepsEditsWithoutBaseline(t *testing.T) {
+	projectDir := t.TempDir()
+	root, err := os.OpenRoot(projectDir)
+	if err != nil {
+		t.Fatalf("failed to open project root: %v", err)
+	}
+	defer root.Close()
+	writeDependencyFile(t, filepath.Join(projectDir, "roles", "base", "main.yml"), "mine\n")
+	writeDependencyFile(t, filepath.Join(projectDir, "roles", "same", "main.yml"), "same\n")
+	writeDependencyFile(t, filepath.Join(projectDir, "roles", "old", "main.yml"), "old\n")
+
+	upgrade := fstest.MapFS{
+		"roles/base/main.yml": {Data: []byte("v2\n")},
+		"roles/same/main.yml": {Data: []byte("same\n")},
+		"roles/new/main.yml":  {Data: []byte("new\n")},
+	}
+	result, err := syncEmbeddedProject(upgrade, root, embeddedProjectSync{protect: true})
+	if err != nil {
+		t.Fatalf("protected sync returned error: %v", err)
+	}
+	if got := strings.Join(result.kept, ","); got != "roles/base/main.yml,roles/old/main.yml" {
+		t.Fatalf("expected every differing file to be kept, got %q", got)
+	}
+	for path, want := range map[string]string{
+		"roles/base/main.yml": "mine\n",
+		"roles/old/main.yml":  "old\n",
+		"roles/new/main.yml":  "new\n",
+	} {
+		if content, err := os.ReadFile(filepath.Join(projectDir, path)); err != nil || string(content) != want {
+			t.Fatalf("expected %s to hold %q, got %q err=%v", path, want, content, err)
+		}
+	}
+	if _, ok := result.files["roles/base/main.yml"]; ok {
+		t.Fatalf("expected no hash for a kept file without baseline, got %v", result.files)
+	}
+	if result.files["roles/same/main.yml"] != contentSHA256([]byte("same\n")) || result.files["roles/new/main.yml"] != contentSHA256([]byte("new\n")) {
+		t.Fatalf("expected matching and new files to enter the baseline, got %v", result.files)
+	}
+
+	result, err = syncEmbeddedProject(upgrade, root, embeddedProjectSync{protect: true, baseline: result.files})
+	if err != nil {
+		t.Fatalf("second protected sync returned error: %v", err)
+	}
+	if got := strings.Join(result.kept, ","); got != "roles/base/main.yml,roles/old/main.yml" {
+		t.Fatalf("expected the edits to stay kept on the next sync, got %q", got)
+	}
+}
+
+func TestEnsureEmbeddedProjectDir_WritesFileHashes(t *testing.T) {
+	projectDir, err := ensureEmbeddedProjectDir(t.TempDir(), "")
+	if err != nil {
+		t.Fatalf("ensureEmbeddedProjectDir returned error: %v", err)
+	}
+	root, err := os.OpenRoot(projectDir)
+	if err != nil {
+		t.Fatalf("failed to open project root: %v", err)
+	}
+	defer root.Close()
+
+	files, err := readEmbeddedProjectFiles(root)
+	if err != nil {
+		t.Fatalf("readEmbeddedProjectFiles returned error: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(projectDir, "ansible.cfg"))
+	if err != nil {
+		t.Fatalf("failed to read extracted asset: %v", err)
+	}
+	if files["ansible.cfg"] != contentSHA256(content) {
+		t.Fatalf("expected the hash of ansible.cfg to be recorded, got %q", files["ansible.cfg"])
+	}
+}
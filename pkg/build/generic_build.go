@@ -6,7 +6,6 @@ import (
 	"errors"
 	"fmt"
 	"log"
-	"net"
 	"os"
 	"os/exec"
 	"os/signal"
@@ -49,8 +48,20 @@ func RunBuildScript(config VirtualMachineConfig, executable string, args []strin
 	}
 	defer restoreInteractiveTerminal()
 
+	if err := runBuildPreflight(config); err != nil {
+		if cleanupErr := cleanupArtifacts(false); cleanupErr != nil {
+			log.Printf("Build artifact cleanup failed after preflight error: %v", cleanupErr)
+		}
+		return err
+	}
+
 	// Ensure all required dependencies are present
-	DependencyReconciliation(config)
+	if err := DependencyReconciliation(config); err != nil {
+		if cleanupErr := cleanupArtifacts(false); cleanupErr != nil {
+			log.Printf("Build artifact cleanup failed after dependency error: %v", cleanupErr)
+		}
+		return fmt.Errorf("dependency reconciliation failed: %w", err)
+	}
 
 	// Check if VNC port is free, if not, increment until a free port is found
 	_ = getFreeVncPort(&config)
@@ -363,18 +374,7 @@ func printCurrentWorkingDirectory() {
 }
 
 func getFreeVncPort(config *VirtualMachineConfig) int {
-	port := config.VncPort
-	for {
-		addr := fmt.Sprintf("127.0.0.1:%d", port)
-		ln, err := net.Listen("tcp", addr)
-		if err == nil {
-			if closeErr := ln.Close(); closeErr != nil {
-				log.Printf("Failed to release test listener on %s: %v", addr, closeErr)
-			}
-			break
-		}
-		port++
-	}
+	port := nextFreeVncPort(config.VncPort, nil)
 	config.VncPort = port
 	log.Printf("Using VNC port: %d", config.VncPort)
 	return port
@@ -423,8 +423,16 @@ func prepareBuildArtifactsForBuild(config VirtualMachineConfig) (bool, func(bool
 		}
 		cleanup := func(success bool) error {
 			if success {
+				var optimizations map[string]ArtifactOptimization
+				if config.Optimize {
+					optimizations = optimizeBuildArtifacts(stagedArtifacts, artifacts)
+				}
 				log.Printf("Promoting staged build artifact(s) after successful no-cache build: %v -> %v", stagedArtifacts, artifacts)
-				return promoteStagedBuildArtifacts(artifacts, stagedArtifacts)
+				if err := promoteStagedBuildArtifacts(artifacts, stagedArtifacts); err != nil {
+					return err
+				}
+				logBuildArtifactMetadataError(recordBuiltArtifacts(artifacts, optimizations))
+				return nil
 			}
 			log.Printf("Removing staged build artifact(s) after failed no-cache build: %v", stagedArtifacts)
 			return removeBuildArtifacts(stagedArtifacts)
@@ -440,6 +448,11 @@ func prepareBuildArtifactsForBuild(config VirtualMachineConfig) (bool, func(bool
 
 	cleanup := func(success bool) error {
 		if success {
+			var optimizations map[string]ArtifactOptimization
+			if config.Optimize {
+				optimizations = optimizeBuildArtifacts(artifacts, artifacts)
+			}
+			logBuildArtifactMetadataError(recordBuiltArtifacts(artifacts, optimizations))
 			return removeBackedUpArtifacts(backups)
 		}
 		return errors.Join(removeBuildArtifacts(artifacts), restoreBackedUpArtifacts(backups))
@@ -448,6 +461,12 @@ func prepareBuildArtifactsForBuild(config VirtualMachineConfig) (bool, func(bool
 	return false, cleanup, nil
 }
 
+func logBuildArtifactMetadataError(err error) {
+	if err != nil {
+		log.Printf("Failed to record build artifact metadata: %v", err)
+	}
+}
+
 func withStagedBuildArtifactsForNoCache(config VirtualMachineConfig) (VirtualMachineConfig, error) {
 	if !config.NoCache || len(config.StagedBuildArtifacts) > 0 {
 		return config, nil
@@ -468,3 +468,39 @@ func TestRemoveBuildArtifactsForConfigUsesResolvedArtifacts(t *testing.T) {
 		t.Fatalf("expected resolved artifact to be removed, got err=%v", err)
 	}
 }
+
+func TestPrepareBuildArtifactsForBuildOptimizesStagedArtifactBeforePromotion(t *testing.T) {
+	stubArtifactOptimizer(t, &optimizeStub{missing: map[string]bool{virtSparsifyExecutable: true}, convertOutput: []byte("tiny")})
+
+	tempDir := t.TempDir()
+	artifact := filepath.Join(tempDir, "artifact-a.qcow2")
+	stagedArtifact := filepath.Join(tempDir, "artifact-a.dev-alchemy-build-1.qcow2")
+
+	_, cleanup, err := prepareBuildArtifactsForBuild(VirtualMachineConfig{
+		ExpectedBuildArtifacts: []string{artifact},
+		StagedBuildArtifacts:   []string{stagedArtifact},
+		NoCache:                true,
+		Optimize:               true,
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := os.WriteFile(stagedArtifact, []byte("rebuilt image"), 0644); err != nil {
+		t.Fatalf("failed to create staged artifact: %v", err)
+	}
+	if err := cleanup(true); err != nil {
+		t.Fatalf("expected cleanup to promote staged artifact, got %v", err)
+	}
+
+	content, err := os.ReadFile(artifact)
+	if err != nil || string(content) != "tiny" {
+		t.Fatalf("expected optimized artifact to be promoted, got %q err=%v", content, err)
+	}
+	metadata, exists, err := ReadBuildArtifactMetadata(artifact)
+	if err != nil || !exists {
+		t.Fatalf("expected metadata for promoted artifact, exists=%t err=%v", exists, err)
+	}
+	if metadata.Optimization == nil || metadata.Optimization.OriginalSizeBytes != 13 || metadata.Optimization.OptimizedSizeBytes != 4 {
+		t.Fatalf("unexpected optimization metadata %+v", metadata.Optimization)
+	}
+}
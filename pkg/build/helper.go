@@ -1,6 +1,7 @@
 package build
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -101,3 +102,17 @@ func getQemuBuildOutputDir(config VirtualMachineConfig) string {
 func getDarwinQemuBuildOutputDir(config VirtualMachineConfig) string {
 	return getQemuBuildOutputDir(config)
 }
+
+// FormatByteSize renders a byte count with binary units, e.g. "2.9 GiB".
+func FormatByteSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
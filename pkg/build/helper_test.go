@@ -18,3 +18,18 @@ func TestGetQemuBuildOutputDir(t *testing.T) {
 		t.Fatalf("expected %q, got %q", want, got)
 	}
 }
+
+func TestFormatByteSize(t *testing.T) {
+	tests := map[int64]string{
+		0:              "0 B",
+		1023:           "1023 B",
+		1536:           "1.5 KiB",
+		3 << 30:        "3.0 GiB",
+		64<<30 + 1<<29: "64.5 GiB",
+	}
+	for size, want := range tests {
+		if got := FormatByteSize(size); got != want {
+			t.Fatalf("FormatByteSize(%d) = %q, want %q", size, got, want)
+		}
+	}
+}
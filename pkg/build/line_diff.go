@@ -0,0 +1,152 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	lineDiffContext = 3
+	// maxLineDiffCells bounds the lines-times-lines table of a line diff.
+	maxLineDiffCells = 4_000_000
+)
+
+type lineDiffOp struct {
+	kind byte
+	text string
+}
+
+// unifiedDiff returns a unified diff from a to b with three lines of context,
+// or "" when both are equal. Binary and very large files are only reported as
+// different.
+func unifiedDiff(fromName string, toName string, a []byte, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	if bytes.IndexByte(a, 0) >= 0 || bytes.IndexByte(b, 0) >= 0 {
+		return fmt.Sprintf("Binary files %s and %s differ\n", fromName, toName)
+	}
+	ops, ok := diffLines(splitDiffLines(a), splitDiffLines(b))
+	if !ok {
+		return fmt.Sprintf("Files %s and %s differ (too large for a line diff)\n", fromName, toName)
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+	for start := 0; start < len(ops); {
+		if ops[start].kind == ' ' {
+			start++
+			continue
+		}
+		end := start
+		for next := start; next < len(ops); next++ {
+			if ops[next].kind != ' ' {
+				if next-end > 2*lineDiffContext {
+					break
+				}
+				end = next + 1
+			}
+		}
+		writeDiffHunk(&out, ops, max(0, start-lineDiffContext), min(len(ops), end+lineDiffContext))
+		start = end
+	}
+	return out.String()
+}
+
+func writeDiffHunk(out *strings.Builder, ops []lineDiffOp, from int, to int) {
+	var aStart, bStart int
+	for _, op := range ops[:from] {
+		if op.kind != '+' {
+			aStart++
+		}
+		if op.kind != '-' {
+			bStart++
+		}
+	}
+	var aCount, bCount int
+	for _, op := range ops[from:to] {
+		if op.kind != '+' {
+			aCount++
+		}
+		if op.kind != '-' {
+			bCount++
+		}
+	}
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", diffRange(aStart, aCount), diffRange(bStart, bCount))
+	for _, op := range ops[from:to] {
+		fmt.Fprintf(out, "%c%s\n", op.kind, op.text)
+	}
+}
+
+func diffRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func splitDiffLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+// diffLines aligns a and b on their longest common subsequence after
+// trimming the common prefix and suffix. ok is false when the remaining
+// table would exceed maxLineDiffCells.
+func diffLines(a []string, b []string) ([]lineDiffOp, bool) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	x, y := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if (len(x)+1)*(len(y)+1) > maxLineDiffCells {
+		return nil, false
+	}
+
+	// lcs[i][j] is the common subsequence length of x[i:] and y[j:].
+	width := len(y) + 1
+	lcs := make([]int32, (len(x)+1)*width)
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i*width+j] = lcs[(i+1)*width+j+1] + 1
+			} else {
+				lcs[i*width+j] = max(lcs[(i+1)*width+j], lcs[i*width+j+1])
+			}
+		}
+	}
+
+	ops := make([]lineDiffOp, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, lineDiffOp{' ', line})
+	}
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			ops = append(ops, lineDiffOp{' ', x[i]})
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[(i+1)*width+j] >= lcs[i*width+j+1]):
+			ops = append(ops, lineDiffOp{'-', x[i]})
+			i++
+		default:
+			ops = append(ops, lineDiffOp{'+', y[j]})
+			j++
+		}
+	}
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, lineDiffOp{' ', line})
+	}
+	return ops, true
+}
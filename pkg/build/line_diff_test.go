@@ -0,0 +1,52 @@
+package build
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestUnifiedDiffWritesHunksWithContext(t *testing.T) {
+	var a, b []string
+	for i := 1; i <= 20; i++ {
+		a = append(a, fmt.Sprintf("line %d", i))
+	}
+	b = append(b, a...)
+	b[1] = "line two"
+	b = append(b[:15], append([]string{"inserted"}, b[15:]...)...)
+
+	got := unifiedDiff("embedded/x", "project/x", []byte(strings.Join(a, "\n")+"\n"), []byte(strings.Join(b, "\n")+"\n"))
+	want := `--- embedded/x
++++ project/x
+@@ -1,5 +1,5 @@
+ line 1
+-line 2
++line two
+ line 3
+ line 4
+ line 5
+@@ -13,6 +13,7 @@
+ line 13
+ line 14
+ line 15
++inserted
+ line 16
+ line 17
+ line 18
+`
+	if got != want {
+		t.Fatalf("unexpected diff:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUnifiedDiffHandlesEqualEmptyAndBinaryFiles(t *testing.T) {
+	if got := unifiedDiff("a", "b", []byte("same\n"), []byte("same\n")); got != "" {
+		t.Fatalf("expected no diff for equal files, got %q", got)
+	}
+	if got := unifiedDiff("a", "b", nil, []byte("new\n")); got != "--- a\n+++ b\n@@ -0,0 +1 @@\n+new\n" {
+		t.Fatalf("unexpected diff for an added file: %q", got)
+	}
+	if got := unifiedDiff("a", "b", []byte{0, 1}, []byte{0, 2}); got != "Binary files a and b differ\n" {
+		t.Fatalf("unexpected diff for binary files: %q", got)
+	}
+}
@@ -10,7 +10,13 @@ func runQemuUbuntuBuild(config VirtualMachineConfig, relativeScriptPath string)
 	if err != nil {
 		return err
 	}
+	// Resolve the VNC port before rendering the script args so the wrapper
+	// script and the VNC recorder agree on the same port.
+	_ = getFreeVncPort(&config)
+	return RunBuildScript(config, "bash", qemuUbuntuBuildArgs(config, relativeScriptPath))
+}
 
+func qemuUbuntuBuildArgs(config VirtualMachineConfig, relativeScriptPath string) []string {
 	scriptPath := filepath.Join(GetDirectoriesInstance().GetDirectories().ProjectDir, relativeScriptPath)
 	args := []string{
 		scriptPath,
@@ -25,13 +31,14 @@ func runQemuUbuntuBuild(config VirtualMachineConfig, relativeScriptPath string)
 	if stagedArtifact, ok := firstStagedBuildArtifact(config); ok {
 		args = append(args, "--artifact-output-path", stagedArtifact)
 	}
+	args = append(args, packerScriptVarArgs(config)...)
 	if config.Headless {
 		args = append(args, "--headless")
 	}
 	if config.Verbose {
 		args = append(args, "--verbose")
 	}
-	return RunBuildScript(config, "bash", args)
+	return args
 }
 
 func RunQemuUbuntuBuildOnLinux(config VirtualMachineConfig) error {
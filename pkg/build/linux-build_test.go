@@ -48,37 +48,49 @@ func linuxQemuWindowsConfig(arch string, vncPort int) VirtualMachineConfig {
 func TestIntegrationDependencyReconciliationQemuUbuntuAmd64OnLinux(t *testing.T) {
 	requireIntegrationTests(t)
 
-	DependencyReconciliation(linuxQemuUbuntuConfig("amd64", "server", 5922))
+	if err := DependencyReconciliation(linuxQemuUbuntuConfig("amd64", "server", 5922)); err != nil {
+		t.Fatalf("DependencyReconciliation returned error: %v", err)
+	}
 }
 
 func TestIntegrationDependencyReconciliationQemuUbuntuArm64OnLinux(t *testing.T) {
 	requireIntegrationTests(t)
 
-	DependencyReconciliation(linuxQemuUbuntuConfig("arm64", "server", 5921))
+	if err := DependencyReconciliation(linuxQemuUbuntuConfig("arm64", "server", 5921)); err != nil {
+		t.Fatalf("DependencyReconciliation returned error: %v", err)
+	}
 }
 
 func TestIntegrationDependencyReconciliationQemuUbuntuDesktopAmd64OnLinux(t *testing.T) {
 	requireIntegrationTests(t)
 
-	DependencyReconciliation(linuxQemuUbuntuConfig("amd64", "desktop", 5924))
+	if err := DependencyReconciliation(linuxQemuUbuntuConfig("amd64", "desktop", 5924)); err != nil {
+		t.Fatalf("DependencyReconciliation returned error: %v", err)
+	}
 }
 
 func TestIntegrationDependencyReconciliationQemuUbuntuDesktopArm64OnLinux(t *testing.T) {
 	requireIntegrationTests(t)
 
-	DependencyReconciliation(linuxQemuUbuntuConfig("arm64", "desktop", 5923))
+	if err := DependencyReconciliation(linuxQemuUbuntuConfig("arm64", "desktop", 5923)); err != nil {
+		t.Fatalf("DependencyReconciliation returned error: %v", err)
+	}
 }
 
 func TestIntegrationDependencyReconciliationQemuWindows11Amd64OnLinux(t *testing.T) {
 	requireIntegrationTests(t)
 
-	DependencyReconciliation(linuxQemuWindowsConfig("amd64", 5932))
+	if err := DependencyReconciliation(linuxQemuWindowsConfig("amd64", 5932)); err != nil {
+		t.Fatalf("DependencyReconciliation returned error: %v", err)
+	}
 }
 
 func TestIntegrationDependencyReconciliationQemuWindows11Arm64OnLinux(t *testing.T) {
 	requireIntegrationTests(t)
 
-	DependencyReconciliation(linuxQemuWindowsConfig("arm64", 5931))
+	if err := DependencyReconciliation(linuxQemuWindowsConfig("arm64", 5931)); err != nil {
+		t.Fatalf("DependencyReconciliation returned error: %v", err)
+	}
 }
 
 func TestBuildQemuUbuntuServerAmd64OnLinux(t *testing.T) {
@@ -0,0 +1,32 @@
+//go:build linux
+
+package build
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// getSystemAvailableMemoryMB returns MemAvailable from /proc/meminfo in megabytes.
+func getSystemAvailableMemoryMB() (uint64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemAvailable:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb / 1024, nil
+		}
+	}
+	return 0, scanner.Err()
+}
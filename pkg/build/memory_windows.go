@@ -40,3 +40,16 @@ func getSystemTotalMemoryMB() (uint64, error) {
 
 	return mem.ullTotalPhys / (1024 * 1024), nil
 }
+
+// getSystemAvailableMemoryMB returns the currently available physical memory in megabytes.
+func getSystemAvailableMemoryMB() (uint64, error) {
+	var mem memoryStatusEx
+	mem.dwLength = uint32(unsafe.Sizeof(mem))
+
+	ret, _, err := procGlobalMemStatus.Call(uintptr(unsafe.Pointer(&mem)))
+	if ret == 0 {
+		return 0, fmt.Errorf("GlobalMemoryStatusEx failed: %w", err)
+	}
+
+	return mem.ullAvailPhys / (1024 * 1024), nil
+}
@@ -0,0 +1,212 @@
+package build
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const (
+	ubuntuQemuPackerFile  = "build/packer/linux/ubuntu/linux-ubuntu-qemu.pkr.hcl"
+	windowsQemuPackerFile = "build/packer/windows/windows11-qemu.pkr.hcl"
+)
+
+var (
+	packerVariableDeclarationPattern = regexp.MustCompile(`(?m)^\s*variable\s+"([^"]+)"`)
+	packerVarFileAssignmentPattern   = regexp.MustCompile(`(?m)^([A-Za-z_][A-Za-z0-9_-]*)\s*=`)
+	packerVariableNamePattern        = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+)
+
+// reservedPackerVariables are owned by the build flow itself. Overriding them
+// would break artifact staging and promotion, so user overrides are rejected.
+var reservedPackerVariables = map[string]string{
+	"artifact_output_path": "it is managed by the staged build artifact flow",
+	"build_output_dir":     "it is managed by the QEMU build output cleanup",
+}
+
+// ParsePackerVarAssignments parses repeated key=value CLI assignments.
+// Later assignments for the same key win.
+func ParsePackerVarAssignments(assignments []string) (map[string]string, error) {
+	vars := make(map[string]string, len(assignments))
+	for _, assignment := range assignments {
+		key, value, ok := strings.Cut(assignment, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid Packer variable %q; expected key=value", assignment)
+		}
+		if !packerVariableNamePattern.MatchString(key) {
+			return nil, fmt.Errorf("invalid Packer variable name %q", key)
+		}
+		vars[key] = value
+	}
+	return vars, nil
+}
+
+// WithPackerVariables returns a copy of config with vars and varFiles layered
+// on top of any Packer overrides it already carries.
+func WithPackerVariables(config VirtualMachineConfig, vars map[string]string, varFiles []string) VirtualMachineConfig {
+	if len(vars) == 0 && len(varFiles) == 0 {
+		return config
+	}
+
+	merged := make(map[string]string, len(config.PackerVars)+len(vars))
+	maps.Copy(merged, config.PackerVars)
+	maps.Copy(merged, vars)
+	config.PackerVars = merged
+
+	files := slices.Clone(config.PackerVarFiles)
+	for _, varFile := range varFiles {
+		if !slices.Contains(files, varFile) {
+			files = append(files, varFile)
+		}
+	}
+	config.PackerVarFiles = files
+	return config
+}
+
+// PackerTemplateForConfig returns the project-relative Packer template used to
+// build the given target.
+func PackerTemplateForConfig(config VirtualMachineConfig) (string, error) {
+	switch config.VirtualizationEngine {
+	case VirtualizationEngineQemu, VirtualizationEngineUtm:
+		switch config.OS {
+		case "ubuntu":
+			return ubuntuQemuPackerFile, nil
+		case "windows11":
+			return windowsQemuPackerFile, nil
+		}
+	case VirtualizationEngineHyperv:
+		switch config.OS {
+		case "ubuntu":
+			return ubuntuHypervPackerFile, nil
+		case "windows11":
+			return hypervPackerFile, nil
+		}
+	case VirtualizationEngineVirtualBox:
+		if config.OS == "windows11" {
+			return virtualBoxPackerFile, nil
+		}
+	}
+	return "", fmt.Errorf("no Packer template defined for OS=%s engine=%s", config.OS, config.VirtualizationEngine)
+}
+
+// ValidatePackerVariables checks every Packer override on config against the
+// variables declared by the target's template, so typos fail before a build
+// starts instead of after a long ISO download.
+func ValidatePackerVariables(config VirtualMachineConfig) error {
+	if len(config.PackerVars) == 0 && len(config.PackerVarFiles) == 0 {
+		return nil
+	}
+
+	template, err := PackerTemplateForConfig(config)
+	if err != nil {
+		return err
+	}
+	templatePath := filepath.Join(GetDirectoriesInstance().GetDirectories().ProjectDir, filepath.FromSlash(template))
+	declared, err := declaredPackerVariables(templatePath)
+	if err != nil {
+		return err
+	}
+
+	names := make(map[string]string, len(config.PackerVars))
+	for name := range config.PackerVars {
+		names[name] = "--var"
+	}
+	for _, varFile := range config.PackerVarFiles {
+		fileNames, err := packerVarFileVariables(varFile)
+		if err != nil {
+			return err
+		}
+		for _, name := range fileNames {
+			if _, ok := names[name]; !ok {
+				names[name] = varFile
+			}
+		}
+	}
+
+	var problems []string
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		if reason, reserved := reservedPackerVariables[name]; reserved {
+			problems = append(problems, fmt.Sprintf("%q (from %s) cannot be overridden because %s", name, names[name], reason))
+			continue
+		}
+		if _, ok := declared[name]; !ok {
+			problems = append(problems, fmt.Sprintf("%q (from %s) is not declared", name, names[name]))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf(
+			"invalid Packer variable override(s) for %s: %s; declared variables: %s",
+			template,
+			strings.Join(problems, "; "),
+			strings.Join(slices.Sorted(maps.Keys(declared)), ", "),
+		)
+	}
+	return nil
+}
+
+func declaredPackerVariables(templatePath string) (map[string]struct{}, error) {
+	content, err := os.ReadFile(templatePath) // #nosec G304 -- templatePath is resolved from the fixed template allowlist under the project dir.
+	if err != nil {
+		return nil, fmt.Errorf("read Packer template %s: %w", templatePath, err)
+	}
+
+	declared := make(map[string]struct{})
+	for _, match := range packerVariableDeclarationPattern.FindAllStringSubmatch(string(content), -1) {
+		declared[match[1]] = struct{}{}
+	}
+	return declared, nil
+}
+
+func packerVarFileVariables(varFile string) ([]string, error) {
+	content, err := os.ReadFile(varFile) // #nosec G304 -- varFile is an explicit user-selected Packer var file.
+	if err != nil {
+		return nil, fmt.Errorf("read Packer var file %s: %w", varFile, err)
+	}
+
+	if strings.EqualFold(filepath.Ext(varFile), ".json") {
+		values := map[string]any{}
+		if err := json.Unmarshal(content, &values); err != nil {
+			return nil, fmt.Errorf("parse Packer var file %s: %w", varFile, err)
+		}
+		return slices.Sorted(maps.Keys(values)), nil
+	}
+
+	var names []string
+	for _, match := range packerVarFileAssignmentPattern.FindAllStringSubmatch(string(content), -1) {
+		names = append(names, match[1])
+	}
+	slices.Sort(names)
+	return slices.Compact(names), nil
+}
+
+// packerVarArgs renders Packer overrides as packer build arguments. Var files
+// come first so explicit key=value overrides take precedence.
+func packerVarArgs(config VirtualMachineConfig) []string {
+	args := make([]string, 0, len(config.PackerVarFiles)+2*len(config.PackerVars))
+	for _, varFile := range config.PackerVarFiles {
+		args = append(args, "-var-file="+varFile)
+	}
+	for _, name := range slices.Sorted(maps.Keys(config.PackerVars)) {
+		args = append(args, "-var", name+"="+config.PackerVars[name])
+	}
+	return args
+}
+
+// packerScriptVarArgs renders Packer overrides for the QEMU wrapper scripts,
+// which forward them to their packer build invocation.
+func packerScriptVarArgs(config VirtualMachineConfig) []string {
+	args := make([]string, 0, 2*(len(config.PackerVarFiles)+len(config.PackerVars)))
+	for _, varFile := range config.PackerVarFiles {
+		args = append(args, "--packer-var-file", varFile)
+	}
+	for _, name := range slices.Sorted(maps.Keys(config.PackerVars)) {
+		args = append(args, "--packer-var", name+"="+config.PackerVars[name])
+	}
+	return args
+}
@@ -0,0 +1,178 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParsePackerVarAssignments(t *testing.T) {
+	vars, err := ParsePackerVarAssignments([]string{"disk_size=81920", "boot_wait=5s", "disk_size=40960", "empty="})
+	if err != nil {
+		t.Fatalf("ParsePackerVarAssignments returned error: %v", err)
+	}
+	want := map[string]string{"disk_size": "40960", "boot_wait": "5s", "empty": ""}
+	if !reflect.DeepEqual(vars, want) {
+		t.Fatalf("expected %v, got %v", want, vars)
+	}
+}
+
+func TestParsePackerVarAssignmentsRejectsMalformedValues(t *testing.T) {
+	for _, assignment := range []string{"disk_size", "=1", "bad name=1"} {
+		if _, err := ParsePackerVarAssignments([]string{assignment}); err == nil {
+			t.Fatalf("expected %q to be rejected", assignment)
+		}
+	}
+}
+
+func TestWithPackerVariablesDoesNotAliasInputMaps(t *testing.T) {
+	base := VirtualMachineConfig{PackerVars: map[string]string{"cpus": "2"}, PackerVarFiles: []string{"/a.pkrvars.hcl"}}
+
+	got := WithPackerVariables(base, map[string]string{"cpus": "8", "memory": "4096"}, []string{"/a.pkrvars.hcl", "/b.pkrvars.hcl"})
+
+	if base.PackerVars["cpus"] != "2" {
+		t.Fatalf("expected base config vars to stay untouched, got %v", base.PackerVars)
+	}
+	if !reflect.DeepEqual(got.PackerVars, map[string]string{"cpus": "8", "memory": "4096"}) {
+		t.Fatalf("unexpected merged vars %v", got.PackerVars)
+	}
+	if !reflect.DeepEqual(got.PackerVarFiles, []string{"/a.pkrvars.hcl", "/b.pkrvars.hcl"}) {
+		t.Fatalf("unexpected merged var files %v", got.PackerVarFiles)
+	}
+}
+
+func TestPackerTemplateForConfigCoversBuildTargets(t *testing.T) {
+	for _, config := range AvailableVirtualMachineConfigs() {
+		if config.VirtualizationEngine == VirtualizationEngineTart {
+			continue
+		}
+		template, err := PackerTemplateForConfig(config)
+		if err != nil {
+			t.Fatalf("expected template for %s/%s/%s/%s: %v", config.OS, config.UbuntuType, config.Arch, config.VirtualizationEngine, err)
+		}
+		if _, err := os.Stat(repoPath(t, template)); err != nil {
+			t.Fatalf("expected template %s to exist: %v", template, err)
+		}
+	}
+}
+
+func TestValidatePackerVariablesAcceptsDeclaredVariables(t *testing.T) {
+	withRepoProjectDir(t)
+	varFile := filepath.Join(t.TempDir(), "ubuntu.pkrvars.hcl")
+	if err := os.WriteFile(varFile, []byte("cpus = 2\n\nmemory = 4096\nheadless = true\n"), 0o600); err != nil {
+		t.Fatalf("failed to write var file: %v", err)
+	}
+
+	config := linuxUbuntuServerConfig()
+	config.PackerVars = map[string]string{"vnc_port": "5999"}
+	config.PackerVarFiles = []string{varFile}
+
+	if err := ValidatePackerVariables(config); err != nil {
+		t.Fatalf("expected declared variables to validate: %v", err)
+	}
+}
+
+func TestValidatePackerVariablesRejectsUnknownAndReservedVariables(t *testing.T) {
+	withRepoProjectDir(t)
+	varFile := filepath.Join(t.TempDir(), "ubuntu.json")
+	if err := os.WriteFile(varFile, []byte(`{"artifact_output_path": "/tmp/x.qcow2"}`), 0o600); err != nil {
+		t.Fatalf("failed to write var file: %v", err)
+	}
+
+	config := linuxUbuntuServerConfig()
+	config.PackerVars = map[string]string{"memroy": "4096"}
+	config.PackerVarFiles = []string{varFile}
+
+	err := ValidatePackerVariables(config)
+	if err == nil {
+		t.Fatal("expected unknown and reserved variables to be rejected")
+	}
+	for _, want := range []string{
+		`"memroy" (from --var) is not declared`,
+		`"artifact_output_path" (from ` + varFile + `) cannot be overridden`,
+		"declared variables:",
+		"memory",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected error to contain %q, got %q", want, err.Error())
+		}
+	}
+}
+
+func TestPackerVarArgsPlaceVarFilesBeforeSortedVars(t *testing.T) {
+	config := VirtualMachineConfig{
+		PackerVars:     map[string]string{"memory": "4096", "cpus": "2"},
+		PackerVarFiles: []string{"/tmp/a.pkrvars.hcl"},
+	}
+
+	wantPacker := []string{"-var-file=/tmp/a.pkrvars.hcl", "-var", "cpus=2", "-var", "memory=4096"}
+	if got := packerVarArgs(config); !reflect.DeepEqual(got, wantPacker) {
+		t.Fatalf("expected %v, got %v", wantPacker, got)
+	}
+
+	wantScript := []string{"--packer-var-file", "/tmp/a.pkrvars.hcl", "--packer-var", "cpus=2", "--packer-var", "memory=4096"}
+	if got := packerScriptVarArgs(config); !reflect.DeepEqual(got, wantScript) {
+		t.Fatalf("expected %v, got %v", wantScript, got)
+	}
+}
+
+func TestBuildPackerArgsAppendsOverridesBeforeTemplate(t *testing.T) {
+	config := VirtualMachineConfig{
+		OS:                   "windows11",
+		Arch:                 "amd64",
+		HostOs:               HostOsWindows,
+		VirtualizationEngine: VirtualizationEngineHyperv,
+		PackerVars:           map[string]string{"cpus": "6"},
+	}
+
+	args := buildPackerArgs(config, hypervPackerFile)
+	if args[len(args)-1] != hypervPackerFile {
+		t.Fatalf("expected template to stay last, got %v", args)
+	}
+	if args[len(args)-3] != "-var" || args[len(args)-2] != "cpus=6" {
+		t.Fatalf("expected override right before the template, got %v", args)
+	}
+}
+
+func TestQemuWrapperScriptsForwardPackerOverrides(t *testing.T) {
+	t.Parallel()
+
+	for _, scriptPath := range []string{
+		"build/packer/linux/ubuntu/linux-ubuntu-qemu.sh",
+		"build/packer/linux/ubuntu/linux-ubuntu-on-macos.sh",
+		"build/packer/windows/windows11-qemu.sh",
+	} {
+		content, err := os.ReadFile(repoPath(t, scriptPath))
+		if err != nil {
+			t.Fatalf("failed to read script %q: %v", scriptPath, err)
+		}
+		for _, want := range []string{"--packer-var)", "--packer-var-file)", `"${packer_extra_args[@]}"`} {
+			if !strings.Contains(string(content), want) {
+				t.Fatalf("expected script %q to contain %q", scriptPath, want)
+			}
+		}
+	}
+}
+
+func linuxUbuntuServerConfig() VirtualMachineConfig {
+	return VirtualMachineConfig{
+		OS:                   "ubuntu",
+		UbuntuType:           "server",
+		Arch:                 "amd64",
+		HostOs:               HostOsLinux,
+		VirtualizationEngine: VirtualizationEngineQemu,
+	}
+}
+
+func withRepoProjectDir(t *testing.T) {
+	t.Helper()
+
+	dirs := GetDirectoriesInstance()
+	previous := *dirs
+	t.Cleanup(func() {
+		*dirs = previous
+	})
+	dirs.ProjectDir = repoPath(t, ".")
+}
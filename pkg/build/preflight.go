@@ -0,0 +1,271 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	// packerCacheMinFreeBytes leaves room for plugins and ISO copies Packer
+	// keeps in PACKER_CACHE_DIR.
+	packerCacheMinFreeBytes      = 2 << 30
+	kvmDevicePath                = "/dev/kvm"
+	forceSoftwareEmulationEnvVar = "DEV_ALCHEMY_QEMU_FORCE_SOFTWARE_EMULATION"
+)
+
+var (
+	preflightLookPath          = exec.LookPath
+	preflightFreeDiskBytes     = freeDiskBytes
+	preflightAvailableMemoryMB = getSystemAvailableMemoryMB
+	preflightKvmAccess         = kvmDeviceAccess
+	preflightHostVirtualized   = hostLooksVirtualized
+	preflightHostArch          = runtime.GOARCH
+)
+
+type preflightProblem struct {
+	problem string
+	fix     string
+}
+
+// buildPreflightError lists every failed preflight check for one target so
+// all fixes can be applied before the next attempt.
+type buildPreflightError struct {
+	target   string
+	problems []preflightProblem
+}
+
+func (e *buildPreflightError) Error() string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "build preflight failed for %s:", e.target)
+	for _, problem := range e.problems {
+		fmt.Fprintf(&builder, "\n  - %s\n    fix: %s", problem.problem, problem.fix)
+	}
+	builder.WriteString("\nre-run with --skip-preflight to bypass these checks")
+	return builder.String()
+}
+
+// runBuildPreflight checks host capacity and capabilities before any
+// dependency download starts, so a full disk or missing KVM access fails in
+// seconds instead of after a multi-gigabyte ISO download.
+func runBuildPreflight(config VirtualMachineConfig) error {
+	if config.SkipPreflight {
+		log.Printf("Skipping build preflight checks (--skip-preflight)")
+		return nil
+	}
+
+	log.Printf("Running build preflight checks")
+	var problems []preflightProblem
+	problems = append(problems, preflightDiskSpace(config)...)
+	problems = append(problems, preflightExecutables(config)...)
+	problems = append(problems, preflightVirtualization(config)...)
+	problems = append(problems, preflightMemory(config)...)
+	if len(problems) > 0 {
+		return &buildPreflightError{
+			target:   fmt.Sprintf("%s/%s/%s (%s)", config.OS, config.UbuntuType, config.Arch, config.VirtualizationEngine),
+			problems: problems,
+		}
+	}
+	log.Printf("Build preflight checks passed")
+	return nil
+}
+
+func preflightDiskSpace(config VirtualMachineConfig) []preflightProblem {
+	artifacts, err := planBuildArtifacts(config)
+	if err != nil {
+		return []preflightProblem{{
+			problem: fmt.Sprintf("could not inspect build artifacts: %v", err),
+			fix:     "check permissions on the cache directory",
+		}}
+	}
+	artifactBytes := estimatedBuildArtifactBytes(config, artifacts)
+
+	var downloadBytes int64
+	planner := newBuildPlanner()
+	for _, dep := range webFileDependenciesForVMConfig(config) {
+		planned := planner.planDependency(dep)
+		if !planned.Cached && planned.SizeBytes > 0 {
+			downloadBytes += planned.SizeBytes
+		}
+	}
+
+	dirs := GetDirectoriesInstance().GetDirectories()
+	requirements := []struct {
+		label    string
+		path     string
+		required int64
+	}{
+		{"cache dir", dirs.CacheDir, downloadBytes + artifactBytes},
+		{"Packer cache dir", dirs.PackerCacheDir, packerCacheMinFreeBytes},
+	}
+	if buildEngineUsesVnc(config.VirtualizationEngine) {
+		requirements = append(requirements, struct {
+			label    string
+			path     string
+			required int64
+		}{"QEMU build output dir", getQemuBuildOutputDir(config), artifactBytes})
+	}
+
+	var problems []preflightProblem
+	for _, requirement := range requirements {
+		free, err := preflightFreeDiskBytes(nearestExistingDir(requirement.path))
+		if err != nil {
+			log.Printf("Could not determine free space for %s %s: %v", requirement.label, requirement.path, err)
+			continue
+		}
+		if int64(free) < requirement.required { // #nosec G115 -- free space on real filesystems is far below MaxInt64.
+			problems = append(problems, preflightProblem{
+				problem: fmt.Sprintf("not enough free space in %s %s: %s free, about %s required", requirement.label, requirement.path, FormatByteSize(int64(free)), FormatByteSize(requirement.required)), // #nosec G115 -- see above.
+				fix:     fmt.Sprintf("free up space on that filesystem or set %s to an app data directory on a larger disk", devAlchemyAppDataEnvVar),
+			})
+		}
+	}
+	return problems
+}
+
+func preflightExecutables(config VirtualMachineConfig) []preflightProblem {
+	required := []string{packerExecutable}
+	if buildEngineUsesVnc(config.VirtualizationEngine) {
+		required = append(required, "qemu-img", QemuSystemExecutable(config.Arch))
+		if hostSupportsVncRecording(runtime.GOOS) {
+			required = append(required, vncRecordingFfmpegExecutable, vncRecordingSnapshotExecutable)
+		}
+	}
+
+	var problems []preflightProblem
+	for _, executable := range required {
+		if _, err := preflightLookPath(executable); err != nil {
+			problems = append(problems, preflightProblem{
+				problem: fmt.Sprintf("required executable %q was not found on PATH", executable),
+				fix:     installHint(executable),
+			})
+		}
+	}
+	return problems
+}
+
+func preflightVirtualization(config VirtualMachineConfig) []preflightProblem {
+	if config.HostOs != HostOsLinux || config.VirtualizationEngine != VirtualizationEngineQemu {
+		return nil
+	}
+	if config.Arch != preflightHostArch || isTruthyEnv(os.Getenv(forceSoftwareEmulationEnvVar)) {
+		// Cross-architecture builds always use software emulation.
+		return nil
+	}
+
+	err := preflightKvmAccess()
+	if err == nil {
+		return nil
+	}
+
+	fix := fmt.Sprintf("add your user to the kvm group (sudo usermod -aG kvm $USER, then log in again), or set %s=1 to accept a much slower software-emulated build", forceSoftwareEmulationEnvVar)
+	if errors.Is(err, os.ErrNotExist) {
+		fix = fmt.Sprintf("load the kvm_intel or kvm_amd kernel module and enable virtualization in the firmware, or set %s=1 to accept a much slower software-emulated build", forceSoftwareEmulationEnvVar)
+		if preflightHostVirtualized() {
+			fix = fmt.Sprintf("this host is itself a VM; enable nested virtualization on its hypervisor, or set %s=1 to accept a much slower software-emulated build", forceSoftwareEmulationEnvVar)
+		}
+	}
+	return []preflightProblem{{
+		problem: fmt.Sprintf("KVM is not usable: %v", err),
+		fix:     fix,
+	}}
+}
+
+func preflightMemory(config VirtualMachineConfig) []preflightProblem {
+	availableMB, err := preflightAvailableMemoryMB()
+	if err != nil || availableMB == 0 {
+		return nil
+	}
+	requiredMB := GetVmMemoryMB(config)
+	if override, err := strconv.Atoi(config.PackerVars["memory"]); err == nil && override > 0 {
+		requiredMB = override
+	}
+	if availableMB >= uint64(requiredMB) { // #nosec G115 -- VM memory is always positive.
+		return nil
+	}
+	return []preflightProblem{{
+		problem: fmt.Sprintf("the VM needs %d MB of memory but only %d MB is available on the host", requiredMB, availableMB),
+		fix:     "close memory-heavy applications or lower the VM memory with --var memory=<MB>",
+	}}
+}
+
+// QemuHardwareAccelerationAvailable reports whether a QEMU guest for config
+// can use KVM on this host. Cross-architecture guests and hosts with
+// DEV_ALCHEMY_QEMU_FORCE_SOFTWARE_EMULATION set use software emulation.
+func QemuHardwareAccelerationAvailable(config VirtualMachineConfig) bool {
+	if config.Arch != preflightHostArch || isTruthyEnv(os.Getenv(forceSoftwareEmulationEnvVar)) {
+		return false
+	}
+	return preflightKvmAccess() == nil
+}
+
+// QemuSystemExecutable returns the qemu-system binary that emulates arch.
+func QemuSystemExecutable(arch string) string {
+	if arch == "arm64" {
+		return "qemu-system-aarch64"
+	}
+	return "qemu-system-x86_64"
+}
+
+func installHint(executable string) string {
+	switch runtime.GOOS {
+	case "darwin":
+		return fmt.Sprintf("install it with Homebrew (brew install %s) and make sure it is on PATH", brewFormula(executable))
+	case "windows":
+		return fmt.Sprintf("install %s and make sure it is on PATH", executable)
+	default:
+		return fmt.Sprintf("install %s with your package manager and make sure it is on PATH", executable)
+	}
+}
+
+func brewFormula(executable string) string {
+	switch {
+	case strings.HasPrefix(executable, "qemu-"):
+		return "qemu"
+	case executable == packerExecutable:
+		return "hashicorp/tap/packer"
+	default:
+		return executable
+	}
+}
+
+func hostLooksVirtualized() bool {
+	content, err := os.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "flags") && strings.Contains(" "+line+" ", " hypervisor ") {
+			return true
+		}
+	}
+	return false
+}
+
+func nearestExistingDir(path string) string {
+	for {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+func isTruthyEnv(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "true", "yes", "on":
+		return true
+	default:
+		return false
+	}
+}
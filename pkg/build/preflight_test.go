@@ -0,0 +1,148 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type preflightStub struct {
+	freeBytes   uint64
+	missing     map[string]bool
+	kvmErr      error
+	virtualized bool
+	availableMB uint64
+}
+
+func stubPreflight(t *testing.T, stub preflightStub) {
+	t.Helper()
+	withRepoProjectDir(t)
+	withPlanCacheDir(t)
+	stubBuildPlanProbes(t, nil, 3<<30)
+
+	previousLookPath := preflightLookPath
+	previousFreeDiskBytes := preflightFreeDiskBytes
+	previousAvailableMemoryMB := preflightAvailableMemoryMB
+	previousKvmAccess := preflightKvmAccess
+	previousHostVirtualized := preflightHostVirtualized
+	previousHostArch := preflightHostArch
+	t.Cleanup(func() {
+		preflightLookPath = previousLookPath
+		preflightFreeDiskBytes = previousFreeDiskBytes
+		preflightAvailableMemoryMB = previousAvailableMemoryMB
+		preflightKvmAccess = previousKvmAccess
+		preflightHostVirtualized = previousHostVirtualized
+		preflightHostArch = previousHostArch
+	})
+
+	preflightLookPath = func(name string) (string, error) {
+		if stub.missing[name] {
+			return "", errors.New("not found")
+		}
+		return "/usr/bin/" + name, nil
+	}
+	preflightFreeDiskBytes = func(string) (uint64, error) { return stub.freeBytes, nil }
+	preflightAvailableMemoryMB = func() (uint64, error) { return stub.availableMB, nil }
+	preflightKvmAccess = func() error { return stub.kvmErr }
+	preflightHostVirtualized = func() bool { return stub.virtualized }
+	preflightHostArch = "amd64"
+}
+
+func preflightUbuntuConfig(t *testing.T) VirtualMachineConfig {
+	config := linuxUbuntuServerConfig()
+	config.MemoryMB = 4096
+	config.ExpectedBuildArtifacts = []string{filepath.Join(t.TempDir(), "ubuntu.qcow2")}
+	return config
+}
+
+func TestRunBuildPreflightPassesOnCapableHost(t *testing.T) {
+	stubPreflight(t, preflightStub{freeBytes: 100 << 30, availableMB: 16384})
+
+	if err := runBuildPreflight(preflightUbuntuConfig(t)); err != nil {
+		t.Fatalf("expected preflight to pass, got %v", err)
+	}
+}
+
+func TestRunBuildPreflightReportsEveryProblemWithFixes(t *testing.T) {
+	stubPreflight(t, preflightStub{
+		freeBytes:   5 << 30,
+		missing:     map[string]bool{"qemu-img": true, "vncsnapshot": true},
+		kvmErr:      fmt.Errorf("/dev/kvm: %w", os.ErrNotExist),
+		virtualized: true,
+		availableMB: 2048,
+	})
+
+	err := runBuildPreflight(preflightUbuntuConfig(t))
+	if err == nil {
+		t.Fatal("expected preflight to fail")
+	}
+	for _, want := range []string{
+		"build preflight failed for ubuntu/server/amd64 (qemu)",
+		"not enough free space in cache dir",
+		"about 11.0 GiB required",
+		`required executable "qemu-img" was not found`,
+		`required executable "vncsnapshot" was not found`,
+		"enable nested virtualization",
+		"DEV_ALCHEMY_QEMU_FORCE_SOFTWARE_EMULATION=1",
+		"needs 4096 MB of memory but only 2048 MB is available",
+		"--skip-preflight",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("expected preflight error to contain %q, got:\n%s", want, err.Error())
+		}
+	}
+}
+
+func TestRunBuildPreflightSkipsWhenRequested(t *testing.T) {
+	stubPreflight(t, preflightStub{missing: map[string]bool{"packer": true}})
+
+	config := preflightUbuntuConfig(t)
+	config.SkipPreflight = true
+	if err := runBuildPreflight(config); err != nil {
+		t.Fatalf("expected skipped preflight to pass, got %v", err)
+	}
+}
+
+func TestPreflightVirtualizationHintsAtKvmGroupForPermissionErrors(t *testing.T) {
+	stubPreflight(t, preflightStub{kvmErr: fmt.Errorf("/dev/kvm: %w", os.ErrPermission)})
+
+	problems := preflightVirtualization(preflightUbuntuConfig(t))
+	if len(problems) != 1 || !strings.Contains(problems[0].fix, "kvm group") {
+		t.Fatalf("expected kvm group hint, got %+v", problems)
+	}
+}
+
+func TestPreflightVirtualizationSkipsSoftwareEmulatedBuilds(t *testing.T) {
+	stubPreflight(t, preflightStub{kvmErr: os.ErrNotExist})
+
+	crossArch := preflightUbuntuConfig(t)
+	crossArch.Arch = "arm64"
+	if problems := preflightVirtualization(crossArch); len(problems) != 0 {
+		t.Fatalf("expected cross-architecture build to skip KVM check, got %+v", problems)
+	}
+
+	t.Setenv(forceSoftwareEmulationEnvVar, "true")
+	if problems := preflightVirtualization(preflightUbuntuConfig(t)); len(problems) != 0 {
+		t.Fatalf("expected forced software emulation to skip KVM check, got %+v", problems)
+	}
+}
+
+func TestPreflightMemoryHonorsPackerMemoryOverride(t *testing.T) {
+	stubPreflight(t, preflightStub{availableMB: 3000})
+
+	config := preflightUbuntuConfig(t)
+	config.PackerVars = map[string]string{"memory": "2048"}
+	if problems := preflightMemory(config); len(problems) != 0 {
+		t.Fatalf("expected --var memory override to satisfy the memory check, got %+v", problems)
+	}
+}
+
+func TestNearestExistingDirWalksUpToExistingParent(t *testing.T) {
+	root := t.TempDir()
+	if got := nearestExistingDir(filepath.Join(root, "missing", "nested")); got != root {
+		t.Fatalf("expected %q, got %q", root, got)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build unix
+
+package build
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+func kvmDeviceAccess() error {
+	if _, err := os.Stat(kvmDevicePath); err != nil {
+		return fmt.Errorf("%s: %w", kvmDevicePath, err)
+	}
+	if err := unix.Access(kvmDevicePath, unix.R_OK|unix.W_OK); err != nil {
+		return fmt.Errorf("%s is not readable and writable by the current user: %w", kvmDevicePath, err)
+	}
+	return nil
+}
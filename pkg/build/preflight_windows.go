@@ -0,0 +1,11 @@
+//go:build windows
+
+package build
+
+import "errors"
+
+// kvmDeviceAccess is never reached on Windows because KVM checks only run for
+// Linux QEMU targets.
+func kvmDeviceAccess() error {
+	return errors.New("KVM is not available on Windows")
+}
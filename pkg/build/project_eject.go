@@ -0,0 +1,175 @@
+package build
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	runtimeassets "github.com/csautter/dev-alchemy"
+)
+
+// ProjectFileStatus describes how a project file differs from the embedded
+// project assets.
+type ProjectFileStatus string
+
+const (
+	ProjectFileModified ProjectFileStatus = "modified"
+	// ProjectFileAdded is a file on disk inside an embedded directory that the
+	// embedded assets do not have.
+	ProjectFileAdded   ProjectFileStatus = "added"
+	ProjectFileMissing ProjectFileStatus = "missing"
+)
+
+// ProjectFileChange is one file that differs between the project dir and
+// the embedded project assets. Path uses slashes.
+type ProjectFileChange struct {
+	Path   string
+	Status ProjectFileStatus
+}
+
+// EjectedProject reports the result of EjectProject.
+type EjectedProject struct {
+	Dir        string
+	Files      int
+	ConfigPath string
+	// OverriddenBy names the environment variable that selects another
+	// project dir and wins over the recorded one.
+	OverriddenBy string
+}
+
+// EjectProject writes an editable copy of the embedded project assets, with
+// the project overlay applied, to dir, which must be empty or missing, and
+// records it as project_dir in directories.yml so later runs use it instead
+// of the git checkout or the embedded project.
+func EjectProject(dir string) (EjectedProject, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return EjectedProject{}, err
+	}
+	if empty, err := isEmptyOrMissingDir(dir); err != nil {
+		return EjectedProject{}, err
+	} else if !empty {
+		return EjectedProject{}, fmt.Errorf("eject target %q must be empty", dir)
+	}
+	directories := GetDirectoriesInstance()
+	directories.GetDirectories()
+	candidate := *directories
+	candidate.ProjectDir = dir
+	if err := validateDataDirs(candidate); err != nil {
+		return EjectedProject{}, err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil { // #nosec G301 -- the ejected project is a user-owned source tree.
+		return EjectedProject{}, fmt.Errorf("create ejected project %q: %w", dir, err)
+	}
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return EjectedProject{}, fmt.Errorf("open ejected project %q: %w", dir, err)
+	}
+	defer root.Close()
+	source, err := newOverlayFS(runtimeassets.FS(), directories.ProjectOverlayDir)
+	if err != nil {
+		return EjectedProject{}, err
+	}
+	result, err := syncEmbeddedProject(source, root, embeddedProjectSync{})
+	if err != nil {
+		return EjectedProject{}, fmt.Errorf("write ejected project %q: %w", dir, err)
+	}
+
+	ejected := EjectedProject{Dir: dir, Files: len(result.files), ConfigPath: DataDirsConfigPath(directories)}
+	if err := setDirectoriesConfigValue(ejected.ConfigPath, projectDirConfigKey, dir); err != nil {
+		return ejected, fmt.Errorf("record project dir in %q: %w", ejected.ConfigPath, err)
+	}
+	if strings.TrimSpace(os.Getenv(devAlchemyProjectEnvVar)) != "" {
+		ejected.OverriddenBy = devAlchemyProjectEnvVar
+	} else {
+		directories.ProjectDir = dir
+	}
+	return ejected, nil
+}
+
+// ProjectDiff compares projectDir with the embedded project assets. Files on
+// disk only count as added inside the embedded directories, so a git
+// checkout does not report its Go sources.
+func ProjectDiff(projectDir string) ([]ProjectFileChange, error) {
+	return projectDiff(runtimeassets.FS(), runtimeassets.EmbeddedRoots, projectDir)
+}
+
+// ProjectFilePatch returns a unified diff from the embedded version of the
+// slash path to the one in projectDir. A missing side counts as empty.
+func ProjectFilePatch(projectDir string, slashPath string) (string, error) {
+	return projectFilePatch(runtimeassets.FS(), projectDir, slashPath)
+}
+
+func projectDiff(source fs.FS, roots []string, projectDir string) ([]ProjectFileChange, error) {
+	var changes []ProjectFileChange
+	embedded := map[string]bool{}
+	err := fs.WalkDir(source, ".", func(slashPath string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		embedded[slashPath] = true
+		if entry.IsDir() {
+			return nil
+		}
+		want, err := fs.ReadFile(source, slashPath)
+		if err != nil {
+			return err
+		}
+		got, err := os.ReadFile(filepath.Join(projectDir, filepath.FromSlash(slashPath))) // #nosec G304 -- the path is an embedded asset path below the project dir.
+		switch {
+		case errors.Is(err, fs.ErrPermission):
+			return fmt.Errorf("read project file %s: %w", slashPath, err)
+		case err != nil:
+			// Not there, or replaced by a directory.
+			changes = append(changes, ProjectFileChange{Path: slashPath, Status: ProjectFileMissing})
+		case string(got) != string(want):
+			changes = append(changes, ProjectFileChange{Path: slashPath, Status: ProjectFileModified})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, root := range roots {
+		rootPath := filepath.Join(projectDir, filepath.FromSlash(root))
+		err := walkCache(rootPath, func(localPath string, entry fs.DirEntry) error {
+			relativePath, err := filepath.Rel(projectDir, localPath)
+			if err != nil {
+				return err
+			}
+			slashPath := filepath.ToSlash(relativePath)
+			if embedded[slashPath] || entry.IsDir() {
+				return nil
+			}
+			changes = append(changes, ProjectFileChange{Path: slashPath, Status: ProjectFileAdded})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes, nil
+}
+
+func projectFilePatch(source fs.FS, projectDir string, slashPath string) (string, error) {
+	want, err := fs.ReadFile(source, slashPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	got, err := os.ReadFile(filepath.Join(projectDir, filepath.FromSlash(slashPath))) // #nosec G304 -- the path comes from ProjectDiff below the project dir.
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	return unifiedDiff(path.Join("embedded", slashPath), path.Join("project", slashPath), want, got), nil
+}
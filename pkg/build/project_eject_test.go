@@ -0,0 +1,98 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestProjectDiffReportsModifiedAddedAndMissingFiles(t *testing.T) {
+	source := fstest.MapFS{
+		"ansible.cfg":               {Data: []byte("[defaults]\n")},
+		"roles/base/tasks/main.yml": {Data: []byte("- debug: {}\n")},
+		"roles/base/README.md":      {Data: []byte("base\n")},
+	}
+	projectDir := t.TempDir()
+	writeDependencyFile(t, filepath.Join(projectDir, "ansible.cfg"), "[defaults]\n")
+	writeDependencyFile(t, filepath.Join(projectDir, "roles", "base", "tasks", "main.yml"), "- debug: {msg: hi}\n")
+	writeDependencyFile(t, filepath.Join(projectDir, "roles", "base", "files", "extra.txt"), "extra")
+	writeDependencyFile(t, filepath.Join(projectDir, "cmd", "main.go"), "package main")
+
+	changes, err := projectDiff(source, []string{"ansible.cfg", "roles"}, projectDir)
+	if err != nil {
+		t.Fatalf("projectDiff returned error: %v", err)
+	}
+	want := []ProjectFileChange{
+		{Path: "roles/base/README.md", Status: ProjectFileMissing},
+		{Path: "roles/base/files/extra.txt", Status: ProjectFileAdded},
+		{Path: "roles/base/tasks/main.yml", Status: ProjectFileModified},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("expected %+v, got %+v", want, changes)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Fatalf("expected %+v, got %+v", want, changes)
+		}
+	}
+
+	patch, err := projectFilePatch(source, projectDir, "roles/base/tasks/main.yml")
+	if err != nil {
+		t.Fatalf("projectFilePatch returned error: %v", err)
+	}
+	if !strings.Contains(patch, "-- debug: {}\n+- debug: {msg: hi}\n") {
+		t.Fatalf("unexpected patch:\n%s", patch)
+	}
+}
+
+func TestEjectProjectWritesAssetsAndRecordsProjectDir(t *testing.T) {
+	withPlanCacheDir(t)
+	dirs := GetDirectoriesInstance()
+	originalProjectDir, originalOverlayDir := dirs.ProjectDir, dirs.ProjectOverlayDir
+	dirs.ProjectOverlayDir = filepath.Join(t.TempDir(), "overlay")
+	t.Cleanup(func() {
+		dirs.ProjectDir = originalProjectDir
+		dirs.ProjectOverlayDir = originalOverlayDir
+	})
+	t.Setenv(devAlchemyProjectEnvVar, "")
+	target := filepath.Join(t.TempDir(), "project")
+
+	ejected, err := EjectProject(target)
+	if err != nil {
+		t.Fatalf("EjectProject returned error: %v", err)
+	}
+	if ejected.Files == 0 || ejected.OverriddenBy != "" {
+		t.Fatalf("unexpected eject result %+v", ejected)
+	}
+	if _, err := os.Stat(filepath.Join(target, "playbooks", "setup.yml")); err != nil {
+		t.Fatalf("expected the playbooks to be ejected: %v", err)
+	}
+	if dirs.ProjectDir != target {
+		t.Fatalf("expected the project dir to switch to %q, got %q", target, dirs.ProjectDir)
+	}
+	config, err := loadDataDirsConfig(DataDirsConfigPath(dirs))
+	if err != nil || config.ProjectDir != target {
+		t.Fatalf("expected project_dir %q to be recorded, got %+v err=%v", target, config, err)
+	}
+	if changes, err := ProjectDiff(target); err != nil || len(changes) != 0 {
+		t.Fatalf("expected a fresh eject to match the embedded assets, got %+v err=%v", changes, err)
+	}
+
+	if _, err := EjectProject(target); err == nil || !strings.Contains(err.Error(), "must be empty") {
+		t.Fatalf("expected a second eject into the same dir to be refused, got %v", err)
+	}
+}
+
+func TestResolveProjectDirPrefersEjectedProject(t *testing.T) {
+	ejected := t.TempDir()
+	got, err := resolveProjectDir(t.TempDir(), t.TempDir(), ejected, "")
+	if err != nil || got != ejected {
+		t.Fatalf("expected the ejected project %q, got %q err=%v", ejected, got, err)
+	}
+
+	if _, err := resolveProjectDir(t.TempDir(), t.TempDir(), filepath.Join(ejected, "gone"), ""); err == nil || !strings.Contains(err.Error(), projectDirConfigKey) {
+		t.Fatalf("expected a missing ejected project to point at %s, got %v", projectDirConfigKey, err)
+	}
+}
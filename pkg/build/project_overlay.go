@@ -0,0 +1,312 @@
+package build
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	runtimeassets "github.com/csautter/dev-alchemy"
+)
+
+const (
+	devAlchemyProjectOverlayEnvVar = "DEV_ALCHEMY_PROJECT_OVERLAY_DIR"
+	projectOverlayDirName          = "project-overlay"
+	// projectOverlayBaseFile records, inside the overlay dir, the hash of the
+	// embedded original each overlay file was written against.
+	projectOverlayBaseFile = ".overlay-base.sha256"
+)
+
+// ProjectOverlayStatus describes how an overlay file relates to the embedded
+// project assets.
+type ProjectOverlayStatus string
+
+const (
+	// ProjectOverlayShadows is an overlay file that replaces an embedded file.
+	ProjectOverlayShadows ProjectOverlayStatus = "shadows"
+	// ProjectOverlayOriginalChanged is an overlay file whose embedded original
+	// changed since the overlay was last accepted.
+	ProjectOverlayOriginalChanged ProjectOverlayStatus = "original changed"
+	// ProjectOverlayAdds is an overlay file without an embedded original.
+	ProjectOverlayAdds ProjectOverlayStatus = "adds"
+)
+
+// ProjectOverlayFile is one file of the project overlay dir. Path uses
+// slashes.
+type ProjectOverlayFile struct {
+	Path   string
+	Status ProjectOverlayStatus
+}
+
+// ProjectOverlays lists the files of the overlay dir and whether they shadow
+// an embedded file.
+func ProjectOverlays(overlayDir string) ([]ProjectOverlayFile, error) {
+	return projectOverlays(runtimeassets.FS(), overlayDir)
+}
+
+// AcceptProjectOverlays records the current embedded originals of the
+// overlay files below the slash path prefixes, or of all of them without
+// prefixes, so they no longer count as changed. It returns the accepted
+// paths.
+func AcceptProjectOverlays(overlayDir string, prefixes []string) ([]string, error) {
+	return acceptProjectOverlays(runtimeassets.FS(), overlayDir, prefixes)
+}
+
+func projectOverlays(source fs.FS, overlayDir string) ([]ProjectOverlayFile, error) {
+	paths, err := projectOverlayPaths(overlayDir)
+	if err != nil {
+		return nil, err
+	}
+	base, err := readProjectOverlayBase(overlayDir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]ProjectOverlayFile, 0, len(paths))
+	for _, slashPath := range paths {
+		original, err := fs.ReadFile(source, slashPath)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			files = append(files, ProjectOverlayFile{Path: slashPath, Status: ProjectOverlayAdds})
+			continue
+		case err != nil:
+			return nil, err
+		}
+		status := ProjectOverlayShadows
+		if recorded, ok := base[slashPath]; ok && recorded != contentSHA256(original) {
+			status = ProjectOverlayOriginalChanged
+		}
+		files = append(files, ProjectOverlayFile{Path: slashPath, Status: status})
+	}
+	return files, nil
+}
+
+func acceptProjectOverlays(source fs.FS, overlayDir string, prefixes []string) ([]string, error) {
+	files, err := projectOverlays(source, overlayDir)
+	if err != nil {
+		return nil, err
+	}
+	base, err := readProjectOverlayBase(overlayDir)
+	if err != nil {
+		return nil, err
+	}
+	var accepted []string
+	for _, file := range files {
+		if file.Status == ProjectOverlayAdds || !MatchesSlashPathPrefix(file.Path, prefixes) {
+			continue
+		}
+		original, err := fs.ReadFile(source, file.Path)
+		if err != nil {
+			return nil, err
+		}
+		base[file.Path] = contentSHA256(original)
+		accepted = append(accepted, file.Path)
+	}
+	if len(accepted) == 0 {
+		return nil, nil
+	}
+	return accepted, writeProjectOverlayBase(overlayDir, base)
+}
+
+// recordProjectOverlayBase records the embedded original of overlay files
+// that have no recorded original yet, and keeps the records of overlay files
+// that still exist.
+func recordProjectOverlayBase(source fs.FS, overlayDir string, files []ProjectOverlayFile) error {
+	base, err := readProjectOverlayBase(overlayDir)
+	if err != nil {
+		return err
+	}
+	updated := map[string]string{}
+	for _, file := range files {
+		if file.Status == ProjectOverlayAdds {
+			continue
+		}
+		if recorded, ok := base[file.Path]; ok {
+			updated[file.Path] = recorded
+			continue
+		}
+		original, err := fs.ReadFile(source, file.Path)
+		if err != nil {
+			return err
+		}
+		updated[file.Path] = contentSHA256(original)
+	}
+	if maps.Equal(base, updated) {
+		return nil
+	}
+	return writeProjectOverlayBase(overlayDir, updated)
+}
+
+// projectOverlayPaths returns the slash paths of the files in overlayDir,
+// or none when it does not exist.
+func projectOverlayPaths(overlayDir string) ([]string, error) {
+	if overlayDir == "" {
+		return nil, nil
+	}
+	var paths []string
+	err := walkCache(overlayDir, func(localPath string, entry fs.DirEntry) error {
+		if entry.IsDir() {
+			return nil
+		}
+		relativePath, err := filepath.Rel(overlayDir, localPath)
+		if err != nil {
+			return err
+		}
+		slashPath := filepath.ToSlash(relativePath)
+		if slashPath == projectOverlayBaseFile {
+			return nil
+		}
+		paths = append(paths, slashPath)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read project overlay %q: %w", overlayDir, err)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// projectOverlayHash returns a digest of the overlay files, or "" without
+// any, so that editing the overlay triggers a sync.
+func projectOverlayHash(overlayDir string) (string, error) {
+	paths, err := projectOverlayPaths(overlayDir)
+	if err != nil || len(paths) == 0 {
+		return "", err
+	}
+	hash := sha256.New()
+	for _, slashPath := range paths {
+		content, err := os.ReadFile(filepath.Join(overlayDir, filepath.FromSlash(slashPath))) // #nosec G304 -- the path was listed below the overlay dir.
+		if err != nil {
+			return "", fmt.Errorf("read project overlay file %s: %w", slashPath, err)
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", slashPath, len(content))
+		hash.Write(content)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// warnChangedProjectOverlays logs the overlay files whose embedded original
+// changed since they were accepted.
+func warnChangedProjectOverlays(overlayDir string, files []ProjectOverlayFile) {
+	var changed []string
+	for _, file := range files {
+		if file.Status == ProjectOverlayOriginalChanged {
+			changed = append(changed, file.Path)
+		}
+	}
+	if len(changed) == 0 {
+		return
+	}
+	log.Printf(
+		"The embedded original of %d overlay file(s) in %s changed: %s. Review them, then run `alchemy project overlays --accept` to silence this warning.",
+		len(changed), overlayDir, strings.Join(changed, ", "),
+	)
+}
+
+func readProjectOverlayBase(overlayDir string) (map[string]string, error) {
+	content, err := os.ReadFile(filepath.Join(overlayDir, projectOverlayBaseFile)) // #nosec G304 -- the base file lives in the configured overlay dir.
+	if errors.Is(err, fs.ErrNotExist) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read project overlay originals: %w", err)
+	}
+	return parseSHA256Sums(content), nil
+}
+
+func writeProjectOverlayBase(overlayDir string, base map[string]string) error {
+	basePath := filepath.Join(overlayDir, projectOverlayBaseFile)
+	tempPath := basePath + ".tmp"
+	if err := os.WriteFile(tempPath, formatSHA256Sums(base), 0o600); err != nil {
+		return fmt.Errorf("record project overlay originals: %w", err)
+	}
+	if err := os.Rename(tempPath, basePath); err != nil {
+		return fmt.Errorf("record project overlay originals: %w", err)
+	}
+	return nil
+}
+
+// MatchesSlashPathPrefix reports whether slashPath equals or lies below one of
+// prefixes. Prefixes may use backslashes and surrounding slashes; no prefixes
+// match every path.
+func MatchesSlashPathPrefix(slashPath string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		prefix = strings.Trim(strings.ReplaceAll(prefix, "\\", "/"), "/")
+		if slashPath == prefix || strings.HasPrefix(slashPath, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// newOverlayFS layers the files of overlayDir on top of base. Without an
+// overlay dir, base is returned as is.
+func newOverlayFS(base fs.FS, overlayDir string) (fs.FS, error) {
+	if overlayDir == "" {
+		return base, nil
+	}
+	info, err := os.Stat(overlayDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return base, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("inspect project overlay %q: %w", overlayDir, err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("project overlay %q is not a directory", overlayDir)
+	}
+	return overlayFS{base: base, overlay: os.DirFS(overlayDir)}, nil
+}
+
+// overlayFS serves files from overlay before base and merges directory
+// listings. The overlay base file is hidden.
+type overlayFS struct {
+	base    fs.FS
+	overlay fs.FS
+}
+
+func (o overlayFS) Open(name string) (fs.File, error) {
+	if name != projectOverlayBaseFile {
+		if file, err := o.overlay.Open(name); err == nil {
+			return file, nil
+		}
+	}
+	return o.base.Open(name)
+}
+
+func (o overlayFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	baseEntries, baseErr := fs.ReadDir(o.base, name)
+	overlayEntries, overlayErr := fs.ReadDir(o.overlay, name)
+	if baseErr != nil && overlayErr != nil {
+		return nil, baseErr
+	}
+
+	merged := map[string]fs.DirEntry{}
+	for _, entry := range baseEntries {
+		merged[entry.Name()] = entry
+	}
+	for _, entry := range overlayEntries {
+		if path.Join(name, entry.Name()) == projectOverlayBaseFile {
+			continue
+		}
+		merged[entry.Name()] = entry
+	}
+	entries := make([]fs.DirEntry, 0, len(merged))
+	for _, entry := range merged {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
@@ -0,0 +1,128 @@
+package build
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSyncEmbeddedProjectLayersOverlayFiles(t *testing.T) {
+	overlayDir := t.TempDir()
+	writeDependencyFile(t, filepath.Join(overlayDir, "build", "packer", "http", "user-data"), "custom\n")
+	writeDependencyFile(t, filepath.Join(overlayDir, "scripts", "extra", "setup.sh"), "#!/bin/sh\n")
+	writeDependencyFile(t, filepath.Join(overlayDir, projectOverlayBaseFile), "")
+
+	source, err := newOverlayFS(fstest.MapFS{
+		"build/packer/http/user-data": {Data: []byte("embedded\n")},
+		"build/packer/http/meta-data": {Data: []byte("meta\n")},
+	}, overlayDir)
+	if err != nil {
+		t.Fatalf("newOverlayFS returned error: %v", err)
+	}
+	projectDir := t.TempDir()
+	root, err := os.OpenRoot(projectDir)
+	if err != nil {
+		t.Fatalf("failed to open project root: %v", err)
+	}
+	defer root.Close()
+	if _, err := syncEmbeddedProject(source, root, embeddedProjectSync{}); err != nil {
+		t.Fatalf("syncEmbeddedProject returned error: %v", err)
+	}
+
+	for path, want := range map[string]string{
+		filepath.Join("build", "packer", "http", "user-data"): "custom\n",
+		filepath.Join("build", "packer", "http", "meta-data"): "meta\n",
+		filepath.Join("scripts", "extra", "setup.sh"):         "#!/bin/sh\n",
+	} {
+		if content, err := os.ReadFile(filepath.Join(projectDir, path)); err != nil || string(content) != want {
+			t.Fatalf("expected %s to contain %q, got %q err=%v", path, want, content, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, projectOverlayBaseFile)); !os.IsNotExist(err) {
+		t.Fatalf("expected the overlay base file to stay out of the project, got %v", err)
+	}
+}
+
+func TestProjectOverlaysTracksChangedOriginals(t *testing.T) {
+	overlayDir := t.TempDir()
+	writeDependencyFile(t, filepath.Join(overlayDir, "roles", "base", "main.yml"), "mine\n")
+	writeDependencyFile(t, filepath.Join(overlayDir, "roles", "base", "extra.yml"), "mine\n")
+	v1 := fstest.MapFS{"roles/base/main.yml": {Data: []byte("v1\n")}}
+	v2 := fstest.MapFS{"roles/base/main.yml": {Data: []byte("v2\n")}}
+
+	files, err := projectOverlays(v1, overlayDir)
+	if err != nil {
+		t.Fatalf("projectOverlays returned error: %v", err)
+	}
+	want := []ProjectOverlayFile{
+		{Path: "roles/base/extra.yml", Status: ProjectOverlayAdds},
+		{Path: "roles/base/main.yml", Status: ProjectOverlayShadows},
+	}
+	if len(files) != len(want) || files[0] != want[0] || files[1] != want[1] {
+		t.Fatalf("expected %+v, got %+v", want, files)
+	}
+	if err := recordProjectOverlayBase(v1, overlayDir, files); err != nil {
+		t.Fatalf("recordProjectOverlayBase returned error: %v", err)
+	}
+
+	files, err = projectOverlays(v2, overlayDir)
+	if err != nil || files[1].Status != ProjectOverlayOriginalChanged {
+		t.Fatalf("expected the changed original to be reported, got %+v err=%v", files, err)
+	}
+	// Recording again must not forget the original the overlay was written
+	// against.
+	if err := recordProjectOverlayBase(v2, overlayDir, files); err != nil {
+		t.Fatalf("recordProjectOverlayBase returned error: %v", err)
+	}
+	if files, _ := projectOverlays(v2, overlayDir); files[1].Status != ProjectOverlayOriginalChanged {
+		t.Fatalf("expected the change to stay reported until accepted, got %+v", files)
+	}
+
+	accepted, err := acceptProjectOverlays(v2, overlayDir, []string{"roles/base/"})
+	if err != nil || strings.Join(accepted, ",") != "roles/base/main.yml" {
+		t.Fatalf("expected main.yml to be accepted, got %v err=%v", accepted, err)
+	}
+	if files, _ := projectOverlays(v2, overlayDir); files[1].Status != ProjectOverlayShadows {
+		t.Fatalf("expected the accepted overlay to shadow again, got %+v", files)
+	}
+}
+
+func TestProjectOverlaysWithoutOverlayDir(t *testing.T) {
+	files, err := projectOverlays(fstest.MapFS{}, filepath.Join(t.TempDir(), "missing"))
+	if err != nil || len(files) != 0 {
+		t.Fatalf("expected no overlay files, got %+v err=%v", files, err)
+	}
+}
+
+func TestEnsureEmbeddedProjectDirResyncsWhenOverlayChanges(t *testing.T) {
+	appDataDir := t.TempDir()
+	overlayDir := t.TempDir()
+	if _, err := ensureEmbeddedProjectDir(appDataDir, overlayDir); err != nil {
+		t.Fatalf("ensureEmbeddedProjectDir returned error: %v", err)
+	}
+
+	writeDependencyFile(t, filepath.Join(overlayDir, "ansible.cfg"), "[defaults]\n# overlay\n")
+	projectDir, err := ensureEmbeddedProjectDir(appDataDir, overlayDir)
+	if err != nil {
+		t.Fatalf("ensureEmbeddedProjectDir returned error: %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(projectDir, "ansible.cfg")); string(content) != "[defaults]\n# overlay\n" {
+		t.Fatalf("expected the overlay to replace ansible.cfg, got %q", content)
+	}
+	base, err := readProjectOverlayBase(overlayDir)
+	if err != nil || base["ansible.cfg"] == "" {
+		t.Fatalf("expected the embedded original of ansible.cfg to be recorded, got %v err=%v", base, err)
+	}
+
+	if err := os.Remove(filepath.Join(overlayDir, "ansible.cfg")); err != nil {
+		t.Fatalf("failed to remove overlay file: %v", err)
+	}
+	if _, err := ensureEmbeddedProjectDir(appDataDir, overlayDir); err != nil {
+		t.Fatalf("ensureEmbeddedProjectDir returned error: %v", err)
+	}
+	if content, _ := os.ReadFile(filepath.Join(projectDir, "ansible.cfg")); strings.Contains(string(content), "# overlay") {
+		t.Fatal("expected removing the overlay to restore the embedded ansible.cfg")
+	}
+}
@@ -43,6 +43,21 @@ type VirtualMachineConfig struct {
 	MemoryMB int
 	Headless bool
 	Verbose  bool
+	// PackerVars are extra Packer variables passed to every packer build
+	// invocation for this target. They are validated against the variables
+	// declared by the target's template before the build starts.
+	PackerVars map[string]string
+	// PackerVarFiles are extra Packer var files passed before PackerVars.
+	PackerVarFiles []string
+	// Optimize compresses QCOW2 artifacts after a successful build, see
+	// optimizeBuildArtifacts.
+	Optimize bool
+	// SkipPreflight disables the disk, executable, KVM and memory checks
+	// RunBuildScript performs before downloading dependencies.
+	SkipPreflight bool
+	// StrictChecksums refuses to build when a web file dependency has neither
+	// a checksum in the catalog nor one recorded in the dependency lockfile.
+	StrictChecksums bool
 }
 
 func AvailableVirtualMachineConfigs() []VirtualMachineConfig {
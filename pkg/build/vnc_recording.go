@@ -21,6 +21,7 @@ import (
 const (
 	vncRecordingFfmpegExecutable   = "ffmpeg"
 	vncRecordingSnapshotExecutable = "vncsnapshot"
+	vncRecordingVideoFileName      = "qemu.vnc.mp4"
 )
 
 type VncRecordingConfig struct {
@@ -31,6 +32,16 @@ type VncRecordingConfig struct {
 	StreamedToVideo bool
 }
 
+func vncRecordingDir(vm_config VirtualMachineConfig) string {
+	return GetDirectoriesInstance().RecordingsPath(vm_config.OS, "qemu-out-"+GenerateVirtualMachineSlug(&vm_config)+"-vncsnapshot")
+}
+
+// VncRecordingVideoPath returns the OutputVideoFile that a build of vm_config
+// records its VNC session to.
+func VncRecordingVideoPath(vm_config VirtualMachineConfig) string {
+	return filepath.Join(vncRecordingDir(vm_config), vncRecordingVideoFileName)
+}
+
 func RunVncSnapshotProcess(vm_config VirtualMachineConfig, ctx context.Context, process_config RunProcessConfig, recording_config *VncRecordingConfig) context.Context {
 	// if running on windows, skip vnc snapshot
 	if runtime.GOOS == "windows" {
@@ -39,7 +50,7 @@ func RunVncSnapshotProcess(vm_config VirtualMachineConfig, ctx context.Context,
 	}
 	vnc_display := strconv.Itoa(vm_config.VncPort - 5900)
 
-	snapshot_dir := filepath.Join(GetDirectoriesInstance().GetDirectories().CacheDir, vm_config.OS, "qemu-out-"+GenerateVirtualMachineSlug(&vm_config)+"-vncsnapshot")
+	snapshot_dir := vncRecordingDir(vm_config)
 	recording_config.OutputFolder = snapshot_dir
 
 	if err := os.RemoveAll(snapshot_dir); err != nil {
@@ -51,7 +62,7 @@ func RunVncSnapshotProcess(vm_config VirtualMachineConfig, ctx context.Context,
 	}
 	snapshot_file := filepath.Join(snapshot_dir, "qemu.vnc.jpg")
 	recording_config.OutputFile = snapshot_file
-	video_file := filepath.Join(snapshot_dir, "qemu.vnc.mp4")
+	video_file := filepath.Join(snapshot_dir, vncRecordingVideoFileName)
 	recording_config.OutputVideoFile = video_file
 	recording_config.StreamedToVideo = true
 
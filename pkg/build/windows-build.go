@@ -112,6 +112,7 @@ func buildPackerArgs(config VirtualMachineConfig, packerFile string) []string {
 	if stagedArtifact, ok := firstStagedBuildArtifact(config); ok {
 		args = append(args, "-var", fmt.Sprintf("artifact_output_path=%s", stagedArtifact))
 	}
+	args = append(args, packerVarArgs(config)...)
 	args = append(args, packerFile)
 
 	return args
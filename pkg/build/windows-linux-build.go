@@ -19,6 +19,11 @@ func RunHypervUbuntuBuildOnWindows(config VirtualMachineConfig) error {
 		return fmt.Errorf("failed to initialize packer: %w", err)
 	}
 
+	return RunBuildScript(config, packerExecutable, hypervUbuntuBuildArgs(config))
+}
+
+// hypervUbuntuBuildArgs constructs the packer build arguments for Ubuntu on Hyper-V.
+func hypervUbuntuBuildArgs(config VirtualMachineConfig) []string {
 	args := []string{
 		"build",
 		"-var", fmt.Sprintf("iso_url=%s", ubuntuLiveServerISOPath("amd64", ubuntuLiveServerAMD64Version)),
@@ -27,13 +32,13 @@ func RunHypervUbuntuBuildOnWindows(config VirtualMachineConfig) error {
 		"-var", fmt.Sprintf("ubuntu_type=%s", defaultUbuntuType(config.UbuntuType)),
 		"-var", fmt.Sprintf("cpus=%s", getVmCpuCountString(config)),
 		"-var", fmt.Sprintf("memory=%d", getVmMemoryMB(config)),
-		ubuntuHypervPackerFile,
 	}
 	if stagedArtifact, ok := firstStagedBuildArtifact(config); ok {
-		args = append(args[:len(args)-1], "-var", fmt.Sprintf("artifact_output_path=%s", stagedArtifact), args[len(args)-1])
+		args = append(args, "-var", fmt.Sprintf("artifact_output_path=%s", stagedArtifact))
 	}
-
-	return RunBuildScript(config, packerExecutable, args)
+	args = append(args, packerVarArgs(config)...)
+	args = append(args, ubuntuHypervPackerFile)
+	return args
 }
 
 func defaultUbuntuType(ubuntuType string) string {
@@ -405,12 +405,30 @@ func linuxLibvirtImageDir() string {
 	if override := linuxLibvirtImageDirOverride(); override != "" {
 		return filepath.Clean(override)
 	}
+	if configured := alchemy_build.GetDirectoriesInstance().ImagesDir; configured != "" {
+		return configured
+	}
 	if linuxLibvirtUsesSystemConnection(linuxLibvirtURI()) {
 		return linuxLibvirtSystemImageDir
 	}
 	return filepath.Join(alchemy_build.GetDirectoriesInstance().AppDataDir, linuxLibvirtManagedDomainDirectory)
 }
 
+// LinuxLibvirtImageDir returns the directory that holds the managed libvirt
+// VM disks.
+func LinuxLibvirtImageDir() string {
+	return linuxLibvirtImageDir()
+}
+
+// LibvirtImageCacheRoots returns the directory that holds the VM disks
+// created from build artifacts on Linux hosts, for cache usage reports.
+func LibvirtImageCacheRoots(hostOs alchemy_build.HostOsType) []alchemy_build.CacheCategoryRoot {
+	if hostOs != alchemy_build.HostOsLinux {
+		return nil
+	}
+	return []alchemy_build.CacheCategoryRoot{{Category: alchemy_build.CacheCategoryLibvirtImages, Path: linuxLibvirtImageDir()}}
+}
+
 func linuxLibvirtImageDirOverride() string {
 	return strings.TrimSpace(os.Getenv(linuxLibvirtImageDirEnvVar))
 }
@@ -419,7 +437,7 @@ func ensureLinuxLibvirtImageDir(imageDir string) error {
 	if err := os.MkdirAll(imageDir, linuxLibvirtImageDirPermission); err != nil {
 		return err
 	}
-	if linuxLibvirtImageDirOverride() != "" {
+	if linuxLibvirtImageDirOverride() != "" || alchemy_build.GetDirectoriesInstance().ImagesDir != "" {
 		return nil
 	}
 	if err := os.Chmod(imageDir, linuxLibvirtImageDirPermission); err != nil {
@@ -144,6 +144,38 @@ func TestLinuxLibvirtImageDirUsesSystemDefaultWhenConfigured(t *testing.T) {
 	}
 }
 
+func TestLinuxLibvirtImageDirPrefersConfiguredImagesDir(t *testing.T) {
+	dirs := alchemy_build.GetDirectoriesInstance()
+	originalImagesDir := dirs.ImagesDir
+	dirs.ImagesDir = filepath.Clean("/srv/dev-alchemy/images")
+	t.Cleanup(func() {
+		dirs.ImagesDir = originalImagesDir
+	})
+	t.Setenv(linuxLibvirtURIEnvVar, "qemu:///system")
+	t.Setenv(linuxLibvirtImageDirEnvVar, "")
+
+	if got := linuxLibvirtImageDir(); got != dirs.ImagesDir {
+		t.Fatalf("expected configured images dir %q, got %q", dirs.ImagesDir, got)
+	}
+
+	t.Setenv(linuxLibvirtImageDirEnvVar, "/srv/libvirt/images")
+	if got := linuxLibvirtImageDir(); got != filepath.Clean("/srv/libvirt/images") {
+		t.Fatalf("expected %s to win over the images dir, got %q", linuxLibvirtImageDirEnvVar, got)
+	}
+}
+
+func TestLibvirtImageCacheRootsOnlyReportsLinuxHosts(t *testing.T) {
+	t.Setenv(linuxLibvirtImageDirEnvVar, "/srv/libvirt/images")
+
+	roots := LibvirtImageCacheRoots(alchemy_build.HostOsLinux)
+	if len(roots) != 1 || roots[0].Path != filepath.Clean("/srv/libvirt/images") || roots[0].Category != alchemy_build.CacheCategoryLibvirtImages {
+		t.Fatalf("expected the overridden libvirt image dir, got %+v", roots)
+	}
+	if roots := LibvirtImageCacheRoots(alchemy_build.HostOsDarwin); roots != nil {
+		t.Fatalf("expected no libvirt image dir on macOS hosts, got %+v", roots)
+	}
+}
+
 func TestLinuxLibvirtNetworkArg(t *testing.T) {
 	amd64Config := alchemy_build.VirtualMachineConfig{
 		OS:         "ubuntu",
@@ -2,11 +2,16 @@ package oci
 
 import (
 	"context"
+	"crypto/ed25519"
 	"fmt"
 	"os"
+	"path/filepath"
 	"slices"
+	"strconv"
+	"time"
 
 	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	digest "github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	oras "oras.land/oras-go/v2"
 	"oras.land/oras-go/v2/content/file"
@@ -30,12 +35,39 @@ const (
 type PushOptions struct {
 	RegistryOptions
 	Progress TransferProgress
+	// ChunkSize splits each artifact into zstd-compressed chunk layers of at
+	// most this many uncompressed bytes. Zero pushes one layer per artifact.
+	ChunkSize int64
+	// ContentDefinedChunking cuts chunks at content-defined boundaries so
+	// unchanged regions share chunk digests across image versions. ChunkSize
+	// is then the average chunk size.
+	ContentDefinedChunking bool
+	// SigningKey, when set, signs the pushed manifest and attaches the
+	// signature as a referrer.
+	SigningKey ed25519.PrivateKey
+	// BuildLogPath and RecordingPath, when set, attach the build log and the
+	// VNC recording of the build as referrers of the pushed manifest.
+	BuildLogPath  string
+	RecordingPath string
+	// AttachProvenance attaches a provenance document describing the target
+	// and the build metadata of each artifact as a referrer.
+	AttachProvenance bool
+	// Index pushes the manifest by digest and adds it to the multi-target
+	// index under the tag of the reference, replacing the entry of the same
+	// target.
+	Index bool
 }
 
 type PullOptions struct {
 	RegistryOptions
 	Progress                  TransferProgress
 	ConfirmForeignArtifactUse ForeignArtifactConfirmation
+	// StagingMaxAge is how long blobs of an interrupted pull are kept for a
+	// retry. Zero uses DefaultPullStagingMaxAge.
+	StagingMaxAge time.Duration
+	// VerificationKeys, when set, make the pull refuse an artifact without a
+	// valid signature from one of these keys before any layer is downloaded.
+	VerificationKeys []ed25519.PublicKey
 }
 
 type ArtifactFile struct {
@@ -52,6 +84,21 @@ type TransferResult struct {
 	MediaType string
 	Size      int64
 	Artifacts []ArtifactFile
+	// TransferredBytes, ReusedBytes and ResumedBytes split the blob bytes of
+	// the artifact into those sent over the network, those that the
+	// destination registry or the local cache already had, and those that an
+	// earlier, interrupted pull had already staged.
+	TransferredBytes int64
+	ReusedBytes      int64
+	ResumedBytes     int64
+	// Signature is the digest of the signature referrer that was attached on
+	// push or verified on pull.
+	Signature string
+	// Referrers lists the build log, provenance and recording referrers
+	// attached on push.
+	Referrers []Referrer
+	// Index is the digest of the multi-target index updated by the push.
+	Index string
 }
 
 func Push(ctx context.Context, vm alchemy_build.VirtualMachineConfig, reference string, opts PushOptions) (TransferResult, error) {
@@ -74,29 +121,12 @@ func Push(ctx context.Context, vm alchemy_build.VirtualMachineConfig, reference
 	}
 	defer fs.Close()
 
-	layers := make([]ocispec.Descriptor, 0, len(layout.files))
-	files := make([]ArtifactFile, 0, len(layout.files))
-	for _, artifact := range layout.files {
-		reportTransferStatus(opts.Progress, "Hashing local artifact %s", artifact.Path)
-		desc, err := fs.Add(ctx, artifact.Name, artifact.MediaType, artifact.Path)
-		if err != nil {
-			return TransferResult{}, fmt.Errorf("add artifact %s to OCI store: %w", artifact.Path, err)
-		}
-		artifact.Digest = desc.Digest.String()
-		artifact.Size = desc.Size
-		layers = append(layers, desc)
-		files = append(files, artifact)
-	}
-
-	reportTransferStatus(opts.Progress, "Packing OCI artifact manifest")
-	manifestDesc, err := oras.PackManifest(ctx, fs, oras.PackManifestVersion1_1, ArtifactType, oras.PackManifestOptions{
-		Layers:              layers,
-		ManifestAnnotations: manifestAnnotations(vm),
-	})
+	packed, err := packArtifact(ctx, vm, fs, layout, opts.ChunkSize, opts.ContentDefinedChunking, opts.Progress)
+	defer packed.cleanup()
 	if err != nil {
-		return TransferResult{}, fmt.Errorf("pack OCI artifact manifest: %w", err)
+		return TransferResult{}, err
 	}
-	if err := fs.Tag(ctx, manifestDesc, remoteRef.reference); err != nil {
+	if err := fs.Tag(ctx, packed.manifest, remoteRef.reference); err != nil {
 		return TransferResult{}, fmt.Errorf("tag local OCI artifact: %w", err)
 	}
 
@@ -107,12 +137,41 @@ func Push(ctx context.Context, vm alchemy_build.VirtualMachineConfig, reference
 	}
 
 	reportTransferStatus(opts.Progress, "Uploading OCI artifact")
-	pushedDesc, err := copyArtifact(ctx, fs, remoteRef.reference, repo, remoteRef.reference, descriptorTotal(append(layers, manifestDesc)...), opts.Progress)
+	total := descriptorTotal(append(slices.Clone(packed.layers), packed.manifest)...)
+	dstRef := remoteRef.reference
+	if opts.Index {
+		dstRef = packed.manifest.Digest.String()
+	}
+	pushedDesc, existingBytes, err := copyArtifact(ctx, fs, remoteRef.reference, repo, dstRef, total, opts.Progress)
 	if err != nil {
 		return TransferResult{}, fmt.Errorf("push OCI artifact %s: %w", reference, err)
 	}
 
-	return transferResult(reference, pushedDesc, files), nil
+	result := transferResult(reference, pushedDesc, packed.files)
+	result.TransferredBytes = total - existingBytes
+	result.ReusedBytes = existingBytes
+	if opts.SigningKey != nil {
+		reportTransferStatus(opts.Progress, "Signing OCI artifact")
+		signature, err := attachSignature(ctx, repo, pushedDesc, opts.SigningKey)
+		if err != nil {
+			return TransferResult{}, fmt.Errorf("sign OCI artifact %s: %w", reference, err)
+		}
+		result.Signature = signature.Digest.String()
+	}
+	referrers, err := attachBuildReferrers(ctx, repo, vm, pushedDesc, packed.files, opts)
+	if err != nil {
+		return TransferResult{}, fmt.Errorf("attach referrers to OCI artifact %s: %w", reference, err)
+	}
+	result.Referrers = referrers
+	if opts.Index {
+		reportTransferStatus(opts.Progress, "Updating OCI index %s", reference)
+		indexDesc, err := updateArtifactIndex(ctx, repo, remoteRef.reference, pushedDesc, vm)
+		if err != nil {
+			return TransferResult{}, err
+		}
+		result.Index = indexDesc.Digest.String()
+	}
+	return result, nil
 }
 
 func Pull(ctx context.Context, vm alchemy_build.VirtualMachineConfig, reference string, opts PullOptions) (TransferResult, error) {
@@ -139,43 +198,181 @@ func Pull(ctx context.Context, vm alchemy_build.VirtualMachineConfig, reference
 		return TransferResult{}, err
 	}
 	manifestDesc := remoteManifest.descriptor
+	var signature ocispec.Descriptor
+	if len(opts.VerificationKeys) > 0 {
+		reportTransferStatus(opts.Progress, "Verifying OCI artifact signature")
+		signature, err = verifySignature(ctx, repo, manifestDesc, opts.VerificationKeys)
+		if err != nil {
+			return TransferResult{}, err
+		}
+	}
 
 	if err := os.MkdirAll(layout.root, 0o700); err != nil {
 		return TransferResult{}, fmt.Errorf("create artifact root %s: %w", layout.root, err)
 	}
 	reportTransferStatus(opts.Progress, "Preparing OCI pull staging directory")
+	staging, err := openPullStaging(layout.root, opts.StagingMaxAge)
+	if err != nil {
+		return TransferResult{}, err
+	}
 	stagingRoot, err := os.MkdirTemp(layout.root, ".dev-alchemy-oci-pull-*")
 	if err != nil {
 		return TransferResult{}, fmt.Errorf("create OCI pull staging directory: %w", err)
 	}
 	defer os.RemoveAll(stagingRoot)
 
-	fs, err := file.New(stagingRoot)
+	reuse, err := planChunkReuse(ctx, remoteManifest.layers, layout.files, opts.Progress)
 	if err != nil {
-		return TransferResult{}, fmt.Errorf("create OCI file store: %w", err)
+		return TransferResult{}, err
 	}
-	defer fs.Close()
+	downloadLayers := slices.DeleteFunc(slices.Clone(remoteManifest.layers), func(layer ocispec.Descriptor) bool {
+		_, ok := reuse[layer.Digest]
+		return ok
+	})
+	total := descriptorTotal(downloadLayers...)
+	reusedBytes := descriptorTotal(remoteManifest.layers...) - total
 
 	reportTransferStatus(opts.Progress, "Downloading OCI artifact")
-	if _, err := copyArtifact(ctx, repo, remoteRef.reference, fs, "pulled", descriptorTotal(append(remoteManifest.layers, manifestDesc)...), opts.Progress); err != nil {
-		return TransferResult{}, fmt.Errorf("pull OCI artifact %s: %w", reference, err)
+	resumedBytes, err := staging.fetchAll(ctx, repo.Blobs(), downloadLayers, total, opts.Progress)
+	if err != nil {
+		return TransferResult{}, fmt.Errorf("pull OCI artifact %s: %w; run the pull again to resume", reference, err)
+	}
+	for _, layer := range downloadLayers {
+		target := filepath.Join(stagingRoot, filepath.FromSlash(layer.Annotations[ocispec.AnnotationTitle]))
+		if err := staging.materialize(layer, target); err != nil {
+			return TransferResult{}, fmt.Errorf("stage pulled layer %s: %w", layer.Annotations[ocispec.AnnotationTitle], err)
+		}
 	}
 
-	reportTransferStatus(opts.Progress, "Promoting pulled artifacts into the local cache")
-	if err := promotePulledArtifacts(stagingRoot, layout.files); err != nil {
+	pulledFiles, err := promoteStagedLayers(ctx, stagingRoot, remoteManifest.layers, layout.files, reuse, opts.Progress)
+	if err != nil {
 		return TransferResult{}, err
 	}
 
-	pulledFiles := slices.Clone(layout.files)
+	if err := staging.remove(downloadLayers); err != nil {
+		reportTransferStatus(opts.Progress, "Could not clean up OCI pull staging directory: %v", err)
+	}
+
+	result := transferResult(reference, manifestDesc, pulledFiles)
+	result.TransferredBytes = total - resumedBytes
+	result.ReusedBytes = reusedBytes
+	result.ResumedBytes = resumedBytes
+	if len(opts.VerificationKeys) > 0 {
+		result.Signature = signature.Digest.String()
+	}
+	return result, nil
+}
+
+// packedArtifact is an artifact manifest packed into a local file store.
+type packedArtifact struct {
+	manifest  ocispec.Descriptor
+	layers    []ocispec.Descriptor
+	files     []ArtifactFile
+	chunkRoot string
+}
+
+// cleanup removes the chunks written for the file store.
+func (p packedArtifact) cleanup() {
+	if p.chunkRoot != "" {
+		_ = os.RemoveAll(p.chunkRoot)
+	}
+}
+
+// packArtifact adds the artifacts of layout to fs, as one layer each or, with a
+// positive chunkSize, as chunk layers, and packs the manifest that Push
+// publishes and Export archives.
+func packArtifact(ctx context.Context, vm alchemy_build.VirtualMachineConfig, fs *file.Store, layout artifactLayout, chunkSize int64, contentDefined bool, progress TransferProgress) (packedArtifact, error) {
+	var packed packedArtifact
+	if chunkSize > 0 {
+		chunkRoot, err := os.MkdirTemp(layout.root, ".dev-alchemy-oci-push-*")
+		if err != nil {
+			return packed, fmt.Errorf("create OCI push staging directory: %w", err)
+		}
+		packed.chunkRoot = chunkRoot
+		chunking := artifactChunking{contentDefined: contentDefined, size: chunkSize}
+		packed.layers, packed.files, err = addChunkedArtifacts(ctx, fs, layout.files, chunkRoot, chunking, progress)
+		if err != nil {
+			return packed, err
+		}
+	} else {
+		for _, artifact := range layout.files {
+			reportTransferStatus(progress, "Hashing local artifact %s", artifact.Path)
+			desc, err := fs.Add(ctx, artifact.Name, artifact.MediaType, artifact.Path)
+			if err != nil {
+				return packed, fmt.Errorf("add artifact %s to OCI store: %w", artifact.Path, err)
+			}
+			artifact.Digest = desc.Digest.String()
+			artifact.Size = desc.Size
+			packed.layers = append(packed.layers, desc)
+			packed.files = append(packed.files, artifact)
+		}
+	}
+
+	reportTransferStatus(progress, "Packing OCI artifact manifest")
+	manifestDesc, err := oras.PackManifest(ctx, fs, oras.PackManifestVersion1_1, ArtifactType, oras.PackManifestOptions{
+		Layers:              packed.layers,
+		ManifestAnnotations: manifestAnnotations(vm),
+	})
+	if err != nil {
+		return packed, fmt.Errorf("pack OCI artifact manifest: %w", err)
+	}
+	packed.manifest = manifestDesc
+	return packed, nil
+}
+
+// promoteStagedLayers reassembles chunked artifacts from the layers staged in
+// stagingRoot, promotes the artifacts into the local cache, and returns them
+// with their final sizes.
+func promoteStagedLayers(ctx context.Context, stagingRoot string, layers []ocispec.Descriptor, files []ArtifactFile, reuse map[digest.Digest]localChunk, progress TransferProgress) ([]ArtifactFile, error) {
+	if err := reassembleChunkedArtifacts(ctx, stagingRoot, layers, reuse); err != nil {
+		return nil, err
+	}
+
+	reportTransferStatus(progress, "Promoting pulled artifacts into the local cache")
+	if err := promotePulledArtifacts(stagingRoot, files); err != nil {
+		return nil, err
+	}
+
+	pulledFiles := slices.Clone(files)
 	for i := range pulledFiles {
 		info, err := os.Stat(pulledFiles[i].Path)
 		if err != nil {
-			return TransferResult{}, fmt.Errorf("inspect pulled artifact %s: %w", pulledFiles[i].Path, err)
+			return nil, fmt.Errorf("inspect pulled artifact %s: %w", pulledFiles[i].Path, err)
 		}
 		pulledFiles[i].Size = info.Size()
 	}
+	return pulledFiles, nil
+}
 
-	return transferResult(reference, manifestDesc, pulledFiles), nil
+// addChunkedArtifacts compresses each artifact into chunks below chunkRoot and
+// adds them to the file store as chunk layers.
+func addChunkedArtifacts(ctx context.Context, fs *file.Store, artifacts []ArtifactFile, chunkRoot string, chunking artifactChunking, progress TransferProgress) ([]ocispec.Descriptor, []ArtifactFile, error) {
+	var layers []ocispec.Descriptor
+	files := make([]ArtifactFile, 0, len(artifacts))
+	for i, artifact := range artifacts {
+		reportTransferStatus(progress, "Compressing local artifact %s into chunks", artifact.Path)
+		dir := filepath.Join(chunkRoot, strconv.Itoa(i))
+		if err := os.Mkdir(dir, 0o700); err != nil {
+			return nil, nil, err
+		}
+		chunks, fileDigest, fileSize, err := writeArtifactChunks(ctx, artifact, dir, chunking)
+		if err != nil {
+			return nil, nil, err
+		}
+		for index, chunk := range chunks {
+			annotations := chunkAnnotations(artifact, fileDigest, fileSize, chunking, index, chunk)
+			desc, err := fs.Add(ctx, annotations[ocispec.AnnotationTitle], MediaTypeChunk, chunk.path)
+			if err != nil {
+				return nil, nil, fmt.Errorf("add chunk %d of artifact %s to OCI store: %w", index, artifact.Path, err)
+			}
+			desc.Annotations = annotations
+			layers = append(layers, desc)
+		}
+		artifact.Digest = fileDigest.String()
+		artifact.Size = fileSize
+		files = append(files, artifact)
+	}
+	return layers, files, nil
 }
 
 func transferResult(reference string, desc ocispec.Descriptor, files []ArtifactFile) TransferResult {
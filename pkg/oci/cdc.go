@@ -0,0 +1,90 @@
+package oci
+
+import (
+	"bufio"
+	"io"
+	"math/bits"
+)
+
+// Content-defined chunking cuts chunks where a gear rolling hash (as used by
+// FastCDC) of the last 64 bytes hits a pattern, so boundaries follow the data
+// rather than fixed offsets. An insertion or deletion only changes the chunks
+// around it, and unchanged regions of a rebuilt image produce identical chunks
+// and therefore identical blob digests.
+
+var gearTable = newGearTable()
+
+// newGearTable derives the gear table from splitmix64 with a fixed seed. The
+// table must never change: it decides every chunk boundary, and changing it
+// would stop chunks from matching those of previously pushed images.
+func newGearTable() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x6465762d616c6368)
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}
+
+// contentDefinedReader reads source up to the next content-defined chunk
+// boundary and then reports io.EOF until reset.
+type contentDefinedReader struct {
+	source  *bufio.Reader
+	minSize int64
+	maxSize int64
+	shift   uint
+	hash    uint64
+	size    int64
+	done    bool
+}
+
+// newContentDefinedReader returns a reader producing chunks that average
+// roughly average bytes and range from a quarter to four times that size.
+func newContentDefinedReader(source *bufio.Reader, average int64) *contentDefinedReader {
+	maskBits := max(bits.Len64(uint64(average))-1, 1)
+	return &contentDefinedReader{
+		source:  source,
+		minSize: max(average/4, 1),
+		maxSize: max(average*4, 1),
+		shift:   uint(64 - maskBits),
+	}
+}
+
+func (r *contentDefinedReader) reset() {
+	r.hash = 0
+	r.size = 0
+	r.done = false
+}
+
+func (r *contentDefinedReader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if _, err := r.source.Peek(1); err != nil {
+		return 0, err
+	}
+	window, _ := r.source.Peek(min(len(p), r.source.Buffered()))
+
+	n := 0
+	for n < len(window) {
+		r.hash = r.hash<<1 + gearTable[window[n]]
+		r.size++
+		n++
+		if r.size >= r.maxSize || (r.size >= r.minSize && r.hash>>r.shift == 0) {
+			r.done = true
+			break
+		}
+	}
+	copy(p, window[:n])
+	if _, err := r.source.Discard(n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,452 @@
+package oci
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// MediaTypeChunk is a zstd-compressed slice of a build artifact. The
+	// chunk annotations describe which artifact it belongs to and where.
+	MediaTypeChunk = "application/vnd.dev-alchemy.vm-build.chunk.v1+zstd"
+
+	AnnotationChunkFile          = "dev.alchemy.chunk.file"
+	AnnotationChunkFileMediaType = "dev.alchemy.chunk.file.media_type"
+	AnnotationChunkFileDigest    = "dev.alchemy.chunk.file.digest"
+	AnnotationChunkFileSize      = "dev.alchemy.chunk.file.size"
+	AnnotationChunkIndex         = "dev.alchemy.chunk.index"
+	AnnotationChunkOffset        = "dev.alchemy.chunk.offset"
+	AnnotationChunkSize          = "dev.alchemy.chunk.size"
+	AnnotationChunkDigest        = "dev.alchemy.chunk.digest"
+	AnnotationChunking           = "dev.alchemy.chunk.chunking"
+	AnnotationChunkTargetSize    = "dev.alchemy.chunk.target_size"
+
+	ChunkingFixed          = "fixed"
+	ChunkingContentDefined = "content-defined"
+
+	DefaultChunkSize int64 = 64 << 20
+)
+
+// artifactChunk is one compressed chunk written for push.
+type artifactChunk struct {
+	path   string
+	offset int64
+	size   int64
+	digest digest.Digest
+}
+
+// chunkedArtifact describes an artifact split into chunk layers.
+type chunkedArtifact struct {
+	file   ArtifactFile
+	digest digest.Digest
+	size   int64
+	chunks []ocispec.Descriptor
+}
+
+func chunkLayerName(name string, index int) string {
+	return fmt.Sprintf("%s.chunk-%06d.zst", name, index)
+}
+
+// artifactChunking selects how an artifact is split into chunks.
+type artifactChunking struct {
+	contentDefined bool
+	// size is the fixed chunk size, or the average size of content-defined
+	// chunks.
+	size int64
+}
+
+func (c artifactChunking) name() string {
+	if c.contentDefined {
+		return ChunkingContentDefined
+	}
+	return ChunkingFixed
+}
+
+// splitter returns a function yielding a reader over each successive chunk of
+// source.
+func (c artifactChunking) splitter(source *bufio.Reader) func() io.Reader {
+	if c.contentDefined {
+		reader := newContentDefinedReader(source, c.size)
+		return func() io.Reader {
+			reader.reset()
+			return reader
+		}
+	}
+	return func() io.Reader {
+		return io.LimitReader(source, c.size)
+	}
+}
+
+// chunkingFromLayer returns the chunking recorded on a chunk layer. Layers
+// without it cannot be matched against local chunks.
+func chunkingFromLayer(layer ocispec.Descriptor) (artifactChunking, bool) {
+	size, err := strconv.ParseInt(layer.Annotations[AnnotationChunkTargetSize], 10, 64)
+	if err != nil || size <= 0 {
+		return artifactChunking{}, false
+	}
+	switch layer.Annotations[AnnotationChunking] {
+	case ChunkingFixed:
+		return artifactChunking{size: size}, true
+	case ChunkingContentDefined:
+		return artifactChunking{contentDefined: true, size: size}, true
+	default:
+		return artifactChunking{}, false
+	}
+}
+
+// writeArtifactChunks compresses the artifact chunk by chunk into dir and
+// returns the chunks together with the digest of the whole artifact.
+func writeArtifactChunks(ctx context.Context, artifact ArtifactFile, dir string, chunking artifactChunking) ([]artifactChunk, digest.Digest, int64, error) {
+	if chunking.size <= 0 {
+		return nil, "", 0, fmt.Errorf("chunk size must be positive, got %d", chunking.size)
+	}
+	source, err := os.Open(artifact.Path) // #nosec G304 -- artifact paths come from the resolved artifact layout.
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("open artifact %s: %w", artifact.Path, err)
+	}
+	defer source.Close()
+
+	// A single encoder goroutine keeps the compressed output, and so the blob
+	// digest of an unchanged chunk, independent of the host's CPU count.
+	encoder, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, "", 0, err
+	}
+	defer encoder.Close()
+
+	buffered := bufio.NewReaderSize(source, 1<<20)
+	nextChunk := chunking.splitter(buffered)
+	fileHash := sha256.New()
+	var chunks []artifactChunk
+	var offset int64
+	for index := 0; ; index++ {
+		if err := ctx.Err(); err != nil {
+			return nil, "", 0, err
+		}
+		chunkPath := filepath.Join(dir, fmt.Sprintf("%06d.zst", index))
+		chunk, err := writeArtifactChunk(encoder, io.TeeReader(nextChunk(), fileHash), chunkPath)
+		if err != nil {
+			return nil, "", 0, fmt.Errorf("compress chunk %d of %s: %w", index, artifact.Path, err)
+		}
+		chunk.offset = offset
+		chunks = append(chunks, chunk)
+		offset += chunk.size
+		if _, err := buffered.Peek(1); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, "", 0, fmt.Errorf("read artifact %s: %w", artifact.Path, err)
+		}
+	}
+	return chunks, digest.NewDigest(digest.SHA256, fileHash), offset, nil
+}
+
+func writeArtifactChunk(encoder *zstd.Encoder, source io.Reader, chunkPath string) (artifactChunk, error) {
+	target, err := os.OpenFile(chunkPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600) // #nosec G304 -- chunkPath is inside the push staging dir.
+	if err != nil {
+		return artifactChunk{}, err
+	}
+	encoder.Reset(target)
+	chunkHash := sha256.New()
+	size, copyErr := io.Copy(encoder, io.TeeReader(source, chunkHash))
+	closeErr := encoder.Close()
+	if err := errors.Join(copyErr, closeErr, target.Close()); err != nil {
+		return artifactChunk{}, err
+	}
+	return artifactChunk{path: chunkPath, size: size, digest: digest.NewDigest(digest.SHA256, chunkHash)}, nil
+}
+
+// chunkAnnotations returns the layer annotations of chunk index of artifact.
+func chunkAnnotations(artifact ArtifactFile, fileDigest digest.Digest, fileSize int64, chunking artifactChunking, index int, chunk artifactChunk) map[string]string {
+	return map[string]string{
+		ocispec.AnnotationTitle:      chunkLayerName(artifact.Name, index),
+		AnnotationChunkFile:          artifact.Name,
+		AnnotationChunkFileMediaType: artifact.MediaType,
+		AnnotationChunkFileDigest:    fileDigest.String(),
+		AnnotationChunkFileSize:      strconv.FormatInt(fileSize, 10),
+		AnnotationChunking:           chunking.name(),
+		AnnotationChunkTargetSize:    strconv.FormatInt(chunking.size, 10),
+		AnnotationChunkIndex:         strconv.Itoa(index),
+		AnnotationChunkOffset:        strconv.FormatInt(chunk.offset, 10),
+		AnnotationChunkSize:          strconv.FormatInt(chunk.size, 10),
+		AnnotationChunkDigest:        chunk.digest.String(),
+	}
+}
+
+// chunkedArtifacts groups the chunk layers of a manifest by artifact and
+// checks that each artifact is covered by a complete, ordered set of chunks.
+func chunkedArtifacts(layers []ocispec.Descriptor) (map[string]*chunkedArtifact, error) {
+	artifacts := map[string]*chunkedArtifact{}
+	for _, layer := range layers {
+		if layer.MediaType != MediaTypeChunk {
+			continue
+		}
+		name := layer.Annotations[AnnotationChunkFile]
+		if name == "" {
+			return nil, errors.New("OCI artifact chunk layer is missing its file annotation")
+		}
+		fileDigest, err := digest.Parse(layer.Annotations[AnnotationChunkFileDigest])
+		if err != nil {
+			return nil, fmt.Errorf("OCI artifact chunk of %q has an invalid file digest: %w", name, err)
+		}
+		fileSize, err := chunkAnnotationInt(layer, AnnotationChunkFileSize)
+		if err != nil {
+			return nil, err
+		}
+		artifact, ok := artifacts[name]
+		if !ok {
+			artifact = &chunkedArtifact{
+				file:   ArtifactFile{Name: name, MediaType: layer.Annotations[AnnotationChunkFileMediaType]},
+				digest: fileDigest,
+				size:   fileSize,
+			}
+			artifacts[name] = artifact
+		}
+		if artifact.digest != fileDigest || artifact.size != fileSize || artifact.file.MediaType != layer.Annotations[AnnotationChunkFileMediaType] {
+			return nil, fmt.Errorf("OCI artifact chunks of %q disagree on the file they belong to", name)
+		}
+		artifact.chunks = append(artifact.chunks, layer)
+	}
+
+	for name, artifact := range artifacts {
+		sort.SliceStable(artifact.chunks, func(i, j int) bool {
+			a, _ := strconv.Atoi(artifact.chunks[i].Annotations[AnnotationChunkIndex])
+			b, _ := strconv.Atoi(artifact.chunks[j].Annotations[AnnotationChunkIndex])
+			return a < b
+		})
+		var offset int64
+		for index, chunk := range artifact.chunks {
+			if chunk.Annotations[AnnotationChunkIndex] != strconv.Itoa(index) {
+				return nil, fmt.Errorf("OCI artifact chunks of %q are not numbered 0 to %d", name, len(artifact.chunks)-1)
+			}
+			if chunk.Annotations[ocispec.AnnotationTitle] != chunkLayerName(name, index) {
+				return nil, fmt.Errorf("OCI artifact chunk %d of %q has title %q, expected %q", index, name, chunk.Annotations[ocispec.AnnotationTitle], chunkLayerName(name, index))
+			}
+			chunkOffset, err := chunkAnnotationInt(chunk, AnnotationChunkOffset)
+			if err != nil {
+				return nil, err
+			}
+			chunkSize, err := chunkAnnotationInt(chunk, AnnotationChunkSize)
+			if err != nil {
+				return nil, err
+			}
+			if chunkOffset != offset {
+				return nil, fmt.Errorf("OCI artifact chunk %d of %q starts at %d, expected %d", index, name, chunkOffset, offset)
+			}
+			offset += chunkSize
+		}
+		if offset != artifact.size {
+			return nil, fmt.Errorf("OCI artifact chunks of %q cover %d bytes, expected %d", name, offset, artifact.size)
+		}
+	}
+	return artifacts, nil
+}
+
+func chunkAnnotationInt(layer ocispec.Descriptor, key string) (int64, error) {
+	value, err := strconv.ParseInt(layer.Annotations[key], 10, 64)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("OCI artifact chunk layer has invalid %s annotation %q", key, layer.Annotations[key])
+	}
+	return value, nil
+}
+
+// localChunk is a chunk that already exists in a local artifact file.
+type localChunk struct {
+	path   string
+	offset int64
+	size   int64
+}
+
+// planChunkReuse finds chunk layers whose content already exists in the local
+// copy of their artifact, keyed by layer blob digest. It splits each local
+// artifact with the chunking the layers were pushed with, so unchanged regions
+// line up with the remote chunks. Reuse is an optimization: a local artifact
+// that cannot be read is skipped rather than failing the pull.
+func planChunkReuse(ctx context.Context, layers []ocispec.Descriptor, files []ArtifactFile, progress TransferProgress) (map[digest.Digest]localChunk, error) {
+	artifacts, err := chunkedArtifacts(layers)
+	if err != nil {
+		return nil, err
+	}
+	reuse := map[digest.Digest]localChunk{}
+	for _, file := range files {
+		artifact, ok := artifacts[file.Name]
+		if !ok {
+			continue
+		}
+		chunking, ok := chunkingFromLayer(artifact.chunks[0])
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(file.Path); err != nil {
+			continue
+		}
+		reportTransferStatus(progress, "Indexing local artifact %s for chunk reuse", file.Path)
+		local, err := indexLocalChunks(ctx, file.Path, chunking)
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return nil, ctxErr
+			}
+			reportTransferStatus(progress, "Skipping chunk reuse for %s: %v", file.Path, err)
+			continue
+		}
+		for _, chunk := range artifact.chunks {
+			size, _ := chunkAnnotationInt(chunk, AnnotationChunkSize)
+			if match, ok := local[digest.Digest(chunk.Annotations[AnnotationChunkDigest])]; ok && match.size == size {
+				reuse[chunk.Digest] = match
+			}
+		}
+	}
+	return reuse, nil
+}
+
+// indexLocalChunks splits the file at path with chunking and returns its
+// chunks keyed by uncompressed digest.
+func indexLocalChunks(ctx context.Context, path string, chunking artifactChunking) (map[digest.Digest]localChunk, error) {
+	source, err := os.Open(path) // #nosec G304 -- path is an expected artifact path from the resolved layout.
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+
+	buffered := bufio.NewReaderSize(source, 1<<20)
+	nextChunk := chunking.splitter(buffered)
+	chunks := map[digest.Digest]localChunk{}
+	var offset int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		chunkHash := sha256.New()
+		size, err := io.Copy(chunkHash, nextChunk())
+		if err != nil {
+			return nil, err
+		}
+		chunks[digest.NewDigest(digest.SHA256, chunkHash)] = localChunk{path: path, offset: offset, size: size}
+		offset += size
+		if _, err := buffered.Peek(1); err == io.EOF {
+			return chunks, nil
+		} else if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// reassembleChunkedArtifacts writes each chunked artifact into stagingRoot
+// from its pulled chunks, or from local chunks listed in reuse, verifies its
+// size and digest, and removes the pulled chunks.
+func reassembleChunkedArtifacts(ctx context.Context, stagingRoot string, layers []ocispec.Descriptor, reuse map[digest.Digest]localChunk) error {
+	artifacts, err := chunkedArtifacts(layers)
+	if err != nil {
+		return err
+	}
+	if len(artifacts) == 0 {
+		return nil
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return err
+	}
+	defer decoder.Close()
+
+	for name, artifact := range artifacts {
+		if err := reassembleChunkedArtifact(ctx, decoder, stagingRoot, name, artifact, reuse); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func reassembleChunkedArtifact(ctx context.Context, decoder *zstd.Decoder, stagingRoot string, name string, artifact *chunkedArtifact, reuse map[digest.Digest]localChunk) error {
+	targetPath := filepath.Join(stagingRoot, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(targetPath), 0o700); err != nil {
+		return fmt.Errorf("create directory for reassembled artifact %s: %w", name, err)
+	}
+	target, err := os.OpenFile(targetPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600) // #nosec G304 -- the name was validated against the expected artifacts.
+	if err != nil {
+		return fmt.Errorf("create reassembled artifact %s: %w", name, err)
+	}
+	fileHash := artifact.digest.Algorithm().Hash()
+	writeErr := writeChunks(ctx, decoder, stagingRoot, artifact, reuse, io.MultiWriter(target, fileHash))
+	if err := errors.Join(writeErr, target.Close()); err != nil {
+		return fmt.Errorf("reassemble artifact %s: %w", name, err)
+	}
+	if got := digest.NewDigest(artifact.digest.Algorithm(), fileHash); got != artifact.digest {
+		return fmt.Errorf("reassembled artifact %s has digest %s, expected %s", name, got, artifact.digest)
+	}
+	for _, chunk := range artifact.chunks {
+		if _, ok := reuse[chunk.Digest]; ok {
+			continue
+		}
+		if err := os.Remove(filepath.Join(stagingRoot, filepath.FromSlash(chunk.Annotations[ocispec.AnnotationTitle]))); err != nil {
+			return fmt.Errorf("remove pulled chunk of %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func writeChunks(ctx context.Context, decoder *zstd.Decoder, stagingRoot string, artifact *chunkedArtifact, reuse map[digest.Digest]localChunk, target io.Writer) error {
+	for index, chunk := range artifact.chunks {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		size, _ := chunkAnnotationInt(chunk, AnnotationChunkSize)
+		var err error
+		if local, ok := reuse[chunk.Digest]; ok {
+			err = copyLocalChunk(local, target)
+		} else {
+			err = writeChunk(decoder, filepath.Join(stagingRoot, filepath.FromSlash(chunk.Annotations[ocispec.AnnotationTitle])), size, target)
+		}
+		if err != nil {
+			return fmt.Errorf("chunk %d: %w", index, err)
+		}
+	}
+	return nil
+}
+
+func copyLocalChunk(chunk localChunk, target io.Writer) error {
+	source, err := os.Open(chunk.path) // #nosec G304 -- local chunk paths are expected artifact paths.
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	written, err := io.Copy(target, io.NewSectionReader(source, chunk.offset, chunk.size))
+	if err != nil {
+		return err
+	}
+	if written != chunk.size {
+		return fmt.Errorf("local chunk of %s is %d bytes, expected %d", chunk.path, written, chunk.size)
+	}
+	return nil
+}
+
+func writeChunk(decoder *zstd.Decoder, chunkPath string, size int64, target io.Writer) error {
+	source, err := os.Open(chunkPath) // #nosec G304 -- chunk paths are derived from validated layer titles.
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	if err := decoder.Reset(source); err != nil {
+		return err
+	}
+	// Read one byte past the expected size to detect oversized chunks
+	// without decompressing them in full.
+	written, err := io.Copy(target, io.LimitReader(decoder, size+1))
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("decompressed size differs from the expected %d bytes", size)
+	}
+	return nil
+}
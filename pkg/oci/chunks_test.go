@@ -0,0 +1,229 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestChunkedArtifactRoundTrip(t *testing.T) {
+	content := bytes.Repeat([]byte("dev-alchemy-chunk-"), 200)
+	artifact, stagingRoot := writeTestArtifact(t, "ubuntu/image.qcow2", content)
+
+	layers := stageTestChunks(t, artifact, stagingRoot, artifactChunking{size: 1000})
+	if len(layers) != 4 {
+		t.Fatalf("expected 4 chunk layers for %d bytes, got %d", len(content), len(layers))
+	}
+	if err := validateManifestLayers(ocispec.Manifest{Layers: layers}, []ArtifactFile{{Name: artifact.Name, MediaType: MediaTypeQCOW2}}); err != nil {
+		t.Fatalf("expected chunked manifest to validate, got %v", err)
+	}
+
+	if err := reassembleChunkedArtifacts(context.Background(), stagingRoot, layers, nil); err != nil {
+		t.Fatalf("failed to reassemble chunked artifact: %v", err)
+	}
+	reassembled, err := os.ReadFile(filepath.Join(stagingRoot, "ubuntu", "image.qcow2"))
+	if err != nil {
+		t.Fatalf("failed to read reassembled artifact: %v", err)
+	}
+	if !bytes.Equal(reassembled, content) {
+		t.Fatal("reassembled artifact differs from the original")
+	}
+	if _, err := os.Stat(filepath.Join(stagingRoot, filepath.FromSlash(chunkLayerName(artifact.Name, 0)))); !os.IsNotExist(err) {
+		t.Fatalf("expected pulled chunks to be removed after reassembly, got %v", err)
+	}
+}
+
+func TestChunkedArtifactEmptyFileHasOneChunk(t *testing.T) {
+	artifact, stagingRoot := writeTestArtifact(t, "image.qcow2", nil)
+
+	layers := stageTestChunks(t, artifact, stagingRoot, artifactChunking{size: 1000})
+	if len(layers) != 1 {
+		t.Fatalf("expected 1 chunk layer for an empty artifact, got %d", len(layers))
+	}
+	if err := reassembleChunkedArtifacts(context.Background(), stagingRoot, layers, nil); err != nil {
+		t.Fatalf("failed to reassemble empty artifact: %v", err)
+	}
+}
+
+func TestReassembleChunkedArtifactsRejectsDigestMismatch(t *testing.T) {
+	artifact, stagingRoot := writeTestArtifact(t, "image.qcow2", []byte("original artifact content"))
+
+	layers := stageTestChunks(t, artifact, stagingRoot, artifactChunking{size: 8})
+	for i := range layers {
+		layers[i].Annotations[AnnotationChunkFileDigest] = "sha256:" + strings.Repeat("0", 64)
+	}
+
+	err := reassembleChunkedArtifacts(context.Background(), stagingRoot, layers, nil)
+	if err == nil {
+		t.Fatal("expected digest mismatch to fail reassembly")
+	}
+	if !strings.Contains(err.Error(), "has digest") {
+		t.Fatalf("expected digest mismatch error, got %q", err.Error())
+	}
+}
+
+func TestValidateManifestLayersRejectsMissingChunk(t *testing.T) {
+	artifact, stagingRoot := writeTestArtifact(t, "image.qcow2", []byte("original artifact content"))
+
+	layers := stageTestChunks(t, artifact, stagingRoot, artifactChunking{size: 8})
+	layers = append(layers[:1], layers[2:]...)
+
+	err := validateManifestLayers(ocispec.Manifest{Layers: layers}, []ArtifactFile{{Name: artifact.Name, MediaType: MediaTypeQCOW2}})
+	if err == nil {
+		t.Fatal("expected missing chunk to fail validation")
+	}
+	if !strings.Contains(err.Error(), "are not numbered") {
+		t.Fatalf("expected chunk numbering error, got %q", err.Error())
+	}
+}
+
+func TestValidateManifestLayersRejectsChunkMediaTypeMismatch(t *testing.T) {
+	artifact, stagingRoot := writeTestArtifact(t, "image.qcow2", []byte("original artifact content"))
+
+	layers := stageTestChunks(t, artifact, stagingRoot, artifactChunking{size: 1000})
+
+	err := validateManifestLayers(ocispec.Manifest{Layers: layers}, []ArtifactFile{{Name: artifact.Name, MediaType: MediaTypeVagrantBox}})
+	if err == nil {
+		t.Fatal("expected chunk media type mismatch to fail validation")
+	}
+	if !strings.Contains(err.Error(), "has media type") {
+		t.Fatalf("expected media type error, got %q", err.Error())
+	}
+}
+
+func TestContentDefinedChunksSurviveInsertion(t *testing.T) {
+	original := testRandomContent(1, 256<<10)
+	shifted := append([]byte("inserted bytes shift every later offset"), original...)
+	chunking := artifactChunking{contentDefined: true, size: 8 << 10}
+
+	originalLayers := stageTestChunks(t, writeTestArtifactOnly(t, original), t.TempDir(), chunking)
+	shiftedLayers := stageTestChunks(t, writeTestArtifactOnly(t, shifted), t.TempDir(), chunking)
+
+	originalDigests := map[digest.Digest]bool{}
+	for _, layer := range originalLayers {
+		originalDigests[layer.Digest] = true
+	}
+	shared := 0
+	for _, layer := range shiftedLayers {
+		if originalDigests[layer.Digest] {
+			shared++
+		}
+	}
+	if shared < len(shiftedLayers)-2 {
+		t.Fatalf("expected all but the first chunks to be shared after an insertion, got %d of %d", shared, len(shiftedLayers))
+	}
+}
+
+func TestPullReusesChunksFromLocalArtifact(t *testing.T) {
+	previous := testRandomContent(2, 256<<10)
+	current := slices.Clone(previous)
+	copy(current[100<<10:], "a small change in the middle of the rebuilt image")
+	chunking := artifactChunking{contentDefined: true, size: 8 << 10}
+
+	artifact, stagingRoot := writeTestArtifact(t, "ubuntu/image.qcow2", current)
+	layers := stageTestChunks(t, artifact, stagingRoot, chunking)
+	localPath := filepath.Join(t.TempDir(), "image.qcow2")
+	if err := os.WriteFile(localPath, previous, 0o600); err != nil {
+		t.Fatalf("failed to write local artifact: %v", err)
+	}
+
+	reuse, err := planChunkReuse(context.Background(), layers, []ArtifactFile{{Name: artifact.Name, Path: localPath, MediaType: MediaTypeQCOW2}}, nil)
+	if err != nil {
+		t.Fatalf("failed to plan chunk reuse: %v", err)
+	}
+	if len(reuse) == 0 || len(reuse) >= len(layers) {
+		t.Fatalf("expected some but not all of %d chunks to be reused, got %d", len(layers), len(reuse))
+	}
+	for _, layer := range layers {
+		if _, ok := reuse[layer.Digest]; ok {
+			if err := os.Remove(filepath.Join(stagingRoot, filepath.FromSlash(layer.Annotations[ocispec.AnnotationTitle]))); err != nil {
+				t.Fatalf("failed to drop reused chunk from staging: %v", err)
+			}
+		}
+	}
+
+	if err := reassembleChunkedArtifacts(context.Background(), stagingRoot, layers, reuse); err != nil {
+		t.Fatalf("failed to reassemble artifact from reused chunks: %v", err)
+	}
+	reassembled, err := os.ReadFile(filepath.Join(stagingRoot, "ubuntu", "image.qcow2"))
+	if err != nil {
+		t.Fatalf("failed to read reassembled artifact: %v", err)
+	}
+	if !bytes.Equal(reassembled, current) {
+		t.Fatal("reassembled artifact differs from the pushed artifact")
+	}
+}
+
+func testRandomContent(seed uint64, size int) []byte {
+	content := make([]byte, size)
+	random := rand.New(rand.NewPCG(seed, seed))
+	for i := range content {
+		content[i] = byte(random.Uint32())
+	}
+	return content
+}
+
+func writeTestArtifactOnly(t *testing.T, content []byte) ArtifactFile {
+	t.Helper()
+
+	artifact, _ := writeTestArtifact(t, "image.qcow2", content)
+	return artifact
+}
+
+func writeTestArtifact(t *testing.T, name string, content []byte) (ArtifactFile, string) {
+	t.Helper()
+
+	root := t.TempDir()
+	path := filepath.Join(root, "artifact")
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatalf("failed to write artifact: %v", err)
+	}
+	stagingRoot := filepath.Join(root, "staging")
+	if err := os.Mkdir(stagingRoot, 0o700); err != nil {
+		t.Fatalf("failed to create staging dir: %v", err)
+	}
+	return ArtifactFile{Name: name, Path: path, MediaType: MediaTypeQCOW2}, stagingRoot
+}
+
+// stageTestChunks chunks artifact and places the chunks in stagingRoot the way
+// a pull into the file store would, returning their layer descriptors.
+func stageTestChunks(t *testing.T, artifact ArtifactFile, stagingRoot string, chunking artifactChunking) []ocispec.Descriptor {
+	t.Helper()
+
+	chunkDir := t.TempDir()
+	chunks, fileDigest, fileSize, err := writeArtifactChunks(context.Background(), artifact, chunkDir, chunking)
+	if err != nil {
+		t.Fatalf("failed to write artifact chunks: %v", err)
+	}
+
+	layers := make([]ocispec.Descriptor, 0, len(chunks))
+	for index, chunk := range chunks {
+		annotations := chunkAnnotations(artifact, fileDigest, fileSize, chunking, index, chunk)
+		compressed, err := os.ReadFile(chunk.path)
+		if err != nil {
+			t.Fatalf("failed to read chunk: %v", err)
+		}
+		target := filepath.Join(stagingRoot, filepath.FromSlash(annotations[ocispec.AnnotationTitle]))
+		if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+			t.Fatalf("failed to create chunk dir: %v", err)
+		}
+		if err := os.Rename(chunk.path, target); err != nil {
+			t.Fatalf("failed to stage chunk: %v", err)
+		}
+		layers = append(layers, ocispec.Descriptor{
+			MediaType:   MediaTypeChunk,
+			Digest:      digest.FromBytes(compressed),
+			Size:        int64(len(compressed)),
+			Annotations: annotations,
+		})
+	}
+	return layers
+}
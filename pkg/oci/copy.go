@@ -0,0 +1,216 @@
+package oci
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	oras "oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry"
+)
+
+type CopyOptions struct {
+	// Source and Destination are the registry settings of each side, so the
+	// copy can read and write with different credentials.
+	Source      RegistryOptions
+	Destination RegistryOptions
+	// Tags are additional tags for the copied artifact in the destination
+	// repository, such as "stable".
+	Tags     []string
+	Progress TransferProgress
+}
+
+type CopyResult struct {
+	Source      string   `json:"source"`
+	Destination string   `json:"destination"`
+	Digest      string   `json:"digest"`
+	MediaType   string   `json:"media_type"`
+	Tags        []string `json:"tags"`
+	// Referrers counts the referrers, such as signatures and build logs, that
+	// were copied with the artifact.
+	Referrers        int   `json:"referrers"`
+	TransferredBytes int64 `json:"transferred_bytes"`
+	ReusedBytes      int64 `json:"reused_bytes"`
+}
+
+// Copy copies the artifact at srcRef, a single-target manifest or a
+// multi-target index, to dstRef together with the referrers of each manifest.
+// Blobs are streamed from the source to the destination registry without
+// being stored locally; manifests are copied byte for byte, so digests and
+// annotations are preserved.
+func Copy(ctx context.Context, srcRef string, dstRef string, opts CopyOptions) (CopyResult, error) {
+	srcRemote, err := parsePullReference(srcRef)
+	if err != nil {
+		return CopyResult{}, err
+	}
+	dstRemote, err := parsePushReference(dstRef)
+	if err != nil {
+		return CopyResult{}, err
+	}
+	for _, tag := range opts.Tags {
+		if err := (registry.Reference{Reference: tag}).ValidateReferenceAsTag(); err != nil {
+			return CopyResult{}, fmt.Errorf("invalid tag %q: %w", tag, err)
+		}
+	}
+
+	reportTransferStatus(opts.Progress, "Preparing OCI registry clients")
+	src, err := newRepository(srcRemote, opts.Source)
+	if err != nil {
+		return CopyResult{}, err
+	}
+	dst, err := newRepository(dstRemote, opts.Destination)
+	if err != nil {
+		return CopyResult{}, err
+	}
+
+	reportTransferStatus(opts.Progress, "Resolving OCI artifact %s", srcRef)
+	root, err := src.Resolve(ctx, srcRemote.reference)
+	if err != nil {
+		return CopyResult{}, fmt.Errorf("resolve OCI artifact %s: %w", srcRef, err)
+	}
+	reportTransferStatus(opts.Progress, "Listing blobs and referrers of %s", root.Digest)
+	graph, err := walkArtifactGraph(ctx, src, root)
+	if err != nil {
+		return CopyResult{}, err
+	}
+
+	reportTransferStatus(opts.Progress, "Checking which blobs %s already has", dstRemote.repository)
+	var existing []ocispec.Descriptor
+	for _, node := range graph.nodes {
+		exists, err := dst.Exists(ctx, node)
+		if err != nil {
+			return CopyResult{}, fmt.Errorf("check %s in destination registry: %w", node.Digest, err)
+		}
+		if exists {
+			existing = append(existing, node)
+		}
+	}
+	total := descriptorTotal(graph.nodes...)
+	existingBytes := descriptorTotal(existing...)
+	if err := copyArtifactGraph(ctx, src, root, graph.referrers, dst, dstRemote.reference, total, opts.Progress); err != nil {
+		return CopyResult{}, fmt.Errorf("copy OCI artifact %s to %s: %w", srcRef, dstRef, err)
+	}
+
+	tags := []string{dstRemote.reference}
+	for _, tag := range opts.Tags {
+		if slices.Contains(tags, tag) {
+			continue
+		}
+		reportTransferStatus(opts.Progress, "Tagging %s as %s", root.Digest, tag)
+		if err := dst.Tag(ctx, root, tag); err != nil {
+			return CopyResult{}, fmt.Errorf("tag OCI artifact %s as %s: %w", dstRef, tag, err)
+		}
+		tags = append(tags, tag)
+	}
+
+	return CopyResult{
+		Source:           srcRef,
+		Destination:      dstRef,
+		Digest:           root.Digest.String(),
+		MediaType:        root.MediaType,
+		Tags:             tags,
+		Referrers:        len(graph.referrers),
+		TransferredBytes: total - existingBytes,
+		ReusedBytes:      existingBytes,
+	}, nil
+}
+
+// artifactGraph lists every node reachable from a root through manifest
+// successors and referrers.
+type artifactGraph struct {
+	nodes     []ocispec.Descriptor
+	referrers []ocispec.Descriptor
+}
+
+func walkArtifactGraph(ctx context.Context, src content.ReadOnlyGraphStorage, root ocispec.Descriptor) (artifactGraph, error) {
+	var graph artifactGraph
+	seen := map[digest.Digest]bool{}
+	var visit func(desc ocispec.Descriptor) error
+	visit = func(desc ocispec.Descriptor) error {
+		if seen[desc.Digest] {
+			return nil
+		}
+		seen[desc.Digest] = true
+		graph.nodes = append(graph.nodes, desc)
+		if desc.MediaType != ocispec.MediaTypeImageManifest && desc.MediaType != ocispec.MediaTypeImageIndex {
+			return nil
+		}
+
+		successors, err := content.Successors(ctx, src, desc)
+		if err != nil {
+			return fmt.Errorf("read OCI manifest %s: %w", desc.Digest, err)
+		}
+		for _, successor := range successors {
+			if err := visit(successor); err != nil {
+				return err
+			}
+		}
+		referrers, err := registry.Referrers(ctx, src, desc, "")
+		if err != nil {
+			return fmt.Errorf("list referrers of OCI artifact %s: %w", desc.Digest, err)
+		}
+		for _, referrer := range referrers {
+			if seen[referrer.Digest] {
+				continue
+			}
+			graph.referrers = append(graph.referrers, referrer)
+			if err := visit(referrer); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return graph, visit(root)
+}
+
+// copyArtifactGraph copies root under dstRef and then each referrer.
+func copyArtifactGraph(
+	ctx context.Context,
+	src oras.ReadOnlyTarget,
+	root ocispec.Descriptor,
+	referrers []ocispec.Descriptor,
+	dst oras.Target,
+	dstRef string,
+	totalBytes int64,
+	progress TransferProgress,
+) (err error) {
+	var counted sync.Map
+	if progress != nil {
+		progress = newCappedTransferProgress(progress, totalBytes)
+		progress.Start(totalBytes)
+		defer func() {
+			progress.Done(err == nil)
+		}()
+		src = progressReadOnlyTarget{
+			ReadOnlyTarget: src,
+			progress:       progress,
+		}
+	}
+	copyOptions := oras.DefaultCopyOptions
+	copyOptions.PostCopy = func(ctx context.Context, desc ocispec.Descriptor) error {
+		counted.Store(desc.Digest, true)
+		return nil
+	}
+	copyOptions.OnCopySkipped = func(ctx context.Context, desc ocispec.Descriptor) error {
+		// The subject of a referrer is skipped again after the root copy.
+		if _, loaded := counted.LoadOrStore(desc.Digest, true); loaded {
+			return nil
+		}
+		addProgress(progress, desc.Size)
+		return nil
+	}
+
+	if _, err := oras.Copy(ctx, src, root.Digest.String(), dst, dstRef, copyOptions); err != nil {
+		return err
+	}
+	for _, referrer := range referrers {
+		if err := oras.CopyGraph(ctx, src, dst, referrer, copyOptions.CopyGraphOptions); err != nil {
+			return fmt.Errorf("copy referrer %s: %w", referrer.Digest, err)
+		}
+	}
+	return nil
+}
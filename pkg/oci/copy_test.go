@@ -0,0 +1,108 @@
+package oci
+
+import (
+	"context"
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"testing"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+)
+
+func TestCopyStreamsArtifactWithReferrersAndAddsTags(t *testing.T) {
+	staging := newTestRegistry(t)
+	production := newTestRegistry(t)
+	privateKey, publicKey := testSigningKeyPair(t)
+	vm, artifactPath := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, []byte("dev-alchemy-promoted"))
+	buildLog := filepath.Join(t.TempDir(), "build.log")
+	if err := os.WriteFile(buildLog, []byte("build ok\n"), 0o644); err != nil {
+		t.Fatalf("failed to write build log: %v", err)
+	}
+	source := staging.reference("dev-alchemy/ubuntu", "nightly")
+	pushed, err := Push(context.Background(), vm, source, PushOptions{RegistryOptions: testRegistryOptions(), SigningKey: privateKey, BuildLogPath: buildLog})
+	if err != nil {
+		t.Fatalf("failed to push source artifact: %v", err)
+	}
+
+	destination := production.reference("dev-alchemy/ubuntu", "2026.10")
+	copied, err := Copy(context.Background(), source, destination, CopyOptions{
+		Source:      testRegistryOptions(),
+		Destination: testRegistryOptions(),
+		Tags:        []string{"stable"},
+	})
+	if err != nil {
+		t.Fatalf("failed to copy artifact: %v", err)
+	}
+	if copied.Digest != pushed.Digest {
+		t.Fatalf("expected the copied digest %s to match the pushed digest %s", copied.Digest, pushed.Digest)
+	}
+	if copied.Referrers != 2 || copied.TransferredBytes == 0 || copied.ReusedBytes != 0 {
+		t.Fatalf("unexpected copy result %+v", copied)
+	}
+
+	referrers, err := ListReferrers(context.Background(), production.reference("dev-alchemy/ubuntu", "stable"), testRegistryOptions())
+	if err != nil {
+		t.Fatalf("failed to list destination referrers: %v", err)
+	}
+	if len(referrers) != 2 {
+		t.Fatalf("expected signature and build log referrers in the destination, got %+v", referrers)
+	}
+
+	if err := os.Remove(artifactPath); err != nil {
+		t.Fatalf("failed to remove local artifact: %v", err)
+	}
+	pulled, err := Pull(context.Background(), vm, destination, PullOptions{RegistryOptions: testRegistryOptions(), VerificationKeys: []ed25519.PublicKey{publicKey}})
+	if err != nil {
+		t.Fatalf("failed to pull verified copy: %v", err)
+	}
+	if pulled.Digest != pushed.Digest {
+		t.Fatalf("expected pulled digest %s, got %s", pushed.Digest, pulled.Digest)
+	}
+
+	again, err := Copy(context.Background(), source, destination, CopyOptions{Source: testRegistryOptions(), Destination: testRegistryOptions()})
+	if err != nil {
+		t.Fatalf("failed to repeat copy: %v", err)
+	}
+	if again.TransferredBytes != 0 || again.ReusedBytes != copied.TransferredBytes {
+		t.Fatalf("expected a repeated copy to reuse every blob, got %+v after %+v", again, copied)
+	}
+}
+
+func TestCopyIncludesReferrersOfIndexEntries(t *testing.T) {
+	staging := newTestRegistry(t)
+	production := newTestRegistry(t)
+	privateKey, publicKey := testSigningKeyPair(t)
+	source := staging.reference("dev-alchemy/ubuntu-server", "2026.10")
+	linuxVM, linuxPath := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, []byte("linux"))
+	darwinVM, _ := testLayoutArchiveVM(t, alchemy_build.HostOsDarwin, alchemy_build.VirtualizationEngineUtm, []byte("darwin"))
+	for _, vm := range []alchemy_build.VirtualMachineConfig{linuxVM, darwinVM} {
+		if _, err := Push(context.Background(), vm, source, PushOptions{RegistryOptions: testRegistryOptions(), SigningKey: privateKey, Index: true}); err != nil {
+			t.Fatalf("failed to push %s to index: %v", vm.HostOs, err)
+		}
+	}
+
+	destination := production.reference("dev-alchemy/ubuntu-server", "2026.10")
+	copied, err := Copy(context.Background(), source, destination, CopyOptions{Source: testRegistryOptions(), Destination: testRegistryOptions()})
+	if err != nil {
+		t.Fatalf("failed to copy index: %v", err)
+	}
+	if copied.Referrers != 2 {
+		t.Fatalf("expected the signature of each index entry to be copied, got %d referrers", copied.Referrers)
+	}
+
+	if err := os.Remove(linuxPath); err != nil {
+		t.Fatalf("failed to remove local artifact: %v", err)
+	}
+	if _, err := Pull(context.Background(), linuxVM, destination, PullOptions{RegistryOptions: testRegistryOptions(), VerificationKeys: []ed25519.PublicKey{publicKey}}); err != nil {
+		t.Fatalf("failed to pull verified index entry from the copy: %v", err)
+	}
+}
+
+func TestCopyRejectsInvalidTag(t *testing.T) {
+	registry := newTestRegistry(t)
+	_, err := Copy(context.Background(), registry.reference("dev-alchemy/ubuntu", "nightly"), registry.reference("dev-alchemy/ubuntu", "stable"), CopyOptions{Tags: []string{"not a tag"}})
+	if err == nil {
+		t.Fatal("expected an invalid tag to be rejected")
+	}
+}
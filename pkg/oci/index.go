@@ -0,0 +1,274 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	oras "oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/errdef"
+)
+
+// indexEntryAnnotationKeys are the manifest annotations copied to each index
+// entry so a pull can pick its target without fetching every manifest.
+var indexEntryAnnotationKeys = []string{
+	AnnotationVMOS,
+	AnnotationVMType,
+	AnnotationVMArch,
+	AnnotationVMHostOS,
+	AnnotationVMVirtualizationEngine,
+	AnnotationVMSlug,
+}
+
+// indexEntry describes the manifest of one target in a multi-target index.
+// The platform carries the host OS and architecture; the virtualization engine
+// and guest OS are only in the annotations.
+func indexEntry(manifestDesc ocispec.Descriptor, annotations map[string]string) ocispec.Descriptor {
+	entryAnnotations := make(map[string]string, len(indexEntryAnnotationKeys))
+	for _, key := range indexEntryAnnotationKeys {
+		if value, ok := annotations[key]; ok {
+			entryAnnotations[key] = value
+		}
+	}
+	return ocispec.Descriptor{
+		MediaType:    manifestDesc.MediaType,
+		ArtifactType: ArtifactType,
+		Digest:       manifestDesc.Digest,
+		Size:         manifestDesc.Size,
+		Platform: &ocispec.Platform{
+			Architecture: annotations[AnnotationVMArch],
+			OS:           annotations[AnnotationVMHostOS],
+		},
+		Annotations: entryAnnotations,
+	}
+}
+
+// indexUpdateAttempts bounds how often updateArtifactIndex merges its entry
+// again after a concurrent push replaced the index without it.
+const indexUpdateAttempts = 3
+
+// updateArtifactIndex adds the pushed manifest of vm to the index tagged tag,
+// replacing an earlier entry for the same target. A tag that still holds a
+// single-target artifact becomes the first entry of the new index.
+//
+// Registries cannot update a tag conditionally, so a concurrent push to the
+// same tag may replace the index with one that lacks this entry. The tag is
+// resolved again after the update and the merge is retried while the entry is
+// missing. This narrows the race but cannot close it.
+func updateArtifactIndex(ctx context.Context, target oras.Target, tag string, manifestDesc ocispec.Descriptor, vm alchemy_build.VirtualMachineConfig) (ocispec.Descriptor, error) {
+	entry := indexEntry(manifestDesc, manifestAnnotations(vm))
+	for attempt := 1; ; attempt++ {
+		if err := mergeArtifactIndex(ctx, target, tag, entry); err != nil {
+			return ocispec.Descriptor{}, err
+		}
+		desc, err := target.Resolve(ctx, tag)
+		if err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("resolve OCI index %s: %w", tag, err)
+		}
+		if desc.MediaType == ocispec.MediaTypeImageIndex {
+			index, err := fetchArtifactIndex(ctx, target, desc)
+			if err != nil {
+				return ocispec.Descriptor{}, err
+			}
+			if slices.ContainsFunc(index.Manifests, func(existing ocispec.Descriptor) bool {
+				return existing.Digest == entry.Digest && indexEntryTarget(existing) == indexEntryTarget(entry)
+			}) {
+				return desc, nil
+			}
+		}
+		if attempt == indexUpdateAttempts {
+			return ocispec.Descriptor{}, fmt.Errorf("OCI index %s lost the entry for %s to concurrent pushes %d times; push again", tag, indexEntryTarget(entry), attempt)
+		}
+	}
+}
+
+// mergeArtifactIndex tags an index of the entries currently tagged tag with
+// entry added or replaced.
+func mergeArtifactIndex(ctx context.Context, target oras.Target, tag string, entry ocispec.Descriptor) error {
+	entries, err := existingIndexEntries(ctx, target, tag)
+	if err != nil {
+		return err
+	}
+	entries = slices.DeleteFunc(entries, func(existing ocispec.Descriptor) bool {
+		return indexEntryTarget(existing) == indexEntryTarget(entry)
+	})
+	entries = append(entries, entry)
+	slices.SortFunc(entries, func(a, b ocispec.Descriptor) int {
+		return strings.Compare(indexEntryTarget(a), indexEntryTarget(b))
+	})
+
+	index := ocispec.Index{
+		Versioned:    specs.Versioned{SchemaVersion: 2},
+		MediaType:    ocispec.MediaTypeImageIndex,
+		ArtifactType: ArtifactType,
+		Manifests:    entries,
+		Annotations: map[string]string{
+			ocispec.AnnotationCreated: time.Now().UTC().Format(time.RFC3339),
+		},
+	}
+	indexBytes, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	if _, err := oras.TagBytes(ctx, target, ocispec.MediaTypeImageIndex, indexBytes, tag); err != nil {
+		return fmt.Errorf("push OCI index %s: %w", tag, err)
+	}
+	return nil
+}
+
+// existingIndexEntries returns the entries of the index tagged tag, the
+// single-target artifact tagged tag as one entry, or nothing for a new tag.
+func existingIndexEntries(ctx context.Context, target oras.ReadOnlyTarget, tag string) ([]ocispec.Descriptor, error) {
+	desc, err := target.Resolve(ctx, tag)
+	if err != nil {
+		if errors.Is(err, errdef.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("resolve OCI index %s: %w", tag, err)
+	}
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageIndex:
+		index, err := fetchArtifactIndex(ctx, target, desc)
+		if err != nil {
+			return nil, err
+		}
+		return index.Manifests, nil
+	case ocispec.MediaTypeImageManifest:
+		manifestBytes, err := content.FetchAll(ctx, target, desc)
+		if err != nil {
+			return nil, fmt.Errorf("fetch OCI artifact manifest %s: %w", tag, err)
+		}
+		var manifest ocispec.Manifest
+		if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+			return nil, fmt.Errorf("decode OCI artifact manifest %s: %w", tag, err)
+		}
+		if manifest.ArtifactType != ArtifactType {
+			return nil, fmt.Errorf("OCI tag %s holds artifact type %q, expected %q", tag, manifest.ArtifactType, ArtifactType)
+		}
+		return []ocispec.Descriptor{indexEntry(desc, manifest.Annotations)}, nil
+	default:
+		return nil, fmt.Errorf("OCI tag %s holds unsupported media type %q", tag, desc.MediaType)
+	}
+}
+
+func fetchArtifactIndex(ctx context.Context, target content.Fetcher, desc ocispec.Descriptor) (ocispec.Index, error) {
+	indexBytes, err := content.FetchAll(ctx, target, desc)
+	if err != nil {
+		return ocispec.Index{}, fmt.Errorf("fetch OCI index %s: %w", desc.Digest, err)
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(indexBytes, &index); err != nil {
+		return ocispec.Index{}, fmt.Errorf("decode OCI index %s: %w", desc.Digest, err)
+	}
+	if index.ArtifactType != ArtifactType {
+		return ocispec.Index{}, fmt.Errorf("OCI index %s has artifact type %q, expected %q", desc.Digest, index.ArtifactType, ArtifactType)
+	}
+	return index, nil
+}
+
+// selectIndexManifest returns the index entry for vm, or an entry of a
+// compatible foreign darwin/linux target when the index has no exact match.
+// The foreign artifact is confirmed later, when its manifest is validated.
+func selectIndexManifest(ctx context.Context, target content.Fetcher, indexDesc ocispec.Descriptor, vm alchemy_build.VirtualMachineConfig) (ocispec.Descriptor, error) {
+	index, err := fetchArtifactIndex(ctx, target, indexDesc)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	if entry, ok := exactIndexEntry(index.Manifests, vm); ok {
+		return entry, nil
+	}
+	if entry, ok := foreignIndexEntry(index.Manifests, vm); ok {
+		return entry, nil
+	}
+	return ocispec.Descriptor{}, noIndexEntryError(index.Manifests, vm)
+}
+
+func exactIndexEntry(entries []ocispec.Descriptor, vm alchemy_build.VirtualMachineConfig) (ocispec.Descriptor, bool) {
+	for _, entry := range entries {
+		if validateManifestTarget(ocispec.Manifest{Annotations: entry.Annotations}, vm) == nil {
+			return entry, true
+		}
+	}
+	return ocispec.Descriptor{}, false
+}
+
+func foreignIndexEntry(entries []ocispec.Descriptor, vm alchemy_build.VirtualMachineConfig) (ocispec.Descriptor, bool) {
+	for _, entry := range entries {
+		if _, ok := compatibleForeignArtifact(ocispec.Manifest{Annotations: entry.Annotations}, vm); ok {
+			return entry, true
+		}
+	}
+	return ocispec.Descriptor{}, false
+}
+
+func noIndexEntryError(entries []ocispec.Descriptor, vm alchemy_build.VirtualMachineConfig) error {
+	return fmt.Errorf(
+		"OCI index has no entry for OS=%s, Type=%s, Arch=%s, HostOS=%s, Engine=%s; available targets: %s",
+		vm.OS, vm.UbuntuType, vm.Arch, vm.HostOs, vm.VirtualizationEngine, indexEntryTargets(entries),
+	)
+}
+
+// indexEntryTarget names the target of an index entry, such as
+// "ubuntu-server-amd64 on darwin/utm". The slug alone does not include the
+// host OS and engine.
+func indexEntryTarget(entry ocispec.Descriptor) string {
+	return fmt.Sprintf("%s on %s/%s", entry.Annotations[AnnotationVMSlug], entry.Annotations[AnnotationVMHostOS], entry.Annotations[AnnotationVMVirtualizationEngine])
+}
+
+func indexEntryTargets(entries []ocispec.Descriptor) string {
+	targets := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		targets = append(targets, indexEntryTarget(entry))
+	}
+	return strings.Join(targets, ", ")
+}
+
+// ResolveIndexTarget picks the target a pull of the multi-target index at
+// reference should use. candidates are the local targets in order of
+// preference; the first with an exact index entry wins, then the first with a
+// compatible foreign entry. isIndex is false when reference is not an index.
+func ResolveIndexTarget(ctx context.Context, reference string, opts RegistryOptions, candidates []alchemy_build.VirtualMachineConfig) (vm alchemy_build.VirtualMachineConfig, isIndex bool, err error) {
+	remoteRef, err := parsePullReference(reference)
+	if err != nil {
+		return alchemy_build.VirtualMachineConfig{}, false, err
+	}
+	repo, err := newRepository(remoteRef, opts)
+	if err != nil {
+		return alchemy_build.VirtualMachineConfig{}, false, err
+	}
+	desc, err := repo.Resolve(ctx, remoteRef.reference)
+	if err != nil {
+		return alchemy_build.VirtualMachineConfig{}, false, fmt.Errorf("resolve OCI artifact %s: %w", reference, err)
+	}
+	if desc.MediaType != ocispec.MediaTypeImageIndex {
+		return alchemy_build.VirtualMachineConfig{}, false, nil
+	}
+	index, err := fetchArtifactIndex(ctx, repo, desc)
+	if err != nil {
+		return alchemy_build.VirtualMachineConfig{}, true, err
+	}
+	vm, err = resolveIndexTarget(index.Manifests, candidates)
+	return vm, true, err
+}
+
+func resolveIndexTarget(entries []ocispec.Descriptor, candidates []alchemy_build.VirtualMachineConfig) (alchemy_build.VirtualMachineConfig, error) {
+	for _, candidate := range candidates {
+		if _, ok := exactIndexEntry(entries, candidate); ok {
+			return candidate, nil
+		}
+	}
+	for _, candidate := range candidates {
+		if _, ok := foreignIndexEntry(entries, candidate); ok {
+			return candidate, nil
+		}
+	}
+	return alchemy_build.VirtualMachineConfig{}, fmt.Errorf("OCI index has no entry usable on this host; available targets: %s", indexEntryTargets(entries))
+}
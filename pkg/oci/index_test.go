@@ -0,0 +1,211 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	oras "oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/memory"
+)
+
+func TestPushIndexPublishesOneEntryPerTargetAndPullSelectsHostEntry(t *testing.T) {
+	registry := newTestRegistry(t)
+	reference := registry.reference("dev-alchemy/ubuntu-server", "2026.10")
+	linuxVM, linuxPath := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, []byte("linux-qemu-v1"))
+	darwinVM, darwinPath := testLayoutArchiveVM(t, alchemy_build.HostOsDarwin, alchemy_build.VirtualizationEngineUtm, []byte("darwin-utm"))
+
+	for _, vm := range []alchemy_build.VirtualMachineConfig{linuxVM, darwinVM} {
+		result, err := Push(context.Background(), vm, reference, PushOptions{RegistryOptions: testRegistryOptions(), Index: true})
+		if err != nil {
+			t.Fatalf("failed to push %s to index: %v", vm.HostOs, err)
+		}
+		if result.Index == "" || result.Index == result.Digest {
+			t.Fatalf("expected the push to report an index digest separate from the manifest, got %+v", result)
+		}
+	}
+	if err := os.WriteFile(linuxPath, []byte("linux-qemu-v2"), 0o600); err != nil {
+		t.Fatalf("failed to rewrite linux artifact: %v", err)
+	}
+	if _, err := Push(context.Background(), linuxVM, reference, PushOptions{RegistryOptions: testRegistryOptions(), Index: true}); err != nil {
+		t.Fatalf("failed to repush linux artifact: %v", err)
+	}
+
+	index := testFetchIndex(t, registry, "dev-alchemy/ubuntu-server", "2026.10")
+	if len(index.Manifests) != 2 {
+		t.Fatalf("expected one index entry per target, got %d", len(index.Manifests))
+	}
+	for _, entry := range index.Manifests {
+		if entry.Platform == nil || entry.Platform.Architecture != "amd64" || entry.Platform.OS != entry.Annotations[AnnotationVMHostOS] {
+			t.Fatalf("expected platform-like descriptor, got %+v", entry)
+		}
+		if entry.Annotations[AnnotationVMVirtualizationEngine] == "" {
+			t.Fatalf("expected engine annotation on index entry, got %+v", entry.Annotations)
+		}
+	}
+
+	for vm, want := range map[string]struct {
+		vm      alchemy_build.VirtualMachineConfig
+		path    string
+		content string
+	}{
+		"linux":  {linuxVM, linuxPath, "linux-qemu-v2"},
+		"darwin": {darwinVM, darwinPath, "darwin-utm"},
+	} {
+		if err := os.Remove(want.path); err != nil {
+			t.Fatalf("failed to remove %s artifact: %v", vm, err)
+		}
+		if _, err := Pull(context.Background(), want.vm, reference, PullOptions{RegistryOptions: testRegistryOptions()}); err != nil {
+			t.Fatalf("failed to pull %s entry from index: %v", vm, err)
+		}
+		got, err := os.ReadFile(want.path)
+		if err != nil {
+			t.Fatalf("failed to read pulled %s artifact: %v", vm, err)
+		}
+		if !bytes.Equal(got, []byte(want.content)) {
+			t.Fatalf("expected %s artifact %q, got %q", vm, want.content, got)
+		}
+	}
+}
+
+func TestPushIndexKeepsSingleTargetArtifactAsEntry(t *testing.T) {
+	registry := newTestRegistry(t)
+	reference := registry.reference("dev-alchemy/ubuntu-server", "nightly")
+	linuxVM, _ := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, []byte("linux"))
+	darwinVM, _ := testLayoutArchiveVM(t, alchemy_build.HostOsDarwin, alchemy_build.VirtualizationEngineUtm, []byte("darwin"))
+
+	single, err := Push(context.Background(), linuxVM, reference, PushOptions{RegistryOptions: testRegistryOptions()})
+	if err != nil {
+		t.Fatalf("failed to push single-target artifact: %v", err)
+	}
+	if _, err := Push(context.Background(), darwinVM, reference, PushOptions{RegistryOptions: testRegistryOptions(), Index: true}); err != nil {
+		t.Fatalf("failed to push to index: %v", err)
+	}
+
+	index := testFetchIndex(t, registry, "dev-alchemy/ubuntu-server", "nightly")
+	if len(index.Manifests) != 2 || index.Manifests[1].Digest.String() != single.Digest {
+		t.Fatalf("expected the single-target artifact to stay as an index entry, got %+v", index.Manifests)
+	}
+}
+
+func TestResolveIndexTargetPrefersExactEntryOverForeignEntry(t *testing.T) {
+	registry := newTestRegistry(t)
+	reference := registry.reference("dev-alchemy/ubuntu-server", "2026.10")
+	darwinVM, _ := testLayoutArchiveVM(t, alchemy_build.HostOsDarwin, alchemy_build.VirtualizationEngineUtm, []byte("darwin"))
+	if _, err := Push(context.Background(), darwinVM, reference, PushOptions{RegistryOptions: testRegistryOptions(), Index: true}); err != nil {
+		t.Fatalf("failed to push to index: %v", err)
+	}
+	linuxVM, _ := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, []byte("linux"))
+	windowsVM, _ := testLayoutArchiveVM(t, alchemy_build.HostOsWindows, alchemy_build.VirtualizationEngineHyperv, []byte("windows"))
+
+	vm, isIndex, err := ResolveIndexTarget(context.Background(), reference, testRegistryOptions(), []alchemy_build.VirtualMachineConfig{windowsVM, linuxVM})
+	if err != nil || !isIndex {
+		t.Fatalf("expected the foreign darwin entry to resolve for linux, got %v (index %t)", err, isIndex)
+	}
+	if vm.HostOs != alchemy_build.HostOsLinux {
+		t.Fatalf("expected the linux candidate, got %+v", vm)
+	}
+	if _, err := Pull(context.Background(), linuxVM, reference, PullOptions{RegistryOptions: testRegistryOptions()}); err == nil {
+		t.Fatal("expected pulling a foreign index entry without confirmation to fail")
+	}
+
+	if _, _, err := ResolveIndexTarget(context.Background(), reference, testRegistryOptions(), []alchemy_build.VirtualMachineConfig{windowsVM}); err == nil || !strings.Contains(err.Error(), "available targets") {
+		t.Fatalf("expected no usable entry error, got %v", err)
+	}
+
+	single := registry.reference("dev-alchemy/ubuntu-server", "single")
+	if _, err := Push(context.Background(), linuxVM, single, PushOptions{RegistryOptions: testRegistryOptions()}); err != nil {
+		t.Fatalf("failed to push single-target artifact: %v", err)
+	}
+	if _, isIndex, err := ResolveIndexTarget(context.Background(), single, testRegistryOptions(), []alchemy_build.VirtualMachineConfig{linuxVM}); err != nil || isIndex {
+		t.Fatalf("expected a single-target artifact not to be an index, got %v (index %t)", err, isIndex)
+	}
+}
+
+func TestUpdateArtifactIndexMergesAgainAfterConcurrentPush(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	linuxVM := alchemy_build.VirtualMachineConfig{OS: "ubuntu", UbuntuType: "server", Arch: "amd64", HostOs: alchemy_build.HostOsLinux, VirtualizationEngine: alchemy_build.VirtualizationEngineQemu}
+	darwinVM := linuxVM
+	darwinVM.HostOs = alchemy_build.HostOsDarwin
+	darwinVM.VirtualizationEngine = alchemy_build.VirtualizationEngineUtm
+	linuxManifest := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("linux"), Size: 5}
+	darwinManifest := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("darwin"), Size: 6}
+
+	linuxIndex, err := updateArtifactIndex(ctx, store, "nightly", linuxManifest, linuxVM)
+	if err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	// The concurrent push read the index before the darwin entry was added
+	// and tags it again right after the darwin update.
+	target := &racingIndexTarget{Target: store, race: func() error {
+		return store.Tag(ctx, linuxIndex, "nightly")
+	}}
+	desc, err := updateArtifactIndex(ctx, target, "nightly", darwinManifest, darwinVM)
+	if err != nil {
+		t.Fatalf("failed to update index: %v", err)
+	}
+	if target.tags != 2 {
+		t.Fatalf("expected the lost entry to be merged again, got %d index updates", target.tags)
+	}
+	index, err := fetchArtifactIndex(ctx, store, desc)
+	if err != nil {
+		t.Fatalf("failed to fetch index: %v", err)
+	}
+	if len(index.Manifests) != 2 {
+		t.Fatalf("expected the linux and darwin entries, got %s", indexEntryTargets(index.Manifests))
+	}
+}
+
+// racingIndexTarget runs race once after the first tag, like a concurrent
+// push that tags its own index right after this one.
+type racingIndexTarget struct {
+	oras.Target
+	race func() error
+	tags int
+}
+
+func (t *racingIndexTarget) Tag(ctx context.Context, desc ocispec.Descriptor, reference string) error {
+	t.tags++
+	if err := t.Target.Tag(ctx, desc, reference); err != nil {
+		return err
+	}
+	race := t.race
+	t.race = nil
+	if race != nil {
+		return race()
+	}
+	return nil
+}
+
+func testFetchIndex(t *testing.T, registry *testRegistry, repository string, tag string) ocispec.Index {
+	t.Helper()
+
+	repo, err := newRepository(remoteReference{repository: registry.host + "/" + repository, registry: registry.host}, testRegistryOptions())
+	if err != nil {
+		t.Fatalf("failed to create repository: %v", err)
+	}
+	desc, err := repo.Resolve(context.Background(), tag)
+	if err != nil {
+		t.Fatalf("failed to resolve %s: %v", tag, err)
+	}
+	if desc.MediaType != ocispec.MediaTypeImageIndex {
+		t.Fatalf("expected %s to be an image index, got %s", tag, desc.MediaType)
+	}
+	data, err := content.FetchAll(context.Background(), repo, desc)
+	if err != nil {
+		t.Fatalf("failed to fetch index: %v", err)
+	}
+	var index ocispec.Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		t.Fatalf("failed to decode index: %v", err)
+	}
+	return index
+}
@@ -0,0 +1,317 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	oras "oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry"
+)
+
+const (
+	// CompatibilityNative marks a local target that can pull an artifact
+	// without confirmation.
+	CompatibilityNative = "native"
+	// CompatibilityForeign marks a local target that can pull a compatible
+	// darwin/linux artifact after confirmation.
+	CompatibilityForeign = "foreign"
+)
+
+type InspectOptions struct {
+	RegistryOptions
+	// Targets are the local targets the artifact is checked against, usually
+	// every OCI target of the current host.
+	Targets []alchemy_build.VirtualMachineConfig
+}
+
+// ArtifactInspection describes an artifact manifest or multi-target index in
+// a registry. An index has no layers of its own; Entries describe the
+// manifest of each target and Compatibility lists the targets a pull of the
+// index serves.
+type ArtifactInspection struct {
+	Reference    string              `json:"reference"`
+	Digest       string              `json:"digest"`
+	MediaType    string              `json:"media_type"`
+	ArtifactType string              `json:"artifact_type"`
+	Created      string              `json:"created,omitempty"`
+	Annotations  map[string]string   `json:"annotations"`
+	Artifacts    []InspectedArtifact `json:"artifacts"`
+	Layers       []InspectedLayer    `json:"layers"`
+	// TotalSize is the size of all distinct layer blobs.
+	TotalSize     int64                 `json:"total_size"`
+	Compatibility []TargetCompatibility `json:"compatibility"`
+	Entries       []InspectedIndexEntry `json:"entries,omitempty"`
+}
+
+// InspectedIndexEntry is the manifest of one target in a multi-target index.
+type InspectedIndexEntry struct {
+	// Target names the entry, such as "ubuntu-server-amd64 on darwin/utm".
+	Target string `json:"target"`
+	ArtifactInspection
+}
+
+// InspectedArtifact is one artifact file of a manifest, which is a single
+// layer or a set of chunk layers.
+type InspectedArtifact struct {
+	Name      string `json:"name"`
+	MediaType string `json:"media_type"`
+	Size      int64  `json:"size"`
+	// LayerSize is the size of the layers holding the artifact, which is
+	// smaller than Size for compressed chunks.
+	LayerSize int64  `json:"layer_size"`
+	Layers    int    `json:"layers"`
+	Chunking  string `json:"chunking,omitempty"`
+}
+
+type InspectedLayer struct {
+	Title     string `json:"title"`
+	MediaType string `json:"media_type"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// TargetCompatibility is a local target that can pull the artifact.
+type TargetCompatibility struct {
+	OS                   string `json:"os"`
+	UbuntuType           string `json:"type,omitempty"`
+	Arch                 string `json:"arch"`
+	HostOS               string `json:"host_os"`
+	VirtualizationEngine string `json:"virtualization_engine"`
+	Status               string `json:"status"`
+}
+
+// ListTags returns the tags of an OCI repository given as
+// <registry>/<repository>.
+func ListTags(ctx context.Context, repository string, opts RegistryOptions) ([]string, error) {
+	remoteRef, err := parseRepositoryReference(repository)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := newRepository(remoteRef, opts)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := registry.Tags(ctx, repo)
+	if err != nil {
+		return nil, fmt.Errorf("list tags of OCI repository %s: %w", remoteRef.repository, err)
+	}
+	return tags, nil
+}
+
+// Inspect fetches the manifest of reference and describes its target
+// annotations, layers and the local targets that can pull it. For a
+// multi-target index, every entry is described.
+func Inspect(ctx context.Context, reference string, opts InspectOptions) (ArtifactInspection, error) {
+	remoteRef, err := parsePullReference(reference)
+	if err != nil {
+		return ArtifactInspection{}, err
+	}
+	repo, err := newRepository(remoteRef, opts.RegistryOptions)
+	if err != nil {
+		return ArtifactInspection{}, err
+	}
+	inspection, err := inspectManifest(ctx, repo, remoteRef.reference, opts.Targets)
+	if err != nil {
+		return ArtifactInspection{}, err
+	}
+	inspection.Reference = reference
+	return inspection, nil
+}
+
+func parseRepositoryReference(repository string) (remoteReference, error) {
+	parsed, err := registry.ParseReference(repository)
+	if err != nil {
+		return remoteReference{}, fmt.Errorf("parse OCI repository %q: %w", repository, err)
+	}
+	if parsed.Reference != "" {
+		return remoteReference{}, fmt.Errorf("OCI repository %q must not include a tag or digest", repository)
+	}
+	return parseRemoteReference(repository)
+}
+
+func inspectManifest(ctx context.Context, repo oras.ReadOnlyTarget, ref string, targets []alchemy_build.VirtualMachineConfig) (ArtifactInspection, error) {
+	desc, err := repo.Resolve(ctx, ref)
+	if err != nil {
+		return ArtifactInspection{}, fmt.Errorf("resolve OCI artifact %s: %w", ref, err)
+	}
+	switch desc.MediaType {
+	case ocispec.MediaTypeImageManifest:
+		return inspectManifestDescriptor(ctx, repo, ref, desc, targets)
+	case ocispec.MediaTypeImageIndex:
+		return inspectIndex(ctx, repo, ref, desc, targets)
+	default:
+		return ArtifactInspection{}, fmt.Errorf("OCI artifact %s has media type %q, expected %q or %q", ref, desc.MediaType, ocispec.MediaTypeImageManifest, ocispec.MediaTypeImageIndex)
+	}
+}
+
+// inspectIndex describes the multi-target index desc and the manifest of each
+// of its entries.
+func inspectIndex(ctx context.Context, repo oras.ReadOnlyTarget, ref string, desc ocispec.Descriptor, targets []alchemy_build.VirtualMachineConfig) (ArtifactInspection, error) {
+	index, err := fetchArtifactIndex(ctx, repo, desc)
+	if err != nil {
+		return ArtifactInspection{}, err
+	}
+	inspection := ArtifactInspection{
+		Reference:     ref,
+		Digest:        desc.Digest.String(),
+		MediaType:     desc.MediaType,
+		ArtifactType:  index.ArtifactType,
+		Created:       index.Annotations[ocispec.AnnotationCreated],
+		Annotations:   map[string]string{},
+		Artifacts:     []InspectedArtifact{},
+		Layers:        []InspectedLayer{},
+		Compatibility: indexCompatibility(index.Manifests, targets),
+		Entries:       make([]InspectedIndexEntry, 0, len(index.Manifests)),
+	}
+	var layers []ocispec.Descriptor
+	for _, entry := range index.Manifests {
+		entryInspection, err := inspectManifestDescriptor(ctx, repo, entry.Digest.String(), entry, targets)
+		if err != nil {
+			return ArtifactInspection{}, fmt.Errorf("inspect OCI index entry %s: %w", indexEntryTarget(entry), err)
+		}
+		inspection.Entries = append(inspection.Entries, InspectedIndexEntry{Target: indexEntryTarget(entry), ArtifactInspection: entryInspection})
+		for _, layer := range entryInspection.Layers {
+			layers = append(layers, ocispec.Descriptor{Digest: digest.Digest(layer.Digest), Size: layer.Size})
+		}
+	}
+	inspection.TotalSize = descriptorTotal(layers...)
+	return inspection, nil
+}
+
+func inspectManifestDescriptor(ctx context.Context, repo oras.ReadOnlyTarget, ref string, desc ocispec.Descriptor, targets []alchemy_build.VirtualMachineConfig) (ArtifactInspection, error) {
+	if desc.MediaType != ocispec.MediaTypeImageManifest {
+		return ArtifactInspection{}, fmt.Errorf("OCI artifact %s has media type %q, expected %q", ref, desc.MediaType, ocispec.MediaTypeImageManifest)
+	}
+	manifestBytes, err := content.FetchAll(ctx, repo, desc)
+	if err != nil {
+		return ArtifactInspection{}, fmt.Errorf("fetch OCI artifact manifest %s: %w", ref, err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return ArtifactInspection{}, fmt.Errorf("decode OCI artifact manifest %s: %w", ref, err)
+	}
+
+	artifacts, err := inspectedArtifacts(manifest.Layers)
+	if err != nil {
+		return ArtifactInspection{}, err
+	}
+	inspection := ArtifactInspection{
+		Reference:     ref,
+		Digest:        desc.Digest.String(),
+		MediaType:     desc.MediaType,
+		ArtifactType:  manifest.ArtifactType,
+		Created:       manifest.Annotations[ocispec.AnnotationCreated],
+		Annotations:   map[string]string{},
+		Artifacts:     artifacts,
+		Layers:        make([]InspectedLayer, 0, len(manifest.Layers)),
+		TotalSize:     descriptorTotal(manifest.Layers...),
+		Compatibility: []TargetCompatibility{},
+	}
+	for key, value := range manifest.Annotations {
+		if strings.HasPrefix(key, "dev.alchemy.vm.") {
+			inspection.Annotations[key] = value
+		}
+	}
+	for _, layer := range manifest.Layers {
+		inspection.Layers = append(inspection.Layers, InspectedLayer{
+			Title:     layer.Annotations[ocispec.AnnotationTitle],
+			MediaType: layer.MediaType,
+			Digest:    layer.Digest.String(),
+			Size:      layer.Size,
+		})
+	}
+	if manifest.ArtifactType == ArtifactType {
+		inspection.Compatibility = targetCompatibility(manifest, targets)
+	}
+	return inspection, nil
+}
+
+// inspectedArtifacts groups the layers of a manifest by the artifact file
+// they hold, in layer order.
+func inspectedArtifacts(layers []ocispec.Descriptor) ([]InspectedArtifact, error) {
+	chunked, err := chunkedArtifacts(layers)
+	if err != nil {
+		return nil, err
+	}
+	artifacts := []InspectedArtifact{}
+	listed := map[string]bool{}
+	for _, layer := range layers {
+		if layer.MediaType != MediaTypeChunk {
+			artifacts = append(artifacts, InspectedArtifact{
+				Name:      layer.Annotations[ocispec.AnnotationTitle],
+				MediaType: layer.MediaType,
+				Size:      layer.Size,
+				LayerSize: layer.Size,
+				Layers:    1,
+			})
+			continue
+		}
+		name := layer.Annotations[AnnotationChunkFile]
+		if listed[name] {
+			continue
+		}
+		listed[name] = true
+		artifact := chunked[name]
+		chunking, _ := chunkingFromLayer(layer)
+		artifacts = append(artifacts, InspectedArtifact{
+			Name:      artifact.file.Name,
+			MediaType: artifact.file.MediaType,
+			Size:      artifact.size,
+			LayerSize: descriptorTotal(artifact.chunks...),
+			Layers:    len(artifact.chunks),
+			Chunking:  chunking.name(),
+		})
+	}
+	return artifacts, nil
+}
+
+// targetCompatibility returns the targets that can pull manifest, applying
+// the same target checks as a pull.
+func targetCompatibility(manifest ocispec.Manifest, targets []alchemy_build.VirtualMachineConfig) []TargetCompatibility {
+	compatible := []TargetCompatibility{}
+	for _, vm := range targets {
+		status := CompatibilityNative
+		if err := validateManifestTarget(manifest, vm); err != nil {
+			if _, ok := compatibleForeignArtifact(manifest, vm); !ok {
+				continue
+			}
+			status = CompatibilityForeign
+		}
+		compatible = append(compatible, compatibleTarget(vm, status))
+	}
+	return compatible
+}
+
+// indexCompatibility returns the targets that a pull of an index with entries
+// serves, natively when the index has an exact entry for the target.
+func indexCompatibility(entries []ocispec.Descriptor, targets []alchemy_build.VirtualMachineConfig) []TargetCompatibility {
+	compatible := []TargetCompatibility{}
+	for _, vm := range targets {
+		status := CompatibilityNative
+		if _, ok := exactIndexEntry(entries, vm); !ok {
+			if _, ok := foreignIndexEntry(entries, vm); !ok {
+				continue
+			}
+			status = CompatibilityForeign
+		}
+		compatible = append(compatible, compatibleTarget(vm, status))
+	}
+	return compatible
+}
+
+func compatibleTarget(vm alchemy_build.VirtualMachineConfig, status string) TargetCompatibility {
+	return TargetCompatibility{
+		OS:                   vm.OS,
+		UbuntuType:           vm.UbuntuType,
+		Arch:                 vm.Arch,
+		HostOS:               string(vm.HostOs),
+		VirtualizationEngine: string(vm.VirtualizationEngine),
+		Status:               status,
+	}
+}
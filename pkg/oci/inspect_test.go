@@ -0,0 +1,152 @@
+package oci
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content/oci"
+	"oras.land/oras-go/v2/registry"
+)
+
+func TestInspectManifestDescribesArtifactAndCompatibleTargets(t *testing.T) {
+	content := testRandomContent(4, 5_000)
+	vm, _ := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, content)
+	store := testArchiveStore(t, vm, ExportOptions{ChunkSize: 1000})
+
+	darwinVM := vm
+	darwinVM.HostOs = alchemy_build.HostOsDarwin
+	darwinVM.VirtualizationEngine = alchemy_build.VirtualizationEngineUtm
+	windowsVM := vm
+	windowsVM.HostOs = alchemy_build.HostOsWindows
+	windowsVM.VirtualizationEngine = alchemy_build.VirtualizationEngineHyperv
+	otherArchVM := vm
+	otherArchVM.Arch = "arm64"
+
+	inspection, err := inspectManifest(context.Background(), store, "ubuntu-server-amd64", []alchemy_build.VirtualMachineConfig{vm, darwinVM, windowsVM, otherArchVM})
+	if err != nil {
+		t.Fatalf("failed to inspect manifest: %v", err)
+	}
+	if inspection.ArtifactType != ArtifactType || inspection.Created == "" {
+		t.Fatalf("expected artifact type and creation time, got %q and %q", inspection.ArtifactType, inspection.Created)
+	}
+	if inspection.Annotations[AnnotationVMHostOS] != string(alchemy_build.HostOsLinux) {
+		t.Fatalf("expected host OS annotation, got %v", inspection.Annotations)
+	}
+	if _, ok := inspection.Annotations[ocispec.AnnotationTitle]; ok {
+		t.Fatal("expected only dev.alchemy.vm annotations")
+	}
+	if len(inspection.Artifacts) != 1 || len(inspection.Layers) != 5 {
+		t.Fatalf("expected 1 artifact in 5 chunk layers, got %d artifacts and %d layers", len(inspection.Artifacts), len(inspection.Layers))
+	}
+	artifact := inspection.Artifacts[0]
+	if artifact.Name != "layout-archive.qcow2" || artifact.Size != int64(len(content)) || artifact.Layers != 5 || artifact.Chunking != ChunkingFixed {
+		t.Fatalf("unexpected chunked artifact %+v", artifact)
+	}
+
+	statuses := map[alchemy_build.HostOsType]string{}
+	for _, target := range inspection.Compatibility {
+		statuses[alchemy_build.HostOsType(target.HostOS)] = target.Status
+	}
+	if len(inspection.Compatibility) != 2 || statuses[alchemy_build.HostOsLinux] != CompatibilityNative || statuses[alchemy_build.HostOsDarwin] != CompatibilityForeign {
+		t.Fatalf("expected native linux and foreign darwin targets, got %+v", inspection.Compatibility)
+	}
+}
+
+func TestInspectManifestRejectsUnknownReference(t *testing.T) {
+	vm, _ := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, []byte("dev-alchemy-inspect"))
+	store := testArchiveStore(t, vm, ExportOptions{})
+
+	if _, err := inspectManifest(context.Background(), store, "missing", nil); err == nil {
+		t.Fatal("expected an unknown reference to fail")
+	}
+}
+
+func TestArchiveStoreListsExportedTag(t *testing.T) {
+	vm, _ := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, []byte("dev-alchemy-tags"))
+	store := testArchiveStore(t, vm, ExportOptions{})
+
+	tags, err := registry.Tags(context.Background(), store)
+	if err != nil {
+		t.Fatalf("failed to list tags: %v", err)
+	}
+	if !slices.Contains(tags, "ubuntu-server-amd64") {
+		t.Fatalf("expected the VM slug tag, got %v", tags)
+	}
+}
+
+func TestParseRepositoryReferenceRejectsTag(t *testing.T) {
+	if _, err := parseRepositoryReference("localhost:5000/dev-alchemy/ubuntu"); err != nil {
+		t.Fatalf("expected repository to parse: %v", err)
+	}
+	if _, err := parseRepositoryReference("localhost:5000/dev-alchemy/ubuntu:latest"); err == nil {
+		t.Fatal("expected a tagged repository to be rejected")
+	}
+}
+
+// testArchiveStore exports vm and opens the archive as a read-only OCI store,
+// which serves as a registry stand-in.
+func testArchiveStore(t *testing.T, vm alchemy_build.VirtualMachineConfig, opts ExportOptions) *oci.ReadOnlyStore {
+	t.Helper()
+
+	archivePath := filepath.Join(t.TempDir(), "image.tar")
+	if _, err := Export(context.Background(), vm, archivePath, opts); err != nil {
+		t.Fatalf("failed to export artifact: %v", err)
+	}
+	store, err := oci.NewFromTar(context.Background(), archivePath)
+	if err != nil {
+		t.Fatalf("failed to open exported archive: %v", err)
+	}
+	return store
+}
+
+func TestInspectDescribesIndexEntriesAndHostCompatibility(t *testing.T) {
+	registry := newTestRegistry(t)
+	reference := registry.reference("dev-alchemy/ubuntu-server", "nightly")
+	linuxVM, _ := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, []byte("linux"))
+	darwinVM, _ := testLayoutArchiveVM(t, alchemy_build.HostOsDarwin, alchemy_build.VirtualizationEngineUtm, []byte("darwin"))
+	for _, vm := range []alchemy_build.VirtualMachineConfig{linuxVM, darwinVM} {
+		if _, err := Push(context.Background(), vm, reference, PushOptions{RegistryOptions: testRegistryOptions(), Index: true}); err != nil {
+			t.Fatalf("failed to push %s artifact to index: %v", vm.HostOs, err)
+		}
+	}
+	windowsVM := linuxVM
+	windowsVM.HostOs = alchemy_build.HostOsWindows
+	windowsVM.VirtualizationEngine = alchemy_build.VirtualizationEngineHyperv
+
+	inspection, err := Inspect(context.Background(), reference, InspectOptions{RegistryOptions: testRegistryOptions(), Targets: []alchemy_build.VirtualMachineConfig{linuxVM, windowsVM}})
+	if err != nil {
+		t.Fatalf("failed to inspect index: %v", err)
+	}
+	if inspection.MediaType != ocispec.MediaTypeImageIndex || len(inspection.Entries) != 2 {
+		t.Fatalf("expected an index with two entries, got %+v", inspection)
+	}
+	if len(inspection.Compatibility) != 1 || inspection.Compatibility[0].HostOS != string(alchemy_build.HostOsLinux) || inspection.Compatibility[0].Status != CompatibilityNative {
+		t.Fatalf("expected only the linux target to pull the index natively, got %+v", inspection.Compatibility)
+	}
+	var entryTotal int64
+	for _, entry := range inspection.Entries {
+		entryTotal += entry.TotalSize
+		if len(entry.Artifacts) != 1 || entry.Annotations[AnnotationVMHostOS] == "" {
+			t.Fatalf("expected each entry to describe its manifest, got %+v", entry)
+		}
+		wantStatus := CompatibilityForeign
+		if entry.Annotations[AnnotationVMHostOS] == string(alchemy_build.HostOsLinux) {
+			wantStatus = CompatibilityNative
+		}
+		gotStatus := ""
+		if len(entry.Compatibility) == 1 {
+			gotStatus = entry.Compatibility[0].Status
+		}
+		if gotStatus != wantStatus || !strings.Contains(entry.Target, entry.Annotations[AnnotationVMHostOS]) {
+			t.Fatalf("unexpected compatibility of entry %s: %+v", entry.Target, entry.Compatibility)
+		}
+	}
+	if inspection.TotalSize != entryTotal {
+		t.Fatalf("expected the index size to add up its entries, got %d and %d", inspection.TotalSize, entryTotal)
+	}
+}
@@ -0,0 +1,334 @@
+package oci
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/content/file"
+	"oras.land/oras-go/v2/content/oci"
+)
+
+type ExportOptions struct {
+	Progress TransferProgress
+	// ChunkSize and ContentDefinedChunking select the layer format as they do
+	// for PushOptions.
+	ChunkSize              int64
+	ContentDefinedChunking bool
+}
+
+type ImportOptions struct {
+	Progress                  TransferProgress
+	ConfirmForeignArtifactUse ForeignArtifactConfirmation
+}
+
+// Export writes the artifact that Push would publish for vm to an OCI
+// image-layout tar archive at outputPath, tagged with the VM slug, so images
+// can move between machines without a registry.
+func Export(ctx context.Context, vm alchemy_build.VirtualMachineConfig, outputPath string, opts ExportOptions) (TransferResult, error) {
+	reportTransferStatus(opts.Progress, "Resolving local artifact paths")
+	layout, err := resolveArtifactLayout(vm)
+	if err != nil {
+		return TransferResult{}, err
+	}
+
+	reportTransferStatus(opts.Progress, "Preparing local OCI artifact store")
+	fs, err := file.New(layout.root)
+	if err != nil {
+		return TransferResult{}, fmt.Errorf("create OCI file store: %w", err)
+	}
+	defer fs.Close()
+
+	packed, err := packArtifact(ctx, vm, fs, layout, opts.ChunkSize, opts.ContentDefinedChunking, opts.Progress)
+	defer packed.cleanup()
+	if err != nil {
+		return TransferResult{}, err
+	}
+
+	absOutput, err := filepath.Abs(outputPath)
+	if err != nil {
+		return TransferResult{}, fmt.Errorf("resolve OCI layout archive path %s: %w", outputPath, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(absOutput), 0o755); err != nil {
+		return TransferResult{}, fmt.Errorf("create directory for OCI layout archive %s: %w", absOutput, err)
+	}
+	archive, err := os.CreateTemp(filepath.Dir(absOutput), ".dev-alchemy-oci-export-*")
+	if err != nil {
+		return TransferResult{}, fmt.Errorf("create OCI layout archive %s: %w", absOutput, err)
+	}
+	defer os.Remove(archive.Name())
+
+	slugVM := vm
+	reportTransferStatus(opts.Progress, "Writing OCI layout archive %s", absOutput)
+	total, err := writeLayoutArchive(ctx, fs, packed.manifest, alchemy_build.GenerateVirtualMachineSlug(&slugVM), archive, opts.Progress)
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return TransferResult{}, fmt.Errorf("write OCI layout archive %s: %w", absOutput, err)
+	}
+	if err := os.Rename(archive.Name(), absOutput); err != nil {
+		return TransferResult{}, fmt.Errorf("write OCI layout archive %s: %w", absOutput, err)
+	}
+
+	result := transferResult(absOutput, packed.manifest, packed.files)
+	result.TransferredBytes = total
+	return result, nil
+}
+
+// writeLayoutArchive writes the manifest desc and every blob it references as
+// an OCI image layout in tar format, with desc tagged as ref in index.json. It
+// returns the number of blob bytes written.
+func writeLayoutArchive(ctx context.Context, store content.ReadOnlyStorage, desc ocispec.Descriptor, ref string, output io.Writer, progress TransferProgress) (total int64, err error) {
+	successors, err := content.Successors(ctx, store, desc)
+	if err != nil {
+		return 0, fmt.Errorf("read OCI artifact manifest: %w", err)
+	}
+	blobs := append(successors, desc)
+	total = descriptorTotal(blobs...)
+
+	if progress != nil {
+		progress = newCappedTransferProgress(progress, total)
+		progress.Start(total)
+		defer func() {
+			progress.Done(err == nil)
+		}()
+	}
+
+	tw := tar.NewWriter(output)
+	modTime := time.Now()
+	layoutFile, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return 0, err
+	}
+	if err := writeArchiveFile(tw, ocispec.ImageLayoutFile, layoutFile, modTime); err != nil {
+		return 0, err
+	}
+
+	seen := make(map[digest.Digest]bool, len(blobs))
+	for _, blob := range blobs {
+		if seen[blob.Digest] {
+			continue
+		}
+		seen[blob.Digest] = true
+		if err := writeArchiveBlob(ctx, tw, store, blob, modTime, progress); err != nil {
+			return 0, err
+		}
+	}
+
+	tagged := desc
+	tagged.Annotations = map[string]string{ocispec.AnnotationRefName: ref}
+	index, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{tagged},
+	})
+	if err != nil {
+		return 0, err
+	}
+	if err := writeArchiveFile(tw, ocispec.ImageIndexFile, index, modTime); err != nil {
+		return 0, err
+	}
+	return total, tw.Close()
+}
+
+func writeArchiveFile(tw *tar.Writer, name string, data []byte, modTime time.Time) error {
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: modTime}); err != nil {
+		return err
+	}
+	_, err := tw.Write(data)
+	return err
+}
+
+func writeArchiveBlob(ctx context.Context, tw *tar.Writer, store content.Fetcher, desc ocispec.Descriptor, modTime time.Time, progress TransferProgress) error {
+	rc, err := store.Fetch(ctx, desc)
+	if err != nil {
+		return fmt.Errorf("read OCI blob %s: %w", desc.Digest, err)
+	}
+	defer rc.Close()
+
+	name := strings.Join([]string{ocispec.ImageBlobsDir, desc.Digest.Algorithm().String(), desc.Digest.Encoded()}, "/")
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: desc.Size, ModTime: modTime}); err != nil {
+		return err
+	}
+	reader := content.NewVerifyReader(progressReadCloser{ReadCloser: rc, progress: progress}, desc)
+	if _, err := io.Copy(tw, reader); err != nil {
+		return fmt.Errorf("write OCI blob %s: %w", desc.Digest, err)
+	}
+	return reader.Verify()
+}
+
+// Import validates an OCI image-layout tar archive written by Export with the
+// same checks as Pull, including the foreign-host confirmation, and promotes
+// its artifacts into the local cache.
+func Import(ctx context.Context, vm alchemy_build.VirtualMachineConfig, archivePath string, opts ImportOptions) (TransferResult, error) {
+	reportTransferStatus(opts.Progress, "Resolving local artifact paths")
+	layout, err := resolveArtifactLayout(vm)
+	if err != nil {
+		return TransferResult{}, err
+	}
+
+	reportTransferStatus(opts.Progress, "Reading OCI layout archive %s", archivePath)
+	store, err := oci.NewFromTar(ctx, archivePath)
+	if err != nil {
+		return TransferResult{}, fmt.Errorf("read OCI layout archive %s: %w", archivePath, err)
+	}
+	slugVM := vm
+	ref, err := archiveReference(ctx, store, alchemy_build.GenerateVirtualMachineSlug(&slugVM))
+	if err != nil {
+		return TransferResult{}, fmt.Errorf("read OCI layout archive %s: %w", archivePath, err)
+	}
+
+	reportTransferStatus(opts.Progress, "Validating OCI artifact manifest")
+	archived, err := validateRemoteManifest(ctx, store, ref, vm, layout.files, PullOptions{
+		Progress:                  opts.Progress,
+		ConfirmForeignArtifactUse: opts.ConfirmForeignArtifactUse,
+	})
+	if err != nil {
+		return TransferResult{}, err
+	}
+
+	if err := os.MkdirAll(layout.root, 0o700); err != nil {
+		return TransferResult{}, fmt.Errorf("create artifact root %s: %w", layout.root, err)
+	}
+	stagingRoot, err := os.MkdirTemp(layout.root, ".dev-alchemy-oci-import-*")
+	if err != nil {
+		return TransferResult{}, fmt.Errorf("create OCI import staging directory: %w", err)
+	}
+	defer os.RemoveAll(stagingRoot)
+
+	reportTransferStatus(opts.Progress, "Extracting OCI artifact layers")
+	total, err := extractArchivedLayers(ctx, store, archived.layers, stagingRoot, opts.Progress)
+	if err != nil {
+		return TransferResult{}, fmt.Errorf("import OCI layout archive %s: %w", archivePath, err)
+	}
+
+	importedFiles, err := promoteStagedLayers(ctx, stagingRoot, archived.layers, layout.files, nil, opts.Progress)
+	if err != nil {
+		return TransferResult{}, err
+	}
+
+	result := transferResult(archivePath, archived.descriptor, importedFiles)
+	result.TransferredBytes = total
+	return result, nil
+}
+
+// archiveReference picks the image of an OCI layout archive: the one tagged
+// slug, or else the only tagged image.
+func archiveReference(ctx context.Context, store *oci.ReadOnlyStore, slug string) (string, error) {
+	var tags []string
+	if err := store.Tags(ctx, "", func(page []string) error {
+		tags = append(tags, page...)
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	switch {
+	case slug != "" && slices.Contains(tags, slug):
+		return slug, nil
+	case len(tags) == 1:
+		return tags[0], nil
+	case len(tags) == 0:
+		return "", errors.New("archive contains no tagged image")
+	default:
+		return "", fmt.Errorf("archive contains images %s and none is tagged %q", strings.Join(tags, ", "), slug)
+	}
+}
+
+// ArchiveTarget is the VM target an OCI layout archive was exported for.
+type ArchiveTarget struct {
+	OS                   string
+	UbuntuType           string
+	Arch                 string
+	HostOS               string
+	VirtualizationEngine string
+}
+
+// ReadArchiveTarget returns the target annotations of the image in an OCI
+// layout archive, so an import can select the matching local target.
+func ReadArchiveTarget(ctx context.Context, archivePath string) (ArchiveTarget, error) {
+	store, err := oci.NewFromTar(ctx, archivePath)
+	if err != nil {
+		return ArchiveTarget{}, fmt.Errorf("read OCI layout archive %s: %w", archivePath, err)
+	}
+	ref, err := archiveReference(ctx, store, "")
+	if err != nil {
+		return ArchiveTarget{}, fmt.Errorf("read OCI layout archive %s: %w", archivePath, err)
+	}
+	desc, err := store.Resolve(ctx, ref)
+	if err != nil {
+		return ArchiveTarget{}, fmt.Errorf("resolve %s in OCI layout archive %s: %w", ref, archivePath, err)
+	}
+	manifestBytes, err := content.FetchAll(ctx, store, desc)
+	if err != nil {
+		return ArchiveTarget{}, fmt.Errorf("read OCI artifact manifest from %s: %w", archivePath, err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return ArchiveTarget{}, fmt.Errorf("decode OCI artifact manifest from %s: %w", archivePath, err)
+	}
+	return ArchiveTarget{
+		OS:                   manifest.Annotations[AnnotationVMOS],
+		UbuntuType:           manifest.Annotations[AnnotationVMType],
+		Arch:                 manifest.Annotations[AnnotationVMArch],
+		HostOS:               manifest.Annotations[AnnotationVMHostOS],
+		VirtualizationEngine: manifest.Annotations[AnnotationVMVirtualizationEngine],
+	}, nil
+}
+
+// extractArchivedLayers copies each layer into stagingRoot under its title,
+// verifying its digest, and returns the number of bytes copied.
+func extractArchivedLayers(ctx context.Context, store content.Fetcher, layers []ocispec.Descriptor, stagingRoot string, progress TransferProgress) (total int64, err error) {
+	total = descriptorTotal(layers...)
+	if progress != nil {
+		progress = newCappedTransferProgress(progress, total)
+		progress.Start(total)
+		defer func() {
+			progress.Done(err == nil)
+		}()
+	}
+
+	for _, layer := range layers {
+		title := layer.Annotations[ocispec.AnnotationTitle]
+		if err := extractArchivedLayer(ctx, store, layer, filepath.Join(stagingRoot, filepath.FromSlash(title)), progress); err != nil {
+			return 0, fmt.Errorf("extract layer %s: %w", title, err)
+		}
+	}
+	return total, nil
+}
+
+func extractArchivedLayer(ctx context.Context, store content.Fetcher, layer ocispec.Descriptor, target string, progress TransferProgress) error {
+	rc, err := store.Fetch(ctx, layer)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600) // #nosec G304 -- target is inside the import staging directory.
+	if err != nil {
+		return err
+	}
+	reader := content.NewVerifyReader(progressReadCloser{ReadCloser: rc, progress: progress}, layer)
+	_, copyErr := io.Copy(file, reader)
+	if err := errors.Join(copyErr, file.Close()); err != nil {
+		return err
+	}
+	return reader.Verify()
+}
@@ -0,0 +1,116 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		opts ExportOptions
+	}{
+		{name: "single layer"},
+		{name: "chunked", opts: ExportOptions{ChunkSize: 1000}},
+		{name: "content-defined chunks", opts: ExportOptions{ChunkSize: 1000, ContentDefinedChunking: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content := testRandomContent(3, 10_000)
+			vm, artifactPath := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, content)
+			archivePath := filepath.Join(t.TempDir(), "image.tar")
+
+			exported, err := Export(context.Background(), vm, archivePath, tt.opts)
+			if err != nil {
+				t.Fatalf("failed to export artifact: %v", err)
+			}
+			if err := os.Remove(artifactPath); err != nil {
+				t.Fatalf("failed to remove local artifact before import: %v", err)
+			}
+
+			imported, err := Import(context.Background(), vm, archivePath, ImportOptions{})
+			if err != nil {
+				t.Fatalf("failed to import artifact: %v", err)
+			}
+			if imported.Digest != exported.Digest {
+				t.Fatalf("expected imported manifest %s, got %s", exported.Digest, imported.Digest)
+			}
+			got, err := os.ReadFile(artifactPath)
+			if err != nil {
+				t.Fatalf("failed to read imported artifact: %v", err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Fatal("imported artifact differs from the exported artifact")
+			}
+		})
+	}
+}
+
+func TestImportRequiresConfirmationForForeignArtifact(t *testing.T) {
+	content := []byte("dev-alchemy-foreign-import")
+	darwinVM, _ := testLayoutArchiveVM(t, alchemy_build.HostOsDarwin, alchemy_build.VirtualizationEngineUtm, content)
+	archivePath := filepath.Join(t.TempDir(), "image.tar")
+	if _, err := Export(context.Background(), darwinVM, archivePath, ExportOptions{}); err != nil {
+		t.Fatalf("failed to export artifact: %v", err)
+	}
+	linuxVM, artifactPath := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, nil)
+	if err := os.Remove(artifactPath); err != nil {
+		t.Fatalf("failed to remove local artifact before import: %v", err)
+	}
+
+	_, err := Import(context.Background(), linuxVM, archivePath, ImportOptions{})
+	if err == nil {
+		t.Fatal("expected foreign artifact import to require confirmation")
+	}
+	if !strings.Contains(err.Error(), AnnotationVMHostOS) {
+		t.Fatalf("expected host OS validation error, got %q", err.Error())
+	}
+
+	confirmed := false
+	_, err = Import(context.Background(), linuxVM, archivePath, ImportOptions{
+		ConfirmForeignArtifactUse: func(context.Context, ForeignArtifact) (bool, error) {
+			confirmed = true
+			return true, nil
+		},
+	})
+	if err != nil {
+		t.Fatalf("expected confirmed foreign artifact import to succeed: %v", err)
+	}
+	if !confirmed {
+		t.Fatal("expected foreign artifact confirmation to be requested")
+	}
+	got, err := os.ReadFile(artifactPath)
+	if err != nil {
+		t.Fatalf("failed to read imported artifact: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("imported artifact differs from the exported artifact")
+	}
+}
+
+func testLayoutArchiveVM(t *testing.T, hostOs alchemy_build.HostOsType, engine alchemy_build.VirtualizationEngine, content []byte) (alchemy_build.VirtualMachineConfig, string) {
+	t.Helper()
+
+	artifactPath := filepath.Join(t.TempDir(), "cache", "ubuntu", "layout-archive.qcow2")
+	if err := os.MkdirAll(filepath.Dir(artifactPath), 0o700); err != nil {
+		t.Fatalf("failed to create artifact dir: %v", err)
+	}
+	if err := os.WriteFile(artifactPath, content, 0o600); err != nil {
+		t.Fatalf("failed to write artifact: %v", err)
+	}
+	return alchemy_build.VirtualMachineConfig{
+		OS:                     "ubuntu",
+		UbuntuType:             "server",
+		Arch:                   "amd64",
+		HostOs:                 hostOs,
+		VirtualizationEngine:   engine,
+		ExpectedBuildArtifacts: []string{artifactPath},
+	}, artifactPath
+}
@@ -11,8 +11,8 @@ import (
 
 	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	oras "oras.land/oras-go/v2"
 	"oras.land/oras-go/v2/content"
-	"oras.land/oras-go/v2/registry/remote"
 )
 
 // ForeignArtifactConfirmation decides whether a compatible artifact built for a
@@ -58,11 +58,17 @@ func manifestAnnotations(vm alchemy_build.VirtualMachineConfig) map[string]strin
 	}
 }
 
-func validateRemoteManifest(ctx context.Context, repo *remote.Repository, ref string, vm alchemy_build.VirtualMachineConfig, expected []ArtifactFile, opts PullOptions) (remoteManifest, error) {
+func validateRemoteManifest(ctx context.Context, repo oras.ReadOnlyTarget, ref string, vm alchemy_build.VirtualMachineConfig, expected []ArtifactFile, opts PullOptions) (remoteManifest, error) {
 	desc, err := repo.Resolve(ctx, ref)
 	if err != nil {
 		return remoteManifest{}, fmt.Errorf("resolve OCI artifact %s: %w", ref, err)
 	}
+	if desc.MediaType == ocispec.MediaTypeImageIndex {
+		desc, err = selectIndexManifest(ctx, repo, desc, vm)
+		if err != nil {
+			return remoteManifest{}, fmt.Errorf("select OCI artifact %s: %w", ref, err)
+		}
+	}
 	manifestBytes, err := content.FetchAll(ctx, repo, desc)
 	if err != nil {
 		return remoteManifest{}, fmt.Errorf("fetch OCI artifact manifest %s: %w", ref, err)
@@ -214,12 +220,12 @@ func validateManifestLayers(manifest ocispec.Manifest, expected []ArtifactFile)
 		expectedByName[file.Name] = file
 	}
 
+	chunked, err := chunkedArtifacts(manifest.Layers)
+	if err != nil {
+		return err
+	}
 	seen := make(map[string]bool, len(manifest.Layers))
-	for _, layer := range manifest.Layers {
-		name := layer.Annotations[ocispec.AnnotationTitle]
-		if name == "" {
-			return errors.New("OCI artifact layer is missing title annotation")
-		}
+	checkLayer := func(name string, mediaType string) error {
 		if seen[name] {
 			return fmt.Errorf("OCI artifact contains duplicate layer %q", name)
 		}
@@ -227,14 +233,33 @@ func validateManifestLayers(manifest ocispec.Manifest, expected []ArtifactFile)
 		if !ok {
 			return fmt.Errorf("OCI artifact contains unexpected layer %q", name)
 		}
-		if layer.MediaType != expectedFile.MediaType {
-			return fmt.Errorf("OCI artifact layer %q has media type %q, expected %q", name, layer.MediaType, expectedFile.MediaType)
+		if mediaType != expectedFile.MediaType {
+			return fmt.Errorf("OCI artifact layer %q has media type %q, expected %q", name, mediaType, expectedFile.MediaType)
 		}
 		seen[name] = true
+		return nil
+	}
+
+	for _, layer := range manifest.Layers {
+		if layer.MediaType == MediaTypeChunk {
+			continue
+		}
+		name := layer.Annotations[ocispec.AnnotationTitle]
+		if name == "" {
+			return errors.New("OCI artifact layer is missing title annotation")
+		}
+		if err := checkLayer(name, layer.MediaType); err != nil {
+			return err
+		}
+	}
+	for name, artifact := range chunked {
+		if err := checkLayer(name, artifact.file.MediaType); err != nil {
+			return err
+		}
 	}
 
-	if len(manifest.Layers) != len(expected) {
-		return fmt.Errorf("OCI artifact contains %d layers, expected %d", len(manifest.Layers), len(expected))
+	if len(seen) != len(expected) {
+		return fmt.Errorf("OCI artifact contains %d layers, expected %d", len(seen), len(expected))
 	}
 
 	for _, expectedFile := range expected {
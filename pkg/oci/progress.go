@@ -6,6 +6,7 @@ import (
 	"io"
 	"sync/atomic"
 
+	digest "github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	oras "oras.land/oras-go/v2"
 )
@@ -32,6 +33,9 @@ func reportTransferStatus(progress TransferProgress, format string, args ...any)
 	reporter.Status(fmt.Sprintf(format, args...))
 }
 
+// copyArtifact copies the artifact graph from src to dst. It returns the bytes
+// of blobs that the destination already had or that were mounted from another
+// repository.
 func copyArtifact(
 	ctx context.Context,
 	src oras.ReadOnlyTarget,
@@ -40,37 +44,46 @@ func copyArtifact(
 	dstRef string,
 	totalBytes int64,
 	progress TransferProgress,
-) (desc ocispec.Descriptor, err error) {
+) (desc ocispec.Descriptor, existingBytes int64, err error) {
+	var existing atomic.Int64
 	copyOptions := oras.DefaultCopyOptions
-	if progress == nil {
-		return oras.Copy(ctx, src, srcRef, dst, dstRef, copyOptions)
-	}
-
-	progress = newCappedTransferProgress(progress, totalBytes)
-	progress.Start(totalBytes)
-	defer func() {
-		progress.Done(err == nil)
-	}()
-
-	src = progressReadOnlyTarget{
-		ReadOnlyTarget: src,
-		progress:       progress,
+	if progress != nil {
+		progress = newCappedTransferProgress(progress, totalBytes)
+		progress.Start(totalBytes)
+		defer func() {
+			progress.Done(err == nil)
+		}()
+
+		src = progressReadOnlyTarget{
+			ReadOnlyTarget: src,
+			progress:       progress,
+		}
 	}
 	copyOptions.OnCopySkipped = func(ctx context.Context, desc ocispec.Descriptor) error {
+		existing.Add(desc.Size)
 		addProgress(progress, desc.Size)
 		return nil
 	}
 	copyOptions.OnMounted = func(ctx context.Context, desc ocispec.Descriptor) error {
+		existing.Add(desc.Size)
 		addProgress(progress, desc.Size)
 		return nil
 	}
 
-	return oras.Copy(ctx, src, srcRef, dst, dstRef, copyOptions)
+	desc, err = oras.Copy(ctx, src, srcRef, dst, dstRef, copyOptions)
+	return desc, existing.Load(), err
 }
 
+// descriptorTotal sums the sizes of descs, counting a blob that is listed
+// more than once, such as a repeated chunk, only once.
 func descriptorTotal(descs ...ocispec.Descriptor) int64 {
 	var total int64
+	seen := make(map[digest.Digest]bool, len(descs))
 	for _, desc := range descs {
+		if desc.Digest != "" && seen[desc.Digest] {
+			continue
+		}
+		seen[desc.Digest] = true
 		if desc.Size > 0 {
 			total += desc.Size
 		}
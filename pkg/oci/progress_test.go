@@ -67,7 +67,7 @@ func TestCopyArtifactReportsByteProgress(t *testing.T) {
 	defer dst.Close()
 	progress := &recordingProgress{}
 	total := descriptorTotal(layerDesc, manifestDesc)
-	got, err := copyArtifact(ctx, src, "progress-test", dst, "progress-test", total, progress)
+	got, _, err := copyArtifact(ctx, src, "progress-test", dst, "progress-test", total, progress)
 	if err != nil {
 		t.Fatalf("expected copy to succeed: %v", err)
 	}
@@ -119,7 +119,7 @@ func TestCopyArtifactMarksProgressFailed(t *testing.T) {
 	}
 	defer dst.Close()
 	progress := &recordingProgress{}
-	_, err = copyArtifact(ctx, src, "progress-test", failingTarget{Target: dst}, "progress-test", descriptorTotal(layerDesc), progress)
+	_, _, err = copyArtifact(ctx, src, "progress-test", failingTarget{Target: dst}, "progress-test", descriptorTotal(layerDesc), progress)
 	if err == nil {
 		t.Fatal("expected copy to fail")
 	}
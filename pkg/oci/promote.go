@@ -6,6 +6,8 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
 )
 
 type artifactReplacement struct {
@@ -14,6 +16,8 @@ type artifactReplacement struct {
 	replaced  bool
 }
 
+// promotePulledArtifacts moves the staged files into place and removes the
+// build metadata sidecars, which describe the replaced local build.
 func promotePulledArtifacts(stagingRoot string, files []ArtifactFile) error {
 	replacements := make([]artifactReplacement, 0, len(files))
 	for _, file := range files {
@@ -62,6 +66,11 @@ func promotePulledArtifacts(stagingRoot string, files []ArtifactFile) error {
 	}
 
 	var cleanupErrs []error
+	for _, file := range files {
+		if err := alchemy_build.RemoveBuildArtifactMetadata(file.Path); err != nil {
+			cleanupErrs = append(cleanupErrs, err)
+		}
+	}
 	for _, replacement := range replacements {
 		if replacement.replaced {
 			if err := os.RemoveAll(replacement.backup); err != nil {
@@ -4,6 +4,9 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
 )
 
 func TestPromotePulledArtifactsReplacesExistingArtifact(t *testing.T) {
@@ -22,6 +25,10 @@ func TestPromotePulledArtifactsReplacesExistingArtifact(t *testing.T) {
 	if err := os.WriteFile(final, []byte("old"), 0o600); err != nil {
 		t.Fatalf("failed to write existing artifact: %v", err)
 	}
+	verification := alchemy_build.ArtifactVerification{VerifiedAt: time.Now(), Protocol: "ssh"}
+	if err := alchemy_build.WriteBuildArtifactMetadata(final, alchemy_build.BuildArtifactMetadata{BuiltAt: time.Now(), Verification: &verification}); err != nil {
+		t.Fatalf("failed to write existing artifact metadata: %v", err)
+	}
 
 	err := promotePulledArtifacts(staging, []ArtifactFile{{Name: "ubuntu/artifact.qcow2", Path: final}})
 	if err != nil {
@@ -42,6 +49,9 @@ func TestPromotePulledArtifactsReplacesExistingArtifact(t *testing.T) {
 	if len(backups) != 0 {
 		t.Fatalf("expected backup cleanup, got %v", backups)
 	}
+	if _, exists, err := alchemy_build.ReadBuildArtifactMetadata(final); err != nil || exists {
+		t.Fatalf("expected the metadata of the replaced build to be removed, got exists=%t err=%v", exists, err)
+	}
 }
 
 func TestPromotePulledArtifactsRollsBackEarlierReplacement(t *testing.T) {
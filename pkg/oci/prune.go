@@ -0,0 +1,292 @@
+package oci
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+// PrunePolicy selects the tagged artifacts that PruneRepository deletes. An
+// artifact is deleted only when every rule allows it.
+type PrunePolicy struct {
+	// KeepLast keeps the newest KeepLast artifacts by their
+	// org.opencontainers.image.created annotation. A negative value disables
+	// the rule.
+	KeepLast int
+	// KeepTags are path.Match patterns; an artifact with a matching tag is
+	// kept.
+	KeepTags []string
+	// OlderThan keeps artifacts created more recently. Zero disables the rule.
+	OlderThan time.Duration
+	DryRun    bool
+}
+
+// PrunedArtifact is a tagged artifact of a pruned repository, with the
+// reason it is deleted or kept.
+type PrunedArtifact struct {
+	Digest    string   `json:"digest"`
+	MediaType string   `json:"media_type"`
+	Tags      []string `json:"tags"`
+	Created   string   `json:"created,omitempty"`
+	Reason    string   `json:"reason"`
+	// Manifests and Referrers list the index entries and the referrers, such
+	// as signatures, that are deleted with the artifact.
+	Manifests []string `json:"manifests,omitempty"`
+	Referrers []string `json:"referrers,omitempty"`
+}
+
+type PruneResult struct {
+	Repository string           `json:"repository"`
+	DryRun     bool             `json:"dry_run"`
+	Deleted    []PrunedArtifact `json:"deleted"`
+	Kept       []PrunedArtifact `json:"kept"`
+}
+
+// prunedManifest is the part of a manifest or index that pruning reads.
+type prunedManifest struct {
+	Annotations map[string]string    `json:"annotations"`
+	Manifests   []ocispec.Descriptor `json:"manifests"`
+}
+
+type pruneCandidate struct {
+	desc     ocispec.Descriptor
+	tags     []string
+	created  time.Time
+	children []ocispec.Descriptor
+}
+
+// PruneRepository applies policy to the tagged artifacts of repository and
+// deletes the manifests it selects, together with their referrers and the
+// entries of deleted indexes that no kept artifact uses. An artifact that is
+// an entry of a kept index is kept even when the policy selects it. The registry
+// reclaims the blobs in its own garbage collection.
+func PruneRepository(ctx context.Context, repository string, policy PrunePolicy, opts RegistryOptions) (PruneResult, error) {
+	if policy.KeepLast < 0 && policy.OlderThan <= 0 {
+		return PruneResult{}, errors.New("prune needs a keep-last count or an older-than age")
+	}
+	for _, pattern := range policy.KeepTags {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return PruneResult{}, fmt.Errorf("invalid tag pattern %q: %w", pattern, err)
+		}
+	}
+	remoteRef, err := parseRepositoryReference(repository)
+	if err != nil {
+		return PruneResult{}, err
+	}
+	repo, err := newRepository(remoteRef, opts)
+	if err != nil {
+		return PruneResult{}, err
+	}
+
+	candidates, err := pruneCandidates(ctx, repo)
+	if err != nil {
+		return PruneResult{}, err
+	}
+	result := PruneResult{
+		Repository: remoteRef.repository,
+		DryRun:     policy.DryRun,
+		Deleted:    []PrunedArtifact{},
+		Kept:       []PrunedArtifact{},
+	}
+	reasons := make([]string, len(candidates))
+	deleteArtifacts := make([]bool, len(candidates))
+	protected := map[digest.Digest]bool{}
+	// keptIndexes names a kept index of each protected entry, so an entry
+	// that the policy would delete on its own is kept with its index.
+	keptIndexes := map[digest.Digest]string{}
+	for i, candidate := range candidates {
+		reasons[i], deleteArtifacts[i] = pruneDecision(candidate, candidates, policy, time.Now())
+		if !deleteArtifacts[i] {
+			protected[candidate.desc.Digest] = true
+			for _, child := range candidate.children {
+				protected[child.Digest] = true
+				keptIndexes[child.Digest] = candidate.tags[0]
+			}
+		}
+	}
+	var deleted []pruneCandidate
+	for i, candidate := range candidates {
+		artifact := PrunedArtifact{
+			Digest:    candidate.desc.Digest.String(),
+			MediaType: candidate.desc.MediaType,
+			Tags:      candidate.tags,
+			Reason:    reasons[i],
+		}
+		if !candidate.created.IsZero() {
+			artifact.Created = candidate.created.Format(time.RFC3339)
+		}
+		if deleteArtifacts[i] && protected[candidate.desc.Digest] {
+			artifact.Reason = "entry of kept index " + keptIndexes[candidate.desc.Digest]
+			for _, child := range candidate.children {
+				protected[child.Digest] = true
+			}
+			deleteArtifacts[i] = false
+		}
+		if !deleteArtifacts[i] {
+			result.Kept = append(result.Kept, artifact)
+			continue
+		}
+		result.Deleted = append(result.Deleted, artifact)
+		deleted = append(deleted, candidate)
+	}
+
+	for i, candidate := range deleted {
+		nodes := []ocispec.Descriptor{candidate.desc}
+		for _, child := range candidate.children {
+			if !protected[child.Digest] {
+				nodes = append(nodes, child)
+				result.Deleted[i].Manifests = append(result.Deleted[i].Manifests, child.Digest.String())
+			}
+		}
+		var referrers []ocispec.Descriptor
+		for _, node := range nodes {
+			nodeReferrers, err := collectReferrers(ctx, repo, node)
+			if err != nil {
+				return result, err
+			}
+			referrers = append(referrers, nodeReferrers...)
+		}
+		for _, referrer := range referrers {
+			result.Deleted[i].Referrers = append(result.Deleted[i].Referrers, referrer.Digest.String())
+		}
+		if policy.DryRun {
+			continue
+		}
+		// Referrers go first so a failed prune never leaves them without a
+		// subject; the index goes before its entries.
+		for _, node := range append(slices.Clone(referrers), nodes...) {
+			if err := repo.Delete(ctx, node); err != nil {
+				return result, fmt.Errorf("delete OCI manifest %s from %s: %w", node.Digest, remoteRef.repository, err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// pruneCandidates returns the tagged artifacts of repo, one per digest,
+// newest first.
+func pruneCandidates(ctx context.Context, repo *remote.Repository) ([]pruneCandidate, error) {
+	tags, err := registry.Tags(ctx, repo)
+	if err != nil {
+		return nil, fmt.Errorf("list tags of OCI repository %s: %w", repo.Reference.Repository, err)
+	}
+	byDigest := map[digest.Digest]*pruneCandidate{}
+	var candidates []*pruneCandidate
+	for _, tag := range tags {
+		desc, err := repo.Resolve(ctx, tag)
+		if err != nil {
+			return nil, fmt.Errorf("resolve OCI tag %s: %w", tag, err)
+		}
+		if candidate, ok := byDigest[desc.Digest]; ok {
+			candidate.tags = append(candidate.tags, tag)
+			continue
+		}
+		manifestBytes, err := content.FetchAll(ctx, repo, desc)
+		if err != nil {
+			return nil, fmt.Errorf("fetch OCI manifest %s: %w", tag, err)
+		}
+		var manifest prunedManifest
+		if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+			return nil, fmt.Errorf("decode OCI manifest %s: %w", tag, err)
+		}
+		candidate := &pruneCandidate{desc: desc, tags: []string{tag}}
+		if created, err := time.Parse(time.RFC3339, manifest.Annotations[ocispec.AnnotationCreated]); err == nil {
+			candidate.created = created
+		}
+		if desc.MediaType == ocispec.MediaTypeImageIndex {
+			candidate.children = manifest.Manifests
+		}
+		byDigest[desc.Digest] = candidate
+		candidates = append(candidates, candidate)
+	}
+
+	slices.SortStableFunc(candidates, func(a, b *pruneCandidate) int {
+		if c := b.created.Compare(a.created); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.desc.Digest, b.desc.Digest)
+	})
+	result := make([]pruneCandidate, 0, len(candidates))
+	for _, candidate := range candidates {
+		result = append(result, *candidate)
+	}
+	return result, nil
+}
+
+// pruneDecision reports whether policy deletes candidate and why. candidates
+// are all artifacts of the repository, newest first. Artifacts kept for their
+// tags do not count towards KeepLast.
+func pruneDecision(candidate pruneCandidate, candidates []pruneCandidate, policy PrunePolicy, now time.Time) (string, bool) {
+	if tag, pattern, ok := keptTag(candidate, policy.KeepTags); ok {
+		return fmt.Sprintf("tag %s matches %s", tag, pattern), false
+	}
+	if candidate.created.IsZero() {
+		return "no " + ocispec.AnnotationCreated + " annotation", false
+	}
+
+	var reasons []string
+	if policy.KeepLast >= 0 {
+		rank := 0
+		for _, other := range candidates {
+			if other.desc.Digest == candidate.desc.Digest {
+				break
+			}
+			if _, _, kept := keptTag(other, policy.KeepTags); !kept && !other.created.IsZero() {
+				rank++
+			}
+		}
+		if rank < policy.KeepLast {
+			return fmt.Sprintf("one of the newest %d", policy.KeepLast), false
+		}
+		reasons = append(reasons, fmt.Sprintf("not one of the newest %d", policy.KeepLast))
+	}
+	if policy.OlderThan > 0 {
+		if now.Sub(candidate.created) < policy.OlderThan {
+			return fmt.Sprintf("newer than %s", policy.OlderThan), false
+		}
+		reasons = append(reasons, fmt.Sprintf("older than %s", policy.OlderThan))
+	}
+	return strings.Join(reasons, " and "), true
+}
+
+func keptTag(candidate pruneCandidate, patterns []string) (tag string, pattern string, ok bool) {
+	for _, tag := range candidate.tags {
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, tag); matched {
+				return tag, pattern, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// collectReferrers returns the referrers of desc and, recursively, their
+// referrers, deepest first.
+func collectReferrers(ctx context.Context, repo content.ReadOnlyGraphStorage, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+	referrers, err := registry.Referrers(ctx, repo, desc, "")
+	if err != nil {
+		return nil, fmt.Errorf("list referrers of OCI artifact %s: %w", desc.Digest, err)
+	}
+	var collected []ocispec.Descriptor
+	for _, referrer := range referrers {
+		nested, err := collectReferrers(ctx, repo, referrer)
+		if err != nil {
+			return nil, err
+		}
+		collected = append(collected, nested...)
+		collected = append(collected, referrer)
+	}
+	return collected, nil
+}
@@ -0,0 +1,231 @@
+package oci
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	oras "oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/registry/remote"
+)
+
+func TestPruneRepositoryAppliesRetentionRules(t *testing.T) {
+	registry := newTestRegistry(t)
+	repo := testPruneRepository(t, registry, "dev-alchemy/ubuntu")
+	now := time.Now().UTC()
+	nightly3 := testPruneArtifact(t, repo, now.Add(-1*time.Hour), "nightly-3")
+	nightly2 := testPruneArtifact(t, repo, now.Add(-48*time.Hour), "nightly-2")
+	nightly1 := testPruneArtifact(t, repo, now.Add(-72*time.Hour), "nightly-1")
+	release := testPruneArtifact(t, repo, now.Add(-90*24*time.Hour), "v1.0.0", "stable")
+	buildLog, err := attachReferrerBytes(context.Background(), repo, nightly1, ArtifactTypeBuildLog, MediaTypeBuildLog, "build.log", []byte("build ok\n"))
+	if err != nil {
+		t.Fatalf("failed to attach referrer: %v", err)
+	}
+
+	policy := PrunePolicy{KeepLast: 1, KeepTags: []string{"v*"}, OlderThan: 24 * time.Hour, DryRun: true}
+	dryRun, err := PruneRepository(context.Background(), registry.host+"/dev-alchemy/ubuntu", policy, testRegistryOptions())
+	if err != nil {
+		t.Fatalf("failed to plan prune: %v", err)
+	}
+	if got := prunedDigests(dryRun.Deleted); !slices.Equal(got, []digest.Digest{nightly2.Digest, nightly1.Digest}) {
+		t.Fatalf("expected nightly-2 and nightly-1 to be deleted, got %v", got)
+	}
+	if got := prunedDigests(dryRun.Kept); !slices.Equal(got, []digest.Digest{nightly3.Digest, release.Digest}) {
+		t.Fatalf("expected nightly-3 and the release to be kept, got %v", got)
+	}
+	if reason := dryRun.Deleted[0].Reason; reason != "not one of the newest 1 and older than 24h0m0s" {
+		t.Fatalf("unexpected delete reason %q", reason)
+	}
+	if reason := dryRun.Kept[1].Reason; reason != "tag stable matches v*" && reason != "tag v1.0.0 matches v*" {
+		t.Fatalf("unexpected keep reason %q", reason)
+	}
+	if !slices.Equal(dryRun.Deleted[1].Referrers, []string{buildLog.Digest}) {
+		t.Fatalf("expected the build log referrer to be listed with nightly-1, got %+v", dryRun.Deleted[1])
+	}
+	for _, desc := range []ocispec.Descriptor{nightly2, nightly1} {
+		if !testManifestExists(t, repo, desc) {
+			t.Fatalf("expected dry run to keep %s", desc.Digest)
+		}
+	}
+
+	policy.DryRun = false
+	pruned, err := PruneRepository(context.Background(), registry.host+"/dev-alchemy/ubuntu", policy, testRegistryOptions())
+	if err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	if len(pruned.Deleted) != 2 {
+		t.Fatalf("expected two deleted artifacts, got %+v", pruned.Deleted)
+	}
+	for _, desc := range []ocispec.Descriptor{nightly2, nightly1, {MediaType: ocispec.MediaTypeImageManifest, Digest: digest.Digest(buildLog.Digest)}} {
+		if testManifestExists(t, repo, desc) {
+			t.Fatalf("expected %s to be deleted", desc.Digest)
+		}
+	}
+	for _, desc := range []ocispec.Descriptor{nightly3, release} {
+		if !testManifestExists(t, repo, desc) {
+			t.Fatalf("expected %s to be kept", desc.Digest)
+		}
+	}
+	if _, err := repo.Resolve(context.Background(), "nightly-2"); err == nil {
+		t.Fatal("expected the tag of a deleted artifact to be gone")
+	}
+}
+
+func TestPruneRepositoryKeepsIndexEntriesThatAreStillTagged(t *testing.T) {
+	registry := newTestRegistry(t)
+	reference := registry.reference("dev-alchemy/ubuntu-server", "nightly")
+	privateKey, _ := testSigningKeyPair(t)
+	linuxVM, _ := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, []byte("linux"))
+	darwinVM, _ := testLayoutArchiveVM(t, alchemy_build.HostOsDarwin, alchemy_build.VirtualizationEngineUtm, []byte("darwin"))
+	linux, err := Push(context.Background(), linuxVM, reference, PushOptions{RegistryOptions: testRegistryOptions()})
+	if err != nil {
+		t.Fatalf("failed to push linux artifact: %v", err)
+	}
+	darwin, err := Push(context.Background(), darwinVM, reference, PushOptions{RegistryOptions: testRegistryOptions(), SigningKey: privateKey, Index: true})
+	if err != nil {
+		t.Fatalf("failed to push darwin artifact to index: %v", err)
+	}
+	repo := testPruneRepository(t, registry, "dev-alchemy/ubuntu-server")
+	linuxDesc, err := repo.Resolve(context.Background(), linux.Digest)
+	if err != nil {
+		t.Fatalf("failed to resolve linux manifest: %v", err)
+	}
+	if err := repo.Tag(context.Background(), linuxDesc, "linux"); err != nil {
+		t.Fatalf("failed to tag linux manifest: %v", err)
+	}
+
+	result, err := PruneRepository(context.Background(), registry.host+"/dev-alchemy/ubuntu-server", PrunePolicy{KeepLast: 0, KeepTags: []string{"linux"}}, testRegistryOptions())
+	if err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	if len(result.Deleted) != 1 || result.Deleted[0].Digest != darwin.Index {
+		t.Fatalf("expected only the index to be deleted, got %+v", result.Deleted)
+	}
+	if !slices.Equal(result.Deleted[0].Manifests, []string{darwin.Digest}) || len(result.Deleted[0].Referrers) != 1 {
+		t.Fatalf("expected the darwin entry and its signature to be deleted with the index, got %+v", result.Deleted[0])
+	}
+	if testManifestExists(t, repo, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.Digest(darwin.Digest)}) {
+		t.Fatal("expected the darwin entry to be deleted")
+	}
+	if !testManifestExists(t, repo, linuxDesc) {
+		t.Fatal("expected the tagged linux entry to be kept")
+	}
+}
+
+func TestPruneRepositoryKeepsTaggedEntriesOfKeptIndexes(t *testing.T) {
+	registry := newTestRegistry(t)
+	reference := registry.reference("dev-alchemy/ubuntu-server", "nightly")
+	linuxVM, _ := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, []byte("linux"))
+	linux, err := Push(context.Background(), linuxVM, reference, PushOptions{RegistryOptions: testRegistryOptions(), Index: true})
+	if err != nil {
+		t.Fatalf("failed to push linux artifact to index: %v", err)
+	}
+	repo := testPruneRepository(t, registry, "dev-alchemy/ubuntu-server")
+	linuxDesc, err := repo.Resolve(context.Background(), linux.Digest)
+	if err != nil {
+		t.Fatalf("failed to resolve linux manifest: %v", err)
+	}
+	if err := repo.Tag(context.Background(), linuxDesc, "linux"); err != nil {
+		t.Fatalf("failed to tag linux manifest: %v", err)
+	}
+
+	result, err := PruneRepository(context.Background(), registry.host+"/dev-alchemy/ubuntu-server", PrunePolicy{KeepLast: 0, KeepTags: []string{"nightly"}}, testRegistryOptions())
+	if err != nil {
+		t.Fatalf("failed to prune: %v", err)
+	}
+	if len(result.Deleted) != 0 {
+		t.Fatalf("expected nothing to be deleted, got %+v", result.Deleted)
+	}
+	var entry *PrunedArtifact
+	for i := range result.Kept {
+		if result.Kept[i].Digest == linux.Digest {
+			entry = &result.Kept[i]
+		}
+	}
+	if entry == nil || entry.Reason != "entry of kept index nightly" {
+		t.Fatalf("expected the linux entry to be kept with its index, got %+v", result.Kept)
+	}
+	if !testManifestExists(t, repo, linuxDesc) {
+		t.Fatal("expected the linux entry to stay in the repository")
+	}
+}
+
+func TestPruneDecisionKeepsArtifactsWithoutCreatedTime(t *testing.T) {
+	now := time.Now()
+	undated := pruneCandidate{desc: ocispec.Descriptor{Digest: digest.FromString("undated")}, tags: []string{"manual"}}
+	old := pruneCandidate{desc: ocispec.Descriptor{Digest: digest.FromString("old")}, tags: []string{"old"}, created: now.Add(-time.Hour)}
+	candidates := []pruneCandidate{old, undated}
+
+	if reason, deleted := pruneDecision(undated, candidates, PrunePolicy{KeepLast: 0}, now); deleted || !strings.Contains(reason, ocispec.AnnotationCreated) {
+		t.Fatalf("expected the undated artifact to be kept, got %q (deleted %t)", reason, deleted)
+	}
+	if _, deleted := pruneDecision(old, candidates, PrunePolicy{KeepLast: 1}, now); deleted {
+		t.Fatal("expected the undated artifact not to take a keep-last slot")
+	}
+}
+
+func TestPruneRepositoryRejectsMissingRules(t *testing.T) {
+	registry := newTestRegistry(t)
+	_, err := PruneRepository(context.Background(), registry.host+"/dev-alchemy/ubuntu", PrunePolicy{KeepLast: -1, KeepTags: []string{"v*"}}, testRegistryOptions())
+	if err == nil || !strings.Contains(err.Error(), "keep-last") {
+		t.Fatalf("expected a missing rule error, got %v", err)
+	}
+	_, err = PruneRepository(context.Background(), registry.host+"/dev-alchemy/ubuntu", PrunePolicy{KeepLast: 1, KeepTags: []string{"["}}, testRegistryOptions())
+	if err == nil || !strings.Contains(err.Error(), "invalid tag pattern") {
+		t.Fatalf("expected an invalid pattern error, got %v", err)
+	}
+}
+
+func testPruneRepository(t *testing.T, registry *testRegistry, repository string) *remote.Repository {
+	t.Helper()
+
+	repo, err := newRepository(remoteReference{repository: registry.host + "/" + repository, registry: registry.host}, testRegistryOptions())
+	if err != nil {
+		t.Fatalf("failed to create repository: %v", err)
+	}
+	return repo
+}
+
+// testPruneArtifact pushes an empty Dev Alchemy manifest created at created
+// under tags.
+func testPruneArtifact(t *testing.T, repo *remote.Repository, created time.Time, tags ...string) ocispec.Descriptor {
+	t.Helper()
+
+	annotations := map[string]string{
+		ocispec.AnnotationRefName: tags[0],
+		ocispec.AnnotationCreated: created.Format(time.RFC3339),
+	}
+	desc, err := oras.PackManifest(context.Background(), repo, oras.PackManifestVersion1_1, ArtifactType, oras.PackManifestOptions{ManifestAnnotations: annotations})
+	if err != nil {
+		t.Fatalf("failed to push manifest %s: %v", tags[0], err)
+	}
+	for _, tag := range tags {
+		if err := repo.Tag(context.Background(), desc, tag); err != nil {
+			t.Fatalf("failed to tag manifest as %s: %v", tag, err)
+		}
+	}
+	return desc
+}
+
+func testManifestExists(t *testing.T, repo *remote.Repository, desc ocispec.Descriptor) bool {
+	t.Helper()
+
+	exists, err := repo.Manifests().Exists(context.Background(), desc)
+	if err != nil {
+		t.Fatalf("failed to check manifest %s: %v", desc.Digest, err)
+	}
+	return exists
+}
+
+func prunedDigests(artifacts []PrunedArtifact) []digest.Digest {
+	digests := make([]digest.Digest, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		digests = append(digests, digest.Digest(artifact.Digest))
+	}
+	return digests
+}
@@ -0,0 +1,285 @@
+package oci
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/sync/errgroup"
+	"oras.land/oras-go/v2/content"
+)
+
+const (
+	pullStagingDirName = alchemy_build.OCIPullStagingDirName
+	partialBlobSuffix  = ".partial"
+	// pullConcurrency matches the blob concurrency of oras.Copy.
+	pullConcurrency = 3
+
+	// DefaultPullStagingMaxAge is how long blobs of an unfinished pull are
+	// kept for a retry before a later pull removes them.
+	DefaultPullStagingMaxAge = alchemy_build.OCIPullStagingMaxAge
+)
+
+// pullStaging is the persistent, digest-addressed staging area of pulls into
+// one artifact root. Complete blobs are kept as <algorithm>/<encoded> and
+// blobs still downloading as <algorithm>/<encoded>.partial, so a pull that is
+// interrupted and retried only downloads what is still missing.
+type pullStaging struct {
+	root string
+}
+
+// openPullStaging opens the staging area below artifactRoot and removes
+// entries that have not been written to for longer than maxAge.
+func openPullStaging(artifactRoot string, maxAge time.Duration) (pullStaging, error) {
+	staging := pullStaging{root: filepath.Join(artifactRoot, pullStagingDirName)}
+	if err := os.MkdirAll(staging.root, 0o700); err != nil {
+		return pullStaging{}, fmt.Errorf("create OCI pull staging directory: %w", err)
+	}
+	if maxAge <= 0 {
+		maxAge = DefaultPullStagingMaxAge
+	}
+	if err := staging.prune(time.Now().Add(-maxAge)); err != nil {
+		return pullStaging{}, fmt.Errorf("remove aged OCI pull staging entries: %w", err)
+	}
+	return staging, nil
+}
+
+func (s pullStaging) blobPath(d digest.Digest) string {
+	return filepath.Join(s.root, d.Algorithm().String(), d.Encoded())
+}
+
+// prune removes staged blobs last modified before cutoff.
+func (s pullStaging) prune(cutoff time.Time) error {
+	return filepath.WalkDir(s.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Before(cutoff) {
+			return os.Remove(path)
+		}
+		return nil
+	})
+}
+
+// fetchAll makes every blob of descs complete in the staging area and returns
+// the bytes that earlier attempts had already staged.
+func (s pullStaging) fetchAll(ctx context.Context, fetcher content.Fetcher, descs []ocispec.Descriptor, totalBytes int64, progress TransferProgress) (resumedBytes int64, err error) {
+	if progress != nil {
+		progress = newCappedTransferProgress(progress, totalBytes)
+		progress.Start(totalBytes)
+		defer func() {
+			progress.Done(err == nil)
+		}()
+	}
+
+	var resumed atomic.Int64
+	seen := make(map[digest.Digest]bool, len(descs))
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(pullConcurrency)
+	for _, desc := range descs {
+		if seen[desc.Digest] {
+			continue
+		}
+		seen[desc.Digest] = true
+		group.Go(func() error {
+			staged, err := s.fetch(groupCtx, fetcher, desc, progress)
+			resumed.Add(staged)
+			return err
+		})
+	}
+	err = group.Wait()
+	return resumed.Load(), err
+}
+
+// fetch downloads desc into the staging area, resuming a partial download
+// with a range request when the fetched content supports seeking, and
+// verifies the digest before the blob counts as complete. It returns the bytes
+// that were already staged.
+func (s pullStaging) fetch(ctx context.Context, fetcher content.Fetcher, desc ocispec.Descriptor, progress TransferProgress) (int64, error) {
+	if err := desc.Digest.Validate(); err != nil {
+		return 0, fmt.Errorf("invalid OCI blob digest %q: %w", desc.Digest, err)
+	}
+	blobPath := s.blobPath(desc.Digest)
+	if err := verifyBlobFile(blobPath, desc); err == nil {
+		addProgress(progress, desc.Size)
+		return desc.Size, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		if err := os.Remove(blobPath); err != nil {
+			return 0, fmt.Errorf("remove invalid staged blob %s: %w", desc.Digest, err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(blobPath), 0o700); err != nil {
+		return 0, fmt.Errorf("create OCI pull staging directory: %w", err)
+	}
+	partialPath := blobPath + partialBlobSuffix
+	partial, err := os.OpenFile(partialPath, os.O_CREATE|os.O_RDWR, 0o600) // #nosec G304 -- partialPath is derived from a validated digest.
+	if err != nil {
+		return 0, fmt.Errorf("open partial blob %s: %w", desc.Digest, err)
+	}
+	blobHash := desc.Digest.Algorithm().Hash()
+	offset, err := resumeOffset(partial, desc.Size, blobHash)
+	if err == nil {
+		offset, err = downloadBlob(ctx, fetcher, desc, partial, offset, blobHash, progress)
+	}
+	if closeErr := partial.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, fmt.Errorf("download OCI blob %s: %w", desc.Digest, err)
+	}
+	if digest.NewDigest(desc.Digest.Algorithm(), blobHash) != desc.Digest {
+		removeErr := os.Remove(partialPath)
+		return 0, errors.Join(fmt.Errorf("downloaded OCI blob %s does not match its digest", desc.Digest), removeErr)
+	}
+	if err := os.Rename(partialPath, blobPath); err != nil {
+		return 0, fmt.Errorf("complete staged blob %s: %w", desc.Digest, err)
+	}
+	return offset, nil
+}
+
+// resumeOffset feeds the bytes already in partial to blobHash and returns how
+// many there are, discarding a partial that is larger than the blob.
+func resumeOffset(partial *os.File, size int64, blobHash hash.Hash) (int64, error) {
+	offset, err := io.Copy(blobHash, partial)
+	if err != nil {
+		return 0, err
+	}
+	if offset <= size {
+		return offset, nil
+	}
+	return 0, restartPartial(partial, blobHash)
+}
+
+// downloadBlob appends the blob content after offset to partial and returns
+// offset, or zero when the download had to start over.
+func downloadBlob(ctx context.Context, fetcher content.Fetcher, desc ocispec.Descriptor, partial *os.File, offset int64, blobHash hash.Hash, progress TransferProgress) (int64, error) {
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	if offset > 0 && !seekBlob(rc, offset) {
+		// The registry does not serve range requests, so the content is read
+		// again from the start of the response.
+		offset = 0
+		if err := restartPartial(partial, blobHash); err != nil {
+			return 0, err
+		}
+	}
+	addProgress(progress, offset)
+
+	reader := progressReadCloser{ReadCloser: rc, progress: progress}
+	written, err := io.Copy(io.MultiWriter(partial, blobHash), io.LimitReader(reader, desc.Size-offset+1))
+	if err != nil {
+		return 0, err
+	}
+	if offset+written != desc.Size {
+		return 0, fmt.Errorf("received %d bytes, expected %d", offset+written, desc.Size)
+	}
+	return offset, nil
+}
+
+// seekBlob moves rc to offset with a range request. Registry blob readers
+// stay at the start of the content when the registry refuses the range.
+func seekBlob(rc io.ReadCloser, offset int64) bool {
+	seeker, ok := rc.(io.Seeker)
+	if !ok {
+		return false
+	}
+	_, err := seeker.Seek(offset, io.SeekStart)
+	return err == nil
+}
+
+func restartPartial(partial *os.File, blobHash hash.Hash) error {
+	if err := partial.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := partial.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	blobHash.Reset()
+	return nil
+}
+
+// verifyBlobFile checks that the file at path holds exactly the content of
+// desc.
+func verifyBlobFile(path string, desc ocispec.Descriptor) error {
+	file, err := os.Open(path) // #nosec G304 -- path is derived from a validated digest.
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	verifier := desc.Digest.Verifier()
+	size, err := io.Copy(verifier, file)
+	if err != nil {
+		return err
+	}
+	if size != desc.Size || !verifier.Verified() {
+		return fmt.Errorf("staged blob %s does not match its descriptor", desc.Digest)
+	}
+	return nil
+}
+
+// materialize places the staged blob of desc at target, hard-linking where
+// possible so multi-GB blobs are not copied.
+func (s pullStaging) materialize(desc ocispec.Descriptor, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+		return err
+	}
+	blobPath := s.blobPath(desc.Digest)
+	if err := os.Link(blobPath, target); err == nil {
+		return nil
+	}
+	source, err := os.Open(blobPath) // #nosec G304 -- blobPath is derived from a validated digest.
+	if err != nil {
+		return err
+	}
+	defer source.Close()
+	destination, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600) // #nosec G304 -- target is inside the pull staging directory.
+	if err != nil {
+		return err
+	}
+	_, copyErr := io.Copy(destination, source)
+	return errors.Join(copyErr, destination.Close())
+}
+
+// remove deletes the staged blobs of descs once a pull has completed, and the
+// staging area itself when nothing else is left in it.
+func (s pullStaging) remove(descs []ocispec.Descriptor) error {
+	var errs []error
+	for _, desc := range descs {
+		blobPath := s.blobPath(desc.Digest)
+		for _, path := range []string{blobPath, blobPath + partialBlobSuffix} {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+		}
+	}
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	for _, entry := range entries {
+		// Removing a directory fails while another pull still has blobs in
+		// it, which is fine.
+		_ = os.Remove(filepath.Join(s.root, entry.Name()))
+	}
+	_ = os.Remove(s.root)
+	return errors.Join(errs...)
+}
@@ -0,0 +1,254 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+type blobFetcher struct {
+	content  []byte
+	seekable bool
+	// failAfter cuts the response after this many bytes when positive.
+	failAfter int
+	fetches   int
+	seeks     []int64
+}
+
+func (f *blobFetcher) Fetch(context.Context, ocispec.Descriptor) (io.ReadCloser, error) {
+	f.fetches++
+	reader := &blobReader{fetcher: f, content: bytes.NewReader(f.content)}
+	if f.seekable {
+		return seekableBlobReader{reader}, nil
+	}
+	return reader, nil
+}
+
+type blobReader struct {
+	content *bytes.Reader
+	fetcher *blobFetcher
+	read    int
+}
+
+func (r *blobReader) Read(p []byte) (int, error) {
+	if r.fetcher.failAfter > 0 {
+		remaining := r.fetcher.failAfter - r.read
+		if remaining <= 0 {
+			return 0, errors.New("connection reset")
+		}
+		p = p[:min(len(p), remaining)]
+	}
+	n, err := r.content.Read(p)
+	r.read += n
+	return n, err
+}
+
+func (r *blobReader) Close() error {
+	return nil
+}
+
+type seekableBlobReader struct {
+	*blobReader
+}
+
+func (r seekableBlobReader) Seek(offset int64, whence int) (int64, error) {
+	r.fetcher.seeks = append(r.fetcher.seeks, offset)
+	return r.content.Seek(offset, whence)
+}
+
+func TestPullStagingResumesPartialBlobWithRangeRequest(t *testing.T) {
+	content := bytes.Repeat([]byte("dev-alchemy-resume-"), 100)
+	desc := testBlobDescriptor(content)
+	staging, err := openPullStaging(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("failed to open pull staging: %v", err)
+	}
+
+	fetcher := &blobFetcher{content: content, seekable: true, failAfter: 700}
+	if _, err := staging.fetchAll(context.Background(), fetcher, []ocispec.Descriptor{desc}, desc.Size, nil); err == nil {
+		t.Fatal("expected the interrupted download to fail")
+	}
+	partial, err := os.Stat(staging.blobPath(desc.Digest) + partialBlobSuffix)
+	if err != nil {
+		t.Fatalf("expected the partial blob to be kept: %v", err)
+	}
+	if partial.Size() != 700 {
+		t.Fatalf("expected 700 partial bytes, got %d", partial.Size())
+	}
+
+	fetcher.failAfter = 0
+	resumed, err := staging.fetchAll(context.Background(), fetcher, []ocispec.Descriptor{desc}, desc.Size, nil)
+	if err != nil {
+		t.Fatalf("expected the resumed download to succeed: %v", err)
+	}
+	if resumed != 700 {
+		t.Fatalf("expected 700 resumed bytes, got %d", resumed)
+	}
+	if len(fetcher.seeks) != 1 || fetcher.seeks[0] != 700 {
+		t.Fatalf("expected one range request from offset 700, got %v", fetcher.seeks)
+	}
+	assertStagedBlob(t, staging, desc, content)
+}
+
+func TestPullStagingRestartsWithoutRangeSupport(t *testing.T) {
+	content := bytes.Repeat([]byte("dev-alchemy-restart-"), 100)
+	desc := testBlobDescriptor(content)
+	staging, err := openPullStaging(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("failed to open pull staging: %v", err)
+	}
+	writeTestPartialBlob(t, staging, desc, content[:500])
+
+	resumed, err := staging.fetchAll(context.Background(), &blobFetcher{content: content}, []ocispec.Descriptor{desc}, desc.Size, nil)
+	if err != nil {
+		t.Fatalf("expected the restarted download to succeed: %v", err)
+	}
+	if resumed != 0 {
+		t.Fatalf("expected no resumed bytes without range support, got %d", resumed)
+	}
+	assertStagedBlob(t, staging, desc, content)
+}
+
+func TestPullStagingDiscardsCorruptPartialBlob(t *testing.T) {
+	content := bytes.Repeat([]byte("dev-alchemy-corrupt-"), 100)
+	desc := testBlobDescriptor(content)
+	staging, err := openPullStaging(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("failed to open pull staging: %v", err)
+	}
+	writeTestPartialBlob(t, staging, desc, bytes.Repeat([]byte("x"), 500))
+
+	_, err = staging.fetchAll(context.Background(), &blobFetcher{content: content, seekable: true}, []ocispec.Descriptor{desc}, desc.Size, nil)
+	if err == nil {
+		t.Fatal("expected a corrupt partial blob to fail digest verification")
+	}
+	if _, err := os.Stat(staging.blobPath(desc.Digest) + partialBlobSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected the corrupt partial blob to be removed, got %v", err)
+	}
+
+	if _, err := staging.fetchAll(context.Background(), &blobFetcher{content: content, seekable: true}, []ocispec.Descriptor{desc}, desc.Size, nil); err != nil {
+		t.Fatalf("expected the retried download to succeed: %v", err)
+	}
+	assertStagedBlob(t, staging, desc, content)
+}
+
+func TestPullStagingReusesCompleteBlob(t *testing.T) {
+	content := []byte("dev-alchemy-complete")
+	desc := testBlobDescriptor(content)
+	staging, err := openPullStaging(t.TempDir(), 0)
+	if err != nil {
+		t.Fatalf("failed to open pull staging: %v", err)
+	}
+	if _, err := staging.fetchAll(context.Background(), &blobFetcher{content: content}, []ocispec.Descriptor{desc}, desc.Size, nil); err != nil {
+		t.Fatalf("failed to stage blob: %v", err)
+	}
+
+	fetcher := &blobFetcher{content: content}
+	resumed, err := staging.fetchAll(context.Background(), fetcher, []ocispec.Descriptor{desc, desc}, desc.Size, nil)
+	if err != nil {
+		t.Fatalf("expected the staged blob to be reused: %v", err)
+	}
+	if fetcher.fetches != 0 || resumed != desc.Size {
+		t.Fatalf("expected no fetches and %d resumed bytes, got %d fetches and %d bytes", desc.Size, fetcher.fetches, resumed)
+	}
+}
+
+func TestOpenPullStagingRemovesAgedEntries(t *testing.T) {
+	root := t.TempDir()
+	staging, err := openPullStaging(root, 0)
+	if err != nil {
+		t.Fatalf("failed to open pull staging: %v", err)
+	}
+	aged := testBlobDescriptor([]byte("aged"))
+	fresh := testBlobDescriptor([]byte("fresh"))
+	writeTestPartialBlob(t, staging, aged, []byte("ag"))
+	writeTestPartialBlob(t, staging, fresh, []byte("fr"))
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(staging.blobPath(aged.Digest)+partialBlobSuffix, old, old); err != nil {
+		t.Fatalf("failed to age partial blob: %v", err)
+	}
+
+	if _, err := openPullStaging(root, 24*time.Hour); err != nil {
+		t.Fatalf("failed to reopen pull staging: %v", err)
+	}
+	if _, err := os.Stat(staging.blobPath(aged.Digest) + partialBlobSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected the aged partial blob to be removed, got %v", err)
+	}
+	if _, err := os.Stat(staging.blobPath(fresh.Digest) + partialBlobSuffix); err != nil {
+		t.Fatalf("expected the fresh partial blob to be kept: %v", err)
+	}
+}
+
+func TestPullStagingRemoveCleansUpStagingDirectory(t *testing.T) {
+	root := t.TempDir()
+	content := []byte("dev-alchemy-cleanup")
+	desc := testBlobDescriptor(content)
+	staging, err := openPullStaging(root, 0)
+	if err != nil {
+		t.Fatalf("failed to open pull staging: %v", err)
+	}
+	if _, err := staging.fetchAll(context.Background(), &blobFetcher{content: content}, []ocispec.Descriptor{desc}, desc.Size, nil); err != nil {
+		t.Fatalf("failed to stage blob: %v", err)
+	}
+	target := filepath.Join(root, "staged", "artifact.qcow2")
+	if err := staging.materialize(desc, target); err != nil {
+		t.Fatalf("failed to materialize blob: %v", err)
+	}
+
+	if err := staging.remove([]ocispec.Descriptor{desc}); err != nil {
+		t.Fatalf("failed to remove staged blobs: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, pullStagingDirName)); !os.IsNotExist(err) {
+		t.Fatalf("expected the empty staging directory to be removed, got %v", err)
+	}
+	materialized, err := os.ReadFile(target)
+	if err != nil {
+		t.Fatalf("expected the materialized file to outlive the staging area: %v", err)
+	}
+	if !bytes.Equal(materialized, content) {
+		t.Fatal("materialized file differs from the blob")
+	}
+}
+
+func testBlobDescriptor(content []byte) ocispec.Descriptor {
+	return ocispec.Descriptor{
+		MediaType: MediaTypeChunk,
+		Digest:    digest.FromBytes(content),
+		Size:      int64(len(content)),
+	}
+}
+
+func writeTestPartialBlob(t *testing.T, staging pullStaging, desc ocispec.Descriptor, content []byte) {
+	t.Helper()
+
+	path := staging.blobPath(desc.Digest) + partialBlobSuffix
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		t.Fatalf("failed to create staging dir: %v", err)
+	}
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		t.Fatalf("failed to write partial blob: %v", err)
+	}
+}
+
+func assertStagedBlob(t *testing.T, staging pullStaging, desc ocispec.Descriptor, content []byte) {
+	t.Helper()
+
+	staged, err := os.ReadFile(staging.blobPath(desc.Digest))
+	if err != nil {
+		t.Fatalf("failed to read staged blob: %v", err)
+	}
+	if !bytes.Equal(staged, content) {
+		t.Fatal("staged blob differs from the fetched content")
+	}
+	if _, err := os.Stat(staging.blobPath(desc.Digest) + partialBlobSuffix); !os.IsNotExist(err) {
+		t.Fatalf("expected no partial blob after completion, got %v", err)
+	}
+}
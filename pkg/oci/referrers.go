@@ -0,0 +1,510 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	oras "oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry"
+)
+
+const (
+	ArtifactTypeBuildLog     = "application/vnd.dev-alchemy.vm-build.log.v1"
+	ArtifactTypeProvenance   = "application/vnd.dev-alchemy.vm-build.provenance.v1"
+	ArtifactTypeVNCRecording = "application/vnd.dev-alchemy.vm-build.vnc-recording.v1"
+
+	MediaTypeBuildLog     = "text/plain; charset=utf-8"
+	MediaTypeProvenance   = "application/vnd.dev-alchemy.vm-build.provenance.v1+json"
+	MediaTypeVNCRecording = "video/mp4"
+
+	// Referrer kinds name the referrer artifact types on the command line.
+	ReferrerKindBuildLog     = "build-log"
+	ReferrerKindProvenance   = "provenance"
+	ReferrerKindVNCRecording = "vnc-recording"
+	ReferrerKindSignature    = "signature"
+)
+
+// referrerKinds maps each referrer kind to its artifact type.
+var referrerKinds = map[string]string{
+	ReferrerKindBuildLog:     ArtifactTypeBuildLog,
+	ReferrerKindProvenance:   ArtifactTypeProvenance,
+	ReferrerKindVNCRecording: ArtifactTypeVNCRecording,
+	ReferrerKindSignature:    ArtifactTypeSignature,
+}
+
+// Referrer is an artifact attached to an artifact manifest, such as its build
+// log or signature.
+type Referrer struct {
+	Kind         string `json:"kind,omitempty"`
+	ArtifactType string `json:"artifact_type"`
+	Digest       string `json:"digest"`
+	Title        string `json:"title,omitempty"`
+	Created      string `json:"created,omitempty"`
+	// Target names the index entry the referrer is attached to when the
+	// artifact is a multi-target index, such as "ubuntu-server-amd64 on
+	// darwin/utm". It is empty for referrers of the index itself.
+	Target string `json:"target,omitempty"`
+	// Size and Path are only known once the referrer content was fetched.
+	Size int64  `json:"size,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+type FetchReferrerOptions struct {
+	RegistryOptions
+	Progress TransferProgress
+}
+
+// buildProvenance records how and from what a pushed artifact was built.
+type buildProvenance struct {
+	Builder   string               `json:"builder"`
+	PushedAt  time.Time            `json:"pushed_at"`
+	Host      provenanceHost       `json:"host"`
+	Target    provenanceTarget     `json:"target"`
+	Artifacts []provenanceArtifact `json:"artifacts"`
+	Subject   provenanceDescriptor `json:"subject"`
+}
+
+type provenanceHost struct {
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+}
+
+type provenanceTarget struct {
+	OS                   string `json:"os"`
+	Type                 string `json:"type,omitempty"`
+	Arch                 string `json:"arch"`
+	HostOS               string `json:"host_os"`
+	VirtualizationEngine string `json:"virtualization_engine"`
+	Slug                 string `json:"slug"`
+}
+
+type provenanceArtifact struct {
+	Name      string                               `json:"name"`
+	MediaType string                               `json:"media_type"`
+	Digest    string                               `json:"digest"`
+	Size      int64                                `json:"size"`
+	Metadata  *alchemy_build.BuildArtifactMetadata `json:"metadata,omitempty"`
+}
+
+type provenanceDescriptor struct {
+	MediaType string `json:"media_type"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+func referrerKind(artifactType string) string {
+	for kind, kindType := range referrerKinds {
+		if kindType == artifactType {
+			return kind
+		}
+	}
+	return ""
+}
+
+// provenanceDocument describes the pushed subject, the VM target and the
+// artifact files with their build metadata sidecars. The files were hashed
+// when the push packed them; a sidecar is only included when it records the
+// pushed size and the file is unchanged since the build, so the metadata
+// belongs to the pushed digest.
+func provenanceDocument(vm alchemy_build.VirtualMachineConfig, files []ArtifactFile, subject ocispec.Descriptor) ([]byte, error) {
+	slugVM := vm
+	provenance := buildProvenance{
+		Builder:  "dev-alchemy",
+		PushedAt: time.Now().UTC(),
+		Host:     provenanceHost{OS: runtime.GOOS, Arch: runtime.GOARCH},
+		Target: provenanceTarget{
+			OS:                   vm.OS,
+			Type:                 vm.UbuntuType,
+			Arch:                 vm.Arch,
+			HostOS:               string(vm.HostOs),
+			VirtualizationEngine: string(vm.VirtualizationEngine),
+			Slug:                 alchemy_build.GenerateVirtualMachineSlug(&slugVM),
+		},
+		Subject: provenanceDescriptor{MediaType: subject.MediaType, Digest: subject.Digest.String(), Size: subject.Size},
+	}
+	for _, file := range files {
+		artifact := provenanceArtifact{Name: file.Name, MediaType: file.MediaType, Digest: file.Digest, Size: file.Size}
+		metadata, exists, err := alchemy_build.ReadBuildArtifactMetadata(file.Path)
+		if err != nil {
+			return nil, err
+		}
+		if exists && metadata.SizeBytes == file.Size {
+			describes, err := metadata.Describes(file.Path)
+			if err != nil {
+				return nil, err
+			}
+			if describes {
+				artifact.Metadata = &metadata
+			}
+		}
+		provenance.Artifacts = append(provenance.Artifacts, artifact)
+	}
+	return json.MarshalIndent(provenance, "", "  ")
+}
+
+// attachBuildReferrers attaches the build log, provenance document and VNC
+// recording selected in opts to subject.
+func attachBuildReferrers(ctx context.Context, target oras.Target, vm alchemy_build.VirtualMachineConfig, subject ocispec.Descriptor, files []ArtifactFile, opts PushOptions) ([]Referrer, error) {
+	var referrers []Referrer
+	if opts.BuildLogPath != "" {
+		reportTransferStatus(opts.Progress, "Attaching build log %s", opts.BuildLogPath)
+		referrer, err := attachReferrer(ctx, target, subject, ArtifactTypeBuildLog, MediaTypeBuildLog, opts.BuildLogPath)
+		if err != nil {
+			return nil, err
+		}
+		referrers = append(referrers, referrer)
+	}
+	if opts.AttachProvenance {
+		reportTransferStatus(opts.Progress, "Attaching provenance")
+		provenance, err := provenanceDocument(vm, files, subject)
+		if err != nil {
+			return nil, fmt.Errorf("build provenance: %w", err)
+		}
+		referrer, err := attachReferrerBytes(ctx, target, subject, ArtifactTypeProvenance, MediaTypeProvenance, "provenance.json", provenance)
+		if err != nil {
+			return nil, err
+		}
+		referrers = append(referrers, referrer)
+	}
+	if opts.RecordingPath != "" {
+		reportTransferStatus(opts.Progress, "Attaching VNC recording %s", opts.RecordingPath)
+		referrer, err := attachReferrer(ctx, target, subject, ArtifactTypeVNCRecording, MediaTypeVNCRecording, opts.RecordingPath)
+		if err != nil {
+			return nil, err
+		}
+		referrers = append(referrers, referrer)
+	}
+	return referrers, nil
+}
+
+// attachReferrer pushes the file at path as the single layer of a referrer
+// artifact of subject.
+func attachReferrer(ctx context.Context, target oras.Target, subject ocispec.Descriptor, artifactType string, mediaType string, path string) (Referrer, error) {
+	file, err := os.Open(path) // #nosec G304 -- path is a user-selected build log or the recording of the pushed target.
+	if err != nil {
+		return Referrer{}, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer file.Close()
+	layerDigest, err := digest.FromReader(file)
+	if err != nil {
+		return Referrer{}, fmt.Errorf("hash %s: %w", path, err)
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return Referrer{}, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return Referrer{}, err
+	}
+	title := filepath.Base(path)
+	layer := ocispec.Descriptor{
+		MediaType:   mediaType,
+		Digest:      layerDigest,
+		Size:        size,
+		Annotations: map[string]string{ocispec.AnnotationTitle: title},
+	}
+	exists, err := target.Exists(ctx, layer)
+	if err != nil {
+		return Referrer{}, fmt.Errorf("check %s in registry: %w", path, err)
+	}
+	if !exists {
+		if err := target.Push(ctx, layer, file); err != nil {
+			return Referrer{}, fmt.Errorf("push %s: %w", path, err)
+		}
+	}
+	return packReferrer(ctx, target, subject, artifactType, layer)
+}
+
+// attachReferrerBytes pushes data as the single layer of a referrer artifact
+// of subject.
+func attachReferrerBytes(ctx context.Context, target oras.Target, subject ocispec.Descriptor, artifactType string, mediaType string, title string, data []byte) (Referrer, error) {
+	layer, err := oras.PushBytes(ctx, target, mediaType, data)
+	if err != nil {
+		return Referrer{}, fmt.Errorf("push %s: %w", title, err)
+	}
+	layer.Annotations = map[string]string{ocispec.AnnotationTitle: title}
+	return packReferrer(ctx, target, subject, artifactType, layer)
+}
+
+func packReferrer(ctx context.Context, target oras.Target, subject ocispec.Descriptor, artifactType string, layer ocispec.Descriptor) (Referrer, error) {
+	created := time.Now().UTC().Format(time.RFC3339)
+	title := layer.Annotations[ocispec.AnnotationTitle]
+	desc, err := oras.PackManifest(ctx, target, oras.PackManifestVersion1_1, artifactType, oras.PackManifestOptions{
+		Subject: &subject,
+		Layers:  []ocispec.Descriptor{layer},
+		ManifestAnnotations: map[string]string{
+			ocispec.AnnotationCreated: created,
+			ocispec.AnnotationTitle:   title,
+		},
+	})
+	if err != nil {
+		return Referrer{}, fmt.Errorf("push %s referrer manifest: %w", title, err)
+	}
+	return Referrer{
+		Kind:         referrerKind(artifactType),
+		ArtifactType: artifactType,
+		Digest:       desc.Digest.String(),
+		Title:        title,
+		Created:      created,
+		Size:         layer.Size,
+	}, nil
+}
+
+// ListReferrers returns the artifacts attached to the manifest of reference,
+// oldest first. For a multi-target index, the referrers of the index come
+// first, followed by those of each entry.
+func ListReferrers(ctx context.Context, reference string, opts RegistryOptions) ([]Referrer, error) {
+	remoteRef, err := parsePullReference(reference)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := newRepository(remoteRef, opts)
+	if err != nil {
+		return nil, err
+	}
+	desc, err := repo.Resolve(ctx, remoteRef.reference)
+	if err != nil {
+		return nil, fmt.Errorf("resolve OCI artifact %s: %w", reference, err)
+	}
+	attached, err := listSubjectReferrers(ctx, repo, desc)
+	if err != nil {
+		return nil, err
+	}
+	referrers := make([]Referrer, 0, len(attached))
+	for _, referrer := range attached {
+		referrers = append(referrers, referrer.referrer())
+	}
+	return referrers, nil
+}
+
+// subjectReferrer is a referrer descriptor and the subject it is attached to.
+// target names the subject when it is an index entry.
+type subjectReferrer struct {
+	desc    ocispec.Descriptor
+	subject ocispec.Descriptor
+	target  string
+}
+
+func (r subjectReferrer) referrer() Referrer {
+	referrer := referrerFromDescriptor(r.desc)
+	referrer.Target = r.target
+	return referrer
+}
+
+// listSubjectReferrers returns the referrers of desc, and for a multi-target
+// index also those of each entry, oldest first per subject.
+func listSubjectReferrers(ctx context.Context, target content.ReadOnlyGraphStorage, desc ocispec.Descriptor) ([]subjectReferrer, error) {
+	subjects := []ocispec.Descriptor{desc}
+	if desc.MediaType == ocispec.MediaTypeImageIndex {
+		index, err := fetchArtifactIndex(ctx, target, desc)
+		if err != nil {
+			return nil, err
+		}
+		subjects = append(subjects, index.Manifests...)
+	}
+	var referrers []subjectReferrer
+	for _, subject := range subjects {
+		descs, err := listReferrers(ctx, target, subject)
+		if err != nil {
+			return nil, err
+		}
+		subjectTarget := ""
+		if subject.Digest != desc.Digest {
+			subjectTarget = indexEntryTarget(subject)
+		}
+		for _, referrerDesc := range descs {
+			referrers = append(referrers, subjectReferrer{desc: referrerDesc, subject: subject, target: subjectTarget})
+		}
+	}
+	return referrers, nil
+}
+
+// listReferrers returns the referrer descriptors of subject, oldest first.
+func listReferrers(ctx context.Context, target content.ReadOnlyGraphStorage, subject ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+	descs, err := registry.Referrers(ctx, target, subject, "")
+	if err != nil {
+		return nil, fmt.Errorf("list referrers of OCI artifact %s: %w", subject.Digest, err)
+	}
+	slices.SortStableFunc(descs, func(a, b ocispec.Descriptor) int {
+		return strings.Compare(a.Annotations[ocispec.AnnotationCreated], b.Annotations[ocispec.AnnotationCreated])
+	})
+	return descs, nil
+}
+
+func referrerFromDescriptor(desc ocispec.Descriptor) Referrer {
+	return Referrer{
+		Kind:         referrerKind(desc.ArtifactType),
+		ArtifactType: desc.ArtifactType,
+		Digest:       desc.Digest.String(),
+		Title:        desc.Annotations[ocispec.AnnotationTitle],
+		Created:      desc.Annotations[ocispec.AnnotationCreated],
+	}
+}
+
+// FetchReferrer downloads the content of a referrer of the manifest of
+// reference to outputPath. selector is a referrer digest or kind; a kind
+// selects the newest referrer of that kind. For a multi-target index, the
+// referrers of the index and of every entry are searched, and a kind must
+// select the referrers of a single subject. An empty outputPath writes to the
+// referrer title in the working directory.
+func FetchReferrer(ctx context.Context, reference string, selector string, outputPath string, opts FetchReferrerOptions) (Referrer, error) {
+	remoteRef, err := parsePullReference(reference)
+	if err != nil {
+		return Referrer{}, err
+	}
+	repo, err := newRepository(remoteRef, opts.RegistryOptions)
+	if err != nil {
+		return Referrer{}, err
+	}
+	reportTransferStatus(opts.Progress, "Resolving OCI artifact %s", reference)
+	subject, err := repo.Resolve(ctx, remoteRef.reference)
+	if err != nil {
+		return Referrer{}, fmt.Errorf("resolve OCI artifact %s: %w", reference, err)
+	}
+	return fetchReferrer(ctx, repo, subject, selector, outputPath, opts.Progress)
+}
+
+func fetchReferrer(ctx context.Context, target content.ReadOnlyGraphStorage, subject ocispec.Descriptor, selector string, outputPath string, progress TransferProgress) (Referrer, error) {
+	attached, err := listSubjectReferrers(ctx, target, subject)
+	if err != nil {
+		return Referrer{}, err
+	}
+	selected, err := selectReferrer(attached, selector)
+	if err != nil {
+		return Referrer{}, err
+	}
+	desc, subject := selected.desc, selected.subject
+	referrer := selected.referrer()
+
+	if desc.Size > maxSignatureSize {
+		return Referrer{}, fmt.Errorf("referrer manifest %s is %d bytes, more than %d", desc.Digest, desc.Size, maxSignatureSize)
+	}
+	manifestBytes, err := content.FetchAll(ctx, target, desc)
+	if err != nil {
+		return Referrer{}, fmt.Errorf("fetch referrer manifest %s: %w", desc.Digest, err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return Referrer{}, fmt.Errorf("decode referrer manifest %s: %w", desc.Digest, err)
+	}
+	if manifest.Subject == nil || manifest.Subject.Digest != subject.Digest {
+		return Referrer{}, fmt.Errorf("referrer %s does not refer to %s", desc.Digest, subject.Digest)
+	}
+	if len(manifest.Layers) != 1 {
+		return Referrer{}, fmt.Errorf("referrer %s has %d layers, expected 1", desc.Digest, len(manifest.Layers))
+	}
+	layer := manifest.Layers[0]
+
+	if outputPath == "" {
+		outputPath = filepath.Base(filepath.FromSlash(layer.Annotations[ocispec.AnnotationTitle]))
+		if outputPath == "." || outputPath == string(filepath.Separator) {
+			return Referrer{}, fmt.Errorf("referrer %s has no file name; pass an output path", desc.Digest)
+		}
+	}
+	reportTransferStatus(progress, "Downloading %s referrer to %s", referrer.ArtifactType, outputPath)
+	if err := downloadReferrerLayer(ctx, target, layer, outputPath, progress); err != nil {
+		return Referrer{}, err
+	}
+	referrer.Size = layer.Size
+	referrer.Path = outputPath
+	return referrer, nil
+}
+
+// selectReferrer picks the referrer with the selector digest, or the newest
+// referrer of the selector kind. A kind whose referrers are attached to
+// several subjects of an index is ambiguous.
+func selectReferrer(referrers []subjectReferrer, selector string) (subjectReferrer, error) {
+	if artifactType, ok := referrerKinds[selector]; ok {
+		var selected []subjectReferrer
+		for _, referrer := range referrers {
+			if referrer.desc.ArtifactType != artifactType {
+				continue
+			}
+			if len(selected) > 0 && selected[0].subject.Digest != referrer.subject.Digest {
+				return subjectReferrer{}, fmt.Errorf("OCI index has %s referrers for several targets (%s); select one by digest from \"alchemy oci referrers\"", selector, referrerTargets(referrers, artifactType))
+			}
+			selected = append(selected, referrer)
+		}
+		if len(selected) == 0 {
+			return subjectReferrer{}, fmt.Errorf("OCI artifact has no %s referrer", selector)
+		}
+		return selected[len(selected)-1], nil
+	}
+	if _, err := digest.Parse(selector); err != nil {
+		kinds := slices.Sorted(maps.Keys(referrerKinds))
+		return subjectReferrer{}, fmt.Errorf("invalid referrer %q; expected a digest or one of %s", selector, strings.Join(kinds, ", "))
+	}
+	for _, referrer := range referrers {
+		if referrer.desc.Digest.String() == selector {
+			return referrer, nil
+		}
+	}
+	return subjectReferrer{}, fmt.Errorf("OCI artifact has no referrer %s", selector)
+}
+
+// referrerTargets names the subjects with referrers of artifactType.
+func referrerTargets(referrers []subjectReferrer, artifactType string) string {
+	var targets []string
+	for _, referrer := range referrers {
+		target := referrer.target
+		if target == "" {
+			target = "the index"
+		}
+		if referrer.desc.ArtifactType == artifactType && !slices.Contains(targets, target) {
+			targets = append(targets, target)
+		}
+	}
+	return strings.Join(targets, ", ")
+}
+
+func downloadReferrerLayer(ctx context.Context, target content.Fetcher, layer ocispec.Descriptor, outputPath string, progress TransferProgress) (err error) {
+	if progress != nil {
+		progress = newCappedTransferProgress(progress, layer.Size)
+		progress.Start(layer.Size)
+		defer func() {
+			progress.Done(err == nil)
+		}()
+	}
+
+	rc, err := target.Fetch(ctx, layer)
+	if err != nil {
+		return fmt.Errorf("fetch referrer layer %s: %w", layer.Digest, err)
+	}
+	defer rc.Close()
+
+	if err := os.MkdirAll(filepath.Dir(outputPath), 0o755); err != nil {
+		return fmt.Errorf("create directory for %s: %w", outputPath, err)
+	}
+	output, err := os.CreateTemp(filepath.Dir(outputPath), ".dev-alchemy-oci-referrer-*")
+	if err != nil {
+		return fmt.Errorf("create %s: %w", outputPath, err)
+	}
+	defer os.Remove(output.Name())
+
+	reader := content.NewVerifyReader(progressReadCloser{ReadCloser: rc, progress: progress}, layer)
+	_, copyErr := io.Copy(output, reader)
+	if copyErr == nil {
+		copyErr = reader.Verify()
+	}
+	if err := errors.Join(copyErr, output.Close()); err != nil {
+		return fmt.Errorf("download referrer layer %s: %w", layer.Digest, err)
+	}
+	if err := os.Rename(output.Name(), outputPath); err != nil {
+		return fmt.Errorf("write %s: %w", outputPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPushAttachesBuildReferrers(t *testing.T) {
+	registry := newTestRegistry(t)
+	vm, _ := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, []byte("dev-alchemy-referrers"))
+	buildLog := filepath.Join(t.TempDir(), "build.log")
+	if err := os.WriteFile(buildLog, []byte("==> qemu: build finished\n"), 0o644); err != nil {
+		t.Fatalf("failed to write build log: %v", err)
+	}
+	recording := filepath.Join(t.TempDir(), "qemu.vnc.mp4")
+	if err := os.WriteFile(recording, []byte("fake-mp4"), 0o644); err != nil {
+		t.Fatalf("failed to write recording: %v", err)
+	}
+	reference := registry.reference("dev-alchemy/ubuntu", "referrers")
+
+	pushed, err := Push(context.Background(), vm, reference, PushOptions{
+		RegistryOptions:  testRegistryOptions(),
+		BuildLogPath:     buildLog,
+		RecordingPath:    recording,
+		AttachProvenance: true,
+	})
+	if err != nil {
+		t.Fatalf("failed to push artifact with referrers: %v", err)
+	}
+	if len(pushed.Referrers) != 3 {
+		t.Fatalf("expected 3 attached referrers, got %+v", pushed.Referrers)
+	}
+
+	referrers, err := ListReferrers(context.Background(), reference, testRegistryOptions())
+	if err != nil {
+		t.Fatalf("failed to list referrers: %v", err)
+	}
+	kinds := map[string]Referrer{}
+	for _, referrer := range referrers {
+		kinds[referrer.Kind] = referrer
+	}
+	for _, kind := range []string{ReferrerKindBuildLog, ReferrerKindProvenance, ReferrerKindVNCRecording} {
+		if kinds[kind].Digest == "" {
+			t.Fatalf("expected a %s referrer, got %+v", kind, referrers)
+		}
+	}
+	if kinds[ReferrerKindVNCRecording].Title != "qemu.vnc.mp4" {
+		t.Fatalf("expected the recording title to be its file name, got %q", kinds[ReferrerKindVNCRecording].Title)
+	}
+
+	outputDir := t.TempDir()
+	fetched, err := FetchReferrer(context.Background(), reference, ReferrerKindBuildLog, filepath.Join(outputDir, "fetched.log"), FetchReferrerOptions{RegistryOptions: testRegistryOptions()})
+	if err != nil {
+		t.Fatalf("failed to fetch build log: %v", err)
+	}
+	got, err := os.ReadFile(fetched.Path)
+	if err != nil {
+		t.Fatalf("failed to read fetched build log: %v", err)
+	}
+	if !bytes.Equal(got, []byte("==> qemu: build finished\n")) {
+		t.Fatalf("unexpected build log content %q", got)
+	}
+
+	provenancePath := filepath.Join(outputDir, "provenance.json")
+	if _, err := FetchReferrer(context.Background(), reference, kinds[ReferrerKindProvenance].Digest, provenancePath, FetchReferrerOptions{RegistryOptions: testRegistryOptions()}); err != nil {
+		t.Fatalf("failed to fetch provenance by digest: %v", err)
+	}
+	data, err := os.ReadFile(provenancePath)
+	if err != nil {
+		t.Fatalf("failed to read provenance: %v", err)
+	}
+	var provenance buildProvenance
+	if err := json.Unmarshal(data, &provenance); err != nil {
+		t.Fatalf("failed to decode provenance: %v", err)
+	}
+	if provenance.Subject.Digest != pushed.Digest || provenance.Target.VirtualizationEngine != string(alchemy_build.VirtualizationEngineQemu) || len(provenance.Artifacts) != 1 {
+		t.Fatalf("unexpected provenance %+v", provenance)
+	}
+}
+
+func TestFetchReferrerRejectsUnknownSelector(t *testing.T) {
+	registry := newTestRegistry(t)
+	vm, _ := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, []byte("dev-alchemy-no-referrers"))
+	reference := registry.reference("dev-alchemy/ubuntu", "plain")
+	if _, err := Push(context.Background(), vm, reference, PushOptions{RegistryOptions: testRegistryOptions()}); err != nil {
+		t.Fatalf("failed to push artifact: %v", err)
+	}
+
+	tests := []struct {
+		selector string
+		want     string
+	}{
+		{selector: ReferrerKindBuildLog, want: "has no build-log referrer"},
+		{selector: "recording", want: "expected a digest or one of"},
+		{selector: "sha256:" + strings.Repeat("0", 64), want: "has no referrer"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			_, err := FetchReferrer(context.Background(), reference, tt.selector, filepath.Join(t.TempDir(), "out"), FetchReferrerOptions{RegistryOptions: testRegistryOptions()})
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestProvenanceDocumentOnlyEmbedsMetadataOfThePushedFile(t *testing.T) {
+	dir := t.TempDir()
+	files := make([]ArtifactFile, 0, 2)
+	for _, name := range []string{"built.qcow2", "replaced.qcow2"} {
+		path := filepath.Join(dir, name)
+		content := []byte(name)
+		if err := os.WriteFile(path, content, 0o600); err != nil {
+			t.Fatalf("failed to write artifact: %v", err)
+		}
+		files = append(files, ArtifactFile{Name: name, Path: path, MediaType: MediaTypeArtifact, Digest: digest.FromBytes(content).String(), Size: int64(len(content))})
+		if err := alchemy_build.MarkBuildArtifactVerified(path, alchemy_build.ArtifactVerification{VerifiedAt: time.Now().UTC(), Protocol: "ssh"}); err != nil {
+			t.Fatalf("failed to write metadata: %v", err)
+		}
+	}
+	// The replacement has the pushed size and is renamed over the built file,
+	// as a pull or a copy from elsewhere would do.
+	replaced := filepath.Join(dir, "replaced.qcow2")
+	if err := os.WriteFile(replaced+".new", []byte("replaced.qcow2"), 0o600); err != nil {
+		t.Fatalf("failed to write replacement: %v", err)
+	}
+	if err := os.Rename(replaced+".new", replaced); err != nil {
+		t.Fatalf("failed to replace artifact: %v", err)
+	}
+
+	vm := alchemy_build.VirtualMachineConfig{OS: "ubuntu", UbuntuType: "server", Arch: "amd64", HostOs: alchemy_build.HostOsLinux, VirtualizationEngine: alchemy_build.VirtualizationEngineQemu}
+	data, err := provenanceDocument(vm, files, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromString("subject")})
+	if err != nil {
+		t.Fatalf("failed to build provenance: %v", err)
+	}
+	var provenance buildProvenance
+	if err := json.Unmarshal(data, &provenance); err != nil {
+		t.Fatalf("failed to decode provenance: %v", err)
+	}
+	if provenance.Artifacts[0].Metadata == nil {
+		t.Fatalf("expected the metadata of the built file to be embedded, got %+v", provenance.Artifacts[0])
+	}
+	if provenance.Artifacts[1].Metadata != nil {
+		t.Fatalf("expected the metadata of another file to be left out, got %+v", provenance.Artifacts[1].Metadata)
+	}
+}
+
+func TestReferrersOfIndexListEachEntry(t *testing.T) {
+	registry := newTestRegistry(t)
+	reference := registry.reference("dev-alchemy/ubuntu-server", "nightly")
+	linuxVM, _ := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, []byte("linux"))
+	darwinVM, _ := testLayoutArchiveVM(t, alchemy_build.HostOsDarwin, alchemy_build.VirtualizationEngineUtm, []byte("darwin"))
+	buildLogs := map[alchemy_build.HostOsType]string{}
+	for _, vm := range []alchemy_build.VirtualMachineConfig{linuxVM, darwinVM} {
+		buildLog := filepath.Join(t.TempDir(), "build.log")
+		if err := os.WriteFile(buildLog, []byte(string(vm.HostOs)+" build finished\n"), 0o644); err != nil {
+			t.Fatalf("failed to write build log: %v", err)
+		}
+		pushed, err := Push(context.Background(), vm, reference, PushOptions{RegistryOptions: testRegistryOptions(), BuildLogPath: buildLog, Index: true})
+		if err != nil {
+			t.Fatalf("failed to push %s artifact to index: %v", vm.HostOs, err)
+		}
+		buildLogs[vm.HostOs] = pushed.Referrers[0].Digest
+	}
+
+	referrers, err := ListReferrers(context.Background(), reference, testRegistryOptions())
+	if err != nil {
+		t.Fatalf("failed to list referrers: %v", err)
+	}
+	if len(referrers) != 2 {
+		t.Fatalf("expected the build log of each entry, got %+v", referrers)
+	}
+	for _, referrer := range referrers {
+		if referrer.Kind != ReferrerKindBuildLog || referrer.Target == "" {
+			t.Fatalf("expected entry build logs named by target, got %+v", referrer)
+		}
+	}
+
+	_, err = FetchReferrer(context.Background(), reference, ReferrerKindBuildLog, filepath.Join(t.TempDir(), "build.log"), FetchReferrerOptions{RegistryOptions: testRegistryOptions()})
+	if err == nil || !strings.Contains(err.Error(), "several targets") {
+		t.Fatalf("expected a kind shared by entries to be ambiguous, got %v", err)
+	}
+	fetched, err := FetchReferrer(context.Background(), reference, buildLogs[alchemy_build.HostOsDarwin], filepath.Join(t.TempDir(), "build.log"), FetchReferrerOptions{RegistryOptions: testRegistryOptions()})
+	if err != nil {
+		t.Fatalf("failed to fetch the darwin build log by digest: %v", err)
+	}
+	got, err := os.ReadFile(fetched.Path)
+	if err != nil {
+		t.Fatalf("failed to read fetched build log: %v", err)
+	}
+	if string(got) != string(alchemy_build.HostOsDarwin)+" build finished\n" || !strings.Contains(fetched.Target, string(alchemy_build.HostOsDarwin)) {
+		t.Fatalf("unexpected fetched build log %q for %+v", got, fetched)
+	}
+}
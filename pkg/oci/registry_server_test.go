@@ -0,0 +1,254 @@
+package oci
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// testRegistry is an in-process stand-in for an OCI distribution registry. It
+// serves the blob, manifest, tag listing and referrers endpoints that the
+// registry client uses, over plain HTTP.
+type testRegistry struct {
+	host string
+
+	mu        sync.Mutex
+	blobs     map[digest.Digest][]byte
+	uploads   map[string]*bytes.Buffer
+	manifests map[string]map[digest.Digest]testManifest
+	tags      map[string]map[string]digest.Digest
+	// blobGets counts blob downloads per repository.
+	blobGets map[string]int
+}
+
+type testManifest struct {
+	mediaType string
+	content   []byte
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	t.Helper()
+
+	r := &testRegistry{
+		blobs:     map[digest.Digest][]byte{},
+		uploads:   map[string]*bytes.Buffer{},
+		manifests: map[string]map[digest.Digest]testManifest{},
+		tags:      map[string]map[string]digest.Digest{},
+		blobGets:  map[string]int{},
+	}
+	server := httptest.NewServer(r)
+	t.Cleanup(server.Close)
+	r.host = strings.TrimPrefix(server.URL, "http://")
+	return r
+}
+
+// reference returns a reference to repository and tag in the registry.
+func (r *testRegistry) reference(repository string, tag string) string {
+	return r.host + "/" + repository + ":" + tag
+}
+
+func (r *testRegistry) blobDownloads(repository string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.blobGets[repository]
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	if path == "" || path == req.URL.Path {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, route := range []struct {
+		separator string
+		handle    func(http.ResponseWriter, *http.Request, string, string)
+	}{
+		{"/blobs/uploads/", r.serveUpload},
+		{"/blobs/", r.serveBlob},
+		{"/manifests/", r.serveManifest},
+		{"/referrers/", r.serveReferrers},
+		{"/tags/list", r.serveTags},
+	} {
+		if index := strings.LastIndex(path, route.separator); index > 0 {
+			route.handle(w, req, path[:index], path[index+len(route.separator):])
+			return
+		}
+	}
+	http.NotFound(w, req)
+}
+
+func (r *testRegistry) serveUpload(w http.ResponseWriter, req *http.Request, repository string, id string) {
+	switch req.Method {
+	case http.MethodPost:
+		id = fmt.Sprintf("upload-%d", len(r.uploads)+1)
+		r.uploads[id] = &bytes.Buffer{}
+		w.Header().Set("Location", "/v2/"+repository+"/blobs/uploads/"+id)
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPatch, http.MethodPut:
+		upload, ok := r.uploads[id]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = io.Copy(upload, req.Body)
+		if req.Method == http.MethodPatch {
+			w.Header().Set("Location", "/v2/"+repository+"/blobs/uploads/"+id)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		expected := digest.Digest(req.URL.Query().Get("digest"))
+		if digest.FromBytes(upload.Bytes()) != expected {
+			http.Error(w, "digest mismatch", http.StatusBadRequest)
+			return
+		}
+		delete(r.uploads, id)
+		r.blobs[expected] = upload.Bytes()
+		w.Header().Set("Docker-Content-Digest", expected.String())
+		w.Header().Set("Location", "/v2/"+repository+"/blobs/"+expected.String())
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *testRegistry) serveBlob(w http.ResponseWriter, req *http.Request, repository string, reference string) {
+	content, ok := r.blobs[digest.Digest(reference)]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	if req.Method == http.MethodGet {
+		r.blobGets[repository]++
+	}
+	w.Header().Set("Docker-Content-Digest", reference)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, req, "", time.Time{}, bytes.NewReader(content))
+}
+
+func (r *testRegistry) serveManifest(w http.ResponseWriter, req *http.Request, repository string, reference string) {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead:
+		manifestDigest, ok := r.resolve(repository, reference)
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		manifest := r.manifests[repository][manifestDigest]
+		w.Header().Set("Content-Type", manifest.mediaType)
+		w.Header().Set("Docker-Content-Digest", manifestDigest.String())
+		w.Header().Set("Content-Length", fmt.Sprint(len(manifest.content)))
+		w.WriteHeader(http.StatusOK)
+		if req.Method == http.MethodGet {
+			_, _ = w.Write(manifest.content)
+		}
+	case http.MethodPut:
+		content, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		manifestDigest := digest.FromBytes(content)
+		if r.manifests[repository] == nil {
+			r.manifests[repository] = map[digest.Digest]testManifest{}
+			r.tags[repository] = map[string]digest.Digest{}
+		}
+		r.manifests[repository][manifestDigest] = testManifest{mediaType: req.Header.Get("Content-Type"), content: content}
+		if _, err := digest.Parse(reference); err != nil {
+			r.tags[repository][reference] = manifestDigest
+		}
+		var subject struct {
+			Subject *ocispec.Descriptor `json:"subject"`
+		}
+		if json.Unmarshal(content, &subject) == nil && subject.Subject != nil {
+			w.Header().Set("OCI-Subject", subject.Subject.Digest.String())
+		}
+		w.Header().Set("Docker-Content-Digest", manifestDigest.String())
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		manifestDigest, err := digest.Parse(reference)
+		if err != nil {
+			http.Error(w, "manifests are deleted by digest", http.StatusBadRequest)
+			return
+		}
+		if _, ok := r.manifests[repository][manifestDigest]; !ok {
+			http.NotFound(w, req)
+			return
+		}
+		delete(r.manifests[repository], manifestDigest)
+		for tag, tagged := range r.tags[repository] {
+			if tagged == manifestDigest {
+				delete(r.tags[repository], tag)
+			}
+		}
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (r *testRegistry) resolve(repository string, reference string) (digest.Digest, bool) {
+	manifestDigest, err := digest.Parse(reference)
+	if err != nil {
+		manifestDigest = r.tags[repository][reference]
+	}
+	_, ok := r.manifests[repository][manifestDigest]
+	return manifestDigest, ok
+}
+
+func (r *testRegistry) serveReferrers(w http.ResponseWriter, req *http.Request, repository string, reference string) {
+	artifactType := req.URL.Query().Get("artifactType")
+	index := ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{},
+	}
+	for manifestDigest, manifest := range r.manifests[repository] {
+		var referrer ocispec.Manifest
+		if json.Unmarshal(manifest.content, &referrer) != nil || referrer.Subject == nil || referrer.Subject.Digest.String() != reference {
+			continue
+		}
+		if artifactType != "" && referrer.ArtifactType != artifactType {
+			continue
+		}
+		index.Manifests = append(index.Manifests, ocispec.Descriptor{
+			MediaType:    manifest.mediaType,
+			ArtifactType: referrer.ArtifactType,
+			Digest:       manifestDigest,
+			Size:         int64(len(manifest.content)),
+			Annotations:  referrer.Annotations,
+		})
+	}
+	slices.SortFunc(index.Manifests, func(a, b ocispec.Descriptor) int {
+		return strings.Compare(a.Digest.String(), b.Digest.String())
+	})
+	if artifactType != "" {
+		w.Header().Set("OCI-Filters-Applied", "artifactType")
+	}
+	w.Header().Set("Content-Type", ocispec.MediaTypeImageIndex)
+	_ = json.NewEncoder(w).Encode(index)
+}
+
+func (r *testRegistry) serveTags(w http.ResponseWriter, req *http.Request, repository string, _ string) {
+	tags := []string{}
+	for tag := range r.tags[repository] {
+		tags = append(tags, tag)
+	}
+	slices.Sort(tags)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"name": repository, "tags": tags})
+}
@@ -0,0 +1,278 @@
+package oci
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	oras "oras.land/oras-go/v2"
+	"oras.land/oras-go/v2/content"
+	"oras.land/oras-go/v2/registry"
+)
+
+const (
+	ArtifactTypeSignature = "application/vnd.dev-alchemy.vm-build.signature.v1"
+	MediaTypeSignature    = "application/vnd.dev-alchemy.vm-build.signature.v1+json"
+
+	AnnotationSignatureKeyID = "dev.alchemy.signature.key_id"
+
+	signatureAlgorithmEd25519 = "ed25519"
+	// signaturePayloadPrefix separates Dev Alchemy manifest signatures from
+	// any other use of the same key.
+	signaturePayloadPrefix = "dev-alchemy-manifest-signature-v1"
+	// maxSignatureSize bounds what verification reads from a referrer.
+	maxSignatureSize = 64 << 10
+)
+
+// ErrSignatureVerification is returned when an artifact has no signature
+// from a trusted key.
+var ErrSignatureVerification = errors.New("OCI artifact signature verification failed")
+
+// artifactSignature is the content of a signature referrer layer.
+type artifactSignature struct {
+	Algorithm string `json:"algorithm"`
+	KeyID     string `json:"key_id"`
+	Subject   struct {
+		MediaType string `json:"media_type"`
+		Digest    string `json:"digest"`
+		Size      int64  `json:"size"`
+	} `json:"subject"`
+	Signature string `json:"signature"`
+}
+
+// signaturePayload is the byte string signed for a manifest. It binds the
+// media type, digest and size of the manifest descriptor.
+func signaturePayload(subject ocispec.Descriptor) []byte {
+	return fmt.Appendf(nil, "%s\n%s\n%s\n%d\n", signaturePayloadPrefix, subject.MediaType, subject.Digest, subject.Size)
+}
+
+// SigningKeyID identifies a public key by the SHA-256 digest of its PKIX
+// encoding.
+func SigningKeyID(key ed25519.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(der)
+	return "sha256:" + hex.EncodeToString(sum[:]), nil
+}
+
+// GenerateSigningKeyPair writes a new Ed25519 key pair as PEM files: the
+// PKCS #8 private key to privatePath and the PKIX public key to publicPath.
+func GenerateSigningKeyPair(privatePath string, publicPath string) error {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return fmt.Errorf("generate signing key: %w", err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return fmt.Errorf("encode signing key: %w", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return fmt.Errorf("encode public key: %w", err)
+	}
+	for _, path := range []string{privatePath, publicPath} {
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return fmt.Errorf("create key directory for %s: %w", path, err)
+		}
+	}
+	if err := writeNewFile(privatePath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0o600); err != nil {
+		return fmt.Errorf("write signing key %s: %w", privatePath, err)
+	}
+	if err := writeNewFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0o644); err != nil {
+		return fmt.Errorf("write public key %s: %w", publicPath, err)
+	}
+	return nil
+}
+
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm) // #nosec G304 -- path is the user-selected key file.
+	if err != nil {
+		return err
+	}
+	_, writeErr := file.Write(data)
+	return errors.Join(writeErr, file.Close())
+}
+
+// LoadSigningKey reads a PEM-encoded PKCS #8 Ed25519 private key.
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	block, err := readPEMFile(path, "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse signing key %s: %w", path, err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is a %T, expected an Ed25519 key", path, key)
+	}
+	return privateKey, nil
+}
+
+// LoadVerificationKey reads a PEM-encoded PKIX Ed25519 public key.
+func LoadVerificationKey(path string) (ed25519.PublicKey, error) {
+	block, err := readPEMFile(path, "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse public key %s: %w", path, err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key %s is a %T, expected an Ed25519 key", path, key)
+	}
+	return publicKey, nil
+}
+
+func readPEMFile(path string, blockType string) (*pem.Block, error) {
+	data, err := os.ReadFile(path) // #nosec G304 -- path is the user-selected key file.
+	if err != nil {
+		return nil, fmt.Errorf("read key %s: %w", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != blockType {
+		return nil, fmt.Errorf("key %s is not a PEM %s", path, blockType)
+	}
+	return block, nil
+}
+
+// attachSignature signs subject with key and pushes the signature as a
+// referrer of subject.
+func attachSignature(ctx context.Context, target oras.Target, subject ocispec.Descriptor, key ed25519.PrivateKey) (ocispec.Descriptor, error) {
+	keyID, err := SigningKeyID(key.Public().(ed25519.PublicKey))
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("identify signing key: %w", err)
+	}
+	signature := artifactSignature{
+		Algorithm: signatureAlgorithmEd25519,
+		KeyID:     keyID,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, signaturePayload(subject))),
+	}
+	signature.Subject.MediaType = subject.MediaType
+	signature.Subject.Digest = subject.Digest.String()
+	signature.Subject.Size = subject.Size
+	signatureBytes, err := json.Marshal(signature)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	layer, err := oras.PushBytes(ctx, target, MediaTypeSignature, signatureBytes)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("push OCI artifact signature: %w", err)
+	}
+	desc, err := oras.PackManifest(ctx, target, oras.PackManifestVersion1_1, ArtifactTypeSignature, oras.PackManifestOptions{
+		Subject: &subject,
+		Layers:  []ocispec.Descriptor{layer},
+		ManifestAnnotations: map[string]string{
+			ocispec.AnnotationCreated: time.Now().UTC().Format(time.RFC3339),
+			AnnotationSignatureKeyID:  keyID,
+		},
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("push OCI artifact signature manifest: %w", err)
+	}
+	return desc, nil
+}
+
+// verifySignature checks that subject has a signature referrer from one of
+// keys and returns that referrer. It only reads manifests and signature
+// blobs, so it runs before any artifact layer is downloaded.
+func verifySignature(ctx context.Context, target content.ReadOnlyGraphStorage, subject ocispec.Descriptor, keys []ed25519.PublicKey) (ocispec.Descriptor, error) {
+	trusted := make(map[string]ed25519.PublicKey, len(keys))
+	for _, key := range keys {
+		keyID, err := SigningKeyID(key)
+		if err != nil {
+			return ocispec.Descriptor{}, fmt.Errorf("identify verification key: %w", err)
+		}
+		trusted[keyID] = key
+	}
+
+	referrers, err := registry.Referrers(ctx, target, subject, ArtifactTypeSignature)
+	if err != nil {
+		return ocispec.Descriptor{}, fmt.Errorf("list signatures of OCI artifact %s: %w", subject.Digest, err)
+	}
+	if len(referrers) == 0 {
+		return ocispec.Descriptor{}, fmt.Errorf("%w: OCI artifact %s has no signature", ErrSignatureVerification, subject.Digest)
+	}
+	var errs []error
+	for _, referrer := range referrers {
+		err := verifySignatureReferrer(ctx, target, subject, referrer, trusted)
+		if err == nil {
+			return referrer, nil
+		}
+		errs = append(errs, err)
+	}
+	return ocispec.Descriptor{}, fmt.Errorf("%w: OCI artifact %s has no valid signature from a trusted key: %w", ErrSignatureVerification, subject.Digest, errors.Join(errs...))
+}
+
+func verifySignatureReferrer(ctx context.Context, target content.ReadOnlyStorage, subject ocispec.Descriptor, referrer ocispec.Descriptor, trusted map[string]ed25519.PublicKey) error {
+	if keyID := referrer.Annotations[AnnotationSignatureKeyID]; keyID != "" && trusted[keyID] == nil {
+		return fmt.Errorf("signature %s uses untrusted key %s", referrer.Digest, keyID)
+	}
+	if referrer.Size > maxSignatureSize {
+		return fmt.Errorf("signature manifest %s is %d bytes, more than %d", referrer.Digest, referrer.Size, maxSignatureSize)
+	}
+	manifestBytes, err := content.FetchAll(ctx, target, referrer)
+	if err != nil {
+		return fmt.Errorf("fetch signature manifest %s: %w", referrer.Digest, err)
+	}
+	var manifest ocispec.Manifest
+	if err := json.Unmarshal(manifestBytes, &manifest); err != nil {
+		return fmt.Errorf("decode signature manifest %s: %w", referrer.Digest, err)
+	}
+	if manifest.Subject == nil || manifest.Subject.Digest != subject.Digest {
+		return fmt.Errorf("signature %s does not refer to %s", referrer.Digest, subject.Digest)
+	}
+	if len(manifest.Layers) != 1 || manifest.Layers[0].MediaType != MediaTypeSignature || manifest.Layers[0].Size > maxSignatureSize {
+		return fmt.Errorf("signature %s must have one %s layer of at most %d bytes", referrer.Digest, MediaTypeSignature, maxSignatureSize)
+	}
+
+	rc, err := target.Fetch(ctx, manifest.Layers[0])
+	if err != nil {
+		return fmt.Errorf("fetch signature %s: %w", referrer.Digest, err)
+	}
+	defer rc.Close()
+	signatureBytes, err := content.ReadAll(rc, manifest.Layers[0])
+	if err != nil {
+		return fmt.Errorf("read signature %s: %w", referrer.Digest, err)
+	}
+	var signature artifactSignature
+	if err := json.Unmarshal(signatureBytes, &signature); err != nil {
+		return fmt.Errorf("decode signature %s: %w", referrer.Digest, err)
+	}
+	if signature.Algorithm != signatureAlgorithmEd25519 {
+		return fmt.Errorf("signature %s uses unsupported algorithm %q", referrer.Digest, signature.Algorithm)
+	}
+	if signature.Subject.MediaType != subject.MediaType || signature.Subject.Digest != subject.Digest.String() || signature.Subject.Size != subject.Size {
+		return fmt.Errorf("signature %s was made for a different manifest", referrer.Digest)
+	}
+	key := trusted[signature.KeyID]
+	if key == nil {
+		return fmt.Errorf("signature %s uses untrusted key %s", referrer.Digest, signature.KeyID)
+	}
+	signed, err := base64.StdEncoding.DecodeString(signature.Signature)
+	if err != nil {
+		return fmt.Errorf("decode signature %s: %w", referrer.Digest, err)
+	}
+	if !ed25519.Verify(key, signaturePayload(subject), signed) {
+		return fmt.Errorf("signature %s does not verify with key %s", referrer.Digest, signature.KeyID)
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+)
+
+func TestPushSignsAndPullVerifiesSignature(t *testing.T) {
+	registry := newTestRegistry(t)
+	content := []byte("dev-alchemy-signed-artifact")
+	vm, artifactPath := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, content)
+	privateKey, publicKey := testSigningKeyPair(t)
+	reference := registry.reference("dev-alchemy/ubuntu", "signed")
+
+	pushed, err := Push(context.Background(), vm, reference, PushOptions{RegistryOptions: testRegistryOptions(), SigningKey: privateKey})
+	if err != nil {
+		t.Fatalf("failed to push signed artifact: %v", err)
+	}
+	if pushed.Signature == "" {
+		t.Fatal("expected the push to report the attached signature")
+	}
+	if err := os.Remove(artifactPath); err != nil {
+		t.Fatalf("failed to remove local artifact before pull: %v", err)
+	}
+
+	pulled, err := Pull(context.Background(), vm, reference, PullOptions{RegistryOptions: testRegistryOptions(), VerificationKeys: []ed25519.PublicKey{publicKey}})
+	if err != nil {
+		t.Fatalf("failed to pull signed artifact: %v", err)
+	}
+	if pulled.Signature != pushed.Signature {
+		t.Fatalf("expected verified signature %s, got %s", pushed.Signature, pulled.Signature)
+	}
+	got, err := os.ReadFile(artifactPath)
+	if err != nil {
+		t.Fatalf("failed to read pulled artifact: %v", err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatal("pulled artifact differs from the pushed artifact")
+	}
+}
+
+func TestPullRefusesArtifactWithoutTrustedSignature(t *testing.T) {
+	_, trustedPublicKey := testSigningKeyPair(t)
+	otherKey, _ := testSigningKeyPair(t)
+	tests := []struct {
+		name       string
+		signingKey ed25519.PrivateKey
+	}{
+		{name: "unsigned"},
+		{name: "signed with an untrusted key", signingKey: otherKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry := newTestRegistry(t)
+			vm, _ := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, []byte("dev-alchemy-untrusted"))
+			reference := registry.reference("dev-alchemy/ubuntu", "untrusted")
+			if _, err := Push(context.Background(), vm, reference, PushOptions{RegistryOptions: testRegistryOptions(), SigningKey: tt.signingKey}); err != nil {
+				t.Fatalf("failed to push artifact: %v", err)
+			}
+
+			_, err := Pull(context.Background(), vm, reference, PullOptions{RegistryOptions: testRegistryOptions(), VerificationKeys: []ed25519.PublicKey{trustedPublicKey}})
+			if !errors.Is(err, ErrSignatureVerification) {
+				t.Fatalf("expected signature verification to fail, got %v", err)
+			}
+			if downloads := registry.blobDownloads("dev-alchemy/ubuntu"); downloads != 0 {
+				t.Fatalf("expected no blob downloads before verification failed, got %d", downloads)
+			}
+		})
+	}
+}
+
+func TestVerifySignatureRejectsSignatureOfOtherManifest(t *testing.T) {
+	registry := newTestRegistry(t)
+	privateKey, publicKey := testSigningKeyPair(t)
+	vm, _ := testLayoutArchiveVM(t, alchemy_build.HostOsLinux, alchemy_build.VirtualizationEngineQemu, []byte("dev-alchemy-first"))
+	first, err := Push(context.Background(), vm, registry.reference("dev-alchemy/ubuntu", "first"), PushOptions{RegistryOptions: testRegistryOptions()})
+	if err != nil {
+		t.Fatalf("failed to push artifact: %v", err)
+	}
+	repo, err := newRepository(remoteReference{repository: registry.host + "/dev-alchemy/ubuntu", registry: registry.host}, testRegistryOptions())
+	if err != nil {
+		t.Fatalf("failed to create repository: %v", err)
+	}
+	subject, err := repo.Resolve(context.Background(), "first")
+	if err != nil {
+		t.Fatalf("failed to resolve artifact: %v", err)
+	}
+	forged := subject
+	forged.Size++
+	if _, err := attachSignature(context.Background(), repo, forged, privateKey); err != nil {
+		t.Fatalf("failed to attach signature: %v", err)
+	}
+
+	if _, err := verifySignature(context.Background(), repo, subject, []ed25519.PublicKey{publicKey}); !errors.Is(err, ErrSignatureVerification) {
+		t.Fatalf("expected a signature over another descriptor of %s to be rejected, got %v", first.Digest, err)
+	}
+}
+
+func TestLoadSigningKeysRejectsWrongKeyType(t *testing.T) {
+	dir := t.TempDir()
+	privatePath := filepath.Join(dir, "signing.key")
+	publicPath := filepath.Join(dir, "signing.pub")
+	if err := GenerateSigningKeyPair(privatePath, publicPath); err != nil {
+		t.Fatalf("failed to generate key pair: %v", err)
+	}
+	if _, err := LoadVerificationKey(privatePath); err == nil {
+		t.Fatal("expected a private key to be rejected as verification key")
+	}
+	if _, err := LoadSigningKey(publicPath); err == nil {
+		t.Fatal("expected a public key to be rejected as signing key")
+	}
+	if err := GenerateSigningKeyPair(privatePath, publicPath); err == nil {
+		t.Fatal("expected key generation to refuse overwriting existing keys")
+	}
+}
+
+func testRegistryOptions() RegistryOptions {
+	return RegistryOptions{PlainHTTP: true, DisableDockerCredentials: true}
+}
+
+func testSigningKeyPair(t *testing.T) (ed25519.PrivateKey, ed25519.PublicKey) {
+	t.Helper()
+
+	dir := t.TempDir()
+	privatePath := filepath.Join(dir, "signing.key")
+	publicPath := filepath.Join(dir, "signing.pub")
+	if err := GenerateSigningKeyPair(privatePath, publicPath); err != nil {
+		t.Fatalf("failed to generate key pair: %v", err)
+	}
+	privateKey, err := LoadSigningKey(privatePath)
+	if err != nil {
+		t.Fatalf("failed to load signing key: %v", err)
+	}
+	publicKey, err := LoadVerificationKey(publicPath)
+	if err != nil {
+		t.Fatalf("failed to load verification key: %v", err)
+	}
+	return privateKey, publicKey
+}
@@ -0,0 +1,139 @@
+package oci
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	trustPolicyConfigEnvVar = "DEV_ALCHEMY_OCI_TRUST_POLICY"
+	trustPolicyConfigFile   = "oci-trust-policy.yml"
+)
+
+// TrustPolicy requires signatures from trusted keys for pulls from matching
+// repositories.
+type TrustPolicy struct {
+	Repositories []TrustPolicyRepository `json:"repositories" yaml:"repositories"`
+}
+
+// TrustPolicyRepository lists the public keys trusted for the repositories
+// matching Scope. A scope is a repository such as ghcr.io/example/ubuntu-24, a
+// prefix ending in /* such as ghcr.io/example/*, or * for every repository.
+type TrustPolicyRepository struct {
+	Scope string `json:"scope" yaml:"scope"`
+	// Keys are PEM public key files, relative to the policy file unless
+	// absolute.
+	Keys []string `json:"keys" yaml:"keys"`
+}
+
+// TrustPolicyConfigPath returns the trust policy location, honoring the
+// DEV_ALCHEMY_OCI_TRUST_POLICY override.
+func TrustPolicyConfigPath(directories *alchemy_build.Directories) string {
+	if override := strings.TrimSpace(os.Getenv(trustPolicyConfigEnvVar)); override != "" {
+		return filepath.Clean(override)
+	}
+
+	return directories.ConfigPath(trustPolicyConfigFile)
+}
+
+// LoadTrustPolicy reads the trust policy from the managed config directory. A
+// missing policy is not an error; exists reports whether a file was found.
+func LoadTrustPolicy() (TrustPolicy, string, bool, error) {
+	configPath := TrustPolicyConfigPath(alchemy_build.GetDirectoriesInstance())
+	policy, exists, err := loadTrustPolicy(configPath)
+	return policy, configPath, exists, err
+}
+
+func loadTrustPolicy(configPath string) (TrustPolicy, bool, error) {
+	data, err := os.ReadFile(configPath) // #nosec G304 -- configPath is the documented user-selected trust policy file.
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return TrustPolicy{}, false, nil
+		}
+		return TrustPolicy{}, false, fmt.Errorf("read OCI trust policy %q: %w", configPath, err)
+	}
+
+	policy := TrustPolicy{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&policy); err != nil && !errors.Is(err, io.EOF) {
+		return TrustPolicy{}, true, fmt.Errorf("parse OCI trust policy %q: %w", configPath, err)
+	}
+
+	for i, repository := range policy.Repositories {
+		scope := strings.TrimSpace(repository.Scope)
+		if scope == "" || len(repository.Keys) == 0 {
+			return TrustPolicy{}, true, fmt.Errorf("%q repository %d needs a scope and at least one key", configPath, i)
+		}
+		policy.Repositories[i].Scope = scope
+		for j, key := range repository.Keys {
+			if !filepath.IsAbs(key) {
+				key = filepath.Join(filepath.Dir(configPath), key)
+			}
+			policy.Repositories[i].Keys[j] = filepath.Clean(key)
+		}
+	}
+	return policy, true, nil
+}
+
+// KeysFor loads the keys trusted for the repository of reference from the
+// most specific matching scope. ok is false when no scope matches, so the
+// policy does not require a signature.
+func (policy TrustPolicy) KeysFor(reference string) (keys []ed25519.PublicKey, ok bool, err error) {
+	remoteRef, err := parseRemoteReference(reference)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var match *TrustPolicyRepository
+	for i, repository := range policy.Repositories {
+		if !trustPolicyScopeMatches(repository.Scope, remoteRef.repository) {
+			continue
+		}
+		if match == nil || len(repository.Scope) > len(match.Scope) {
+			match = &policy.Repositories[i]
+		}
+	}
+	if match == nil {
+		return nil, false, nil
+	}
+
+	for _, path := range match.Keys {
+		key, err := LoadVerificationKey(path)
+		if err != nil {
+			return nil, true, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, true, nil
+}
+
+// CoversEveryRepository reports whether a * scope requires signatures for
+// every repository, including artifacts that come from no repository at all.
+func (policy TrustPolicy) CoversEveryRepository() bool {
+	for _, repository := range policy.Repositories {
+		if repository.Scope == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func trustPolicyScopeMatches(scope string, repository string) bool {
+	if scope == "*" {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(scope, "/*"); ok {
+		return strings.HasPrefix(repository, prefix+"/")
+	}
+	return scope == repository
+}
@@ -0,0 +1,72 @@
+package oci
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTrustPolicyKeysForUsesMostSpecificScope(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"team", "release"} {
+		if err := GenerateSigningKeyPair(filepath.Join(dir, name+".key"), filepath.Join(dir, "keys", name+".pub")); err != nil {
+			t.Fatalf("failed to generate %s key pair: %v", name, err)
+		}
+	}
+	configPath := filepath.Join(dir, "oci-trust-policy.yml")
+	writeTestTrustPolicy(t, configPath, `repositories:
+  - scope: ghcr.io/example/*
+    keys: [keys/team.pub]
+  - scope: ghcr.io/example/ubuntu-24
+    keys: [keys/release.pub]
+`)
+
+	policy, exists, err := loadTrustPolicy(configPath)
+	if err != nil || !exists {
+		t.Fatalf("expected trust policy to load, got exists=%v err=%v", exists, err)
+	}
+	releaseKey, err := LoadVerificationKey(filepath.Join(dir, "keys", "release.pub"))
+	if err != nil {
+		t.Fatalf("failed to load release key: %v", err)
+	}
+
+	keys, ok, err := policy.KeysFor("ghcr.io/example/ubuntu-24:server-amd64-linux-build")
+	if err != nil || !ok {
+		t.Fatalf("expected the repository scope to match, got ok=%v err=%v", ok, err)
+	}
+	if len(keys) != 1 || !keys[0].Equal(releaseKey) {
+		t.Fatal("expected the repository scope to win over the prefix scope")
+	}
+	if _, ok, err := policy.KeysFor("ghcr.io/example/windows11:latest"); err != nil || !ok {
+		t.Fatalf("expected the prefix scope to match, got ok=%v err=%v", ok, err)
+	}
+	if _, ok, err := policy.KeysFor("ghcr.io/other/ubuntu-24:latest"); err != nil || ok {
+		t.Fatalf("expected no scope to match, got ok=%v err=%v", ok, err)
+	}
+}
+
+func TestLoadTrustPolicyRejectsRepositoryWithoutKeys(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "oci-trust-policy.yml")
+	writeTestTrustPolicy(t, configPath, "repositories:\n  - scope: \"*\"\n")
+
+	_, _, err := loadTrustPolicy(configPath)
+	if err == nil || !strings.Contains(err.Error(), "at least one key") {
+		t.Fatalf("expected a repository without keys to be rejected, got %v", err)
+	}
+}
+
+func TestLoadTrustPolicyMissingFileIsNotAnError(t *testing.T) {
+	_, exists, err := loadTrustPolicy(filepath.Join(t.TempDir(), "missing.yml"))
+	if err != nil || exists {
+		t.Fatalf("expected a missing policy to be reported as absent, got exists=%v err=%v", exists, err)
+	}
+}
+
+func writeTestTrustPolicy(t *testing.T, path string, content string) {
+	t.Helper()
+
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write trust policy: %v", err)
+	}
+}
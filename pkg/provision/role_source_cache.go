@@ -0,0 +1,110 @@
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+)
+
+// RoleSourceCacheRoots returns the cache directories that hold Git checkouts
+// of role and playbook sources.
+func RoleSourceCacheRoots() []alchemy_build.CacheCategoryRoot {
+	directories := alchemy_build.GetDirectoriesInstance()
+	return []alchemy_build.CacheCategoryRoot{
+		{Category: alchemy_build.CacheCategoryRoleSources, Path: directories.CachePath(ansibleRoleSourcesCacheDir)},
+		{Category: alchemy_build.CacheCategoryRoleSources, Path: directories.CachePath(ansiblePlaybookSourcesCacheDir)},
+	}
+}
+
+// PruneRoleSourceCheckouts removes Git checkouts that no source in the role
+// source config uses any more, for example after a source was removed or its
+// ref changed. With dryRun the checkouts are reported but kept. An invalid
+// config removes nothing.
+func PruneRoleSourceCheckouts(dryRun bool) ([]alchemy_build.CacheRemoval, error) {
+	config, configPath, _, err := loadCurrentAnsibleRoleSourcesConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	directories := alchemy_build.GetDirectoriesInstance()
+	configured := map[string]map[string]bool{}
+	for cacheDir, sources := range map[string][]ansibleRoleSourceConfig{
+		ansibleRoleSourcesCacheDir:     config.Sources,
+		ansiblePlaybookSourcesCacheDir: config.PlaybookSources,
+	} {
+		names, err := configuredGitSourceCacheNames(sources, cacheDir == ansiblePlaybookSourcesCacheDir)
+		if err != nil {
+			return nil, fmt.Errorf("%q: %w", configPath, err)
+		}
+		configured[directories.CachePath(cacheDir)] = names
+	}
+
+	var removals []alchemy_build.CacheRemoval
+	var errs []error
+	for _, root := range RoleSourceCacheRoots() {
+		entries, err := os.ReadDir(root.Path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return removals, fmt.Errorf("list role source cache %s: %w", root.Path, err)
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() || configured[root.Path][entry.Name()] {
+				continue
+			}
+			checkoutDir := filepath.Join(root.Path, entry.Name())
+			size, err := alchemy_build.CachePathSize(checkoutDir)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if !dryRun {
+				if err := os.RemoveAll(checkoutDir); err != nil {
+					errs = append(errs, fmt.Errorf("remove %s: %w", checkoutDir, err))
+					continue
+				}
+			}
+			removals = append(removals, alchemy_build.CacheRemoval{
+				Category:  root.Category,
+				Path:      checkoutDir,
+				SizeBytes: size,
+				Reason:    "no configured source uses it",
+			})
+		}
+	}
+	sort.Slice(removals, func(i, j int) bool {
+		return removals[i].Path < removals[j].Path
+	})
+	return removals, errors.Join(errs...)
+}
+
+// configuredGitSourceCacheNames returns the checkout directory names of the
+// Git sources, matching resolveGitAnsibleRoleSource and
+// resolveGitAnsiblePlaybookSource.
+func configuredGitSourceCacheNames(sources []ansibleRoleSourceConfig, playbooks bool) (map[string]bool, error) {
+	names := map[string]bool{}
+	for index, source := range sources {
+		sourceType, err := normalizeAnsibleRoleSourceType(source)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source %s: %w", roleSourceLabel(source, index), err)
+		}
+		if sourceType != "git" {
+			continue
+		}
+		subdirectory := source.RolesPath
+		if playbooks {
+			subdirectory = source.PlaybooksPath
+		}
+		name, err := gitSourceCacheName(source, subdirectory)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source %s: %w", roleSourceLabel(source, index), err)
+		}
+		names[name] = true
+	}
+	return names, nil
+}
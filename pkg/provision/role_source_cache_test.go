@@ -0,0 +1,76 @@
+package provision
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPruneRoleSourceCheckoutsRemovesUnconfiguredCheckouts(t *testing.T) {
+	dirs := withIsolatedAlchemyDirectories(t, t.TempDir())
+	writeRoleSourcesConfig(t, dirs.ConfigDir, strings.Join([]string{
+		"sources:",
+		"  - name: public-base",
+		"    url: https://example.test/dev-alchemy-roles.git",
+		"  - path: ./local-roles",
+		"playbook_sources:",
+		"  - url: https://example.test/playbooks.git",
+		"    ref: main",
+		"",
+	}, "\n"))
+	playbookName, err := gitSourceCacheName(ansibleRoleSourceConfig{URL: "https://example.test/playbooks.git", Ref: "main"}, "")
+	if err != nil {
+		t.Fatalf("gitSourceCacheName returned error: %v", err)
+	}
+	configured := []string{
+		filepath.Join(dirs.CacheDir, ansibleRoleSourcesCacheDir, "public-base"),
+		filepath.Join(dirs.CacheDir, ansiblePlaybookSourcesCacheDir, playbookName),
+	}
+	stale := []string{
+		filepath.Join(dirs.CacheDir, ansibleRoleSourcesCacheDir, "removed-source"),
+		filepath.Join(dirs.CacheDir, ansiblePlaybookSourcesCacheDir, "playbooks-0123456789ab"),
+	}
+	for _, checkoutDir := range append(configured, stale...) {
+		writeProjectFile(t, checkoutDir, "README.md", "checkout")
+	}
+
+	removals, err := PruneRoleSourceCheckouts(true)
+	if err != nil {
+		t.Fatalf("PruneRoleSourceCheckouts returned error: %v", err)
+	}
+	if len(removals) != 2 || removals[0].Path != stale[1] || removals[1].Path != stale[0] || removals[0].SizeBytes != int64(len("checkout")) {
+		t.Fatalf("expected both stale checkouts, got %+v", removals)
+	}
+	if _, err := os.Stat(stale[0]); err != nil {
+		t.Fatalf("expected dry run to keep %s, got %v", stale[0], err)
+	}
+
+	if _, err := PruneRoleSourceCheckouts(false); err != nil {
+		t.Fatalf("PruneRoleSourceCheckouts returned error: %v", err)
+	}
+	for _, checkoutDir := range stale {
+		if _, err := os.Stat(checkoutDir); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed, got %v", checkoutDir, err)
+		}
+	}
+	for _, checkoutDir := range configured {
+		if _, err := os.Stat(checkoutDir); err != nil {
+			t.Fatalf("expected configured checkout %s to be kept, got %v", checkoutDir, err)
+		}
+	}
+}
+
+func TestPruneRoleSourceCheckoutsKeepsEverythingWhenConfigIsInvalid(t *testing.T) {
+	dirs := withIsolatedAlchemyDirectories(t, t.TempDir())
+	writeRoleSourcesConfig(t, dirs.ConfigDir, "sources:\n  - type: svn\n    url: https://example.test/roles\n")
+	checkoutDir := filepath.Join(dirs.CacheDir, ansibleRoleSourcesCacheDir, "roles")
+	writeProjectFile(t, checkoutDir, "README.md", "checkout")
+
+	if _, err := PruneRoleSourceCheckouts(false); err == nil {
+		t.Fatal("expected an invalid config to be rejected")
+	}
+	if _, err := os.Stat(checkoutDir); err != nil {
+		t.Fatalf("expected the checkout to be kept, got %v", err)
+	}
+}
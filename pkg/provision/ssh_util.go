@@ -1,34 +1,100 @@
 package provision
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const (
 	sshPortWaitWindow   = 5 * time.Minute
 	sshPortWaitInterval = 2 * time.Second
+	sshConnectTimeout   = 5 * time.Second
+	sshProbeTimeout     = 10 * time.Second
 	sshPort             = 22
 )
 
+// errGuestServiceWaitAborted is returned by waitForGuestService when its
+// abort channel closes before the service answers.
+var errGuestServiceWaitAborted = errors.New("wait aborted")
+
+// guestServiceWait configures how waitForGuestService polls a guest service.
+type guestServiceWait struct {
+	name     string
+	window   time.Duration
+	interval time.Duration
+	// abort ends the wait early when closed, such as when the guest process
+	// exits. A nil channel never aborts.
+	abort <-chan struct{}
+}
+
 func waitForSSHPort(ip string) error {
 	return waitForSSHPortOnPort(ip, sshPort)
 }
 
+// waitForSSHPortOnPort waits until ip:port accepts TCP connections. Provisioned
+// hosts are reached directly, so an accepted connection means the SSH server
+// is listening.
 func waitForSSHPortOnPort(ip string, port int) error {
-	deadline := time.Now().Add(sshPortWaitWindow)
-	var lastErr error
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	return waitForGuestService(address, probeTCPConnect, guestServiceWait{
+		name:     "SSH on " + address,
+		window:   sshPortWaitWindow,
+		interval: sshPortWaitInterval,
+	})
+}
 
-	for time.Now().Before(deadline) {
-		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, fmt.Sprintf("%d", port)), 5*time.Second)
-		if err == nil {
-			_ = conn.Close()
+// waitForGuestService polls probe until it succeeds, the wait window elapses,
+// or the wait is aborted.
+func waitForGuestService(address string, probe func(address string) error, wait guestServiceWait) error {
+	deadline := time.Now().Add(wait.window)
+	for {
+		lastErr := probe(address)
+		if lastErr == nil {
 			return nil
 		}
-		lastErr = err
-		time.Sleep(sshPortWaitInterval)
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("%s did not become reachable within %s: %w", wait.name, wait.window, lastErr)
+		}
+		select {
+		case <-wait.abort:
+			return fmt.Errorf("%s: %w", wait.name, errGuestServiceWaitAborted)
+		case <-time.After(wait.interval):
+		}
+	}
+}
+
+// probeTCPConnect checks that address accepts a TCP connection.
+func probeTCPConnect(address string) error {
+	conn, err := net.DialTimeout("tcp", address, sshConnectTimeout)
+	if err != nil {
+		return err
 	}
+	return conn.Close()
+}
 
-	return fmt.Errorf("SSH on %s:%d did not become reachable within %s: %w", ip, port, sshPortWaitWindow, lastErr)
+// probeSSHBanner checks that an SSH server answers on address. A plain TCP
+// connect is not enough: QEMU user-mode networking accepts forwarded
+// connections on the host before the guest is listening.
+func probeSSHBanner(address string) error {
+	conn, err := net.DialTimeout("tcp", address, sshProbeTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := conn.SetReadDeadline(time.Now().Add(sshProbeTimeout)); err != nil {
+		return err
+	}
+	banner, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("no SSH banner: %w", err)
+	}
+	if !strings.HasPrefix(banner, "SSH-") {
+		return fmt.Errorf("unexpected SSH banner %q", strings.TrimSpace(banner))
+	}
+	return nil
 }
@@ -0,0 +1,408 @@
+package provision
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+)
+
+const (
+	bootVerifyProtocolSSH   = "ssh"
+	bootVerifyProtocolWinRM = "winrm"
+
+	bootVerifyDefaultBootTimeout = 20 * time.Minute
+	bootVerifyPlaybookTimeout    = 30 * time.Minute
+	bootVerifyCommandTimeout     = 2 * time.Minute
+	bootVerifyProbeTimeout       = 10 * time.Second
+	bootVerifyMemoryMB           = 4096
+	bootVerifyCPUs               = 2
+	bootVerifyLogTailLines       = 20
+	bootVerifyLoopbackAddress    = "127.0.0.1"
+	bootVerifyWinRMGuestPort     = 5985
+)
+
+// VerifyOptions configures a boot smoke test of a build artifact.
+type VerifyOptions struct {
+	// CheckPlaybookPath optionally names a playbook run against the booted
+	// guest after it became reachable.
+	CheckPlaybookPath string
+	// BootTimeout bounds how long the guest may take to expose SSH or WinRM.
+	BootTimeout time.Duration
+	Verbosity   int
+}
+
+// bootVerifyMachine is a running throwaway guest.
+type bootVerifyMachine interface {
+	// Done is closed when the guest process exits.
+	Done() <-chan struct{}
+	// Err returns the exit error once Done is closed.
+	Err() error
+	Stop() error
+}
+
+var (
+	startBootVerifyMachine    = startQemuBootVerifyMachine
+	reserveBootVerifyPort     = reserveLocalLoopbackPort
+	probeBootVerifyGuest      = probeBootVerifyGuestService
+	runBootVerifyCommand      = runCommandWithCombinedOutput
+	runBootVerifyPlaybookFunc = runAnsibleProvisionCommand
+	bootVerifyProbeInterval   = 5 * time.Second
+)
+
+// SupportsVerify reports whether the artifact of vm can be boot tested. Only
+// QCOW2 artifacts built with QEMU on Linux are supported.
+func SupportsVerify(vm alchemy_build.VirtualMachineConfig) bool {
+	if vm.HostOs != alchemy_build.HostOsLinux ||
+		vm.VirtualizationEngine != alchemy_build.VirtualizationEngineQemu ||
+		(vm.Arch != "amd64" && vm.Arch != "arm64") {
+		return false
+	}
+	switch vm.OS {
+	case "ubuntu":
+		return vm.UbuntuType == "server" || vm.UbuntuType == "desktop"
+	case "windows11":
+		return true
+	default:
+		return false
+	}
+}
+
+// RunVerify boots a throwaway copy-on-write overlay of the build artifact of
+// vm with QEMU, waits until SSH (Ubuntu) or WinRM (Windows) answers on a
+// forwarded loopback port, optionally runs a check playbook, and tears the
+// guest down again. The artifact itself is never written to; on success its
+// sidecar metadata is marked verified.
+func RunVerify(vm alchemy_build.VirtualMachineConfig, options VerifyOptions) error {
+	if !SupportsVerify(vm) {
+		return fmt.Errorf("verify is not implemented for OS=%s type=%s arch=%s host_os=%s virtualization_engine=%s", vm.OS, vm.UbuntuType, vm.Arch, vm.HostOs, vm.VirtualizationEngine)
+	}
+	if len(vm.ExpectedBuildArtifacts) == 0 {
+		return fmt.Errorf("no build artifact is known for OS=%s type=%s arch=%s", vm.OS, vm.UbuntuType, vm.Arch)
+	}
+	artifact := vm.ExpectedBuildArtifacts[0]
+	if _, err := os.Stat(artifact); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("build artifact %q is missing; build it first", artifact)
+		}
+		return fmt.Errorf("failed to inspect build artifact %q: %w", artifact, err)
+	}
+
+	qemuExecutable := alchemy_build.QemuSystemExecutable(vm.Arch)
+	for _, executable := range []string{"qemu-img", qemuExecutable} {
+		if _, err := lookPathProvisionCommand(executable); err != nil {
+			return fmt.Errorf("%s is required to verify build artifacts: %w", executable, err)
+		}
+	}
+
+	workDir, err := os.MkdirTemp("", "dev-alchemy-verify-*")
+	if err != nil {
+		return fmt.Errorf("failed to create verify work dir: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	overlayPath := filepath.Join(workDir, "overlay.qcow2")
+	if output, err := runBootVerifyCommand(
+		workDir,
+		bootVerifyCommandTimeout,
+		"qemu-img",
+		[]string{"create", "-f", "qcow2", "-F", "qcow2", "-b", artifact, overlayPath},
+	); err != nil {
+		return fmt.Errorf("failed to create overlay for %q: %w; output: %s", artifact, err, strings.TrimSpace(output))
+	}
+
+	hostPort, err := reserveBootVerifyPort()
+	if err != nil {
+		return fmt.Errorf("failed to reserve a loopback port for the guest: %w", err)
+	}
+	args, err := bootVerifyQemuArgs(vm, overlayPath, workDir, hostPort)
+	if err != nil {
+		return err
+	}
+
+	protocol := bootVerifyProtocol(vm)
+	logPath := filepath.Join(workDir, "qemu.log")
+	log.Printf("Booting overlay of %s to verify %s on 127.0.0.1:%d", artifact, protocol, hostPort)
+	machine, err := startBootVerifyMachine(qemuExecutable, args, logPath)
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %w", qemuExecutable, err)
+	}
+	defer func() {
+		if err := machine.Stop(); err != nil {
+			log.Printf("Failed to stop verify guest for %s: %v", artifact, err)
+		}
+	}()
+
+	bootTimeout := options.BootTimeout
+	if bootTimeout <= 0 {
+		bootTimeout = bootVerifyDefaultBootTimeout
+	}
+	address := net.JoinHostPort(bootVerifyLoopbackAddress, strconv.Itoa(hostPort))
+	if err := waitForBootVerifyGuest(machine, protocol, address, bootTimeout); err != nil {
+		if tail := bootVerifyLogTail(logPath); tail != "" {
+			return fmt.Errorf("%w; QEMU output:\n%s", err, tail)
+		}
+		return err
+	}
+	log.Printf("%s is reachable on the verify guest for %s", protocol, artifact)
+
+	if strings.TrimSpace(options.CheckPlaybookPath) != "" {
+		if err := runBootVerifyPlaybook(vm, hostPort, options); err != nil {
+			return err
+		}
+	}
+
+	return alchemy_build.MarkBuildArtifactVerified(artifact, alchemy_build.ArtifactVerification{
+		VerifiedAt:    time.Now().UTC(),
+		Protocol:      protocol,
+		CheckPlaybook: strings.TrimSpace(options.CheckPlaybookPath),
+	})
+}
+
+func bootVerifyProtocol(vm alchemy_build.VirtualMachineConfig) string {
+	if vm.OS == "windows11" {
+		return bootVerifyProtocolWinRM
+	}
+	return bootVerifyProtocolSSH
+}
+
+func bootVerifyGuestPort(vm alchemy_build.VirtualMachineConfig) int {
+	if bootVerifyProtocol(vm) == bootVerifyProtocolWinRM {
+		return bootVerifyWinRMGuestPort
+	}
+	return sshPort
+}
+
+// bootVerifyQemuArgs mirrors the machine, disk, and network devices of the
+// QEMU Packer templates so the guest boots with the drivers it was installed
+// with. User-mode networking forwards the guest SSH or WinRM port to
+// hostPort on the loopback interface only.
+func bootVerifyQemuArgs(vm alchemy_build.VirtualMachineConfig, overlayPath string, workDir string, hostPort int) ([]string, error) {
+	accel := "tcg,thread=multi"
+	cpuModel := "max,sve=off,sme=off,pauth-impdef=on"
+	if vm.Arch == "amd64" {
+		cpuModel = "Skylake-Client"
+	}
+	if alchemy_build.QemuHardwareAccelerationAvailable(vm) {
+		accel = "kvm"
+		cpuModel = "host"
+	}
+
+	args := []string{
+		"-accel", accel,
+		"-cpu", cpuModel,
+		"-m", strconv.Itoa(bootVerifyMemoryMB),
+		"-smp", strconv.Itoa(bootVerifyCPUs),
+		"-display", "none",
+		"-drive", fmt.Sprintf("file=%s,if=none,id=disk0,format=qcow2", overlayPath),
+		"-netdev", fmt.Sprintf("user,id=net0,hostfwd=tcp:%s:%d-:%d", bootVerifyLoopbackAddress, hostPort, bootVerifyGuestPort(vm)),
+	}
+
+	if vm.Arch == "amd64" {
+		return append(args,
+			"-machine", "q35",
+			"-device", "ide-hd,drive=disk0,bootindex=0",
+			"-device", "e1000,netdev=net0",
+		), nil
+	}
+
+	firmwareCode := alchemy_build.GetDirectoriesInstance().CachePath("qemu-uefi", "usr", "share", "AAVMF", "AAVMF_CODE.no-secboot.fd")
+	firmwareVars := alchemy_build.GetDirectoriesInstance().CachePath("qemu-uefi", "usr", "share", "AAVMF", "AAVMF_VARS.fd")
+	if _, err := os.Stat(firmwareCode); err != nil {
+		return nil, fmt.Errorf("arm64 UEFI firmware %q is missing; it is downloaded by the arm64 build: %w", firmwareCode, err)
+	}
+	efiVarsPath := filepath.Join(workDir, "efivars.fd")
+	if err := copyBootVerifyFile(firmwareVars, efiVarsPath); err != nil {
+		return nil, fmt.Errorf("failed to prepare UEFI variables for the verify guest: %w", err)
+	}
+
+	diskDevice := "virtio-blk-pci,drive=disk0,bootindex=0"
+	if vm.OS == "windows11" {
+		diskDevice = "nvme,drive=disk0,serial=deadbeef,bootindex=0"
+	}
+	return append(args,
+		"-machine", "virt,highmem=on",
+		"-drive", fmt.Sprintf("file=%s,if=pflash,unit=0,format=raw,readonly=on", firmwareCode),
+		"-drive", fmt.Sprintf("file=%s,if=pflash,unit=1,format=raw", efiVarsPath),
+		"-device", diskDevice,
+		"-device", "virtio-net-pci,netdev=net0",
+	), nil
+}
+
+// waitForBootVerifyGuest polls the forwarded guest service until it answers,
+// the guest process exits, or timeout elapses.
+func waitForBootVerifyGuest(machine bootVerifyMachine, protocol string, address string, timeout time.Duration) error {
+	err := waitForGuestService(address, func(address string) error {
+		return probeBootVerifyGuest(protocol, address)
+	}, guestServiceWait{
+		name:     protocol + " on the verify guest",
+		window:   timeout,
+		interval: bootVerifyProbeInterval,
+		abort:    machine.Done(),
+	})
+	if errors.Is(err, errGuestServiceWaitAborted) {
+		return fmt.Errorf("QEMU exited before %s on the verify guest became reachable: %v", protocol, machine.Err())
+	}
+	return err
+}
+
+// probeBootVerifyGuestService checks that the guest service itself answers.
+func probeBootVerifyGuestService(protocol string, address string) error {
+	if protocol == bootVerifyProtocolWinRM {
+		client := http.Client{Timeout: bootVerifyProbeTimeout}
+		response, err := client.Post("http://"+address+"/wsman", "application/soap+xml;charset=UTF-8", http.NoBody)
+		if err != nil {
+			return err
+		}
+		_ = response.Body.Close()
+		return nil
+	}
+	return probeSSHBanner(address)
+}
+
+func runBootVerifyPlaybook(vm alchemy_build.VirtualMachineConfig, hostPort int, options VerifyOptions) error {
+	projectDir := alchemy_build.GetDirectoriesInstance().ProjectDir
+	provisionOptions := ProvisionOptions{
+		PlaybookPath:         options.CheckPlaybookPath,
+		PlaybookPathExplicit: true,
+		Verbosity:            options.Verbosity,
+	}
+
+	var (
+		args    []string
+		cleanup func() error
+		err     error
+	)
+	if bootVerifyProtocol(vm) == bootVerifyProtocolWinRM {
+		connectionConfig, loadErr := loadWindowsLibvirtAnsibleConnectionConfig(projectDir)
+		if loadErr != nil {
+			return fmt.Errorf("failed to load libvirt windows ansible configuration: %w", loadErr)
+		}
+		connectionConfig.Port = strconv.Itoa(hostPort)
+		args, cleanup, err = buildWindowsProvisionArgs(projectDir, bootVerifyLoopbackAddress, connectionConfig, provisionOptions)
+	} else {
+		connectionConfig, loadErr := loadUbuntuLibvirtAnsibleConnectionConfig(projectDir)
+		if loadErr != nil {
+			return fmt.Errorf("failed to load libvirt ubuntu ansible configuration: %w", loadErr)
+		}
+		if err := ensureSSHPasswordAuthDependencies(connectionConfig); err != nil {
+			return err
+		}
+		connectionConfig.Port = strconv.Itoa(hostPort)
+		args, cleanup, err = buildSSHProvisionArgs(projectDir, bootVerifyLoopbackAddress, connectionConfig, provisionOptions)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to build ansible arguments for the verify guest: %w", err)
+	}
+
+	runErr := runBootVerifyPlaybookFunc(projectDir, args, bootVerifyPlaybookTimeout, fmt.Sprintf("%s:%s:%s:verify", vm.OS, vm.UbuntuType, vm.Arch))
+	cleanupErr := cleanup()
+	if runErr != nil {
+		return fmt.Errorf("check playbook failed on the verify guest: %w", runErr)
+	}
+	if cleanupErr != nil {
+		return fmt.Errorf("failed to remove ansible extra-vars temp file: %w", cleanupErr)
+	}
+	return nil
+}
+
+type qemuBootVerifyMachine struct {
+	cmd  *exec.Cmd
+	done chan struct{}
+	err  error
+}
+
+func startQemuBootVerifyMachine(executable string, args []string, logPath string) (bootVerifyMachine, error) {
+	logFile, err := os.Create(logPath) // #nosec G304 -- logPath lives in a private temp dir.
+	if err != nil {
+		return nil, err
+	}
+	// #nosec G204 -- executable and argv are built by bootVerifyQemuArgs; no shell interpretation occurs.
+	cmd := exec.Command(executable, args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	if err := cmd.Start(); err != nil {
+		_ = logFile.Close()
+		return nil, err
+	}
+
+	machine := &qemuBootVerifyMachine{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		machine.err = cmd.Wait()
+		_ = logFile.Close()
+		close(machine.done)
+	}()
+	return machine, nil
+}
+
+func (m *qemuBootVerifyMachine) Done() <-chan struct{} {
+	return m.done
+}
+
+func (m *qemuBootVerifyMachine) Err() error {
+	<-m.done
+	return m.err
+}
+
+// Stop kills the guest. The overlay is discarded afterwards, so there is
+// nothing to shut down gracefully.
+func (m *qemuBootVerifyMachine) Stop() error {
+	select {
+	case <-m.done:
+		return nil
+	default:
+	}
+	if err := m.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return err
+	}
+	<-m.done
+	return nil
+}
+
+func reserveLocalLoopbackPort() (int, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort(bootVerifyLoopbackAddress, "0"))
+	if err != nil {
+		return 0, err
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port, nil
+}
+
+func copyBootVerifyFile(source string, destination string) error {
+	in, err := os.Open(source) // #nosec G304 -- source is a managed firmware path in the cache dir.
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(destination) // #nosec G304 -- destination lives in a private temp dir.
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+func bootVerifyLogTail(logPath string) string {
+	content, err := os.ReadFile(logPath) // #nosec G304 -- logPath lives in a private temp dir.
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) > bootVerifyLogTailLines {
+		lines = lines[len(lines)-bootVerifyLogTailLines:]
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
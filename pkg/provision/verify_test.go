@@ -0,0 +1,244 @@
+package provision
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	alchemy_build "github.com/csautter/dev-alchemy/pkg/build"
+)
+
+type fakeBootVerifyMachine struct {
+	done    chan struct{}
+	err     error
+	stopped bool
+}
+
+func newFakeBootVerifyMachine() *fakeBootVerifyMachine {
+	return &fakeBootVerifyMachine{done: make(chan struct{})}
+}
+
+func (m *fakeBootVerifyMachine) Done() <-chan struct{} { return m.done }
+func (m *fakeBootVerifyMachine) Err() error            { return m.err }
+func (m *fakeBootVerifyMachine) Stop() error {
+	m.stopped = true
+	return nil
+}
+
+type bootVerifyStub struct {
+	machine      *fakeBootVerifyMachine
+	probeErrs    []error
+	probes       int
+	qemuArgs     []string
+	playbookArgs []string
+}
+
+func stubBootVerify(t *testing.T, stub *bootVerifyStub) {
+	t.Helper()
+	previousStart := startBootVerifyMachine
+	previousReserve := reserveBootVerifyPort
+	previousProbe := probeBootVerifyGuest
+	previousCommand := runBootVerifyCommand
+	previousPlaybook := runBootVerifyPlaybookFunc
+	previousInterval := bootVerifyProbeInterval
+	previousLookPath := lookPathProvisionCommand
+	t.Cleanup(func() {
+		startBootVerifyMachine = previousStart
+		reserveBootVerifyPort = previousReserve
+		probeBootVerifyGuest = previousProbe
+		runBootVerifyCommand = previousCommand
+		runBootVerifyPlaybookFunc = previousPlaybook
+		bootVerifyProbeInterval = previousInterval
+		lookPathProvisionCommand = previousLookPath
+	})
+	t.Setenv("DEV_ALCHEMY_QEMU_FORCE_SOFTWARE_EMULATION", "1")
+
+	if stub.machine == nil {
+		stub.machine = newFakeBootVerifyMachine()
+	}
+	lookPathProvisionCommand = func(file string) (string, error) { return "/usr/bin/" + file, nil }
+	reserveBootVerifyPort = func() (int, error) { return 42022, nil }
+	bootVerifyProbeInterval = time.Millisecond
+	runBootVerifyCommand = func(workingDir string, timeout time.Duration, executable string, args []string) (string, error) {
+		return "", os.WriteFile(args[len(args)-1], []byte("overlay"), 0o600)
+	}
+	startBootVerifyMachine = func(executable string, args []string, logPath string) (bootVerifyMachine, error) {
+		stub.qemuArgs = append([]string{executable}, args...)
+		return stub.machine, nil
+	}
+	probeBootVerifyGuest = func(protocol string, address string) error {
+		stub.probes++
+		if len(stub.probeErrs) == 0 {
+			return nil
+		}
+		err := stub.probeErrs[0]
+		stub.probeErrs = stub.probeErrs[1:]
+		return err
+	}
+	runBootVerifyPlaybookFunc = func(projectDir string, args []string, timeout time.Duration, logPrefix string) error {
+		stub.playbookArgs = args
+		return nil
+	}
+}
+
+func verifyUbuntuConfig(t *testing.T) alchemy_build.VirtualMachineConfig {
+	t.Helper()
+	artifact := filepath.Join(t.TempDir(), "qemu-ubuntu-server-packer-amd64.qcow2")
+	if err := os.WriteFile(artifact, []byte("image"), 0o600); err != nil {
+		t.Fatalf("failed to write artifact: %v", err)
+	}
+	return alchemy_build.VirtualMachineConfig{
+		OS:                     "ubuntu",
+		UbuntuType:             "server",
+		Arch:                   "amd64",
+		HostOs:                 alchemy_build.HostOsLinux,
+		VirtualizationEngine:   alchemy_build.VirtualizationEngineQemu,
+		ExpectedBuildArtifacts: []string{artifact},
+	}
+}
+
+func TestRunVerifyMarksArtifactVerifiedOnceSSHAnswers(t *testing.T) {
+	stub := &bootVerifyStub{probeErrs: []error{errors.New("no banner"), errors.New("no banner")}}
+	stubBootVerify(t, stub)
+	vm := verifyUbuntuConfig(t)
+
+	if err := RunVerify(vm, VerifyOptions{BootTimeout: time.Minute}); err != nil {
+		t.Fatalf("RunVerify returned error: %v", err)
+	}
+
+	if stub.probes != 3 {
+		t.Fatalf("expected three probes, got %d", stub.probes)
+	}
+	if !stub.machine.stopped {
+		t.Fatal("expected verify guest to be stopped")
+	}
+	qemuCommand := strings.Join(stub.qemuArgs, " ")
+	for _, want := range []string{
+		"qemu-system-x86_64",
+		"hostfwd=tcp:127.0.0.1:42022-:22",
+		"-device ide-hd,drive=disk0",
+		"-accel tcg,thread=multi",
+	} {
+		if !strings.Contains(qemuCommand, want) {
+			t.Fatalf("expected qemu command to contain %q, got %q", want, qemuCommand)
+		}
+	}
+	if strings.Contains(qemuCommand, vm.ExpectedBuildArtifacts[0]) {
+		t.Fatalf("expected qemu to boot the overlay, not the artifact: %q", qemuCommand)
+	}
+
+	metadata, _, err := alchemy_build.ReadBuildArtifactMetadata(vm.ExpectedBuildArtifacts[0])
+	if err != nil {
+		t.Fatalf("failed to read metadata: %v", err)
+	}
+	if metadata.Verification == nil || metadata.Verification.Protocol != "ssh" {
+		t.Fatalf("expected ssh verification to be recorded, got %+v", metadata.Verification)
+	}
+}
+
+func TestRunVerifyFailsWhenGuestExitsBeforeBecomingReachable(t *testing.T) {
+	machine := newFakeBootVerifyMachine()
+	machine.err = errors.New("exit status 1")
+	close(machine.done)
+	stub := &bootVerifyStub{machine: machine, probeErrs: []error{errors.New("connection reset")}}
+	stubBootVerify(t, stub)
+	vm := verifyUbuntuConfig(t)
+
+	err := RunVerify(vm, VerifyOptions{BootTimeout: time.Minute})
+	if err == nil || !strings.Contains(err.Error(), "QEMU exited") {
+		t.Fatalf("expected early exit error, got %v", err)
+	}
+	if _, exists, _ := alchemy_build.ReadBuildArtifactMetadata(vm.ExpectedBuildArtifacts[0]); exists {
+		t.Fatal("expected no metadata for an unverified artifact")
+	}
+}
+
+func TestRunVerifyRunsCheckPlaybookAgainstForwardedPort(t *testing.T) {
+	stub := &bootVerifyStub{}
+	stubBootVerify(t, stub)
+	vm := verifyUbuntuConfig(t)
+
+	if err := RunVerify(vm, VerifyOptions{CheckPlaybookPath: "./playbooks/check.yml"}); err != nil {
+		t.Fatalf("RunVerify returned error: %v", err)
+	}
+
+	if len(stub.playbookArgs) == 0 || stub.playbookArgs[0] != "./playbooks/check.yml" {
+		t.Fatalf("expected check playbook to run, got %v", stub.playbookArgs)
+	}
+	if !strings.Contains(strings.Join(stub.playbookArgs, " "), "-i 127.0.0.1,") {
+		t.Fatalf("expected playbook to target the loopback forward, got %v", stub.playbookArgs)
+	}
+	metadata, _, err := alchemy_build.ReadBuildArtifactMetadata(vm.ExpectedBuildArtifacts[0])
+	if err != nil || metadata.Verification == nil || metadata.Verification.CheckPlaybook != "./playbooks/check.yml" {
+		t.Fatalf("expected check playbook to be recorded, got %+v err=%v", metadata.Verification, err)
+	}
+}
+
+func TestRunVerifyRejectsUnsupportedTargets(t *testing.T) {
+	vm := verifyUbuntuConfig(t)
+	vm.VirtualizationEngine = alchemy_build.VirtualizationEngineUtm
+	vm.HostOs = alchemy_build.HostOsDarwin
+
+	if err := RunVerify(vm, VerifyOptions{}); err == nil || !strings.Contains(err.Error(), "not implemented") {
+		t.Fatalf("expected unsupported target error, got %v", err)
+	}
+}
+
+func TestBootVerifyQemuArgsForwardWinRMForWindows(t *testing.T) {
+	t.Setenv("DEV_ALCHEMY_QEMU_FORCE_SOFTWARE_EMULATION", "1")
+	vm := alchemy_build.VirtualMachineConfig{OS: "windows11", Arch: "amd64"}
+
+	args, err := bootVerifyQemuArgs(vm, "/tmp/overlay.qcow2", t.TempDir(), 42985)
+	if err != nil {
+		t.Fatalf("bootVerifyQemuArgs returned error: %v", err)
+	}
+	if joined := strings.Join(args, " "); !strings.Contains(joined, "hostfwd=tcp:127.0.0.1:42985-:5985") {
+		t.Fatalf("expected WinRM port forward, got %q", joined)
+	}
+}
+
+func TestProbeBootVerifyGuestServiceRequiresSSHBanner(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	banners := []string{"HTTP/1.1 400 Bad Request\r\n", "SSH-2.0-OpenSSH_9.6\r\n"}
+	go func() {
+		for _, banner := range banners {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte(banner))
+			_ = conn.Close()
+		}
+	}()
+
+	if err := probeBootVerifyGuestService("ssh", listener.Addr().String()); err == nil {
+		t.Fatal("expected non-SSH banner to be rejected")
+	}
+	if err := probeBootVerifyGuestService("ssh", listener.Addr().String()); err != nil {
+		t.Fatalf("expected SSH banner to be accepted, got %v", err)
+	}
+}
+
+func TestProbeBootVerifyGuestServiceAcceptsAnyWinRMResponse(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/wsman" {
+			t.Errorf("expected /wsman request, got %s", r.URL.Path)
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	if err := probeBootVerifyGuestService("winrm", strings.TrimPrefix(server.URL, "http://")); err != nil {
+		t.Fatalf("expected unauthorized WinRM response to count as reachable, got %v", err)
+	}
+}
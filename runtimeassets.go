@@ -13,6 +13,22 @@ import (
 //go:embed ansible.cfg playbooks inventory roles roles_test_1 roles_test_2 build/packer deployments/vagrant deployments/utm scripts/macos scripts/windows
 var EmbeddedFiles embed.FS
 
+// EmbeddedRoots lists the paths embedded above. Keep it in sync with the
+// go:embed directive.
+var EmbeddedRoots = []string{
+	"ansible.cfg",
+	"playbooks",
+	"inventory",
+	"roles",
+	"roles_test_1",
+	"roles_test_2",
+	"build/packer",
+	"deployments/vagrant",
+	"deployments/utm",
+	"scripts/macos",
+	"scripts/windows",
+}
+
 // FS returns the embedded runtime asset filesystem.
 func FS() fs.FS {
 	return EmbeddedFiles